@@ -0,0 +1,81 @@
+// Command dead-letters inspects and manages the dead letters of the notification server from the
+// command line. Replays are queued in MongoDB and attempted by the retry worker of a running server.
+//
+// Usage:
+//
+//	dead-letters list [status]
+//	dead-letters show <id>
+//	dead-letters replay <id>
+//	dead-letters discard <id>
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	deadLetterService "r2-notify-server/services/deadletter"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage:
+  dead-letters list [status]   List the most recent dead letters (pending, rejected, failed or resolved)
+  dead-letters show <id>       Print a dead letter with its raw body
+  dead-letters replay <id>     Queue a new processing attempt
+  dead-letters discard <id>    Remove a dead letter without processing it`
+
+func main() {
+	// Only load .env file in local development
+	if os.Getenv("ENV") != data.PRODUCTION_ENV {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal("Error loading .env file")
+		}
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	logger.Init()
+	defer logger.Log.Flush()
+
+	service, err := deadLetterService.NewDeadLetterServiceImpl(deadLetterRepository.NewDeadLetterRepositoryImpl(config.MongoConnection()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	var result any
+	switch {
+	case command == "list" && len(args) <= 1:
+		status := ""
+		if len(args) == 1 {
+			status = args[0]
+		}
+		result, err = service.FindDeadLetters(status)
+	case command == "show" && len(args) == 1:
+		result, err = service.FindById(args[0])
+	case command == "replay" && len(args) == 1:
+		result, err = service.Replay(args[0])
+	case command == "discard" && len(args) == 1:
+		err = service.Discard(args[0])
+		result = map[string]string{"discarded": args[0]}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
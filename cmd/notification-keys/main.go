@@ -0,0 +1,95 @@
+// Command notification-keys manages the keys the title and message of notifications, the payloads of
+// webhook deliveries and the bodies of dead letters are encrypted with. Rotations can run while the
+// server is running.
+//
+// Usage:
+//
+//	notification-keys generate
+//	notification-keys rotate
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	notificationRepository "r2-notify-server/repository/notification"
+	webhookRepository "r2-notify-server/repository/webhook"
+	"r2-notify-server/utils"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage:
+  notification-keys generate   Print a new random key, to be added to FIELD_ENCRYPTION_KEYS
+  notification-keys rotate     Wrap the data keys of every notification, webhook delivery and dead letter
+                               with FIELD_ENCRYPTION_KEY_ID, and encrypt those stored in plaintext`
+
+func main() {
+	// Only load .env file in local development
+	if os.Getenv("ENV") != data.PRODUCTION_ENV {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal("Error loading .env file")
+		}
+	}
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "generate":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+	case "rotate":
+		rotate()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// rotate moves the notifications and webhook deliveries of every tenant, and the dead letters, to the
+// active key.
+func rotate() {
+	logger.Init()
+	defer logger.Log.Flush()
+
+	if err := utils.InitFieldKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if utils.FieldKeys == nil {
+		log.Fatal("Field encryption is disabled: set FIELD_ENCRYPTION_KEYS")
+	}
+
+	db := config.MongoConnection()
+	notifications := notificationRepository.NewNotificationRepositoryImpl(db)
+	webhooks := webhookRepository.NewWebhookRepositoryImpl(db)
+	for _, tenantId := range config.Tenants() {
+		rotated, err := notifications.ForTenant(tenantId).RotateKeys()
+		if err != nil {
+			log.Fatalf("Failed to rotate the notifications of tenant %s after %d: %v", tenantId, rotated, err)
+		}
+		fmt.Printf("Rotated %d notifications of tenant %s to key %s\n", rotated, tenantId, utils.FieldKeys.ActiveKeyId())
+
+		rotated, err = webhooks.ForTenant(tenantId).RotateKeys()
+		if err != nil {
+			log.Fatalf("Failed to rotate the webhook deliveries of tenant %s after %d: %v", tenantId, rotated, err)
+		}
+		fmt.Printf("Rotated %d webhook deliveries of tenant %s to key %s\n", rotated, tenantId, utils.FieldKeys.ActiveKeyId())
+	}
+
+	rotated, err := deadLetterRepository.NewDeadLetterRepositoryImpl(db).RotateKeys()
+	if err != nil {
+		log.Fatalf("Failed to rotate the dead letters after %d: %v", rotated, err)
+	}
+	fmt.Printf("Rotated %d dead letters to key %s\n", rotated, utils.FieldKeys.ActiveKeyId())
+}
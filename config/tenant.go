@@ -0,0 +1,75 @@
+package config
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// tenantIdPattern restricts tenant IDs to characters that are safe in database names and Redis keys.
+var tenantIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// loadTenantIds reads the tenants listed in TENANT_IDS, ignoring the IDs that are not valid tenant IDs.
+func loadTenantIds() []string {
+	var tenantIds []string
+	for _, tenantId := range strings.Split(GetEnv("TENANT_IDS", ""), ",") {
+		tenantId = strings.TrimSpace(tenantId)
+		if tenantIdPattern.MatchString(tenantId) && !slices.Contains(tenantIds, tenantId) {
+			tenantIds = append(tenantIds, tenantId)
+		}
+	}
+	return tenantIds
+}
+
+// Tenants returns the default tenant followed by the tenants listed in TENANT_IDS.
+func Tenants() []string {
+	cfg := LoadConfig()
+	tenants := []string{cfg.DefaultTenant}
+	for _, tenantId := range cfg.TenantIds {
+		if tenantId != cfg.DefaultTenant {
+			tenants = append(tenants, tenantId)
+		}
+	}
+	return tenants
+}
+
+// ResolveTenant returns the given tenant, or the default tenant for tokens and apps that have none.
+func ResolveTenant(tenantId string) string {
+	if tenantId == "" {
+		return LoadConfig().DefaultTenant
+	}
+	return tenantId
+}
+
+// IsKnownTenant reports whether the tenant is the default tenant or one of TENANT_IDS.
+func IsKnownTenant(tenantId string) bool {
+	return slices.Contains(Tenants(), tenantId)
+}
+
+// IsDefaultTenant reports whether the tenant is the default tenant, whose data is also the data
+// stored before tenants were introduced.
+func IsDefaultTenant(tenantId string) bool {
+	return tenantId == LoadConfig().DefaultTenant
+}
+
+// TenantDatabase returns the database holding the data of a tenant. With TENANT_DATABASES, every
+// tenant but the default one has its own database, named after the configured database and the tenant.
+// Otherwise tenants share the configured database.
+func TenantDatabase(db *mongo.Database, tenantId string) *mongo.Database {
+	if !LoadConfig().TenantDatabases || IsDefaultTenant(tenantId) {
+		return db
+	}
+	return db.Client().Database(db.Name() + "_" + tenantId)
+}
+
+// TenantFilter returns the condition on the tenantId field of the documents of a tenant. The documents
+// of the default tenant include those stored before tenants were introduced, which have no tenantId.
+func TenantFilter(tenantId string) interface{} {
+	if IsDefaultTenant(tenantId) {
+		return bson.M{"$in": bson.A{tenantId, nil}}
+	}
+	return tenantId
+}
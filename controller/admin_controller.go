@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	appRepository "r2-notify-server/repository/app"
+	userRepository "r2-notify-server/repository/user"
+	clientStore "r2-notify-server/services"
+	appService "r2-notify-server/services/app"
+	auditService "r2-notify-server/services/audit"
+	configurationService "r2-notify-server/services/configuration"
+	notificationService "r2-notify-server/services/notification"
+	sessionService "r2-notify-server/services/session"
+	userService "r2-notify-server/services/user"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AdminController struct {
+	appService           appService.AppService
+	userService          userService.UserService
+	notificationService  notificationService.NotificationService
+	configurationService configurationService.ConfigurationService
+	sessionService       sessionService.SessionService
+	auditService         auditService.AuditService
+}
+
+// NewAdminController returns a new instance of AdminController.
+// It requires an appService, a userService, a notificationService, a configurationService, a sessionService
+// and an auditService to be injected for its dependencies.
+func NewAdminController(appService appService.AppService, service userService.UserService, notificationService notificationService.NotificationService, configurationService configurationService.ConfigurationService, sessionService sessionService.SessionService, auditService auditService.AuditService) *AdminController {
+	return &AdminController{
+		appService:           appService,
+		userService:          service,
+		notificationService:  notificationService,
+		configurationService: configurationService,
+		sessionService:       sessionService,
+		auditService:         auditService,
+	}
+}
+
+// ListApps returns the registered apps. Super admins see the apps of every tenant, other users the apps
+// of their tenant, and app admins only the apps they administer.
+func (controller *AdminController) ListApps(ctx *gin.Context) {
+	apps, err := controller.appService.FindAll()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if !userService.HasRole(access, data.ROLE_SUPER_ADMIN) {
+		tenantId := ctx.GetString(data.TENANT_ID)
+		apps = slices.DeleteFunc(apps, func(app data.App) bool {
+			return app.TenantId != tenantId
+		})
+	}
+	if !userService.HasRole(access, data.ROLE_SUPPORT) {
+		apps = slices.DeleteFunc(apps, func(app data.App) bool {
+			return !slices.Contains(access.Apps, app.Id)
+		})
+	}
+	ctx.JSON(http.StatusOK, apps)
+}
+
+// SaveApp registers the app given in the path, replacing the existing one.
+// App admins can only save the apps they administer. The app belongs to the tenant of the user
+// unless another tenant is given, which only super admins can do, as well as saving the apps of
+// other tenants.
+func (controller *AdminController) SaveApp(ctx *gin.Context) {
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if !userService.ManagesApp(access, ctx.Param("appId")) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "App isn't administered by user"})
+		return
+	}
+	var app data.App
+	if err := ctx.ShouldBindJSON(&app); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	app.Id = ctx.Param("appId")
+
+	tenantId := ctx.GetString(data.TENANT_ID)
+	if app.TenantId == "" {
+		app.TenantId = tenantId
+	}
+	if !config.IsKnownTenant(app.TenantId) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tenant " + app.TenantId})
+		return
+	}
+	if !userService.HasRole(access, data.ROLE_SUPER_ADMIN) {
+		existing, err := controller.appService.FindById(app.Id)
+		if err != nil && !errors.Is(err, appRepository.ErrAppNotFound) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if app.TenantId != tenantId || (err == nil && existing.TenantId != tenantId) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "App belongs to another tenant"})
+			return
+		}
+	}
+
+	app, err := controller.appService.Save(app)
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, app)
+}
+
+// DeleteApp unregisters an app.
+func (controller *AdminController) DeleteApp(ctx *gin.Context) {
+	err := controller.appService.Delete(ctx.Param("appId"))
+	if errors.Is(err, appRepository.ErrAppNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListUsers returns the users of the caller's tenant granted roles in the users collection.
+func (controller *AdminController) ListUsers(ctx *gin.Context) {
+	users, err := controller.userService.ForTenant(ctx.GetString(data.TENANT_ID)).FindAll()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, users)
+}
+
+// GetUser returns the roles and apps granted to a user of the caller's tenant in the users collection.
+func (controller *AdminController) GetUser(ctx *gin.Context) {
+	user, err := controller.userService.ForTenant(ctx.GetString(data.TENANT_ID)).FindById(ctx.Param("userId"))
+	if errors.Is(err, userRepository.ErrUserNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// SaveUser replaces the roles and apps granted to a user of the caller's tenant.
+func (controller *AdminController) SaveUser(ctx *gin.Context) {
+	var user data.UserAccess
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.UserId = ctx.Param("userId")
+
+	user, err := controller.userService.ForTenant(ctx.GetString(data.TENANT_ID)).Save(user)
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// GetUserNotifications returns the notifications of any user of the tenant. App admins only see the
+// notifications of the apps they administer.
+func (controller *AdminController) GetUserNotifications(ctx *gin.Context) {
+	notifications, err := controller.notificationService.ForTenant(ctx.GetString(data.TENANT_ID)).FindAll(ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if !userService.HasRole(access, data.ROLE_SUPPORT) {
+		notifications = slices.DeleteFunc(notifications, func(notification data.Notification) bool {
+			return !slices.Contains(access.Apps, notification.AppId)
+		})
+	}
+	ctx.JSON(http.StatusOK, notifications)
+}
+
+// GetUserConfiguration returns the notification configuration of any user of the tenant.
+func (controller *AdminController) GetUserConfiguration(ctx *gin.Context) {
+	configuration, err := controller.configurationService.ForTenant(ctx.GetString(data.TENANT_ID)).FindByAppAndUser(ctx.Param("userId"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, configuration)
+}
+
+// ListSessions returns the open WebSocket connections of every user of the tenant, on every replica.
+func (controller *AdminController) ListSessions(ctx *gin.Context) {
+	sessions, err := clientStore.FindAllSessions(ctx.GetString(data.TENANT_ID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+// GetUserSessions returns the open WebSocket connections of a user, on every replica.
+func (controller *AdminController) GetUserSessions(ctx *gin.Context) {
+	sessions, err := clientStore.FindSessions(ctx.GetString(data.TENANT_ID), ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+// RevokeUserSessions ends every session of a user of the caller's tenant and closes the user's WebSocket
+// connections. Only super admins can revoke the sessions of admins: users granted the app-admin, support
+// or super-admin role in the users collection or through ADMIN_USER_IDS.
+func (controller *AdminController) RevokeUserSessions(ctx *gin.Context) {
+	target, err := controller.userService.ForTenant(ctx.GetString(data.TENANT_ID)).FindUserAccess(ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the roles of user"})
+		return
+	}
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if userService.HasRole(target, data.ROLE_APP_ADMIN, data.ROLE_SUPPORT) && !userService.HasRole(access, data.ROLE_SUPER_ADMIN) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Role " + data.ROLE_SUPER_ADMIN + " required to revoke the sessions of an admin"})
+		return
+	}
+
+	if err := controller.sessionService.RevokeUser(target.TenantId, target.UserId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListAuditEvents returns the latest audit events, filtered by the tenantId, actorId, actorType and action
+// query parameters, and by the from and to RFC 3339 times. Only super admins see the events of other tenants.
+func (controller *AdminController) ListAuditEvents(ctx *gin.Context) {
+	var query data.AuditEventQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if !userService.HasRole(access, data.ROLE_SUPER_ADMIN) {
+		query.TenantId = ctx.GetString(data.TENANT_ID)
+	}
+
+	events, err := controller.auditService.Find(query)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"r2-notify-server/data"
+	auditService "r2-notify-server/services/audit"
+	sessionService "r2-notify-server/services/session"
+	userService "r2-notify-server/services/user"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubUserService grants the roles of its map, keyed by <tenantId>:<userId>, to the users of its tenant.
+type stubUserService struct {
+	userService.UserService
+	tenantId string
+	roles    map[string][]string
+}
+
+func (s stubUserService) ForTenant(tenantId string) userService.UserService {
+	s.tenantId = tenantId
+	return s
+}
+
+func (s stubUserService) FindUserAccess(userId string) (data.UserAccess, error) {
+	return data.UserAccess{TenantId: s.tenantId, UserId: userId, Roles: append([]string{data.ROLE_USER}, s.roles[s.tenantId+":"+userId]...)}, nil
+}
+
+// recordingSessionService records the users whose sessions are revoked.
+type recordingSessionService struct {
+	sessionService.SessionService
+	revoked *[]string
+}
+
+func (s recordingSessionService) RevokeUser(tenantId string, userId string) error {
+	*s.revoked = append(*s.revoked, tenantId+":"+userId)
+	return nil
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	support := data.UserAccess{UserId: "s1", Roles: []string{data.ROLE_USER, data.ROLE_SUPPORT}}
+	superAdmin := data.UserAccess{UserId: "root", Roles: []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}}
+	roles := map[string][]string{
+		"default:app-admin":  {data.ROLE_APP_ADMIN},
+		"default:support":    {data.ROLE_SUPPORT},
+		"default:root":       {data.ROLE_SUPER_ADMIN},
+		"tenant-b:u1":        {data.ROLE_SUPER_ADMIN},
+		"tenant-b:app-admin": {data.ROLE_APP_ADMIN},
+	}
+	tests := []struct {
+		name     string
+		tenantId string
+		access   data.UserAccess
+		userId   string
+		status   int
+		revoked  string
+	}{
+		{"support revokes a user", "default", support, "u1", http.StatusNoContent, "default:u1"},
+		{"support revokes an app admin", "default", support, "app-admin", http.StatusForbidden, ""},
+		{"support revokes another support user", "default", support, "support", http.StatusForbidden, ""},
+		{"support revokes a super admin", "default", support, "root", http.StatusForbidden, ""},
+		{"support of another tenant revokes its user", "tenant-b", support, "app-admin", http.StatusForbidden, ""},
+		{"user is revoked in the tenant of the caller", "tenant-c", support, "u1", http.StatusNoContent, "tenant-c:u1"},
+		{"super admin revokes an app admin", "default", superAdmin, "app-admin", http.StatusNoContent, "default:app-admin"},
+		{"super admin revokes another super admin", "tenant-b", superAdmin, "u1", http.StatusNoContent, "tenant-b:u1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var revoked []string
+			controller := NewAdminController(nil, stubUserService{roles: roles}, nil, nil, recordingSessionService{revoked: &revoked}, nil)
+			engine := gin.New()
+			engine.DELETE("/admin/users/:userId/sessions", func(ctx *gin.Context) {
+				ctx.Set(data.TENANT_ID, test.tenantId)
+				ctx.Set(data.USER_ACCESS, test.access)
+			}, controller.RevokeUserSessions)
+
+			request := httptest.NewRequest(http.MethodDelete, "/admin/users/"+test.userId+"/sessions", nil)
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+			if test.revoked == "" {
+				if len(revoked) != 0 {
+					t.Fatalf("expected no session to be revoked, got %v", revoked)
+				}
+				return
+			}
+			if len(revoked) != 1 || revoked[0] != test.revoked {
+				t.Fatalf("expected the sessions of %s to be revoked, got %v", test.revoked, revoked)
+			}
+		})
+	}
+}
+
+// recordingAuditService records the queries of the audit events.
+type recordingAuditService struct {
+	auditService.AuditService
+	queries *[]data.AuditEventQuery
+}
+
+func (s recordingAuditService) Find(query data.AuditEventQuery) ([]data.AuditEvent, error) {
+	*s.queries = append(*s.queries, query)
+	return []data.AuditEvent{}, nil
+}
+
+func TestListAuditEventsIsScopedToTheTenant(t *testing.T) {
+	support := data.UserAccess{UserId: "s1", Roles: []string{data.ROLE_USER, data.ROLE_SUPPORT}}
+	superAdmin := data.UserAccess{UserId: "root", Roles: []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}}
+	tests := []struct {
+		name     string
+		access   data.UserAccess
+		query    string
+		tenantId string
+	}{
+		{"support lists the events of its tenant", support, "?actorId=u1", "tenant-b"},
+		{"support asks for another tenant", support, "?tenantId=tenant-a&actorId=u1", "tenant-b"},
+		{"super admin lists the events of every tenant", superAdmin, "?actorId=u1", ""},
+		{"super admin lists the events of another tenant", superAdmin, "?tenantId=tenant-a&actorId=u1", "tenant-a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var queries []data.AuditEventQuery
+			controller := NewAdminController(nil, stubUserService{}, nil, nil, nil, recordingAuditService{queries: &queries})
+			engine := gin.New()
+			engine.GET("/admin/audit-events", func(ctx *gin.Context) {
+				ctx.Set(data.TENANT_ID, "tenant-b")
+				ctx.Set(data.USER_ACCESS, test.access)
+			}, controller.ListAuditEvents)
+
+			request := httptest.NewRequest(http.MethodGet, "/admin/audit-events"+test.query, nil)
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+			}
+			if len(queries) != 1 || queries[0].TenantId != test.tenantId || queries[0].ActorId != "u1" {
+				t.Fatalf("expected the events of tenant %q to be listed, got %+v", test.tenantId, queries)
+			}
+		})
+	}
+}
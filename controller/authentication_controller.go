@@ -1,36 +1,105 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"r2-notify-server/data"
+	"r2-notify-server/logger"
 	authenticationService "r2-notify-server/services/authentication"
+	sessionService "r2-notify-server/services/session"
+	"r2-notify-server/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthenticationController struct {
 	authenticationService authenticationService.AuthenticationService
+	sessionService        sessionService.SessionService
 }
 
-func NewAuthController(service authenticationService.AuthenticationService) *AuthenticationController {
-	return &AuthenticationController{authenticationService: service}
+func NewAuthController(service authenticationService.AuthenticationService, sessionService sessionService.SessionService) *AuthenticationController {
+	return &AuthenticationController{authenticationService: service, sessionService: sessionService}
 }
 
-func (controller *AuthenticationController) GoogleAuthHandler(ctx *gin.Context) {
-	var req data.GoogleAuthRequest
+// AuthHandler exchanges the ID token of the identity provider named in the path for the tokens of a new session.
+func (controller *AuthenticationController) AuthHandler(ctx *gin.Context) {
+	var req data.AuthRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	user, jwt, err := controller.authenticationService.GoogleAuthenticate(req.Token)
+	user, tokens, err := controller.authenticationService.Authenticate(ctx.Param("provider"), req.Token)
+	if errors.Is(err, authenticationService.ErrUnknownProvider) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"jwt":  jwt,
-		"user": user,
+		"jwt":          tokens.Jwt,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"user":         user,
+	})
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new refresh token.
+func (controller *AuthenticationController) RefreshHandler(ctx *gin.Context) {
+	var req data.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tokens, err := controller.sessionService.Refresh(req.RefreshToken)
+	if errors.Is(err, sessionService.ErrInvalidRefreshToken) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// LogoutHandler ends the session of a refresh token and closes its WebSocket connections.
+func (controller *AuthenticationController) LogoutHandler(ctx *gin.Context) {
+	var req data.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := controller.sessionService.Logout(req.RefreshToken); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// TicketHandler exchanges the bearer token of the request for a single-use ticket opening a WebSocket connection.
+func (controller *AuthenticationController) TicketHandler(ctx *gin.Context) {
+	claims, _ := ctx.Get(data.TOKEN_CLAIMS)
+	ticket, err := controller.sessionService.IssueTicket(claims.(*utils.TokenClaims))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "AuthenticationController",
+			Operation:     "TicketHandler",
+			Message:       "Failed to issue WebSocket ticket",
+			UserId:        ctx.GetString(data.USER_ID),
+			CorrelationId: ctx.GetString(data.CORRELATION_ID),
+			Error:         err,
+		})
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"ticket":    ticket,
+		"expiresIn": int(sessionService.TicketTtl.Seconds()),
 	})
 }
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	appService "r2-notify-server/services/app"
+	configurationService "r2-notify-server/services/configuration"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChatTargetController struct {
+	configurationService configurationService.ConfigurationService
+	appService           appService.AppService
+}
+
+// NewChatTargetController returns a new instance of ChatTargetController.
+// It requires a configurationService and an appService to be injected for its dependencies.
+func NewChatTargetController(service configurationService.ConfigurationService, appService appService.AppService) *ChatTargetController {
+	return &ChatTargetController{configurationService: service, appService: appService}
+}
+
+// ListChatTargets returns the Slack and Teams targets of the app given by the X-App-ID header,
+// which the caller must administer.
+func (controller *ChatTargetController) ListChatTargets(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	targets, err := controller.configurationService.ForTenant(tenantId).FindAppChatTargets(appId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, data.ChatTargetsRequest{ChatTargets: targets})
+}
+
+// SetChatTargets replaces the Slack and Teams targets of the app given by the X-App-ID header,
+// which the caller must administer. Every notification of the app whose status matches a target's
+// filter is mirrored to it.
+func (controller *ChatTargetController) SetChatTargets(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	var request data.ChatTargetsRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	service := controller.configurationService.ForTenant(tenantId).As(data.AuditActor{
+		Id:            ctx.GetString(data.USER_ID),
+		Type:          data.AUDIT_ACTOR_USER,
+		CorrelationId: ctx.GetString(data.CORRELATION_ID),
+	})
+	if err := service.SetAppChatTargets(appId, request.ChatTargets); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "ChatTargetController",
+			Operation:     "SetChatTargets",
+			Message:       "Failed to update chat targets",
+			UserId:        ctx.GetString(data.USER_ID),
+			AppId:         appId,
+			CorrelationId: ctx.GetString(data.CORRELATION_ID),
+			Error:         err,
+		})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"r2-notify-server/data"
+	configurationService "r2-notify-server/services/configuration"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingConfigurationService records the tenant the chat targets of an app are listed in.
+type recordingConfigurationService struct {
+	configurationService.ConfigurationService
+	tenantId string
+	listed   *[]string
+}
+
+func (s recordingConfigurationService) ForTenant(tenantId string) configurationService.ConfigurationService {
+	s.tenantId = tenantId
+	return s
+}
+
+func (s recordingConfigurationService) FindAppChatTargets(appId string) ([]data.ChatTarget, error) {
+	*s.listed = append(*s.listed, s.tenantId+":"+appId)
+	return []data.ChatTarget{}, nil
+}
+
+func TestChatTargetsRequireAnAdminOfTheApp(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenantId string
+		access   data.UserAccess
+		appId    string
+		status   int
+		listed   string
+	}{
+		{"app admin of the app", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-a"}}, "app-a", http.StatusOK, "default:app-a"},
+		{"app admin of another app", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-a"}}, "app-c", http.StatusForbidden, ""},
+		{"user listing the app", "default", data.UserAccess{UserId: "u2", Roles: []string{data.ROLE_USER}, Apps: []string{"app-a"}}, "app-a", http.StatusForbidden, ""},
+		{"app admin of an app of another tenant", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-b"}}, "app-b", http.StatusForbidden, ""},
+		{"super admin of another tenant", "default", data.UserAccess{UserId: "root", Roles: []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}}, "app-b", http.StatusOK, "tenant-b:app-b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var listed []string
+			controller := NewChatTargetController(
+				recordingConfigurationService{listed: &listed},
+				stubAppService{tenants: map[string]string{"app-b": "tenant-b"}},
+			)
+			engine := gin.New()
+			engine.GET("/chat-targets", func(ctx *gin.Context) {
+				ctx.Set(data.TENANT_ID, test.tenantId)
+				ctx.Set(data.USER_ACCESS, test.access)
+			}, controller.ListChatTargets)
+
+			request := httptest.NewRequest(http.MethodGet, "/chat-targets", nil)
+			request.Header.Set("X-App-ID", test.appId)
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+			if test.listed == "" && len(listed) != 0 {
+				t.Fatalf("expected no chat targets to be listed, listed %v", listed)
+			}
+			if test.listed != "" && (len(listed) != 1 || listed[0] != test.listed) {
+				t.Fatalf("expected the chat targets of %s to be listed, listed %v", test.listed, listed)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	deadLetterService "r2-notify-server/services/deadletter"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeadLetterController struct {
+	deadLetterService deadLetterService.DeadLetterService
+}
+
+// NewDeadLetterController returns a new instance of DeadLetterController.
+// It requires a deadLetterService to be injected for its dependencies.
+func NewDeadLetterController(service deadLetterService.DeadLetterService) *DeadLetterController {
+	return &DeadLetterController{deadLetterService: service}
+}
+
+// ListDeadLetters returns the most recent dead letters.
+// The optional status query parameter filters dead letters by pending, rejected, failed or resolved.
+func (controller *DeadLetterController) ListDeadLetters(ctx *gin.Context) {
+	deadLetters, err := controller.deadLetterService.FindDeadLetters(ctx.Query("status"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, deadLetters)
+}
+
+// GetDeadLetter returns a single dead letter, including the raw event body.
+func (controller *DeadLetterController) GetDeadLetter(ctx *gin.Context) {
+	deadLetter, err := controller.deadLetterService.FindById(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(deadLetterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, deadLetter)
+}
+
+// ReplayDeadLetter queues a new processing attempt of a dead letter.
+func (controller *DeadLetterController) ReplayDeadLetter(ctx *gin.Context) {
+	deadLetter, err := controller.deadLetterService.Replay(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(deadLetterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, deadLetter)
+}
+
+// DiscardDeadLetter removes a dead letter without processing it.
+func (controller *DeadLetterController) DiscardDeadLetter(ctx *gin.Context) {
+	if err := controller.deadLetterService.Discard(ctx.Param("id")); err != nil {
+		ctx.JSON(deadLetterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// deadLetterErrorStatus maps a dead letter lookup error to 404 Not Found, 400 Bad Request or 500 Internal Server Error.
+func deadLetterErrorStatus(err error) int {
+	if errors.Is(err, deadLetterRepository.ErrDeadLetterNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, primitive.ErrInvalidHex) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -1,64 +1,47 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+
 	"fmt"
 	"net/http"
-	"r2-notify-server/config"
 	"r2-notify-server/data"
 	"r2-notify-server/logger"
-	"r2-notify-server/models"
-	clientStore "r2-notify-server/services"
-	notificationService "r2-notify-server/services/notification"
-	"r2-notify-server/utils"
+	ingestionService "r2-notify-server/services/ingestion"
+	rateLimitService "r2-notify-server/services/ratelimit"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 )
 
 type NotificationController struct {
-	notificationService notificationService.NotificationService
+	ingestionService ingestionService.IngestionService
 }
 
 // NewNotificationController returns a new instance of NotificationController.
-// It requires a notificationService to be injected for its dependencies.
-func NewNotificationController(service notificationService.NotificationService) *NotificationController {
-	return &NotificationController{notificationService: service}
+// It requires an ingestionService to be injected for its dependencies.
+func NewNotificationController(service ingestionService.IngestionService) *NotificationController {
+	return &NotificationController{ingestionService: service}
 }
 
 // CreateNotification creates a new notification based on the payload in the request body.
 // The request must include the X-User-ID and X-App-ID headers.
 // The request body must include the groupKey, message, and status.
 // The notification will be sent to the user with the given user ID.
-// The response will include the newly created notification.
+// The notification goes through the same ingestion pipeline as events from the message sources.
+// A CloudEvent is accepted as well, in structured mode (Content-Type application/cloudevents+json)
+// or in binary mode (ce-* headers), its type being a registered notification kind.
+// The response will include the newly created notification and the outcome of its delivery channels.
+// Notifications exceeding the rate limit of their app or recipient are rejected with 429 and a Retry-After header.
 func (controller *NotificationController) CreateNotification(ctx *gin.Context) {
 
-	authorization := ctx.GetHeader("Authorization")
+	userId := ctx.GetString(data.USER_ID)
 	appId := ctx.GetHeader("X-App-ID")
 	correlationId, _ := ctx.Get(data.CORRELATION_ID)
 
-	bearerPrefix := "Bearer "
-	if !strings.HasPrefix(authorization, bearerPrefix) {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
-	}
-
-	tokenString := strings.TrimPrefix(authorization, bearerPrefix)
-	userId, err := utils.ValidateToken(tokenString, []byte(config.LoadConfig().JwtSecret))
-	if err != nil {
-		logger.Log.Error(logger.LogPayload{
-			Component:     "NotificationController",
-			Operation:     "CreateNotification",
-			Message:       "Invalid token",
-			AppId:         appId,
-			CorrelationId: correlationId.(string),
-			Error:         err,
-		})
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
-	}
-
 	logger.Log.Debug(logger.LogPayload{
 		Component:     "NotificationController",
 		Operation:     "CreateNotification",
@@ -81,7 +64,12 @@ func (controller *NotificationController) CreateNotification(ctx *gin.Context) {
 		return
 	}
 
-	var payload data.CreateNotificationRequest
+	if event, ok, err := bindCloudEvent(ctx); ok {
+		controller.createFromCloudEvent(ctx, event, err, userId, appId, correlationId.(string))
+		return
+	}
+
+	payload := data.CreateNotificationRequest{Priority: data.PRIORITY_NORMAL}
 	if err := ctx.ShouldBindJSON(&payload); err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component:     "NotificationController",
@@ -96,25 +84,32 @@ func (controller *NotificationController) CreateNotification(ctx *gin.Context) {
 		return
 	}
 
-	if err := validator.New().Struct(payload); err != nil {
+	notification, err := controller.ingestionService.Ingest(data.EventHubNotificationPayload{
+		AppId:     appId,
+		UserId:    userId,
+		GroupKey:  payload.GroupKey,
+		Title:     payload.Title,
+		ActionUrl: payload.ActionUrl,
+		Message:   payload.Message,
+		Status:    payload.Status,
+		Priority:  payload.Priority,
+		Channels:  payload.Channels,
+		TenantId:  ctx.GetString(data.TENANT_ID),
+	}, data.SOURCE_REST, correlationId.(string))
+	if errors.Is(err, ingestionService.ErrInvalidNotification) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	m := models.Notification{
-		UserId:     userId,
-		AppId:      appId,
-		GroupKey:   payload.GroupKey,
-		Message:    payload.Message,
-		Status:     payload.Status,
-		ReadStatus: false,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	if errors.Is(err, ingestionService.ErrTenantMismatch) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	var limited *rateLimitService.LimitExceededError
+	if errors.As(err, &limited) {
+		ctx.Header("Retry-After", strconv.Itoa(limited.RetryAfterSeconds()))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
 	}
-
-	recordId, err := controller.notificationService.Create(m)
-	m.Id = recordId
-
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component:     "NotificationController",
@@ -132,33 +127,90 @@ func (controller *NotificationController) CreateNotification(ctx *gin.Context) {
 	logger.Log.Debug(logger.LogPayload{
 		Component:     "NotificationController",
 		Operation:     "CreateNotification",
-		Message:       fmt.Sprintf("Notification created with payload %v", m),
+		Message:       fmt.Sprintf("Notification created with payload %v", notification),
 		UserId:        userId,
 		AppId:         appId,
 		CorrelationId: correlationId.(string),
 	})
+	ctx.JSON(http.StatusCreated, notification)
+}
 
-	logger.Log.Debug(logger.LogPayload{
-		Component:     "NotificationController",
-		Operation:     "CreateNotification",
-		Message:       "Sending notification to user",
-		UserId:        userId,
-		AppId:         appId,
-		CorrelationId: correlationId.(string),
-	})
+// createFromCloudEvent creates a notification from a CloudEvent posted to CreateNotification. The subject
+// and the source of the event default to the authenticated user and the X-App-ID header, and must match them when set.
+func (controller *NotificationController) createFromCloudEvent(ctx *gin.Context, event data.CloudEvent, bindErr error, userId string, appId string, correlationId string) {
+	if bindErr != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "NotificationController",
+			Operation:     "CreateNotification",
+			Message:       "Invalid CloudEvent",
+			UserId:        userId,
+			AppId:         appId,
+			CorrelationId: correlationId,
+			Error:         bindErr,
+		})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": bindErr.Error()})
+		return
+	}
+	if event.Subject == "" {
+		event.Subject = userId
+	}
+	if event.Source == "" {
+		event.Source = appId
+	}
+	if event.Subject != userId || event.Source != appId {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "CloudEvent subject and source must match the authenticated user and X-App-ID"})
+		return
+	}
+
+	event.TenantId = ctx.GetString(data.TENANT_ID)
+	notification, err := controller.ingestionService.IngestCloudEvent(event, data.SOURCE_REST, correlationId)
+	if errors.Is(err, ingestionService.ErrInvalidNotification) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ingestionService.ErrTenantMismatch) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	var limited *rateLimitService.LimitExceededError
+	if errors.As(err, &limited) {
+		ctx.Header("Retry-After", strconv.Itoa(limited.RetryAfterSeconds()))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "NotificationController",
+			Operation:     "CreateNotification",
+			Message:       "Failed to create notification from CloudEvent " + event.Id,
+			UserId:        userId,
+			AppId:         appId,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, notification)
+}
 
-	clientStore.SendNotificationToUser(data.EventNotification{
-		Event: data.Event{Event: "newNotification"},
-		Data: data.Notification{
-			Id:        recordId.Hex(),
-			UserID:    m.UserId,
-			AppId:     m.AppId,
-			GroupKey:  m.GroupKey,
-			Message:   m.Message,
-			Status:    m.Status,
-			CreatedAt: m.CreatedAt,
-			UpdatedAt: m.UpdatedAt,
-		},
-	}, false)
-	ctx.JSON(http.StatusCreated, m)
+// bindCloudEvent reads a CloudEvent from the request, either in structured mode (an application/cloudevents+json body)
+// or in binary mode (ce-* headers with the data as body). It reports false when the request does not carry a CloudEvent.
+func bindCloudEvent(ctx *gin.Context) (event data.CloudEvent, ok bool, err error) {
+	structured := strings.HasPrefix(ctx.ContentType(), "application/cloudevents")
+	if !structured && ctx.GetHeader("ce-specversion") == "" {
+		return event, false, nil
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return event, true, err
+	}
+	if !structured {
+		headers := map[string]string{}
+		for key := range ctx.Request.Header {
+			headers[key] = ctx.Request.Header.Get(key)
+		}
+		body = ingestionService.ToStructuredCloudEvent(headers, ctx.ContentType(), body)
+	}
+	return event, true, json.Unmarshal(body, &event)
 }
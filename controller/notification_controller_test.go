@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+	kindRepository "r2-notify-server/repository/kind"
+	ingestionService "r2-notify-server/services/ingestion"
+	kindService "r2-notify-server/services/kind"
+	notificationService "r2-notify-server/services/notification"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingNotificationService records the notifications stored, with the tenant they were stored in.
+type recordingNotificationService struct {
+	notificationService.NotificationService
+	tenantId string
+	stored   *[]models.Notification
+}
+
+func (s recordingNotificationService) ForTenant(tenantId string) notificationService.NotificationService {
+	s.tenantId = tenantId
+	return s
+}
+
+func (s recordingNotificationService) As(data.AuditActor) notificationService.NotificationService {
+	return s
+}
+
+func (s recordingNotificationService) Create(notification models.Notification) (primitive.ObjectID, error) {
+	ids, err := s.CreateMany([]models.Notification{notification})
+	return ids[0], err
+}
+
+func (s recordingNotificationService) CreateMany(notifications []models.Notification) ([]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{}
+	for _, notification := range notifications {
+		notification.Id = primitive.NewObjectID()
+		notification.TenantId = s.tenantId
+		*s.stored = append(*s.stored, notification)
+		ids = append(ids, notification.Id)
+	}
+	return ids, nil
+}
+
+// recordingDeliveryService records the notifications dispatched.
+type recordingDeliveryService struct {
+	dispatched *[]data.Notification
+}
+
+func (s recordingDeliveryService) Dispatch(notification data.Notification) []data.ChannelDelivery {
+	*s.dispatched = append(*s.dispatched, notification)
+	return []data.ChannelDelivery{{Channel: data.CHANNEL_WEBSOCKET, Status: data.CHANNEL_DELIVERY_SENT}}
+}
+
+// stubKindService knows the build.failed notification kind.
+type stubKindService struct {
+	kindService.KindService
+}
+
+func (s stubKindService) FindByType(kindType string) (data.NotificationKind, error) {
+	if kindType != "build.failed" {
+		return data.NotificationKind{}, kindRepository.ErrKindNotFound
+	}
+	return data.NotificationKind{Type: kindType, GroupKey: "builds", Status: "error", Priority: data.PRIORITY_HIGH, Channels: []string{"email"}, Title: "Build failed"}, nil
+}
+
+// unlimitedRateLimitService admits every notification and event.
+type unlimitedRateLimitService struct{}
+
+func (unlimitedRateLimitService) AllowNotification(string, string, string) error {
+	return nil
+}
+
+func (unlimitedRateLimitService) AllowSocketEvent(string, string, string, string) error {
+	return nil
+}
+
+// ingestionRecorder is an ingestion service over recording services.
+type ingestionRecorder struct {
+	service    ingestionService.IngestionService
+	stored     []models.Notification
+	dispatched []data.Notification
+}
+
+func newIngestionRecorder(t *testing.T) *ingestionRecorder {
+	t.Helper()
+	recorder := &ingestionRecorder{}
+	service, err := ingestionService.NewIngestionServiceImpl(
+		recordingNotificationService{stored: &recorder.stored},
+		recordingDeliveryService{dispatched: &recorder.dispatched},
+		stubKindService{},
+		stubAppService{tenants: map[string]string{"app-b": "tenant-b"}},
+		unlimitedRateLimitService{},
+		validator.New(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.service = service
+	return recorder
+}
+
+func TestRestAndEventHubIngestTheSameWay(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		restBody    string
+		hubBody     string
+		valid       bool
+	}{
+		{
+			"notification is normalized",
+			"application/json",
+			`{"groupKey":" builds ","title":" Build failed ","message":" main is red ","status":"ERROR","priority":"HIGH","channels":["Email","email"," push"],"actionUrl":"https://ci.example/1"}`,
+			`{"appId":"app-a","userId":"u1","groupKey":" builds ","title":" Build failed ","message":" main is red ","status":"ERROR","priority":"HIGH","channels":["Email","email"," push"],"actionUrl":"https://ci.example/1"}`,
+			true,
+		},
+		{
+			"priority defaults to normal",
+			"application/json",
+			`{"groupKey":"builds","message":"main is red","status":"error"}`,
+			`{"appId":"app-a","userId":"u1","groupKey":"builds","message":"main is red","status":"error"}`,
+			true,
+		},
+		{
+			"cloud event is completed by its kind",
+			"application/cloudevents+json",
+			`{"specversion":"1.0","id":"e1","type":"build.failed","source":"app-a","subject":"u1","data":{"message":"main is red"}}`,
+			`{"specversion":"1.0","id":"e1","type":"build.failed","source":"app-a","subject":"u1","data":{"message":"main is red"}}`,
+			true,
+		},
+		{
+			"cloud event with a string as data",
+			"application/cloudevents+json",
+			`{"specversion":"1.0","id":"e1","type":"build.failed","source":"app-a","subject":"u1","data":"main is red"}`,
+			`{"specversion":"1.0","id":"e1","type":"build.failed","source":"app-a","subject":"u1","data":"main is red"}`,
+			true,
+		},
+		{
+			"missing message is rejected",
+			"application/json",
+			`{"groupKey":"builds","status":"error"}`,
+			`{"appId":"app-a","userId":"u1","groupKey":"builds","status":"error"}`,
+			false,
+		},
+		{
+			"blank message is rejected",
+			"application/json",
+			`{"groupKey":"builds","message":"   ","status":"error"}`,
+			`{"appId":"app-a","userId":"u1","groupKey":"builds","message":"   ","status":"error"}`,
+			false,
+		},
+		{
+			"unknown channel is rejected",
+			"application/json",
+			`{"groupKey":"builds","message":"main is red","status":"error","channels":["sms"]}`,
+			`{"appId":"app-a","userId":"u1","groupKey":"builds","message":"main is red","status":"error","channels":["sms"]}`,
+			false,
+		},
+		{
+			"invalid action url is rejected",
+			"application/json",
+			`{"groupKey":"builds","message":"main is red","status":"error","actionUrl":"not a url"}`,
+			`{"appId":"app-a","userId":"u1","groupKey":"builds","message":"main is red","status":"error","actionUrl":"not a url"}`,
+			false,
+		},
+		{
+			"cloud event of an unregistered kind is rejected",
+			"application/cloudevents+json",
+			`{"specversion":"1.0","id":"e1","type":"build.passed","source":"app-a","subject":"u1","data":"main is green"}`,
+			`{"specversion":"1.0","id":"e1","type":"build.passed","source":"app-a","subject":"u1","data":"main is green"}`,
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rest := newIngestionRecorder(t)
+			engine := gin.New()
+			engine.POST("/notifications", func(ctx *gin.Context) {
+				ctx.Set(data.USER_ID, "u1")
+				ctx.Set(data.TENANT_ID, "default")
+				ctx.Set(data.CORRELATION_ID, "correlation-1")
+			}, NewNotificationController(rest.service).CreateNotification)
+			request := httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(test.restBody))
+			request.Header.Set("Content-Type", test.contentType)
+			request.Header.Set("X-App-ID", "app-a")
+			response := httptest.NewRecorder()
+			engine.ServeHTTP(response, request)
+
+			hub := newIngestionRecorder(t)
+			errs := hub.service.ProcessBatch(data.SOURCE_EVENT_HUB, [][]byte{[]byte(test.hubBody)})
+
+			if !test.valid {
+				if response.Code != http.StatusBadRequest {
+					t.Fatalf("expected the REST API to reject the notification with 400, got %d: %s", response.Code, response.Body.String())
+				}
+				if len(errs) != 1 || errs[0] == nil {
+					t.Fatalf("expected the Event Hub event to be rejected, got %v", errs)
+				}
+				if len(rest.stored) != 0 || len(hub.stored) != 0 || len(rest.dispatched) != 0 || len(hub.dispatched) != 0 {
+					t.Fatal("expected nothing to be stored or dispatched")
+				}
+				return
+			}
+			if response.Code != http.StatusCreated {
+				t.Fatalf("expected the REST API to create the notification, got %d: %s", response.Code, response.Body.String())
+			}
+			if len(errs) != 1 || errs[0] != nil {
+				t.Fatalf("expected the Event Hub event to be stored, got %v", errs)
+			}
+			if len(rest.stored) != 1 || len(hub.stored) != 1 {
+				t.Fatalf("expected one notification to be stored by each entry point, got %d and %d", len(rest.stored), len(hub.stored))
+			}
+			restStored, hubStored := rest.stored[0], hub.stored[0]
+			if restStored.Source != data.SOURCE_REST || hubStored.Source != data.SOURCE_EVENT_HUB {
+				t.Fatalf("expected the notifications to be tagged with their source, got %s and %s", restStored.Source, hubStored.Source)
+			}
+			if restStored.AppId != "app-a" || restStored.UserId != "u1" || restStored.Message != "main is red" || restStored.TenantId != "default" {
+				t.Fatalf("expected the notification of u1 of app-a to be stored in the default tenant, got %+v", restStored)
+			}
+			restStored.Id, restStored.Source, restStored.CreatedAt, restStored.UpdatedAt = hubStored.Id, hubStored.Source, hubStored.CreatedAt, hubStored.UpdatedAt
+			if !reflect.DeepEqual(restStored, hubStored) {
+				t.Fatalf("expected the same notification to be stored\nREST:      %+v\nEvent Hub: %+v", restStored, hubStored)
+			}
+
+			if len(rest.dispatched) != 1 || len(hub.dispatched) != 1 {
+				t.Fatalf("expected one notification to be dispatched by each entry point, got %d and %d", len(rest.dispatched), len(hub.dispatched))
+			}
+			restDispatched, hubDispatched := rest.dispatched[0], hub.dispatched[0]
+			restDispatched.Id, restDispatched.Source, restDispatched.CreatedAt, restDispatched.UpdatedAt = hubDispatched.Id, hubDispatched.Source, hubDispatched.CreatedAt, hubDispatched.UpdatedAt
+			if !reflect.DeepEqual(restDispatched, hubDispatched) {
+				t.Fatalf("expected the same notification to be dispatched\nREST:      %+v\nEvent Hub: %+v", restDispatched, hubDispatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"r2-notify-server/data"
+	kindRepository "r2-notify-server/repository/kind"
+	kindService "r2-notify-server/services/kind"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type NotificationKindController struct {
+	kindService kindService.KindService
+}
+
+// NewNotificationKindController returns a new instance of NotificationKindController.
+// It requires a kindService to be injected for its dependencies.
+func NewNotificationKindController(service kindService.KindService) *NotificationKindController {
+	return &NotificationKindController{kindService: service}
+}
+
+// ListKinds returns the notification kinds registered for CloudEvents types.
+func (controller *NotificationKindController) ListKinds(ctx *gin.Context) {
+	kinds, err := controller.kindService.FindAll()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, kinds)
+}
+
+// SaveKind registers the notification kind of the CloudEvents type given in the path,
+// replacing the existing one.
+func (controller *NotificationKindController) SaveKind(ctx *gin.Context) {
+	var kind data.NotificationKind
+	if err := ctx.ShouldBindJSON(&kind); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	kind.Type = ctx.Param("type")
+
+	kind, err := controller.kindService.Save(kind)
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, kind)
+}
+
+// DeleteKind unregisters the notification kind of a CloudEvents type. CloudEvents of this type are rejected afterwards.
+func (controller *NotificationKindController) DeleteKind(ctx *gin.Context) {
+	err := controller.kindService.Delete(ctx.Param("type"))
+	if errors.Is(err, kindRepository.ErrKindNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
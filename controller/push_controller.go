@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	pushService "r2-notify-server/services/push"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PushController struct {
+	pushService pushService.PushService
+}
+
+// NewPushController returns a new instance of PushController.
+// It requires a pushService to be injected for its dependencies.
+func NewPushController(service pushService.PushService) *PushController {
+	return &PushController{pushService: service}
+}
+
+// GetVapidPublicKey returns the VAPID public key browsers pass as applicationServerKey
+// to PushManager.subscribe. It responds with 404 Not Found when web push is disabled.
+func (controller *PushController) GetVapidPublicKey(ctx *gin.Context) {
+	publicKey := controller.pushService.PublicKey()
+	if publicKey == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Web push is not enabled"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"publicKey": publicKey})
+}
+
+// CreateSubscription registers the browser push subscription in the request body for the
+// authenticated user, in the user's tenant. The body is the JSON form of a PushSubscription with an optional deviceId.
+func (controller *PushController) CreateSubscription(ctx *gin.Context) {
+	userId := ctx.GetString(data.USER_ID)
+	var request data.PushSubscriptionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := controller.pushService.ForTenant(ctx.GetString(data.TENANT_ID)).Subscribe(userId, request); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "PushController",
+			Operation:     "CreateSubscription",
+			Message:       "Failed to register push subscription",
+			UserId:        userId,
+			CorrelationId: ctx.GetString(data.CORRELATION_ID),
+			Error:         err,
+		})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusCreated)
+}
+
+// DeleteSubscription removes the push subscription given by the endpoint query parameter
+// from the authenticated user.
+func (controller *PushController) DeleteSubscription(ctx *gin.Context) {
+	endpoint := ctx.Query("endpoint")
+	if endpoint == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "endpoint query parameter is required"})
+		return
+	}
+	if err := controller.pushService.ForTenant(ctx.GetString(data.TENANT_ID)).Unsubscribe(ctx.GetString(data.USER_ID), endpoint); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
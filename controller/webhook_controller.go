@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	appService "r2-notify-server/services/app"
+	userService "r2-notify-server/services/user"
+	webhookService "r2-notify-server/services/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	webhookService webhookService.WebhookService
+	appService     appService.AppService
+}
+
+// NewWebhookController returns a new instance of WebhookController.
+// It requires a webhookService and an appService to be injected for its dependencies.
+// Webhooks are managed by the admins of their app, in the tenant of the app.
+func NewWebhookController(service webhookService.WebhookService, appService appService.AppService) *WebhookController {
+	return &WebhookController{webhookService: service, appService: appService}
+}
+
+// CreateSubscription registers a webhook subscription for the app given by the X-App-ID header.
+// The request body must include the url and the list of lifecycle events to subscribe to.
+// The response includes the signing secret, which is not returned by any other endpoint.
+func (controller *WebhookController) CreateSubscription(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	var request data.CreateWebhookRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	subscription, err := controller.webhookService.ForTenant(tenantId).Subscribe(appId, request)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebhookController",
+			Operation:     "CreateSubscription",
+			Message:       "Failed to create webhook subscription",
+			UserId:        ctx.GetString(data.USER_ID),
+			AppId:         appId,
+			CorrelationId: ctx.GetString(data.CORRELATION_ID),
+			Error:         err,
+		})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, subscription)
+}
+
+// ListSubscriptions returns the webhook subscriptions of the app given by the X-App-ID header.
+func (controller *WebhookController) ListSubscriptions(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	subscriptions, err := controller.webhookService.ForTenant(tenantId).FindSubscriptions(appId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, subscriptions)
+}
+
+// DeleteSubscription removes a webhook subscription of the app given by the X-App-ID header.
+func (controller *WebhookController) DeleteSubscription(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	if err := controller.webhookService.ForTenant(tenantId).Unsubscribe(appId, ctx.Param("id")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListDeliveries returns the webhook delivery log of the app given by the X-App-ID header.
+// The optional status query parameter filters deliveries by pending, succeeded or failed.
+func (controller *WebhookController) ListDeliveries(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	deliveries, err := controller.webhookService.ForTenant(tenantId).FindDeliveries(appId, ctx.Query("status"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayDelivery queues a new attempt of an existing webhook delivery.
+func (controller *WebhookController) ReplayDelivery(ctx *gin.Context) {
+	appId, tenantId, ok := requireManagedApp(ctx, controller.appService)
+	if !ok {
+		return
+	}
+	delivery, err := controller.webhookService.ForTenant(tenantId).Replay(appId, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, delivery)
+}
+
+// requireAppId reads the X-App-ID header and responds with 400 Bad Request if it is missing.
+func requireAppId(ctx *gin.Context) (string, bool) {
+	appId := ctx.GetHeader("X-App-ID")
+	if appId == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "X-App-ID header is required"})
+		return "", false
+	}
+	return appId, true
+}
+
+// requireManagedApp reads the X-App-ID header like requireAppId, and checks that the user administers
+// the app. It returns the app together with the tenant it belongs to, and responds with 403 Forbidden
+// to users who do not administer the app, or whose tenant does not own it unless they are super admins.
+// It must be used after RoleMiddleware.
+func requireManagedApp(ctx *gin.Context, appService appService.AppService) (string, string, bool) {
+	appId, ok := requireAppId(ctx)
+	if !ok {
+		return "", "", false
+	}
+	access := ctx.MustGet(data.USER_ACCESS).(data.UserAccess)
+	if !userService.ManagesApp(access, appId) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "App isn't administered by user"})
+		return "", "", false
+	}
+	tenantId, err := appService.FindTenant(appId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+	if tenantId != ctx.GetString(data.TENANT_ID) && !userService.HasRole(access, data.ROLE_SUPER_ADMIN) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "App belongs to another tenant"})
+		return "", "", false
+	}
+	return appId, tenantId, true
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	appService "r2-notify-server/services/app"
+	webhookService "r2-notify-server/services/webhook"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubAppService resolves the tenants of the apps of its map.
+type stubAppService struct {
+	appService.AppService
+	tenants map[string]string
+}
+
+func (s stubAppService) FindTenant(appId string) (string, error) {
+	if tenantId, ok := s.tenants[appId]; ok {
+		return tenantId, nil
+	}
+	return "default", nil
+}
+
+// recordingWebhookService records the tenant its subscriptions are listed in.
+type recordingWebhookService struct {
+	webhookService.WebhookService
+	tenantId string
+	listed   *[]string
+}
+
+func (s recordingWebhookService) ForTenant(tenantId string) webhookService.WebhookService {
+	s.tenantId = tenantId
+	return s
+}
+
+func (s recordingWebhookService) FindSubscriptions(appId string) ([]data.WebhookSubscription, error) {
+	*s.listed = append(*s.listed, s.tenantId+":"+appId)
+	return []data.WebhookSubscription{}, nil
+}
+
+func TestWebhookSubscriptionsRequireAnAdminOfTheApp(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenantId string
+		access   data.UserAccess
+		appId    string
+		status   int
+		listed   string
+	}{
+		{"app admin of the app", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-a"}}, "app-a", http.StatusOK, "default:app-a"},
+		{"app admin of another app", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-a"}}, "app-c", http.StatusForbidden, ""},
+		{"user listing the app", "default", data.UserAccess{UserId: "u2", Roles: []string{data.ROLE_USER}, Apps: []string{"app-a"}}, "app-a", http.StatusForbidden, ""},
+		{"app admin of an app of another tenant", "default", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-b"}}, "app-b", http.StatusForbidden, ""},
+		{"app admin in the tenant of the app", "tenant-b", data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_USER, data.ROLE_APP_ADMIN}, Apps: []string{"app-b"}}, "app-b", http.StatusOK, "tenant-b:app-b"},
+		{"super admin of another tenant", "default", data.UserAccess{UserId: "root", Roles: []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}}, "app-b", http.StatusOK, "tenant-b:app-b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var listed []string
+			controller := NewWebhookController(
+				recordingWebhookService{listed: &listed},
+				stubAppService{tenants: map[string]string{"app-b": "tenant-b"}},
+			)
+			engine := gin.New()
+			engine.GET("/webhooks", func(ctx *gin.Context) {
+				ctx.Set(data.TENANT_ID, test.tenantId)
+				ctx.Set(data.USER_ACCESS, test.access)
+			}, controller.ListSubscriptions)
+
+			request := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+			request.Header.Set("X-App-ID", test.appId)
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+			if test.listed == "" && len(listed) != 0 {
+				t.Fatalf("expected no subscriptions to be listed, listed %v", listed)
+			}
+			if test.listed != "" && (len(listed) != 1 || listed[0] != test.listed) {
+				t.Fatalf("expected the subscriptions of %s to be listed, listed %v", test.listed, listed)
+			}
+		})
+	}
+}
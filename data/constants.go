@@ -10,6 +10,14 @@ const (
 	NEW_NOTIFICATION    = "newNotification"
 	LIST_NOTIFICATIONS  = "listNotifications"
 	LIST_CONFIGURATIONS = "listConfigurations"
+
+	// Token events
+	TOKEN_EXPIRING          = "tokenExpiring"
+	REAUTHENTICATED         = "reauthenticated"
+	REAUTHENTICATION_FAILED = "reauthenticationFailed"
+
+	// Error events
+	ERROR_EVENT = "error"
 )
 
 // Notification event types
@@ -27,8 +35,20 @@ const (
 	DELETE_NOTIFICATION        = "deleteNotification"
 
 	// Other events
-	RELOAD_NOTIFICATIONS    = "reloadNotifications"
-	SET_NOTIFICATION_STATUS = "setNotificationStatus"
+	RELOAD_NOTIFICATIONS           = "reloadNotifications"
+	SET_NOTIFICATION_STATUS        = "setNotificationStatus"
+	SET_EMAIL_NOTIFICATION_STATUS  = "setEmailNotificationStatus"
+	SET_DIGEST_NOTIFICATION_STATUS = "setDigestNotificationStatus"
+	SET_CHAT_TARGETS               = "setChatTargets"
+	REGISTER_PUSH_SUBSCRIPTION     = "registerPushSubscription"
+	REAUTHENTICATE                 = "reauthenticate"
+)
+
+// Notification priorities
+const (
+	PRIORITY_LOW    = "low"
+	PRIORITY_NORMAL = "normal"
+	PRIORITY_HIGH   = "high"
 )
 
 const (
@@ -45,3 +65,105 @@ const (
 )
 
 const CORRELATION_ID = "correlationId"
+const USER_ID = "userId"
+const TOKEN_CLAIMS = "tokenClaims"
+const TENANT_ID = "tenantId"
+const USER_ACCESS = "userAccess"
+
+// Webhook lifecycle events
+const (
+	WEBHOOK_EVENT_CREATED    = "notification.created"
+	WEBHOOK_EVENT_DELIVERED  = "notification.delivered"
+	WEBHOOK_EVENT_READ       = "notification.read"
+	WEBHOOK_EVENT_DELETED    = "notification.deleted"
+	WEBHOOK_EVENT_DISPATCHED = "notification.dispatched"
+)
+
+// Webhook payload formats
+const (
+	WEBHOOK_FORMAT_R2          = "r2"
+	WEBHOOK_FORMAT_CLOUDEVENTS = "cloudevents"
+)
+
+// Webhook delivery statuses
+const (
+	WEBHOOK_DELIVERY_PENDING   = "pending"
+	WEBHOOK_DELIVERY_SUCCEEDED = "succeeded"
+	WEBHOOK_DELIVERY_FAILED    = "failed"
+)
+
+// Delivery channels
+const (
+	CHANNEL_WEBSOCKET = "websocket"
+	CHANNEL_EMAIL     = "email"
+	CHANNEL_PUSH      = "push"
+	CHANNEL_WEBHOOK   = "webhook"
+	CHANNEL_CHAT      = "chat"
+)
+
+// Chat target types
+const (
+	CHAT_TARGET_SLACK = "slack"
+	CHAT_TARGET_TEAMS = "teams"
+)
+
+// Chat delivery statuses
+const (
+	CHAT_DELIVERY_PENDING   = "pending"
+	CHAT_DELIVERY_SUCCEEDED = "succeeded"
+	CHAT_DELIVERY_FAILED    = "failed"
+)
+
+// Channel routing
+const (
+	ROUTE_PRIMARY    = "primary"
+	ROUTE_FALLBACK   = "fallback"
+	ROUTE_ON_REQUEST = "onRequest"
+)
+
+// Channel delivery statuses
+const (
+	CHANNEL_DELIVERY_SENT    = "sent"
+	CHANNEL_DELIVERY_SKIPPED = "skipped"
+	CHANNEL_DELIVERY_FAILED  = "failed"
+)
+
+// Dead letter statuses
+const (
+	DEAD_LETTER_PENDING  = "pending"
+	DEAD_LETTER_REJECTED = "rejected"
+	DEAD_LETTER_FAILED   = "failed"
+	DEAD_LETTER_RESOLVED = "resolved"
+)
+
+// Ingestion sources
+const (
+	SOURCE_REST      = "rest"
+	SOURCE_EVENT_HUB = "eventHub"
+	SOURCE_KAFKA     = "kafka"
+	SOURCE_REDIS     = "redisStream"
+	SOURCE_RABBITMQ  = "rabbitMq"
+)
+
+// Rate limit overflow behaviours of the message sources
+const (
+	RATE_LIMIT_OVERFLOW_DEAD_LETTER = "deadLetter"
+	RATE_LIMIT_OVERFLOW_DROP        = "drop"
+	RATE_LIMIT_OVERFLOW_DELAY       = "delay"
+)
+
+// User roles
+const (
+	ROLE_USER        = "user"
+	ROLE_APP_ADMIN   = "app-admin"
+	ROLE_SUPPORT     = "support"
+	ROLE_SUPER_ADMIN = "super-admin"
+)
+
+// Audit actor types
+const (
+	AUDIT_ACTOR_USER    = "user"
+	AUDIT_ACTOR_SERVICE = "service"
+	AUDIT_ACTOR_ADMIN   = "admin"
+	AUDIT_ACTOR_SYSTEM  = "system"
+)
@@ -1,27 +1,50 @@
 package data
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type EventHubNotificationPayload struct {
-	AppId    string `validate:"required" json:"appId"`
-	UserId   string `validate:"required" json:"userId"`
-	GroupKey string `validate:"required" json:"groupKey"`
-	Message  string `validate:"required" json:"message"`
-	Status   string `validate:"required" json:"status"`
+	AppId     string   `validate:"required" json:"appId"`
+	UserId    string   `validate:"required" json:"userId"`
+	GroupKey  string   `validate:"required" json:"groupKey"`
+	Message   string   `validate:"required" json:"message"`
+	Status    string   `validate:"required" json:"status"`
+	Priority  string   `validate:"omitempty,oneof=low normal high" json:"priority"`
+	Channels  []string `validate:"omitempty,dive,oneof=websocket email push webhook chat" json:"channels"`
+	Title     string   `json:"title"`
+	ActionUrl string   `validate:"omitempty,url" json:"actionUrl"`
+	// TenantId is the tenant of the caller of the REST API, which must own the app. It is empty for
+	// the message sources, whose notifications belong to the tenant of their app.
+	TenantId string `json:"-"`
 }
 
 type Notification struct {
-	Id         string    `json:"id"`
-	AppId      string    `json:"appId"`
-	UserID     string    `json:"userId"`
-	GroupKey   string    `json:"groupKey"`
-	Message    string    `json:"message"`
-	ReadStatus bool      `json:"readStatus"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"createdAt"`
-	UpdatedAt  time.Time `json:"updatedAt"`
+	Id          string            `json:"id"`
+	TenantId    string            `json:"-"`
+	AppId       string            `json:"appId"`
+	UserID      string            `json:"userId"`
+	GroupKey    string            `json:"groupKey"`
+	Title       string            `json:"title,omitempty"`
+	Message     string            `json:"message"`
+	ActionUrl   string            `json:"actionUrl,omitempty"`
+	ReadStatus  bool              `json:"readStatus"`
+	Status      string            `json:"status"`
+	Priority    string            `json:"priority"`
+	Channels    []string          `json:"channels,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Deliveries  []ChannelDelivery `json:"deliveries,omitempty"`
+	DeliveredAt *time.Time        `json:"deliveredAt,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+type ChannelDelivery struct {
+	Channel     string    `json:"channel"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attemptedAt"`
 }
 
 type NotificationStatusUpdate struct {
@@ -46,9 +69,24 @@ type NotificationList struct {
 }
 
 type NotificationConfig struct {
-	Id                 string `json:"id"`
-	UserID             string `json:"userId"`
-	EnableNotification bool   `json:"enableNotification"`
+	Id                 string       `json:"id"`
+	UserID             string       `json:"userId"`
+	EnableNotification bool         `json:"enableNotification"`
+	Email              string       `json:"email,omitempty"`
+	EmailNotification  bool         `json:"emailNotification"`
+	DigestNotification bool         `json:"digestNotification"`
+	DigestCursor       *time.Time   `json:"digestCursor,omitempty"`
+	ChatTargets        []ChatTarget `json:"chatTargets,omitempty"`
+}
+
+type ChatTarget struct {
+	Type     string   `validate:"required,oneof=slack teams" json:"type"`
+	Url      string   `validate:"required,url" json:"url"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+type ChatTargetsRequest struct {
+	ChatTargets []ChatTarget `validate:"dive" json:"chatTargets"`
 }
 
 type Configuration struct {
@@ -57,18 +95,254 @@ type Configuration struct {
 }
 
 type CreateNotificationRequest struct {
-	GroupKey string `validate:"required" json:"groupKey"`
-	Message  string `validate:"required" json:"message"`
-	Status   string `validate:"required" json:"status"`
+	GroupKey  string   `validate:"required" json:"groupKey"`
+	Message   string   `validate:"required" json:"message"`
+	Status    string   `validate:"required" json:"status"`
+	Priority  string   `validate:"omitempty,oneof=low normal high" json:"priority"`
+	Channels  []string `validate:"omitempty,dive,oneof=websocket email push webhook chat" json:"channels"`
+	Title     string   `json:"title"`
+	ActionUrl string   `validate:"omitempty,url" json:"actionUrl"`
 }
 
-type GoogleAuthRequest struct {
-	Token string `json:"token"`
+// AuthRequest carries the ID token issued to the user by an identity provider.
+type AuthRequest struct {
+	Token string `json:"token" binding:"required"`
 }
 
 type UserInfo struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Avatar string `json:"avatar"`
+	ID       string `json:"id"`
+	TenantId string `json:"tenantId,omitempty"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Avatar   string `json:"avatar"`
+}
+
+// RefreshRequest carries the refresh token of a login session.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// SessionTokens are the tokens issued at login and on refresh: a short-lived access token, sent
+// as the bearer token, and the refresh token that replaces it once expired.
+type SessionTokens struct {
+	Jwt          string `json:"jwt"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+type CreateWebhookRequest struct {
+	Url    string   `validate:"required,url" json:"url"`
+	Events []string `validate:"required,min=1,dive,oneof=notification.created notification.delivered notification.read notification.deleted notification.dispatched" json:"events"`
+	Secret string   `json:"secret"`
+	Format string   `validate:"omitempty,oneof=r2 cloudevents" json:"format"`
+}
+
+type WebhookSubscription struct {
+	Id        string    `json:"id"`
+	AppId     string    `json:"appId"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Format    string    `json:"format"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	AppId          string    `json:"appId"`
+	Event          string    `json:"event"`
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseCode   int       `json:"responseCode"`
+	LastError      string    `json:"lastError,omitempty"`
+	ReplayOf       string    `json:"replayOf,omitempty"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type WebhookEvent struct {
+	Id         string       `json:"id"`
+	Event      string       `json:"event"`
+	AppId      string       `json:"appId"`
+	OccurredAt time.Time    `json:"occurredAt"`
+	Data       Notification `json:"data"`
+}
+
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+type PushSubscriptionKeys struct {
+	P256dh string `validate:"required" json:"p256dh"`
+	Auth   string `validate:"required" json:"auth"`
+}
+
+type PushSubscriptionRequest struct {
+	DeviceId string               `json:"deviceId"`
+	Endpoint string               `validate:"required,url" json:"endpoint"`
+	Keys     PushSubscriptionKeys `json:"keys"`
+}
+
+type PushSubscriptionEvent struct {
+	Event
+	Data PushSubscriptionRequest `json:"data"`
+}
+
+// ReauthenticateEvent carries a fresh access token replacing the token a WebSocket connection was opened with.
+type ReauthenticateEvent struct {
+	Event
+	Data struct {
+		Token string `json:"token"`
+	} `json:"data"`
+}
+
+// TokenExpiryEvent tells the client when the token of its connection expires, ahead of the expiry
+// (tokenExpiring) and after a successful reauthentication (reauthenticated).
+type TokenExpiryEvent struct {
+	Event
+	Data struct {
+		ExpiresAt time.Time `json:"expiresAt"`
+	} `json:"data"`
+}
+
+// ErrorEvent tells the client that one of its events was not handled, along with the seconds to wait
+// before sending it again when it was rejected by a rate limit.
+type ErrorEvent struct {
+	Event
+	Data struct {
+		Event      string `json:"event"`
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retryAfter,omitempty"`
+	} `json:"data"`
+}
+
+type PushMessage struct {
+	Id        string `json:"id"`
+	AppId     string `json:"appId"`
+	GroupKey  string `json:"groupKey"`
+	Title     string `json:"title,omitempty"`
+	Message   string `json:"message"`
+	ActionUrl string `json:"actionUrl,omitempty"`
+	Status    string `json:"status"`
+	Priority  string `json:"priority"`
+}
+
+type DeadLetter struct {
+	Id             string     `json:"id"`
+	Source         string     `json:"source"`
+	Topic          string     `json:"topic,omitempty"`
+	ConsumerGroup  string     `json:"consumerGroup,omitempty"`
+	Partition      string     `json:"partition,omitempty"`
+	PartitionKey   string     `json:"partitionKey,omitempty"`
+	Offset         string     `json:"offset,omitempty"`
+	SequenceNumber int64      `json:"sequenceNumber,omitempty"`
+	EnqueuedAt     *time.Time `json:"enqueuedAt,omitempty"`
+	Body           string     `json:"body"`
+	Error          string     `json:"error"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+type CloudEvent struct {
+	SpecVersion     string          `validate:"required,eq=1.0" json:"specversion"`
+	Id              string          `validate:"required" json:"id"`
+	Type            string          `validate:"required" json:"type"`
+	Source          string          `validate:"required" json:"source"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	// TenantId is the tenant of the caller of the REST API, as for EventHubNotificationPayload.
+	TenantId string `json:"-"`
+}
+
+type CloudEventNotificationData struct {
+	GroupKey  string   `json:"groupKey"`
+	Title     string   `json:"title"`
+	Message   string   `json:"message"`
+	ActionUrl string   `json:"actionUrl"`
+	Status    string   `json:"status"`
+	Priority  string   `json:"priority"`
+	Channels  []string `json:"channels"`
+}
+
+type NotificationKind struct {
+	Type      string    `validate:"required" json:"type"`
+	GroupKey  string    `validate:"required" json:"groupKey"`
+	Status    string    `validate:"required" json:"status"`
+	Priority  string    `validate:"omitempty,oneof=low normal high" json:"priority,omitempty"`
+	Channels  []string  `validate:"omitempty,dive,oneof=websocket email push webhook chat" json:"channels,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UserAccess holds the roles of a user, and the apps administered by the user as an app-admin.
+type UserAccess struct {
+	TenantId  string    `json:"tenantId,omitempty"`
+	UserId    string    `json:"userId"`
+	Roles     []string  `validate:"dive,oneof=user app-admin support super-admin" json:"roles"`
+	Apps      []string  `validate:"dive,required" json:"apps"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// App is an application registered to send notifications, identified by its X-App-ID.
+type App struct {
+	Id          string    `validate:"required" json:"id"`
+	TenantId    string    `json:"tenantId"`
+	Name        string    `validate:"required" json:"name"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// ConnectedSession is an open WebSocket connection of a user.
+type ConnectedSession struct {
+	ConnectionId string    `json:"connectionId"`
+	TenantId     string    `json:"tenantId"`
+	UserId       string    `json:"userId"`
+	SessionId    string    `json:"sessionId,omitempty"`
+	Replica      string    `json:"replica"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+}
+
+// AuditEvent records who did what, on which scope, as part of which request.
+type AuditEvent struct {
+	Id            string            `json:"id"`
+	TenantId      string            `json:"tenantId,omitempty"`
+	ActorId       string            `json:"actorId"`
+	ActorType     string            `json:"actorType"`
+	Roles         []string          `json:"roles,omitempty"`
+	Action        string            `json:"action"`
+	Scope         map[string]string `json:"scope,omitempty"`
+	Status        int               `json:"status,omitempty"`
+	CorrelationId string            `json:"correlationId,omitempty"`
+	CreatedAt     time.Time         `json:"createdAt"`
+}
+
+// AuditActor is who a service records its mutations on behalf of: a user of a WebSocket connection,
+// a service posting notifications, or an admin.
+type AuditActor struct {
+	Id            string
+	Type          string
+	CorrelationId string
+}
+
+// AuditEventQuery filters the audit events listed by the admin API. Zero values leave a field unfiltered.
+type AuditEventQuery struct {
+	TenantId  string    `form:"tenantId"`
+	ActorId   string    `form:"actorId"`
+	ActorType string    `form:"actorType" binding:"omitempty,oneof=user service admin system"`
+	Action    string    `form:"action"`
+	From      time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
 }
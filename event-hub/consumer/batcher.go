@@ -0,0 +1,139 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	ingestionService "r2-notify-server/services/ingestion"
+	"sync"
+	"time"
+
+	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+)
+
+// partitionBatcher accumulates the events received from a partition into micro-batches, bounded by
+// EVENT_HUB_BATCH_SIZE events and EVENT_HUB_BATCH_WAIT milliseconds. A batch is stored with a single
+// write, its failed events are stored as dead letters, and only then is the partition checkpointed
+// past it. A batch that can not be committed stops the partition, which is received again from its
+// last checkpoint once leased.
+type partitionBatcher struct {
+	consumer    *EventHubConsumer
+	leaser      *partitionLeaser
+	cfg         *config.Config
+	partitionId string
+	size        int
+	wait        time.Duration
+	failed      func(error)
+	mu          sync.Mutex
+	events      []*eventhub.Event
+	generation  int
+	err         error
+}
+
+func newPartitionBatcher(consumer *EventHubConsumer, leaser *partitionLeaser, cfg *config.Config, partitionId string, failed func(error)) *partitionBatcher {
+	return &partitionBatcher{
+		consumer:    consumer,
+		leaser:      leaser,
+		cfg:         cfg,
+		partitionId: partitionId,
+		size:        max(cfg.EventHubBatchSize, 1),
+		wait:        time.Duration(cfg.EventHubBatchWait) * time.Millisecond,
+		failed:      failed,
+	}
+}
+
+// handle adds an event to the current batch, and commits the batch once full. While a batch is
+// committed, the partition waits, which holds back the hub under bursts.
+func (b *partitionBatcher) handle(ctx context.Context, event *eventhub.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		// The partition is being stopped, the event is received again from the last checkpoint.
+		return nil
+	}
+	b.events = append(b.events, event)
+	if len(b.events) == 1 {
+		generation := b.generation
+		time.AfterFunc(b.wait, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.generation == generation {
+				b.flush()
+			}
+		})
+	}
+	if len(b.events) >= b.size {
+		b.flush()
+	}
+	return nil
+}
+
+// drain commits the pending events before the partition is released.
+func (b *partitionBatcher) drain() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flush()
+}
+
+// abandon drops the pending events of a partition whose lease was lost, without committing them.
+func (b *partitionBatcher) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = nil
+	b.generation++
+	if b.err == nil {
+		b.err = errors.New("lease of partition " + b.partitionId + " was lost")
+	}
+}
+
+// flush commits the current batch. It must be called with the lock held.
+func (b *partitionBatcher) flush() {
+	events := b.events
+	b.events = nil
+	b.generation++
+	if len(events) == 0 || b.err != nil {
+		return
+	}
+	if err := b.commit(events); err != nil {
+		b.err = err
+		logger.Log.Error(logger.LogPayload{
+			Message:   fmt.Sprintf("Failed to commit a batch of %d events of partition %s", len(events), b.partitionId),
+			Component: "Azure EventHub Consumer Consumer",
+			Operation: "CommitBatch",
+			Error:     err,
+		})
+		b.failed(err)
+	}
+}
+
+// commit stores a batch, records its failed events as dead letters and checkpoints the partition.
+func (b *partitionBatcher) commit(events []*eventhub.Event) error {
+	bodies := make([][]byte, len(events))
+	for i, event := range events {
+		attributes := make(map[string]string, len(event.Properties))
+		for key, value := range event.Properties {
+			attributes[key] = fmt.Sprint(value)
+		}
+		bodies[i] = ingestionService.ToStructuredCloudEvent(attributes, "", event.Data)
+	}
+
+	errs := b.consumer.processor.ProcessBatch(data.SOURCE_EVENT_HUB, bodies)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if recordErr := b.consumer.deadLetterService.Record(toDeadLetter(events[i], bodies[i], b.partitionId, b.cfg), err); recordErr != nil {
+			return errors.Join(err, recordErr)
+		}
+	}
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:   fmt.Sprintf("Committed a batch of %d events of partition %s", len(events), b.partitionId),
+		Component: "Azure EventHub Consumer Consumer",
+		Operation: "CommitBatch",
+	})
+	return b.leaser.commit(b.partitionId, eventCheckpoint(events[len(events)-1]))
+}
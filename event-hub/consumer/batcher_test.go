@@ -0,0 +1,207 @@
+package consumer
+
+import (
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/models"
+	deadLetterService "r2-notify-server/services/deadletter"
+	ingestionService "r2-notify-server/services/ingestion"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+)
+
+// stubProcessor records the batches it processes, failing the events whose body is in failures.
+type stubProcessor struct {
+	ingestionService.IngestionService
+	mu       sync.Mutex
+	failures map[string]error
+	batches  [][]string
+}
+
+func (p *stubProcessor) ProcessBatch(source string, bodies [][]byte) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := make([]error, len(bodies))
+	batch := make([]string, len(bodies))
+	for i, body := range bodies {
+		batch[i] = string(body)
+		errs[i] = p.failures[string(body)]
+	}
+	p.batches = append(p.batches, batch)
+	return errs
+}
+
+func (p *stubProcessor) processed() [][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]string(nil), p.batches...)
+}
+
+// stubDeadLetterService stores dead letters in memory, or fails to store them when err is set.
+type stubDeadLetterService struct {
+	deadLetterService.DeadLetterService
+	err         error
+	deadLetters []models.DeadLetter
+}
+
+func (s *stubDeadLetterService) Record(deadLetter models.DeadLetter, cause error) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.deadLetters = append(s.deadLetters, deadLetter)
+	return nil
+}
+
+// batcherFixture is a batcher of partition 0 leased from an in-memory lease repository.
+type batcherFixture struct {
+	batcher     *partitionBatcher
+	processor   *stubProcessor
+	deadLetters *stubDeadLetterService
+	leases      *memoryLeaseRepository
+	leaser      *partitionLeaser
+	failures    chan error
+}
+
+func newBatcherFixture(t *testing.T, size int, wait time.Duration) *batcherFixture {
+	t.Helper()
+	fixture := &batcherFixture{
+		processor:   &stubProcessor{failures: map[string]error{}},
+		deadLetters: &stubDeadLetterService{},
+		leases:      newMemoryLeaseRepository(),
+		failures:    make(chan error, 1),
+	}
+	fixture.leaser = newPartitionLeaser(fixture.leases, "host-a", "notifications", "$Default", time.Minute)
+	if _, _, err := fixture.leaser.balance([]string{"0"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{EventHubNotificationEventName: "notifications", EventHubConsumerGroup: "$Default", EventHubBatchSize: size, EventHubBatchWait: int(wait / time.Millisecond)}
+	consumer := NewEventHubConsumer(fixture.processor, fixture.deadLetters, fixture.leases)
+	fixture.batcher = newPartitionBatcher(consumer, fixture.leaser, cfg, "0", func(err error) { fixture.failures <- err })
+	return fixture
+}
+
+// receive hands the events with the given offsets to the batcher, the body of an event being its offset.
+func (f *batcherFixture) receive(t *testing.T, offsets ...int64) {
+	t.Helper()
+	for _, offset := range offsets {
+		event := eventhub.NewEventFromString(strconv.FormatInt(offset, 10))
+		event.SystemProperties = &eventhub.SystemProperties{Offset: &offset, SequenceNumber: &offset}
+		if err := f.batcher.handle(t.Context(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkpoint returns the offset stored for the partition.
+func (f *batcherFixture) checkpoint() string {
+	lease, _ := f.leases.FindLease(f.leaser.leaseId("0"))
+	return lease.Checkpoint.Offset
+}
+
+func TestBatchIsCommittedOnceFull(t *testing.T) {
+	fixture := newBatcherFixture(t, 3, time.Minute)
+
+	fixture.receive(t, 1, 2)
+	if batches := fixture.processor.processed(); len(batches) != 0 || fixture.checkpoint() != "" {
+		t.Fatalf("expected a partial batch to wait, got %v checkpointed at %q", batches, fixture.checkpoint())
+	}
+	fixture.receive(t, 3, 4)
+	if batches := fixture.processor.processed(); len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected one batch of 3 events, got %v", batches)
+	}
+	if checkpoint := fixture.checkpoint(); checkpoint != "3" {
+		t.Fatalf("expected the partition to be checkpointed past the batch, got %q", checkpoint)
+	}
+}
+
+func TestBatchIsCommittedAfterTheWait(t *testing.T) {
+	fixture := newBatcherFixture(t, 100, 20*time.Millisecond)
+
+	fixture.receive(t, 1, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for fixture.checkpoint() != "2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the batch to be committed after the wait, got %v", fixture.processor.processed())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if batches := fixture.processor.processed(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 events, got %v", batches)
+	}
+}
+
+func TestCheckpointIsHeldBackUntilFailedEventsAreDeadLettered(t *testing.T) {
+	tests := []struct {
+		name           string
+		deadLetterErr  error
+		checkpoint     string
+		deadLetters    int
+		expectFailures bool
+	}{
+		{"events failing to be stored are dead lettered", nil, "3", 1, false},
+		{"events failing to be dead lettered stop the partition", errors.New("mongo is down"), "", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := newBatcherFixture(t, 3, time.Minute)
+			// The second notification of the batch is not stored by CreateMany.
+			fixture.processor.failures["2"] = errors.New("write conflict")
+			fixture.deadLetters.err = test.deadLetterErr
+
+			fixture.receive(t, 1, 2, 3)
+			if checkpoint := fixture.checkpoint(); checkpoint != test.checkpoint {
+				t.Fatalf("expected the partition to be checkpointed at %q, got %q", test.checkpoint, checkpoint)
+			}
+			if len(fixture.deadLetters.deadLetters) != test.deadLetters {
+				t.Fatalf("expected %d dead letters, got %+v", test.deadLetters, fixture.deadLetters.deadLetters)
+			}
+			if test.deadLetters > 0 && fixture.deadLetters.deadLetters[0].Offset != "2" {
+				t.Fatalf("expected the failed event to be dead lettered, got %+v", fixture.deadLetters.deadLetters[0])
+			}
+			select {
+			case err := <-fixture.failures:
+				if !test.expectFailures {
+					t.Fatalf("expected the partition to go on, got %v", err)
+				}
+			default:
+				if test.expectFailures {
+					t.Fatal("expected the partition to be stopped")
+				}
+			}
+
+			// A stopped partition ignores the events it still receives, they are received again
+			// from the last checkpoint.
+			fixture.receive(t, 4, 5, 6)
+			fixture.batcher.drain()
+			if test.expectFailures && (len(fixture.processor.processed()) != 1 || fixture.checkpoint() != "") {
+				t.Fatalf("expected no batch after the failure, got %v", fixture.processor.processed())
+			}
+		})
+	}
+}
+
+func TestPendingEventsOnShutdown(t *testing.T) {
+	t.Run("drain commits the pending events", func(t *testing.T) {
+		fixture := newBatcherFixture(t, 100, time.Minute)
+		fixture.receive(t, 1, 2)
+		fixture.batcher.drain()
+		if checkpoint := fixture.checkpoint(); checkpoint != "2" {
+			t.Fatalf("expected the pending events to be checkpointed, got %q", checkpoint)
+		}
+	})
+
+	t.Run("abandon drops the pending events of a lost lease", func(t *testing.T) {
+		fixture := newBatcherFixture(t, 2, time.Minute)
+		fixture.receive(t, 1)
+		fixture.batcher.abandon()
+		fixture.receive(t, 2, 3)
+		fixture.batcher.drain()
+		if batches := fixture.processor.processed(); len(batches) != 0 || fixture.checkpoint() != "" {
+			t.Fatalf("expected the abandoned events not to be committed, got %v", batches)
+		}
+	})
+}
@@ -4,139 +4,221 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"os"
 	"r2-notify-server/config"
 	"r2-notify-server/data"
+	"r2-notify-server/ingestion"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
-	clientStore "r2-notify-server/services"
-	notificationService "r2-notify-server/services/notification"
-	"r2-notify-server/utils"
+	leaseRepository "r2-notify-server/repository/lease"
+	deadLetterService "r2-notify-server/services/deadletter"
+	ingestionService "r2-notify-server/services/ingestion"
+	"strconv"
 	"time"
 
 	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+	"github.com/Azure/azure-event-hubs-go/v3/persist"
 )
 
-// StartEventHubConsumer starts the Event Hub consumer for notification events.
-// It starts a goroutine for each partition in the Event Hub and reads the events from the partition.
-// For each event received, it creates a notification record in the database and sends the notification to the connected client web socket.
-func StartEventHubConsumer(ctx context.Context, notificationService notificationService.NotificationService) error {
+// EventHubConsumer is the ingestion source consuming notification events from Azure Event Hubs.
+type EventHubConsumer struct {
+	ingestion.Lifecycle
+	processor         ingestionService.IngestionService
+	deadLetterService deadLetterService.DeadLetterService
+	leaseRepository   leaseRepository.LeaseRepository
+}
+
+// partitionReceiver is a partition received from by this replica.
+type partitionReceiver struct {
+	partitionId string
+	handle      *eventhub.ListenerHandle
+	batcher     *partitionBatcher
+}
+
+// NewEventHubConsumer returns a new instance of EventHubConsumer.
+// It requires a processor, a deadLetterService and a leaseRepository to be injected for its dependencies.
+func NewEventHubConsumer(processor ingestionService.IngestionService, deadLetterService deadLetterService.DeadLetterService, leaseRepository leaseRepository.LeaseRepository) *EventHubConsumer {
+	return &EventHubConsumer{processor: processor, deadLetterService: deadLetterService, leaseRepository: leaseRepository}
+}
 
+func (t *EventHubConsumer) Name() string {
+	return data.SOURCE_EVENT_HUB
+}
+
+// Start runs the Event Hub consumer for notification events until it is stopped.
+// Partitions are balanced between the replicas sharing the consumer group through leases stored by the lease repository.
+// The events of every partition are processed in micro-batches, and the position of a partition is checkpointed once
+// a batch is stored, so that a restarted consumer resumes after the last committed batch.
+// Events that fail to be processed are stored as dead letters.
+func (t *EventHubConsumer) Start(ctx context.Context) error {
+
+	ctx = t.Begin(ctx)
 	cfg := config.LoadConfig()
 
-	if !cfg.EnableEventHub {
-		logger.Log.Info(logger.LogPayload{
-			Message:   "Event Hub consumer is disabled",
-			Component: "Azure EventHub Consumer Consumer",
-			Operation: "StartEventHubConsumer",
-		})
-		return nil
-	}
+	leaseDuration := time.Duration(cfg.EventHubLeaseDuration) * time.Second
+	leaser := newPartitionLeaser(t.leaseRepository, consumerName(), cfg.EventHubNotificationEventName, cfg.EventHubConsumerGroup, leaseDuration)
 
 	connectionString := fmt.Sprintf("%s;EntityPath=%s", cfg.EventHubNameSpaceConString, cfg.EventHubNotificationEventName)
-
-	hub, err := eventhub.NewHubFromConnectionString(connectionString)
+	hub, err := eventhub.NewHubFromConnectionString(connectionString, eventhub.HubWithOffsetPersistence(leaser))
 	if err != nil {
 		return fmt.Errorf("failed to connect to Event Hub: %w", err)
 	}
+	defer hub.Close(context.Background())
+
+	info, err := hub.GetRuntimeInformation(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read Event Hub partitions: %w", err)
+	}
 	logger.Log.Debug(logger.LogPayload{
-		Message:   "Connected to Event Hub",
+		Message:   fmt.Sprintf("Connected to Event Hub as %s in consumer group %s with %d partitions", leaser.owner, cfg.EventHubConsumerGroup, len(info.PartitionIDs)),
 		Component: "Azure EventHub Consumer Consumer",
 		Operation: "StartEventHubConsumer",
 	})
 
-	// Default consumer group
-	runtimeInfo, err := hub.GetRuntimeInformation(ctx)
-	if err != nil {
-		return err
-	}
-
-	for _, partitionID := range runtimeInfo.PartitionIDs {
-		go func(pid string) {
-			hub.Receive(ctx, pid, func(ctx context.Context, event *eventhub.Event) error {
-
-				correlationId := utils.GenerateUUID()
-
-				logger.Log.Debug(logger.LogPayload{
-					Message:       fmt.Sprintf("Received event from Event Hub %s", string(event.Data)),
-					Component:     "Azure EventHub Consumer Consumer",
-					Operation:     "OnEventReceived",
-					CorrelationId: correlationId,
+	receivers := map[string]*partitionReceiver{}
+	stopped := make(chan *partitionReceiver)
+	ticker := time.NewTicker(max(leaseDuration/3, time.Second))
+	defer ticker.Stop()
+
+	for {
+		acquired, lost, err := leaser.balance(info.PartitionIDs)
+		t.SetHealth(err)
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Message:   "Failed to balance Event Hub partitions",
+				Component: "Azure EventHub Consumer Consumer",
+				Operation: "BalancePartitions",
+				Error:     err,
+			})
+		}
+		for _, partitionId := range lost {
+			if receiver, ok := receivers[partitionId]; ok {
+				delete(receivers, partitionId)
+				receiver.batcher.abandon()
+				receiver.handle.Close(context.Background())
+			}
+		}
+		for _, lease := range acquired {
+			receiver, err := t.receive(ctx, hub, leaser, cfg, lease, stopped)
+			if err != nil {
+				logger.Log.Error(logger.LogPayload{
+					Message:   "Failed to receive from Event Hub partition " + lease.PartitionId,
+					Component: "Azure EventHub Consumer Consumer",
+					Operation: "ReceivePartition",
+					Error:     err,
 				})
+				leaser.release(lease.PartitionId)
+				continue
+			}
+			receivers[lease.PartitionId] = receiver
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(logger.LogPayload{
+				Message:   "Shutting down event hub consumer",
+				Component: "Azure EventHub Consumer Consumer",
+				Operation: "Shutdown EventHub Consumer",
+			})
+			for partitionId, receiver := range receivers {
+				receiver.handle.Close(context.Background())
+				receiver.batcher.drain()
+				leaser.release(partitionId)
+			}
+			return nil
+		case receiver := <-stopped:
+			// A partition stopped by a failed batch or a closed link is released, and received
+			// again from its last checkpoint by whichever replica leases it next.
+			if receivers[receiver.partitionId] == receiver {
+				delete(receivers, receiver.partitionId)
+				receiver.batcher.abandon()
+				receiver.handle.Close(context.Background())
+				leaser.release(receiver.partitionId)
+			}
+		case <-ticker.C:
+		}
+	}
+}
 
-				var eventData data.EventHubNotificationPayload
-				if err := json.Unmarshal(event.Data, &eventData); err != nil {
-					logger.Log.Error(logger.LogPayload{
-						Message:       "Invalid message format",
-						Component:     "Azure EventHub Consumer Consumer",
-						Operation:     "OnEventReceived",
-						Error:         err,
-						CorrelationId: correlationId,
-					})
-					return nil
-				}
-				// Prepare notification model
-				m := models.Notification{
-					UserId:     eventData.UserId,
-					AppId:      eventData.AppId,
-					GroupKey:   eventData.GroupKey,
-					Message:    eventData.Message,
-					Status:     eventData.Status,
-					ReadStatus: false,
-					CreatedAt:  time.Now(),
-					UpdatedAt:  time.Now(),
-				}
-
-				// Create notification record in database
-				recordId, err := notificationService.Create(m)
-				if err != nil {
-					logger.Log.Error(logger.LogPayload{
-						Message:       "Notification entry insert error",
-						Component:     "Azure EventHub Consumer",
-						Operation:     "OnEventReceived",
-						Error:         err,
-						CorrelationId: correlationId,
-					})
-					return nil
-				}
-
-				// Send Notification to connected client web socket
-				payload := data.EventNotification{
-					Event: data.Event{Event: data.NEW_NOTIFICATION},
-					Data: data.Notification{
-						Id:        recordId.Hex(),
-						UserID:    eventData.UserId,
-						AppId:     eventData.AppId,
-						GroupKey:  eventData.GroupKey,
-						Message:   eventData.Message,
-						Status:    eventData.Status,
-						CreatedAt: m.CreatedAt,
-						UpdatedAt: m.UpdatedAt,
-					},
-				}
-				m.Id = recordId
-				clientStore.SendNotificationToUser(payload, false)
-
-				logger.Log.Info(logger.LogPayload{
-					Message:       fmt.Sprintf("Sending notification to user %v", m),
-					Component:     "Azure EventHub Consumer",
-					Operation:     "OnEventReceived",
-					CorrelationId: correlationId,
-				})
+// receive starts receiving the events of a leased partition from its last checkpoint.
+func (t *EventHubConsumer) receive(ctx context.Context, hub *eventhub.Hub, leaser *partitionLeaser, cfg *config.Config, lease models.PartitionLease, stopped chan<- *partitionReceiver) (*partitionReceiver, error) {
+	receiver := &partitionReceiver{partitionId: lease.PartitionId}
+	notify := func() {
+		select {
+		case stopped <- receiver:
+		case <-ctx.Done():
+		}
+	}
+	receiver.batcher = newPartitionBatcher(t, leaser, cfg, lease.PartitionId, func(error) { go notify() })
 
-				return nil
-			}, eventhub.ReceiveWithLatestOffset())
-		}(partitionID)
+	handle, err := hub.Receive(ctx, lease.PartitionId, receiver.batcher.handle,
+		eventhub.ReceiveWithConsumerGroup(cfg.EventHubConsumerGroup),
+		eventhub.ReceiveWithEpoch(lease.Epoch),
+		eventhub.ReceiveWithPrefetchCount(uint32(max(cfg.EventHubBatchSize, 1))),
+	)
+	if err != nil {
+		return nil, err
 	}
+	receiver.handle = handle
+	go func() {
+		<-handle.Done()
+		notify()
+	}()
 
-	<-ctx.Done()
-	logger.Log.Info(logger.LogPayload{
-		Message:   "Shutting down event hub consumer",
+	logger.Log.Debug(logger.LogPayload{
+		Message:   fmt.Sprintf("Receiving from Event Hub partition %s with epoch %d", lease.PartitionId, lease.Epoch),
 		Component: "Azure EventHub Consumer Consumer",
-		Operation: "Shutdown EventHub Consumer",
+		Operation: "ReceivePartition",
 	})
-	hub.Close(context.Background())
+	return receiver, nil
+}
 
-	return nil
+// toDeadLetter captures the body and the position of a failed event.
+func toDeadLetter(event *eventhub.Event, body []byte, partitionId string, cfg *config.Config) models.DeadLetter {
+	checkpoint := eventCheckpoint(event)
+	deadLetter := models.DeadLetter{
+		Source:         data.SOURCE_EVENT_HUB,
+		Topic:          cfg.EventHubNotificationEventName,
+		ConsumerGroup:  cfg.EventHubConsumerGroup,
+		Partition:      partitionId,
+		Offset:         checkpoint.Offset,
+		SequenceNumber: checkpoint.SequenceNumber,
+		Body:           string(body),
+	}
+	if !checkpoint.EnqueueTime.IsZero() {
+		deadLetter.EnqueuedAt = &checkpoint.EnqueueTime
+	}
+	if event.PartitionKey != nil {
+		deadLetter.PartitionKey = *event.PartitionKey
+	}
+	return deadLetter
+}
+
+// eventCheckpoint returns the position of a received event, read from the system properties the
+// hub decodes from the annotations of the event.
+func eventCheckpoint(event *eventhub.Event) persist.Checkpoint {
+	var checkpoint persist.Checkpoint
+	if event.SystemProperties == nil {
+		return checkpoint
+	}
+	if event.SystemProperties.Offset != nil {
+		checkpoint.Offset = strconv.FormatInt(*event.SystemProperties.Offset, 10)
+	}
+	if event.SystemProperties.SequenceNumber != nil {
+		checkpoint.SequenceNumber = *event.SystemProperties.SequenceNumber
+	}
+	if event.SystemProperties.EnqueuedTime != nil {
+		checkpoint.EnqueueTime = *event.SystemProperties.EnqueuedTime
+	}
+	return checkpoint
+}
+
+// consumerName identifies the replica as the owner of partition leases.
+func consumerName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "r2-notify-server"
+	}
+	return hostname + "-" + strconv.Itoa(os.Getpid())
 }
@@ -0,0 +1,34 @@
+package consumer
+
+import (
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"testing"
+	"time"
+
+	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+)
+
+func TestToDeadLetterCapturesThePositionOfTheEvent(t *testing.T) {
+	offset, sequenceNumber, enqueuedAt, partitionKey := int64(4096), int64(12), time.Now().Add(-time.Minute), "u1"
+	event := eventhub.NewEventFromString(`{"message":"main is red"}`)
+	event.PartitionKey = &partitionKey
+	event.SystemProperties = &eventhub.SystemProperties{Offset: &offset, SequenceNumber: &sequenceNumber, EnqueuedTime: &enqueuedAt}
+	cfg := &config.Config{EventHubNotificationEventName: "notifications", EventHubConsumerGroup: "$Default"}
+
+	deadLetter := toDeadLetter(event, event.Data, "3", cfg)
+	if deadLetter.Source != data.SOURCE_EVENT_HUB || deadLetter.Topic != "notifications" || deadLetter.ConsumerGroup != "$Default" || deadLetter.Partition != "3" {
+		t.Fatalf("expected the dead letter to name the partition of the event, got %+v", deadLetter)
+	}
+	if deadLetter.Offset != "4096" || deadLetter.SequenceNumber != 12 || deadLetter.EnqueuedAt == nil || !deadLetter.EnqueuedAt.Equal(enqueuedAt) {
+		t.Fatalf("expected the dead letter to hold the position of the event, got %+v", deadLetter)
+	}
+	if deadLetter.PartitionKey != "u1" || deadLetter.Body != `{"message":"main is red"}` {
+		t.Fatalf("expected the dead letter to hold the key and body of the event, got %+v", deadLetter)
+	}
+
+	// Events without system properties have no position.
+	if checkpoint := eventCheckpoint(eventhub.NewEventFromString("{}")); checkpoint.Offset != "" || !checkpoint.EnqueueTime.IsZero() {
+		t.Fatalf("expected no position, got %+v", checkpoint)
+	}
+}
@@ -0,0 +1,219 @@
+package consumer
+
+import (
+	"errors"
+	"math/rand"
+	"r2-notify-server/models"
+	leaseRepository "r2-notify-server/repository/lease"
+	"r2-notify-server/utils"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-event-hubs-go/v3/persist"
+)
+
+// partitionLeaser coordinates partition ownership between replicas and persists the last
+// committed position of each partition through the LeaseRepository. Partitions are balanced the
+// same way as the Event Processor Host: expired leases are acquired up to an even share, and a
+// lease is taken over from the busiest replica when the split is off by two or more.
+//
+// It is also the checkpoint persister of the hub. The hub writes the position of every event it
+// hands over, which is only kept in memory so that a recovered link resumes after the events
+// already batched; the stored checkpoint moves once a batch is committed.
+type partitionLeaser struct {
+	repository    leaseRepository.LeaseRepository
+	owner         string
+	hub           string
+	consumerGroup string
+	leaseDuration time.Duration
+	mu            sync.Mutex
+	owned         map[string]models.PartitionLease
+	received      map[string]persist.Checkpoint
+}
+
+func newPartitionLeaser(repository leaseRepository.LeaseRepository, owner string, hub string, consumerGroup string, leaseDuration time.Duration) *partitionLeaser {
+	return &partitionLeaser{
+		repository:    repository,
+		owner:         owner,
+		hub:           hub,
+		consumerGroup: consumerGroup,
+		leaseDuration: leaseDuration,
+		owned:         map[string]models.PartitionLease{},
+		received:      map[string]persist.Checkpoint{},
+	}
+}
+
+func (t *partitionLeaser) leaseId(partitionId string) string {
+	return t.hub + "/" + t.consumerGroup + "/" + partitionId
+}
+
+// balance renews the leases owned by this replica and acquires new ones. It returns the leases
+// acquired, whose partitions should be received from, and the partitions whose lease was lost.
+func (t *partitionLeaser) balance(partitionIds []string) (acquired []models.PartitionLease, lost []string, err error) {
+	for partitionId, lease := range t.ownedLeases() {
+		renewed, renewErr := t.repository.RenewLease(lease.Id, lease.Token, time.Now().Add(t.leaseDuration))
+		if renewErr != nil || !renewed {
+			t.forget(partitionId)
+			lost = append(lost, partitionId)
+		}
+	}
+
+	leases := make([]models.PartitionLease, 0, len(partitionIds))
+	for _, partitionId := range partitionIds {
+		lease, ensureErr := t.repository.EnsureLease(models.PartitionLease{
+			Id:            t.leaseId(partitionId),
+			Hub:           t.hub,
+			ConsumerGroup: t.consumerGroup,
+			PartitionId:   partitionId,
+		})
+		if ensureErr != nil {
+			return acquired, lost, ensureErr
+		}
+		leases = append(leases, lease)
+	}
+
+	owned := t.ownedLeases()
+	byOwner := map[string][]models.PartitionLease{t.owner: nil}
+	var expired []models.PartitionLease
+	for _, lease := range leases {
+		if _, ok := owned[lease.PartitionId]; ok {
+			byOwner[t.owner] = append(byOwner[t.owner], lease)
+		} else if lease.Token == "" || time.Now().After(lease.ExpiresAt) {
+			expired = append(expired, lease)
+		} else {
+			byOwner[lease.Owner] = append(byOwner[lease.Owner], lease)
+		}
+	}
+
+	share := (len(partitionIds) + len(byOwner) - 1) / len(byOwner)
+	count := len(byOwner[t.owner])
+	rand.Shuffle(len(expired), func(i, j int) { expired[i], expired[j] = expired[j], expired[i] })
+	for _, lease := range expired {
+		if count >= share {
+			break
+		}
+		if lease, err = t.acquire(lease.PartitionId); err != nil {
+			return acquired, lost, err
+		}
+		acquired = append(acquired, lease)
+		count++
+	}
+	if len(acquired) > 0 {
+		return acquired, lost, nil
+	}
+
+	var busiest []models.PartitionLease
+	for owner, leases := range byOwner {
+		if owner != t.owner && len(leases) > len(busiest) {
+			busiest = leases
+		}
+	}
+	if len(busiest)-count >= 2 {
+		lease, err := t.acquire(busiest[rand.Intn(len(busiest))].PartitionId)
+		if err != nil {
+			return acquired, lost, err
+		}
+		acquired = append(acquired, lease)
+	}
+	return acquired, lost, nil
+}
+
+// acquire takes the lease of a partition with a new token, incrementing its epoch.
+func (t *partitionLeaser) acquire(partitionId string) (models.PartitionLease, error) {
+	lease, err := t.repository.AcquireLease(t.leaseId(partitionId), t.owner, utils.GenerateUUID(), time.Now().Add(t.leaseDuration))
+	if err != nil {
+		return models.PartitionLease{}, err
+	}
+	t.mu.Lock()
+	t.owned[partitionId] = lease
+	delete(t.received, partitionId)
+	t.mu.Unlock()
+	return lease, nil
+}
+
+// release gives up the lease of a partition so that another replica can take it over immediately.
+func (t *partitionLeaser) release(partitionId string) {
+	t.mu.Lock()
+	lease, ok := t.owned[partitionId]
+	t.mu.Unlock()
+	t.forget(partitionId)
+	if ok {
+		_, _ = t.repository.ReleaseLease(lease.Id, lease.Token)
+	}
+}
+
+// commit stores the position of the last event of a committed batch. Writes of a replica that lost
+// the lease are rejected so that it can not move the position of the new owner.
+func (t *partitionLeaser) commit(partitionId string, checkpoint persist.Checkpoint) error {
+	t.mu.Lock()
+	lease, ok := t.owned[partitionId]
+	t.mu.Unlock()
+	if !ok {
+		return errors.New("lease for partition " + partitionId + " isn't owned by this replica")
+	}
+	updated, err := t.repository.UpdateCheckpoint(lease.Id, lease.Token, models.PartitionCheckpoint{
+		Offset:         checkpoint.Offset,
+		SequenceNumber: checkpoint.SequenceNumber,
+		EnqueueTime:    checkpoint.EnqueueTime,
+	})
+	if err != nil {
+		return err
+	}
+	if !updated {
+		t.forget(partitionId)
+		return errors.New("lease of partition " + partitionId + " was taken over")
+	}
+	return nil
+}
+
+// Read returns the position the hub resumes a partition from: the last event handed over when a
+// link is recovered, or else the stored checkpoint. A partition that was never processed by the
+// consumer group starts at the current time, so that a new deployment does not replay the
+// retention window of the hub.
+func (t *partitionLeaser) Read(namespace, name, consumerGroup, partitionId string) (persist.Checkpoint, error) {
+	t.mu.Lock()
+	checkpoint, ok := t.received[partitionId]
+	t.mu.Unlock()
+	if ok {
+		return checkpoint, nil
+	}
+	stored, err := t.repository.FindLease(t.leaseId(partitionId))
+	if err != nil && !errors.Is(err, leaseRepository.ErrLeaseNotFound) {
+		return persist.Checkpoint{}, err
+	}
+	if stored.Checkpoint.Offset == "" && stored.Checkpoint.EnqueueTime.IsZero() {
+		return persist.Checkpoint{EnqueueTime: time.Now()}, nil
+	}
+	return persist.Checkpoint{
+		Offset:         stored.Checkpoint.Offset,
+		SequenceNumber: stored.Checkpoint.SequenceNumber,
+		EnqueueTime:    stored.Checkpoint.EnqueueTime,
+	}, nil
+}
+
+// Write keeps the position of the last event handed over by the hub in memory.
+func (t *partitionLeaser) Write(namespace, name, consumerGroup, partitionId string, checkpoint persist.Checkpoint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.owned[partitionId]; ok {
+		t.received[partitionId] = checkpoint
+	}
+	return nil
+}
+
+func (t *partitionLeaser) ownedLeases() map[string]models.PartitionLease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	owned := make(map[string]models.PartitionLease, len(t.owned))
+	for partitionId, lease := range t.owned {
+		owned[partitionId] = lease
+	}
+	return owned
+}
+
+func (t *partitionLeaser) forget(partitionId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.owned, partitionId)
+	delete(t.received, partitionId)
+}
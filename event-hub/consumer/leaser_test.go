@@ -0,0 +1,229 @@
+package consumer
+
+import (
+	"os"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	leaseRepository "r2-notify-server/repository/lease"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-event-hubs-go/v3/persist"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// memoryLeaseRepository stores the leases of the partitions in memory, with the semantics of the
+// MongoDB repository: a lease is only renewed, released or checkpointed with its current token.
+type memoryLeaseRepository struct {
+	leaseRepository.LeaseRepository
+	mu     sync.Mutex
+	leases map[string]models.PartitionLease
+}
+
+func newMemoryLeaseRepository() *memoryLeaseRepository {
+	return &memoryLeaseRepository{leases: map[string]models.PartitionLease{}}
+}
+
+func (r *memoryLeaseRepository) FindLease(id string) (models.PartitionLease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, ok := r.leases[id]
+	if !ok {
+		return models.PartitionLease{}, leaseRepository.ErrLeaseNotFound
+	}
+	return lease, nil
+}
+
+func (r *memoryLeaseRepository) EnsureLease(lease models.PartitionLease) (models.PartitionLease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stored, ok := r.leases[lease.Id]; ok {
+		return stored, nil
+	}
+	r.leases[lease.Id] = lease
+	return lease, nil
+}
+
+func (r *memoryLeaseRepository) AcquireLease(id string, owner string, token string, expiresAt time.Time) (models.PartitionLease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, ok := r.leases[id]
+	if !ok {
+		return models.PartitionLease{}, leaseRepository.ErrLeaseNotFound
+	}
+	lease.Owner, lease.Token, lease.ExpiresAt = owner, token, expiresAt
+	lease.Epoch++
+	r.leases[id] = lease
+	return lease, nil
+}
+
+func (r *memoryLeaseRepository) RenewLease(id string, token string, expiresAt time.Time) (bool, error) {
+	return r.updateOwned(id, token, func(lease *models.PartitionLease) { lease.ExpiresAt = expiresAt })
+}
+
+func (r *memoryLeaseRepository) ReleaseLease(id string, token string) (bool, error) {
+	return r.updateOwned(id, token, func(lease *models.PartitionLease) {
+		lease.Owner, lease.Token, lease.ExpiresAt = "", "", time.Time{}
+	})
+}
+
+func (r *memoryLeaseRepository) UpdateCheckpoint(id string, token string, checkpoint models.PartitionCheckpoint) (bool, error) {
+	return r.updateOwned(id, token, func(lease *models.PartitionLease) { lease.Checkpoint = checkpoint })
+}
+
+func (r *memoryLeaseRepository) updateOwned(id string, token string, update func(lease *models.PartitionLease)) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, ok := r.leases[id]
+	if !ok || lease.Token != token {
+		return false, nil
+	}
+	update(&lease)
+	r.leases[id] = lease
+	return true, nil
+}
+
+// expire lets the leases of an owner run out, as if the owner stopped renewing them.
+func (r *memoryLeaseRepository) expire(owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, lease := range r.leases {
+		if lease.Owner == owner {
+			lease.ExpiresAt = time.Now().Add(-time.Second)
+			r.leases[id] = lease
+		}
+	}
+}
+
+// owners returns the number of partitions leased by every owner.
+func (r *memoryLeaseRepository) owners() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	owners := map[string]int{}
+	for _, lease := range r.leases {
+		owners[lease.Owner]++
+	}
+	return owners
+}
+
+func TestTwoHostsSplitThePartitions(t *testing.T) {
+	repository := newMemoryLeaseRepository()
+	partitionIds := []string{"0", "1", "2", "3"}
+	hosts := []*partitionLeaser{
+		newPartitionLeaser(repository, "host-a", "notifications", "$Default", time.Minute),
+		newPartitionLeaser(repository, "host-b", "notifications", "$Default", time.Minute),
+	}
+
+	// The first host leases every partition, the second takes partitions over one at a time.
+	for round := 0; round < 5; round++ {
+		for _, host := range hosts {
+			if _, _, err := host.balance(partitionIds); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		if owned := len(host.ownedLeases()); owned != 2 {
+			t.Fatalf("expected %s to own 2 partitions, got %d", host.owner, owned)
+		}
+		acquired, lost, err := host.balance(partitionIds)
+		if err != nil || len(acquired) != 0 || len(lost) != 0 {
+			t.Fatalf("expected the split to be stable, %s acquired %v and lost %v: %v", host.owner, acquired, lost, err)
+		}
+	}
+	if owners := repository.owners(); owners["host-a"] != 2 || owners["host-b"] != 2 {
+		t.Fatalf("expected the stored leases to be split between the hosts, got %v", owners)
+	}
+	for partitionId := range hosts[0].ownedLeases() {
+		if _, ok := hosts[1].ownedLeases()[partitionId]; ok {
+			t.Fatalf("expected partition %s to be owned by a single host", partitionId)
+		}
+	}
+}
+
+func TestExpiredLeasesAreTakenOver(t *testing.T) {
+	repository := newMemoryLeaseRepository()
+	partitionIds := []string{"0", "1"}
+	crashed := newPartitionLeaser(repository, "host-a", "notifications", "$Default", time.Minute)
+	survivor := newPartitionLeaser(repository, "host-b", "notifications", "$Default", time.Minute)
+	if acquired, _, err := crashed.balance(partitionIds); err != nil || len(acquired) != 2 {
+		t.Fatalf("expected host-a to lease both partitions, got %v: %v", acquired, err)
+	}
+
+	// Expired leases are taken over up to an even share at once, live ones only one at a time.
+	repository.expire("host-a")
+	acquired, _, err := survivor.balance(partitionIds)
+	if err != nil || len(acquired) != 2 {
+		t.Fatalf("expected host-b to take over both expired leases, got %v: %v", acquired, err)
+	}
+	for _, lease := range acquired {
+		if lease.Owner != "host-b" || lease.Epoch != 2 {
+			t.Fatalf("expected the lease to be taken over with a new epoch, got %+v", lease)
+		}
+	}
+
+	if err := crashed.commit("0", persist.Checkpoint{Offset: "42"}); err == nil {
+		t.Fatal("expected the checkpoint of the previous owner to be rejected")
+	}
+	if lease, _ := repository.FindLease(crashed.leaseId("0")); lease.Checkpoint.Offset != "" {
+		t.Fatalf("expected the previous owner not to move the checkpoint, got %+v", lease.Checkpoint)
+	}
+	if _, lost, err := crashed.balance(partitionIds); err != nil || len(lost) != 1 || lost[0] != "1" {
+		t.Fatalf("expected host-a to find out it lost partition 1, got %v: %v", lost, err)
+	}
+}
+
+func TestOnlyCommittedOffsetsAreCheckpointed(t *testing.T) {
+	repository := newMemoryLeaseRepository()
+	leaser := newPartitionLeaser(repository, "host-a", "notifications", "$Default", time.Minute)
+	if _, _, err := leaser.balance([]string{"0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A partition never processed by the consumer group starts at the current time.
+	start, err := leaser.Read("", "notifications", "$Default", "0")
+	if err != nil || start.Offset != "" || time.Since(start.EnqueueTime) > time.Minute {
+		t.Fatalf("expected a new partition to start at the current time, got %+v: %v", start, err)
+	}
+
+	// The hub hands over events past the committed batch, which a recovered link resumes after.
+	if err := leaser.Write("", "notifications", "$Default", "0", persist.Checkpoint{Offset: "20", SequenceNumber: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if lease, _ := repository.FindLease(leaser.leaseId("0")); lease.Checkpoint.Offset != "" {
+		t.Fatalf("expected received events not to be checkpointed, got %+v", lease.Checkpoint)
+	}
+	if received, _ := leaser.Read("", "notifications", "$Default", "0"); received.Offset != "20" {
+		t.Fatalf("expected a recovered link to resume after the received events, got %+v", received)
+	}
+
+	if err := leaser.commit("0", persist.Checkpoint{Offset: "10", SequenceNumber: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if lease, _ := repository.FindLease(leaser.leaseId("0")); lease.Checkpoint.Offset != "10" || lease.Checkpoint.SequenceNumber != 10 {
+		t.Fatalf("expected the committed offset to be checkpointed, got %+v", lease.Checkpoint)
+	}
+
+	// Another replica leasing the partition resumes from the committed offset.
+	leaser.release("0")
+	next := newPartitionLeaser(repository, "host-b", "notifications", "$Default", time.Minute)
+	if _, _, err := next.balance([]string{"0"}); err != nil {
+		t.Fatal(err)
+	}
+	if resumed, err := next.Read("", "notifications", "$Default", "0"); err != nil || resumed.Offset != "10" {
+		t.Fatalf("expected the partition to resume from the committed offset, got %+v: %v", resumed, err)
+	}
+	if err := leaser.Write("", "notifications", "$Default", "0", persist.Checkpoint{Offset: "30"}); err != nil {
+		t.Fatal(err)
+	}
+	if received, _ := leaser.Read("", "notifications", "$Default", "0"); received.Offset != "10" {
+		t.Fatalf("expected events handed over after the release to be ignored, got %+v", received)
+	}
+}
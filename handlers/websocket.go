@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"r2-notify-server/config"
@@ -11,6 +12,9 @@ import (
 	clientStore "r2-notify-server/services"
 	configurationService "r2-notify-server/services/configuration"
 	notificationService "r2-notify-server/services/notification"
+	pushService "r2-notify-server/services/push"
+	rateLimitService "r2-notify-server/services/ratelimit"
+	sessionService "r2-notify-server/services/session"
 	"r2-notify-server/utils"
 	"slices"
 	"time"
@@ -18,7 +22,7 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{}
+var upgrader = websocket.Upgrader{Subprotocols: []string{webSocketProtocol}}
 var allowedOrigins []string
 
 // NewWebSocketHandler creates a new HTTP handler function for handling WebSocket connections.
@@ -27,44 +31,56 @@ var allowedOrigins []string
 // notification configurations for clients, sends notifications and configurations to clients,
 // and listens for incoming WebSocket messages to handle various client events. If a connection
 // error occurs or the client disconnects, the connection is closed and removed from the client store.
-func NewWebSocketHandler(notificationService notificationService.NotificationService, configurationService configurationService.ConfigurationService) http.HandlerFunc {
+// Events exceeding the rate limit of their type are answered with an error event instead of being handled.
+func NewWebSocketHandler(notificationService notificationService.NotificationService, configurationService configurationService.ConfigurationService, pushService pushService.PushService, sessionService sessionService.SessionService, rateLimitService rateLimitService.RateLimitService) http.HandlerFunc {
 
 	origins := config.LoadConfig().AllowedOrigins
 	allowedOrigins = utils.ProcessAllowedOrigins(origins)
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return slices.Contains(allowedOrigins, origin)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		upgrader.CheckOrigin = func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			return slices.Contains(allowedOrigins, origin)
+		// Check the origin before the credentials, so that other sites can not use up tickets
+		if !upgrader.CheckOrigin(r) {
+			logger.Log.Error(logger.LogPayload{
+				Message:   "Origin not allowed. Allowed origins: " + fmt.Sprint(allowedOrigins) + ". Received Origin: " + r.Header.Get("Origin"),
+				Component: "WebSocket",
+				Operation: "NewWebSocketHandler",
+			})
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
 		}
-		conn, err := upgrader.Upgrade(w, r, nil)
+
+		// Authenticate before the upgrade, so that unauthenticated requests are rejected with 401
+		claims, err := authenticate(sessionService, r)
 		if err != nil {
 			logger.Log.Error(logger.LogPayload{
-				Message:   "Upgrade error, origin not allowed. Allowed origins: " + fmt.Sprint(allowedOrigins) + ". Received Origin: " + r.Header.Get("Origin"),
+				Message:   "Failed to authenticate WebSocket connection.",
 				Component: "WebSocket",
 				Operation: "NewWebSocketHandler",
 				Error:     err,
 			})
-			conn.Close()
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		userId := claims.Subject
+		tenantId := claims.Tenant()
+		notificationService := notificationService.ForTenant(tenantId)
+		configurationService := configurationService.ForTenant(tenantId)
 
-		// Extract token from query param
-		tokenString := r.URL.Query().Get("token")
-		// Validate and get user ID
-		var jwtSecret = []byte(config.LoadConfig().JwtSecret)
-		userId, err := utils.ValidateToken(tokenString, jwtSecret)
+		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
+			// The upgrader already responded with the error
 			logger.Log.Error(logger.LogPayload{
-				Message:   "Failed to validate JWT token for WebSocket connection.",
+				Message:   "Upgrade error",
 				Component: "WebSocket",
 				Operation: "NewWebSocketHandler",
+				UserId:    userId,
 				Error:     err,
 			})
-			conn.Close()
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
@@ -101,7 +117,7 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 						UserId:    userId,
 						Error:     err,
 					})
-					clientStore.RemoveConnection(userId, conn)
+					clientStore.RemoveConnection(tenantId, userId, conn)
 					return
 				}
 			}
@@ -110,6 +126,14 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 		// Generate correlation ID
 		correlationId := utils.GenerateUUID()
 
+		// Record the changes of the connection in the audit log on behalf of the user
+		actor := data.AuditActor{Id: userId, Type: data.AUDIT_ACTOR_USER, CorrelationId: correlationId}
+		notificationService = notificationService.As(actor)
+		configurationService = configurationService.As(actor)
+
+		// Close the connection once its token expires, unless the client reauthenticates
+		watcher := watchToken(conn, claims, correlationId)
+
 		// Handle Enable Notification Configuration
 		isEnableNotification := true
 		logger.Log.Info(logger.LogPayload{
@@ -141,6 +165,7 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 					UserId:        userId,
 					CorrelationId: correlationId,
 				})
+				watcher.stop()
 				conn.Close()
 				return
 			}
@@ -150,11 +175,12 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 
 		info := models.ClientInfo{
 			ID:                 userId,
+			TenantId:           tenantId,
 			ConnectedAt:        time.Now(),
 			EnableNotification: isEnableNotification,
 		}
 
-		if err := clientStore.StoreClient(info, conn); err != nil {
+		if err := clientStore.StoreClient(info, conn, claims.SessionId); err != nil {
 			logger.Log.Error(logger.LogPayload{
 				Component:     "WebSocket Redis Store",
 				Operation:     "Redis Store Client",
@@ -163,6 +189,7 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 				Error:         err,
 				CorrelationId: correlationId,
 			})
+			watcher.stop()
 			conn.Close()
 			return
 		}
@@ -176,10 +203,12 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 		})
 
 		// Fetch and send all notifications for the client
-		sendAllNotificationsToClient(notificationService, userId, correlationId, false)
+		if sendAllNotificationsToClient(notificationService, tenantId, userId, correlationId, false) {
+			notificationService.MarkPendingAsDelivered(userId)
+		}
 
 		// Send Client Configurations
-		sendConfigurationsToClient(configurationService, userId, correlationId)
+		sendConfigurationsToClient(configurationService, tenantId, userId, correlationId)
 
 		// Connection close if client disconnect or error occurs
 		go func() {
@@ -194,7 +223,8 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 						UserId:        userId,
 						CorrelationId: correlationId,
 					})
-					clientStore.RemoveConnection(userId, conn)
+					watcher.stop()
+					clientStore.RemoveConnection(tenantId, userId, conn)
 					break
 				}
 
@@ -230,33 +260,49 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 					CorrelationId: correlationId,
 				})
 
+				// Reject the events exceeding the rate limit of their type
+				if err := rateLimitService.AllowSocketEvent(tenantId, userId, correlationId, event.Event); err != nil {
+					sendRateLimitedToClient(conn, event.Event, err, userId, correlationId)
+					continue
+				}
+
 				// Handle events
 				switch event.Event {
 				// Mark as Read Events
 				case data.MARK_AS_READ:
-					markAsReadAction(notificationService, userId, correlationId)
+					markAsReadAction(notificationService, tenantId, userId, correlationId)
 				case data.MARK_APP_AS_READ:
-					markAppReadAction(message, notificationService, userId, correlationId)
+					markAppReadAction(message, notificationService, tenantId, userId, correlationId)
 				case data.MARK_GROUP_AS_READ:
-					markGroupAsReadAction(message, notificationService, userId, correlationId)
+					markGroupAsReadAction(message, notificationService, tenantId, userId, correlationId)
 				case data.MARK_NOTIFICATION_AS_READ:
-					markNotificationAsReadAction(message, notificationService, userId, correlationId)
+					markNotificationAsReadAction(message, notificationService, tenantId, userId, correlationId)
 
 				// Delete Events
 				case data.DELETE_NOTIFICATIONS:
-					deleteNotificationsAction(notificationService, userId, correlationId)
+					deleteNotificationsAction(notificationService, tenantId, userId, correlationId)
 				case data.DELETE_APP_NOTIFICATIONS:
-					deleteAppNotificationsAction(message, notificationService, userId, correlationId)
+					deleteAppNotificationsAction(message, notificationService, tenantId, userId, correlationId)
 				case data.DELETE_GROUP_NOTIFICATIONS:
-					deleteGroupNotificationAction(message, notificationService, userId, correlationId)
+					deleteGroupNotificationAction(message, notificationService, tenantId, userId, correlationId)
 				case data.DELETE_NOTIFICATION:
-					deleteNotificationAction(message, notificationService, userId, correlationId)
+					deleteNotificationAction(message, notificationService, tenantId, userId, correlationId)
 
 				// Other Events
 				case data.RELOAD_NOTIFICATIONS:
-					sendAllNotificationsToClient(notificationService, userId, correlationId, false)
+					sendAllNotificationsToClient(notificationService, tenantId, userId, correlationId, false)
 				case data.SET_NOTIFICATION_STATUS:
-					setNotificationStatusAction(message, configurationService, notificationService, userId, correlationId)
+					setNotificationStatusAction(message, configurationService, notificationService, tenantId, userId, correlationId)
+				case data.SET_EMAIL_NOTIFICATION_STATUS:
+					setEmailNotificationStatusAction(message, configurationService, tenantId, userId, correlationId)
+				case data.SET_DIGEST_NOTIFICATION_STATUS:
+					setDigestNotificationStatusAction(message, configurationService, tenantId, userId, correlationId)
+				case data.SET_CHAT_TARGETS:
+					setChatTargetsAction(message, configurationService, tenantId, userId, correlationId)
+				case data.REGISTER_PUSH_SUBSCRIPTION:
+					registerPushSubscriptionAction(message, pushService, tenantId, userId, correlationId)
+				case data.REAUTHENTICATE:
+					reauthenticateAction(message, conn, watcher, userId, correlationId)
 				default:
 					fmt.Printf("Unknown event -----------------> %+v\n", event)
 					logger.Log.Warn(logger.LogPayload{
@@ -278,7 +324,8 @@ func NewWebSocketHandler(notificationService notificationService.NotificationSer
 // operation is successful, it sends the constructed payload to the client using the clientStore. If the send operation fails, it logs
 // an error.
 // If bypassStatusCheck is true, it will skip the notification status check when sending notifications.
-func sendAllNotificationsToClient(notificationService notificationService.NotificationService, clientId string, correlationId string, bypassStatusCheck bool) {
+// It returns true if the notifications were sent to the client.
+func sendAllNotificationsToClient(notificationService notificationService.NotificationService, tenantId string, clientId string, correlationId string, bypassStatusCheck bool) bool {
 	notifications, err := notificationService.FindAll(clientId)
 	payload := data.NotificationList{
 		Event: data.Event{Event: data.LIST_NOTIFICATIONS},
@@ -299,7 +346,7 @@ func sendAllNotificationsToClient(notificationService notificationService.Notifi
 			Message:       "Sending all notifications to client: " + clientId,
 			CorrelationId: correlationId,
 		})
-		if err := clientStore.SendNotificationListToUser(clientId, payload, bypassStatusCheck); err != nil {
+		if err := clientStore.SendNotificationListToUser(tenantId, clientId, payload, bypassStatusCheck); err != nil {
 			logger.Log.Error(logger.LogPayload{
 				Component:     "WebSocket Notification Handler",
 				Operation:     "SendNotifications",
@@ -307,8 +354,11 @@ func sendAllNotificationsToClient(notificationService notificationService.Notifi
 				Error:         err,
 				CorrelationId: correlationId,
 			})
+			return false
 		}
+		return true
 	}
+	return false
 }
 
 // sendEmptyNotificationListToClient sends all the notifications of a user to the corresponding client identified by the given clientId.
@@ -316,12 +366,12 @@ func sendAllNotificationsToClient(notificationService notificationService.Notifi
 // encapsulating the notifications. If the fetch operation fails, it logs an error and does not send the notifications. If the fetch
 // operation is successful, it sends the constructed payload to the client using the clientStore. If the send operation fails, it logs
 // an error.
-func sendEmptyNotificationListToClient(clientId string, correlationId string, bypassNotificationStatus bool) {
+func sendEmptyNotificationListToClient(tenantId string, clientId string, correlationId string, bypassNotificationStatus bool) {
 	payload := data.NotificationList{
 		Event: data.Event{Event: data.LIST_NOTIFICATIONS},
 		Data:  []data.Notification{},
 	}
-	if err := clientStore.SendNotificationListToUser(clientId, payload, bypassNotificationStatus); err != nil {
+	if err := clientStore.SendNotificationListToUser(tenantId, clientId, payload, bypassNotificationStatus); err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component:     "WebSocket Notification Handler",
 			Operation:     "SendNotifications",
@@ -336,7 +386,7 @@ func sendEmptyNotificationListToClient(clientId string, correlationId string, by
 // identified by the given clientId. If the user is not connected or if the configuration fetch fails,
 // the function logs an error and does not attempt to send the configuration. If the configuration is
 // successfully sent, it will bypass the notification status check.
-func sendConfigurationsToClient(configurationService configurationService.ConfigurationService, clientId string, correlationId string) {
+func sendConfigurationsToClient(configurationService configurationService.ConfigurationService, tenantId string, clientId string, correlationId string) {
 	configuration, err := configurationService.FindByAppAndUser(clientId)
 	payload := data.Configuration{
 		Event: data.Event{Event: data.LIST_CONFIGURATIONS},
@@ -344,6 +394,11 @@ func sendConfigurationsToClient(configurationService configurationService.Config
 			UserID:             clientId,
 			EnableNotification: configuration.Data.EnableNotification,
 			Id:                 configuration.Data.Id,
+			Email:              configuration.Data.Email,
+			EmailNotification:  configuration.Data.EmailNotification,
+			DigestNotification: configuration.Data.DigestNotification,
+			DigestCursor:       configuration.Data.DigestCursor,
+			ChatTargets:        configuration.Data.ChatTargets,
 		},
 	}
 	if err != nil {
@@ -363,7 +418,7 @@ func sendConfigurationsToClient(configurationService configurationService.Config
 			UserId:        clientId,
 			CorrelationId: correlationId,
 		})
-		if err := clientStore.SendConfigurationToUser(payload, true); err != nil {
+		if err := clientStore.SendConfigurationToUser(tenantId, payload, true); err != nil {
 			logger.Log.Error(logger.LogPayload{
 				Component:     "WebSocket Configuration Handler",
 				Operation:     "SendConfigurations",
@@ -376,10 +431,40 @@ func sendConfigurationsToClient(configurationService configurationService.Config
 	}
 }
 
+// sendRateLimitedToClient answers an event rejected by the rate limit with an error event, telling the
+// client how many seconds to wait before sending it again.
+func sendRateLimitedToClient(conn *websocket.Conn, event string, err error, clientId string, correlationId string) {
+	logger.Log.Warn(logger.LogPayload{
+		Component:     "WebSocket Event Handler",
+		Operation:     "HandleEvent",
+		Message:       "Rejected event " + event + " of client " + clientId,
+		Error:         err,
+		UserId:        clientId,
+		CorrelationId: correlationId,
+	})
+	payload := data.ErrorEvent{Event: data.Event{Event: data.ERROR_EVENT}}
+	payload.Data.Event = event
+	payload.Data.Error = err.Error()
+	var limited *rateLimitService.LimitExceededError
+	if errors.As(err, &limited) {
+		payload.Data.RetryAfter = limited.RetryAfterSeconds()
+	}
+	if err := clientStore.SendToConnection(conn, payload); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Event Handler",
+			Operation:     "HandleEvent",
+			Message:       "Failed to send error event to client " + clientId,
+			Error:         err,
+			UserId:        clientId,
+			CorrelationId: correlationId,
+		})
+	}
+}
+
 // markAsReadAction handles the event to mark all notifications as read for a given client.
 // It marks all notifications as read and then sends the updated list of notifications back to the client.
 // Logs errors if the update operation fails.
-func markAsReadAction(notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func markAsReadAction(notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	logger.Log.Debug(logger.LogPayload{
 		Component:     "WebSocket Mark As Read Action",
 		Operation:     "MarkAllAsRead",
@@ -398,14 +483,14 @@ func markAsReadAction(notificationService notificationService.NotificationServic
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // markAppReadAction handles the event to mark all notifications for a specific app as read for a given client.
 // It unmarshals the incoming message to extract the appId, then uses the notificationService to update the read status
 // of the notifications in the database. If successful, it sends the updated list of notifications back to the client.
 // Logs errors if the message format is invalid or if the update operation fails.
-func markAppReadAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func markAppReadAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -437,14 +522,14 @@ func markAppReadAction(message []byte, notificationService notificationService.N
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // markGroupAsReadAction handles the event to mark all notifications with a given appId and groupKey as read for a given client.
 // It unmarshals the incoming message to extract the appId and groupKey, then uses the notificationService to
 // update the read status of the notifications in the database. If successful, it sends the updated list of
 // notifications back to the client. Logs errors if the message format is invalid or if the update operation fails.
-func markGroupAsReadAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func markGroupAsReadAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -478,14 +563,14 @@ func markGroupAsReadAction(message []byte, notificationService notificationServi
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // markNotificationAsReadAction handles the event to mark a specific notification as read for a given client.
 // It unmarshals the incoming message to extract the notification ID, then uses the notificationService to
 // update the read status of the notification in the database. If successful, it sends the updated list of
 // notifications back to the client. Logs errors if the message format is invalid or if the update operation fails.
-func markNotificationAsReadAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func markNotificationAsReadAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -516,14 +601,14 @@ func markNotificationAsReadAction(message []byte, notificationService notificati
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // deleteNotificationsAction handles the event to delete all notifications for a given client.
 // It uses the notificationService to delete the notifications
 // in the database. If successful, it sends the updated list of notifications back to the client.
 // Logs errors if the message format is invalid or if the update operation fails.
-func deleteNotificationsAction(notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func deleteNotificationsAction(notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	logger.Log.Debug(logger.LogPayload{
 		Component:     "WebSocket Delete Notifications Action",
 		Operation:     "DeleteAllNotifications",
@@ -542,14 +627,14 @@ func deleteNotificationsAction(notificationService notificationService.Notificat
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // deleteAppNotificationsAction handles the event to delete all notifications for a specific app for a given client.
 // It unmarshals the incoming message to extract the appId, then uses the notificationService to delete the notifications
 // in the database. If successful, it sends the updated list of notifications back to the client.
 // Logs errors if the message format is invalid or if the update operation fails.
-func deleteAppNotificationsAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func deleteAppNotificationsAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -583,14 +668,14 @@ func deleteAppNotificationsAction(message []byte, notificationService notificati
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // deleteGroupNotificationAction handles the event to delete all notifications with a given appId and groupKey for a given client.
 // It unmarshals the incoming message to extract the appId and groupKey, then uses the notificationService to
 // delete the notifications in the database. If successful, it sends the updated list of
 // notifications back to the client. Logs errors if the message format is invalid or if the deletion operation fails.
-func deleteGroupNotificationAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func deleteGroupNotificationAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -624,14 +709,14 @@ func deleteGroupNotificationAction(message []byte, notificationService notificat
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // deleteNotificationAction handles the event to delete a specific notification for a given client.
 // It unmarshals the incoming message to extract the notification ID, then uses the notificationService to
 // delete the notification from the database. If successful, it sends the updated list of
 // notifications back to the client. Logs errors if the message format is invalid or if the deletion operation fails.
-func deleteNotificationAction(message []byte, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func deleteNotificationAction(message []byte, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.EventNotification
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -662,7 +747,7 @@ func deleteNotificationAction(message []byte, notificationService notificationSe
 			Error:         err,
 		})
 	}
-	sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+	sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 }
 
 // setNotificationStatusAction handles the toggle notification status event.
@@ -670,7 +755,7 @@ func deleteNotificationAction(message []byte, notificationService notificationSe
 // notification settings in the configuration service, and updates the client information in
 // the client store. If notifications are enabled, it sends all notifications to the client.
 // Finally, it sends the updated configuration back to the client.
-func setNotificationStatusAction(message []byte, configurationService configurationService.ConfigurationService, notificationService notificationService.NotificationService, clientID string, correlationId string) {
+func setNotificationStatusAction(message []byte, configurationService configurationService.ConfigurationService, notificationService notificationService.NotificationService, tenantId string, clientID string, correlationId string) {
 	var event data.Configuration
 	if err := json.Unmarshal(message, &event); err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -683,10 +768,7 @@ func setNotificationStatusAction(message []byte, configurationService configurat
 		})
 		return
 	}
-	err := configurationService.Update(models.Configuration{
-		UserId:              clientID,
-		EnableNotifications: event.Data.EnableNotification,
-	})
+	err := configurationService.SetNotificationStatus(clientID, event.Data.EnableNotification)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component:     "WebSocket Toggle Notification Status Event",
@@ -706,6 +788,7 @@ func setNotificationStatusAction(message []byte, configurationService configurat
 	})
 	clientStore.UpdateClientInfo(models.ClientInfo{
 		ID:                 clientID,
+		TenantId:           tenantId,
 		EnableNotification: event.Data.EnableNotification,
 	})
 	if event.Data.EnableNotification {
@@ -716,7 +799,7 @@ func setNotificationStatusAction(message []byte, configurationService configurat
 			UserId:        clientID,
 			CorrelationId: correlationId,
 		})
-		sendAllNotificationsToClient(notificationService, clientID, correlationId, false)
+		sendAllNotificationsToClient(notificationService, tenantId, clientID, correlationId, false)
 	} else {
 		// Send empty notification list to client
 		logger.Log.Debug(logger.LogPayload{
@@ -726,8 +809,161 @@ func setNotificationStatusAction(message []byte, configurationService configurat
 			UserId:        clientID,
 			CorrelationId: correlationId,
 		})
-		sendEmptyNotificationListToClient(clientID, correlationId, true)
+		sendEmptyNotificationListToClient(tenantId, clientID, correlationId, true)
 	}
 	// Send updated configuration to client
-	sendConfigurationsToClient(configurationService, clientID, correlationId)
+	sendConfigurationsToClient(configurationService, tenantId, clientID, correlationId)
+}
+
+// setEmailNotificationStatusAction handles the event to opt in or out of email delivery for
+// notifications received while the client is offline. It unmarshals the incoming message to
+// extract the configuration data, updates the user's configuration and sends the updated
+// configuration back to the client.
+func setEmailNotificationStatusAction(message []byte, configurationService configurationService.ConfigurationService, tenantId string, clientID string, correlationId string) {
+	var event data.Configuration
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Email Notification Status Event",
+			Operation:     "ParseEvent",
+			Message:       "Invalid event format",
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+	err := configurationService.SetEmailNotificationStatus(clientID, event.Data.EmailNotification)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Email Notification Status Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Failed to update email notification status for client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+	} else {
+		logger.Log.Info(logger.LogPayload{
+			Component:     "WebSocket Email Notification Status Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Updated configuration for client: " + clientID + ", EmailNotification: " + fmt.Sprintf("%v", event.Data.EmailNotification),
+			UserId:        clientID,
+			CorrelationId: correlationId,
+		})
+	}
+	sendConfigurationsToClient(configurationService, tenantId, clientID, correlationId)
+}
+
+// setDigestNotificationStatusAction handles the event to opt in or out of the periodic email digest
+// of unread notifications. It unmarshals the incoming message to extract the configuration data,
+// updates the user's configuration and sends the updated configuration back to the client.
+func setDigestNotificationStatusAction(message []byte, configurationService configurationService.ConfigurationService, tenantId string, clientID string, correlationId string) {
+	var event data.Configuration
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Digest Notification Status Event",
+			Operation:     "ParseEvent",
+			Message:       "Invalid event format",
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+	err := configurationService.SetDigestNotificationStatus(clientID, event.Data.DigestNotification)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Digest Notification Status Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Failed to update digest notification status for client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+	} else {
+		logger.Log.Info(logger.LogPayload{
+			Component:     "WebSocket Digest Notification Status Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Updated configuration for client: " + clientID + ", DigestNotification: " + fmt.Sprintf("%v", event.Data.DigestNotification),
+			UserId:        clientID,
+			CorrelationId: correlationId,
+		})
+	}
+	sendConfigurationsToClient(configurationService, tenantId, clientID, correlationId)
+}
+
+// setChatTargetsAction handles the event to replace the Slack and Teams incoming webhooks the
+// client's notifications are mirrored to. It unmarshals the incoming message to extract the
+// configuration data, updates the user's configuration and sends the updated configuration back
+// to the client.
+func setChatTargetsAction(message []byte, configurationService configurationService.ConfigurationService, tenantId string, clientID string, correlationId string) {
+	var event data.Configuration
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Chat Targets Event",
+			Operation:     "ParseEvent",
+			Message:       "Invalid event format",
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+	err := configurationService.SetChatTargets(clientID, event.Data.ChatTargets)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Chat Targets Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Failed to update chat targets for client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+	} else {
+		logger.Log.Info(logger.LogPayload{
+			Component:     "WebSocket Chat Targets Event",
+			Operation:     "UpdateConfiguration",
+			Message:       "Updated configuration for client: " + clientID + ", ChatTargets: " + fmt.Sprintf("%d", len(event.Data.ChatTargets)),
+			UserId:        clientID,
+			CorrelationId: correlationId,
+		})
+	}
+	sendConfigurationsToClient(configurationService, tenantId, clientID, correlationId)
+}
+
+// registerPushSubscriptionAction handles the event to register the Web Push subscription of the
+// browser the client is connected from. It unmarshals the incoming message to extract the
+// subscription and stores it in the tenant of the client, so that notifications reach the browser
+// while the tab is closed.
+func registerPushSubscriptionAction(message []byte, pushService pushService.PushService, tenantId string, clientID string, correlationId string) {
+	var event data.PushSubscriptionEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Push Subscription Event",
+			Operation:     "ParseEvent",
+			Message:       "Invalid event format",
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+	if err := pushService.ForTenant(tenantId).Subscribe(clientID, event.Data); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Push Subscription Event",
+			Operation:     "RegisterPushSubscription",
+			Message:       "Failed to register push subscription for client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component:     "WebSocket Push Subscription Event",
+		Operation:     "RegisterPushSubscription",
+		Message:       "Registered push subscription for client: " + clientID,
+		UserId:        clientID,
+		CorrelationId: correlationId,
+	})
 }
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	sessionService "r2-notify-server/services/session"
+	"r2-notify-server/utils"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// webSocketProtocol is the subprotocol selected by the server. Clients passing their credentials
+	// in the Sec-WebSocket-Protocol header must offer it, as browsers require a selected subprotocol.
+	webSocketProtocol = "r2-notify"
+	// tokenCookie is the cookie an access token can be sent in, by clients served from the same site.
+	tokenCookie = "r2_notify_token"
+)
+
+// authenticate returns the claims of the credentials of a WebSocket connection request, which are
+// taken from the first of:
+//   - a ticket of POST /ws/ticket, in the ticket query parameter
+//   - a ticket or an access token in the Sec-WebSocket-Protocol header, as ticket.<ticket> or bearer.<token>
+//   - an access token in the r2_notify_token cookie
+//   - an access token in the token query parameter, which is deprecated as it ends up in access logs
+//
+// Credentials of revoked sessions are rejected.
+func authenticate(service sessionService.SessionService, r *http.Request) (*utils.TokenClaims, error) {
+	claims, err := credentials(service, r)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := sessionService.IsRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token of a revoked session")
+	}
+	return claims, nil
+}
+
+func credentials(service sessionService.SessionService, r *http.Request) (*utils.TokenClaims, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return service.RedeemTicket(ticket)
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if ticket, ok := strings.CutPrefix(protocol, "ticket."); ok {
+			return service.RedeemTicket(ticket)
+		}
+		if token, ok := strings.CutPrefix(protocol, "bearer."); ok {
+			return utils.ParseToken(token)
+		}
+	}
+	if cookie, err := r.Cookie(tokenCookie); err == nil && cookie.Value != "" {
+		return utils.ParseToken(cookie.Value)
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return utils.ParseToken(token)
+	}
+	return nil, errors.New("no credentials")
+}
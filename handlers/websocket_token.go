@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	clientStore "r2-notify-server/services"
+	sessionService "r2-notify-server/services/session"
+	"r2-notify-server/utils"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeTokenExpired is the close code of connections whose token expired without being replaced.
+// Clients should reconnect with a new token rather than with the same credentials.
+const closeTokenExpired = 4401
+
+// tokenWatcher enforces the expiry of the token a connection was opened with. The client is sent
+// a tokenExpiring event WS_TOKEN_EXPIRY_WARNING seconds ahead of the expiry, and the connection is
+// closed with closeTokenExpired once the token expired, unless the client reauthenticated with a
+// fresh token of the same user and tenant in the meantime.
+type tokenWatcher struct {
+	conn          *websocket.Conn
+	userId        string
+	tenantId      string
+	correlationId string
+	mu            sync.Mutex
+	expiresAt     time.Time
+	warning       *time.Timer
+	expiry        *time.Timer
+	stopped       bool
+}
+
+func watchToken(conn *websocket.Conn, claims *utils.TokenClaims, correlationId string) *tokenWatcher {
+	watcher := &tokenWatcher{conn: conn, userId: claims.Subject, tenantId: claims.Tenant(), correlationId: correlationId}
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.schedule(claims.ExpiresAt.Time)
+	return watcher
+}
+
+// schedule starts the timers of a token expiring at the given time. It must be called with the lock held.
+func (w *tokenWatcher) schedule(expiresAt time.Time) {
+	if w.warning != nil {
+		w.warning.Stop()
+		w.expiry.Stop()
+	}
+	w.expiresAt = expiresAt
+	warning := time.Duration(config.LoadConfig().WsTokenExpiryWarning) * time.Second
+	w.warning = time.AfterFunc(max(time.Until(expiresAt.Add(-warning)), 0), func() { w.warn(expiresAt) })
+	w.expiry = time.AfterFunc(max(time.Until(expiresAt), 0), func() { w.expire(expiresAt) })
+}
+
+// warn sends the tokenExpiring event, unless the token was replaced since.
+func (w *tokenWatcher) warn(expiresAt time.Time) {
+	w.mu.Lock()
+	current := !w.stopped && w.expiresAt.Equal(expiresAt)
+	w.mu.Unlock()
+	if !current {
+		return
+	}
+	if err := clientStore.SendToConnection(w.conn, tokenExpiryEvent(data.TOKEN_EXPIRING, expiresAt)); err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "WebSocket Token Handler",
+			Operation:     "TokenExpiring",
+			Message:       "Failed to send token expiring event to client " + w.userId,
+			UserId:        w.userId,
+			CorrelationId: w.correlationId,
+			Error:         err,
+		})
+	}
+}
+
+// expire closes the connection, unless the token was replaced since.
+func (w *tokenWatcher) expire(expiresAt time.Time) {
+	w.mu.Lock()
+	current := !w.stopped && w.expiresAt.Equal(expiresAt)
+	w.stopped = w.stopped || current
+	w.mu.Unlock()
+	if !current {
+		return
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component:     "WebSocket Token Handler",
+		Operation:     "TokenExpired",
+		Message:       "Closing connection of client " + w.userId + " as its token expired",
+		UserId:        w.userId,
+		CorrelationId: w.correlationId,
+	})
+	message := websocket.FormatCloseMessage(closeTokenExpired, "token expired")
+	_ = w.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	w.conn.Close()
+}
+
+// reauthenticate replaces the token of the connection with a fresh token of the same user and tenant.
+func (w *tokenWatcher) reauthenticate(token string) (*utils.TokenClaims, error) {
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != w.userId {
+		return nil, errors.New("token of another user")
+	}
+	if claims.Tenant() != w.tenantId {
+		return nil, errors.New("token of another tenant")
+	}
+	revoked, err := sessionService.IsRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token of a revoked session")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return nil, errors.New("connection is closed")
+	}
+	w.schedule(claims.ExpiresAt.Time)
+	return claims, nil
+}
+
+func (w *tokenWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.warning.Stop()
+	w.expiry.Stop()
+}
+
+func tokenExpiryEvent(event string, expiresAt time.Time) data.TokenExpiryEvent {
+	payload := data.TokenExpiryEvent{Event: data.Event{Event: event}}
+	payload.Data.ExpiresAt = expiresAt
+	return payload
+}
+
+// reauthenticateAction handles the reauthenticate event, replying with a reauthenticated event
+// carrying the new expiry, or a reauthenticationFailed event when the token is not accepted.
+func reauthenticateAction(message []byte, conn *websocket.Conn, watcher *tokenWatcher, clientID string, correlationId string) {
+	var event data.ReauthenticateEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "WebSocket Reauthenticate Event",
+			Operation:     "ParseEvent",
+			Message:       "Invalid event format",
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		return
+	}
+
+	claims, err := watcher.reauthenticate(event.Data.Token)
+	if err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "WebSocket Reauthenticate Event",
+			Operation:     "Reauthenticate",
+			Message:       "Rejected reauthentication of client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+		_ = clientStore.SendToConnection(conn, data.Event{Event: data.REAUTHENTICATION_FAILED})
+		return
+	}
+
+	clientStore.SetConnectionSession(conn, claims.SessionId)
+	if err := clientStore.SendToConnection(conn, tokenExpiryEvent(data.REAUTHENTICATED, claims.ExpiresAt.Time)); err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "WebSocket Reauthenticate Event",
+			Operation:     "Reauthenticate",
+			Message:       "Failed to send reauthenticated event to client " + clientID,
+			UserId:        clientID,
+			CorrelationId: correlationId,
+			Error:         err,
+		})
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component:     "WebSocket Reauthenticate Event",
+		Operation:     "Reauthenticate",
+		Message:       "Reauthenticated client: " + clientID,
+		UserId:        clientID,
+		CorrelationId: correlationId,
+	})
+}
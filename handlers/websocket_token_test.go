@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// dialWatchedConnection opens a connection watched for the expiry of the given claims, which handles
+// reauthenticate events as the WebSocket handler does.
+func dialWatchedConnection(t *testing.T, claims *utils.TokenClaims) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return
+		}
+		watcher := watchToken(conn, claims, "correlation-1")
+		defer watcher.stop()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var event data.Event
+			if json.Unmarshal(message, &event) == nil && event.Event == data.REAUTHENTICATE {
+				reauthenticateAction(message, conn, watcher, claims.Subject, "correlation-1")
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testClaims(subject string, tenantId string, expiresAt time.Time) *utils.TokenClaims {
+	return &utils.TokenClaims{
+		TenantId:         tenantId,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(expiresAt)},
+	}
+}
+
+func readExpiryEvent(t *testing.T, conn *websocket.Conn, expected string) data.TokenExpiryEvent {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event data.TokenExpiryEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("expected a %s event, got %v", expected, err)
+	}
+	if event.Event.Event != expected {
+		t.Fatalf("expected a %s event, got %s", expected, event.Event.Event)
+	}
+	return event
+}
+
+func expectTokenExpiredClose(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, closeTokenExpired) {
+		t.Fatalf("expected the connection to be closed with %d, got %s %v", closeTokenExpired, message, err)
+	}
+}
+
+func TestTokenExpiringIsSentAheadOfTheCloseOnExpiry(t *testing.T) {
+	t.Setenv("WS_TOKEN_EXPIRY_WARNING", "1")
+	// Token expiries have a precision of a second
+	expiresAt := time.Now().Add(2 * time.Second).Truncate(time.Second)
+	conn := dialWatchedConnection(t, testClaims("u1", "", expiresAt))
+
+	event := readExpiryEvent(t, conn, data.TOKEN_EXPIRING)
+	if now := time.Now(); !now.Before(expiresAt) || now.Before(expiresAt.Add(-time.Second)) {
+		t.Fatalf("expected the warning a second before the expiry at %s, got it at %s", expiresAt, now)
+	}
+	if !event.Data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected the warning to carry the expiry %s, got %s", expiresAt, event.Data.ExpiresAt)
+	}
+
+	expectTokenExpiredClose(t, conn)
+	if time.Now().Before(expiresAt) {
+		t.Fatal("expected the connection to be closed once the token expired, not before")
+	}
+}
+
+func TestReauthenticate(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("WS_TOKEN_EXPIRY_WARNING", "60")
+	if err := utils.InitTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	redisServer := miniredis.RunT(t)
+	config.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	if err := redisServer.Set("revoked:session:revoked-session", "u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"fresh token of the same user", jwt.MapClaims{"sub": "u1", "r2_tenant": "tenant-b"}, true},
+		{"token of the same user with the tid of an Entra directory", jwt.MapClaims{"sub": "u1", "r2_tenant": "tenant-b", "tid": "72f988bf-86f1-41af-91ab-2d7cd011db47"}, true},
+		{"token of another user", jwt.MapClaims{"sub": "u2", "r2_tenant": "tenant-b"}, false},
+		{"token of the same user in another tenant", jwt.MapClaims{"sub": "u1"}, false},
+		{"token of a revoked session", jwt.MapClaims{"sub": "u1", "r2_tenant": "tenant-b", "sid": "revoked-session"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expiresAt := time.Now().Add(2 * time.Second).Truncate(time.Second)
+			conn := dialWatchedConnection(t, testClaims("u1", "tenant-b", expiresAt))
+			// The warning is due at once, as the token expires within WS_TOKEN_EXPIRY_WARNING
+			readExpiryEvent(t, conn, data.TOKEN_EXPIRING)
+
+			test.claims["exp"] = time.Now().Add(time.Hour).Unix()
+			token, err := utils.SignToken(test.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			event := data.ReauthenticateEvent{Event: data.Event{Event: data.REAUTHENTICATE}}
+			event.Data.Token = token
+			if err := conn.WriteJSON(event); err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.valid {
+				readExpiryEvent(t, conn, data.REAUTHENTICATION_FAILED)
+				expectTokenExpiredClose(t, conn)
+				return
+			}
+			reauthenticated := readExpiryEvent(t, conn, data.REAUTHENTICATED)
+			if reauthenticated.Data.ExpiresAt.Unix() != test.claims["exp"] {
+				t.Fatalf("expected the new expiry %v, got %s", test.claims["exp"], reauthenticated.Data.ExpiresAt)
+			}
+			conn.SetReadDeadline(expiresAt.Add(time.Second))
+			_, message, err := conn.ReadMessage()
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("expected the connection to stay open past the expiry of the replaced token, got %s %v", message, err)
+			}
+		})
+	}
+}
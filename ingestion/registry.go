@@ -0,0 +1,163 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"r2-notify-server/logger"
+	"sync"
+	"time"
+)
+
+// Source states reported by the Registry.
+const (
+	STATE_RUNNING    = "running"
+	STATE_RESTARTING = "restarting"
+	STATE_STOPPED    = "stopped"
+)
+
+// maxRestartDelay caps the backoff between two restarts of a failed source. A source that ran
+// for longer than that before failing is restarted after the initial delay again.
+const maxRestartDelay = time.Minute
+
+type SourceStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Healthy   bool      `json:"healthy"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"lastError,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// Registry runs the registered sources concurrently and restarts them with backoff when they fail,
+// so that a broken source does not take the server, or the other sources, down with it.
+type Registry struct {
+	mu       sync.Mutex
+	sources  []IngestionSource
+	statuses map[string]*SourceStatus
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{statuses: map[string]*SourceStatus{}}
+}
+
+// Register adds a source. Sources must be registered before Start is called.
+func (t *Registry) Register(source IngestionSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sources = append(t.sources, source)
+	t.statuses[source.Name()] = &SourceStatus{Name: source.Name(), State: STATE_STOPPED, Since: time.Now()}
+}
+
+// Start runs every registered source in its own goroutine until the context is cancelled or Stop is called.
+func (t *Registry) Start(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ctx, t.cancel = context.WithCancel(ctx)
+	for _, source := range t.sources {
+		t.wg.Add(1)
+		go t.supervise(ctx, source)
+	}
+}
+
+// Stop stops every source and waits for them to return.
+func (t *Registry) Stop() {
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	for _, source := range t.sources {
+		source.Stop()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+// Status returns the status of every registered source, in registration order.
+func (t *Registry) Status() []SourceStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := []SourceStatus{}
+	for _, source := range t.sources {
+		status := *t.statuses[source.Name()]
+		if status.State == STATE_RUNNING {
+			if err := source.Health(); err != nil {
+				status.Healthy = false
+				status.LastError = err.Error()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Healthy reports whether every registered source is running without problems.
+func (t *Registry) Healthy() bool {
+	for _, status := range t.Status() {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// supervise runs a source and restarts it with exponential backoff every time it fails.
+func (t *Registry) supervise(ctx context.Context, source IngestionSource) {
+	defer t.wg.Done()
+	delay := time.Second
+	for ctx.Err() == nil {
+		t.setState(source.Name(), STATE_RUNNING, nil)
+		logger.Log.Info(logger.LogPayload{
+			Component: "Ingestion Registry",
+			Operation: "Supervise",
+			Message:   "Starting ingestion source " + source.Name(),
+		})
+
+		started := time.Now()
+		err := source.Start(ctx)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+		t.setState(source.Name(), STATE_RESTARTING, err)
+		logger.Log.Error(logger.LogPayload{
+			Component: "Ingestion Registry",
+			Operation: "Supervise",
+			Message:   fmt.Sprintf("Ingestion source %s failed, restarting in %s", source.Name(), delay),
+			Error:     err,
+		})
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+	t.setState(source.Name(), STATE_STOPPED, nil)
+	logger.Log.Info(logger.LogPayload{
+		Component: "Ingestion Registry",
+		Operation: "Supervise",
+		Message:   "Ingestion source " + source.Name() + " stopped",
+	})
+}
+
+func (t *Registry) setState(name string, state string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[name]
+	if state == STATE_RESTARTING {
+		status.Restarts++
+	}
+	status.State = state
+	status.Healthy = state == STATE_RUNNING
+	status.Since = time.Now()
+	if err != nil {
+		status.LastError = err.Error()
+	} else if state == STATE_RUNNING {
+		status.LastError = ""
+	}
+}
@@ -0,0 +1,57 @@
+package ingestion
+
+// Package ingestion runs the message sources notifications are consumed from.
+
+import (
+	"context"
+	"sync"
+)
+
+// IngestionSource is a message source notifications are consumed from.
+// Start blocks until the source is stopped or fails. A failed source is restarted by the Registry.
+// Health reports the last problem of a running source, or nil when it is healthy.
+type IngestionSource interface {
+	Name() string
+	Start(ctx context.Context) error
+	Stop()
+	Health() error
+}
+
+// Lifecycle implements Stop and Health for sources embedding it. Start of such a source derives
+// its context with Begin and returns once that context is done.
+type Lifecycle struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	health error
+}
+
+// Begin returns the context a run of the source must stop with.
+func (t *Lifecycle) Begin(ctx context.Context) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.health = nil
+	return ctx
+}
+
+// Stop cancels the context of the current run, if any.
+func (t *Lifecycle) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// SetHealth records the current problem of the source, or clears it with nil.
+func (t *Lifecycle) SetHealth(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.health = err
+}
+
+func (t *Lifecycle) Health() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.health
+}
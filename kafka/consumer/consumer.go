@@ -0,0 +1,185 @@
+package consumer
+
+// Package consumer contains the code for the Kafka notification event consumer.
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/ingestion"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterService "r2-notify-server/services/deadletter"
+	ingestionService "r2-notify-server/services/ingestion"
+	"r2-notify-server/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaConsumer is the ingestion source consuming notification events from a Kafka topic.
+type KafkaConsumer struct {
+	ingestion.Lifecycle
+	processor         deadLetterService.Processor
+	deadLetterService deadLetterService.DeadLetterService
+}
+
+// NewKafkaConsumer returns a new instance of KafkaConsumer.
+// It requires a processor and a deadLetterService to be injected for its dependencies.
+func NewKafkaConsumer(processor deadLetterService.Processor, deadLetterService deadLetterService.DeadLetterService) *KafkaConsumer {
+	return &KafkaConsumer{processor: processor, deadLetterService: deadLetterService}
+}
+
+func (t *KafkaConsumer) Name() string {
+	return data.SOURCE_KAFKA
+}
+
+// Start runs the Kafka consumer for notification events until it is stopped. It joins the configured
+// consumer group, so that the partitions of the topic are balanced between the replicas.
+// Each message is handed to the processor, and messages it fails to process are stored as dead letters.
+// The offset of a message is only committed once it is stored, as a notification or as a dead letter.
+// When a message can not be stored at all an error is returned, and the restarted reader resumes from
+// the last committed offset.
+func (t *KafkaConsumer) Start(ctx context.Context) error {
+
+	ctx = t.Begin(ctx)
+	cfg := config.LoadConfig()
+
+	dialer, err := newDialer(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure Kafka: %w", err)
+	}
+
+	if err := consume(ctx, cfg, dialer, t.processor, t.deadLetterService); err != nil {
+		return err
+	}
+
+	logger.Log.Info(logger.LogPayload{
+		Message:   "Shutting down kafka consumer",
+		Component: "Kafka Consumer",
+		Operation: "Shutdown Kafka Consumer",
+	})
+	return nil
+}
+
+// consume reads the topic until the context is cancelled or a message can not be stored.
+func consume(ctx context.Context, cfg *config.Config, dialer *kafka.Dialer, processor deadLetterService.Processor, deadLetterService deadLetterService.DeadLetterService) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers(cfg.KafkaBrokers),
+		Topic:       cfg.KafkaTopic,
+		GroupID:     cfg.KafkaConsumerGroup,
+		Dialer:      dialer,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:   fmt.Sprintf("Connected to Kafka topic %s in consumer group %s", cfg.KafkaTopic, cfg.KafkaConsumerGroup),
+		Component: "Kafka Consumer",
+		Operation: "Start",
+	})
+
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		logger.Log.Debug(logger.LogPayload{
+			Message:       fmt.Sprintf("Received message from Kafka %s", string(message.Value)),
+			Component:     "Kafka Consumer",
+			Operation:     "OnMessageReceived",
+			CorrelationId: utils.GenerateUUID(),
+		})
+
+		body := ingestionService.ToStructuredCloudEvent(headers(message), "", message.Value)
+		if err := processor.Process(data.SOURCE_KAFKA, body); err != nil {
+			if recordErr := deadLetterService.Record(toDeadLetter(message, body, cfg), err); recordErr != nil {
+				return errors.Join(err, recordErr)
+			}
+		}
+
+		if err := reader.CommitMessages(ctx, message); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// brokers splits the comma separated list of broker addresses.
+func brokers(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// newDialer returns the dialer of the reader, with TLS and SASL authentication when configured.
+func newDialer(cfg *config.Config) (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
+	if cfg.KafkaTLSEnabled {
+		dialer.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	var mechanism sasl.Mechanism
+	var err error
+	switch strings.ToLower(cfg.KafkaSaslMechanism) {
+	case "":
+		return dialer, nil
+	case "plain":
+		mechanism = plain.Mechanism{Username: cfg.KafkaUsername, Password: cfg.KafkaPassword}
+	case "scram-sha-256":
+		mechanism, err = scram.Mechanism(scram.SHA256, cfg.KafkaUsername, cfg.KafkaPassword)
+	case "scram-sha-512":
+		mechanism, err = scram.Mechanism(scram.SHA512, cfg.KafkaUsername, cfg.KafkaPassword)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", cfg.KafkaSaslMechanism)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dialer.SASLMechanism = mechanism
+	return dialer, nil
+}
+
+// headers returns the headers of a message, which carry the attributes of a binary mode CloudEvent.
+func headers(message kafka.Message) map[string]string {
+	values := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		values[header.Key] = string(header.Value)
+	}
+	return values
+}
+
+// toDeadLetter captures the body and the position of a failed message.
+func toDeadLetter(message kafka.Message, body []byte, cfg *config.Config) models.DeadLetter {
+	deadLetter := models.DeadLetter{
+		Source:        data.SOURCE_KAFKA,
+		Topic:         message.Topic,
+		ConsumerGroup: cfg.KafkaConsumerGroup,
+		Partition:     strconv.Itoa(message.Partition),
+		PartitionKey:  string(message.Key),
+		Offset:        strconv.FormatInt(message.Offset, 10),
+		Body:          string(body),
+	}
+	if !message.Time.IsZero() {
+		deadLetter.EnqueuedAt = &message.Time
+	}
+	return deadLetter
+}
@@ -0,0 +1,302 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterService "r2-notify-server/services/deadletter"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+const testTopic = "app-notifications"
+
+// newBroker starts an in-process Kafka broker with the test topic. It returns the configuration of a consumer
+// of the topic, and a client of the broker.
+func newBroker(t *testing.T) (*config.Config, *kgo.Client) {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, testTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	assignMemberIds(cluster, client)
+	return &config.Config{
+		KafkaBrokers:       strings.Join(cluster.ListenAddrs(), ","),
+		KafkaTopic:         testTopic,
+		KafkaConsumerGroup: "r2-notify-server",
+	}, client
+}
+
+// assignMemberIds works around the broker answering the first JoinGroup of a member below version 4,
+// the versions kafka-go uses, with an empty member ID. The member ID is requested with a JoinGroup
+// of version 4 beforehand, which the broker then completes as the rejoin of a pending member.
+func assignMemberIds(cluster *kfake.Cluster, client *kgo.Client) {
+	cluster.ControlKey(kmsg.JoinGroup.Int16(), func(request kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		join := request.(*kmsg.JoinGroupRequest)
+		if join.Version >= 4 || join.MemberID != "" {
+			return nil, nil, false
+		}
+		pending := kmsg.NewPtrJoinGroupRequest()
+		pending.Group = join.Group
+		pending.SessionTimeoutMillis = join.SessionTimeoutMillis
+		pending.RebalanceTimeoutMillis = join.RebalanceTimeoutMillis
+		pending.ProtocolType = join.ProtocolType
+		pending.Protocols = join.Protocols
+		cluster.SleepControl(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if response, err := pending.RequestWith(ctx, client); err == nil {
+				join.MemberID = response.MemberID
+			}
+		})
+		return nil, nil, false
+	})
+}
+
+func produce(t *testing.T, cfg *config.Config, messages ...kafka.Message) {
+	t.Helper()
+	writer := &kafka.Writer{Addr: kafka.TCP(brokers(cfg.KafkaBrokers)...), Topic: cfg.KafkaTopic, BatchTimeout: time.Millisecond}
+	defer writer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := writer.WriteMessages(ctx, messages...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// committedOffset returns the offset of the consumer group in the partition of the test topic, -1 when none was committed.
+func committedOffset(t *testing.T, cfg *config.Config, client *kgo.Client) int64 {
+	t.Helper()
+	request := kmsg.NewPtrOffsetFetchRequest()
+	request.Group = cfg.KafkaConsumerGroup
+	topic := kmsg.NewOffsetFetchRequestTopic()
+	topic.Topic = cfg.KafkaTopic
+	topic.Partitions = []int32{0}
+	request.Topics = append(request.Topics, topic)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := request.RequestWith(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range response.Topics {
+		for _, partition := range topic.Partitions {
+			if partition.ErrorCode == 0 {
+				return partition.Offset
+			}
+		}
+	}
+	return -1
+}
+
+// recordingProcessor records the bodies it is given, failing those containing "unprocessable".
+type recordingProcessor struct {
+	mutex  sync.Mutex
+	bodies []string
+}
+
+func (p *recordingProcessor) Process(source string, body []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.bodies = append(p.bodies, source+" "+string(body))
+	if strings.Contains(string(body), "unprocessable") {
+		return deadLetterService.ErrInvalidEvent
+	}
+	return nil
+}
+
+func (p *recordingProcessor) processed() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.bodies...)
+}
+
+// recordingDeadLetterService records the dead letters, or fails to store them when err is set.
+type recordingDeadLetterService struct {
+	deadLetterService.DeadLetterService
+	mutex       sync.Mutex
+	err         error
+	deadLetters []models.DeadLetter
+}
+
+func (s *recordingDeadLetterService) Record(deadLetter models.DeadLetter, cause error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.deadLetters = append(s.deadLetters, deadLetter)
+	return nil
+}
+
+func (s *recordingDeadLetterService) recorded() []models.DeadLetter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]models.DeadLetter{}, s.deadLetters...)
+}
+
+// startConsumer consumes the topic until the returned function is called, which returns the error of consume.
+func startConsumer(t *testing.T, cfg *config.Config, processor deadLetterService.Processor, deadLetters deadLetterService.DeadLetterService) (stop func() error, done <-chan error) {
+	t.Helper()
+	dialer, err := newDialer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- consume(ctx, cfg, dialer, processor, deadLetters) }()
+	stop = func() error {
+		cancel()
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(30 * time.Second):
+			t.Fatal("expected the consumer to stop")
+			return nil
+		}
+	}
+	t.Cleanup(func() { cancel() })
+	return stop, errs
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMessagesAreCommittedOnceStored(t *testing.T) {
+	cfg, client := newBroker(t)
+	produce(t, cfg,
+		kafka.Message{Key: []byte("u1"), Value: []byte(`{"appId":"app-a","userId":"u1","groupKey":"builds","message":"main is red","status":"error"}`)},
+		kafka.Message{Key: []byte("u1"), Value: []byte(`{"appId":"app-a","message":"unprocessable"}`)},
+		kafka.Message{Key: []byte("u1"), Value: []byte(`"main is red"`), Headers: []kafka.Header{
+			{Key: "ce-specversion", Value: []byte("1.0")},
+			{Key: "ce-id", Value: []byte("e1")},
+			{Key: "ce-type", Value: []byte("build.failed")},
+			{Key: "ce-source", Value: []byte("app-a")},
+			{Key: "ce-subject", Value: []byte("u1")},
+		}},
+	)
+	processor := &recordingProcessor{}
+	deadLetters := &recordingDeadLetterService{}
+
+	stop, _ := startConsumer(t, cfg, processor, deadLetters)
+	waitFor(t, func() bool { return committedOffset(t, cfg, client) == 3 }, "expected the 3 messages to be committed")
+	if err := stop(); err != nil {
+		t.Fatalf("expected the consumer to stop without error, got %v", err)
+	}
+
+	bodies := processor.processed()
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 messages to be processed, got %v", bodies)
+	}
+	if !strings.HasPrefix(bodies[0], data.SOURCE_KAFKA+" {\"appId\":\"app-a\"") {
+		t.Fatalf("expected the payload to be processed as a kafka notification, got %s", bodies[0])
+	}
+	if !strings.Contains(bodies[2], `"specversion":"1.0"`) || !strings.Contains(bodies[2], `"type":"build.failed"`) {
+		t.Fatalf("expected the binary mode CloudEvent to be processed in structured mode, got %s", bodies[2])
+	}
+	recorded := deadLetters.recorded()
+	if len(recorded) != 1 {
+		t.Fatalf("expected the failed message to be stored as a dead letter, got %d", len(recorded))
+	}
+	if deadLetter := recorded[0]; deadLetter.Source != data.SOURCE_KAFKA || deadLetter.Topic != testTopic || deadLetter.ConsumerGroup != "r2-notify-server" ||
+		deadLetter.Partition != "0" || deadLetter.Offset != "1" || deadLetter.PartitionKey != "u1" || deadLetter.Body != `{"appId":"app-a","message":"unprocessable"}` {
+		t.Fatalf("expected the dead letter to capture the failed message and its position, got %+v", deadLetter)
+	}
+}
+
+func TestMessagesAreNotCommittedWhenTheyCanNotBeStored(t *testing.T) {
+	cfg, client := newBroker(t)
+	produce(t, cfg, kafka.Message{Value: []byte(`{"appId":"app-a","message":"unprocessable"}`)})
+	processor := &recordingProcessor{}
+	deadLetters := &recordingDeadLetterService{err: errors.New("mongo is down")}
+
+	_, done := startConsumer(t, cfg, processor, deadLetters)
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "mongo is down") {
+			t.Fatalf("expected the consumer to fail with the dead letter error, got %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("expected the consumer to stop once the message could not be stored")
+	}
+	if offset := committedOffset(t, cfg, client); offset >= 0 {
+		t.Fatalf("expected no offset to be committed, got %d", offset)
+	}
+
+	// The restarted consumer resumes from the last committed offset
+	deadLetters.err = nil
+	stop, _ := startConsumer(t, cfg, processor, deadLetters)
+	waitFor(t, func() bool { return committedOffset(t, cfg, client) == 1 }, "expected the message to be committed once stored")
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(processor.processed()) != 2 || len(deadLetters.recorded()) != 1 {
+		t.Fatalf("expected the message to be redelivered and stored as a dead letter, processed %d", len(processor.processed()))
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		valid     bool
+		name      string
+	}{
+		{"", true, ""},
+		{"PLAIN", true, "PLAIN"},
+		{"scram-sha-256", true, "SCRAM-SHA-256"},
+		{"scram-sha-512", true, "SCRAM-SHA-512"},
+		{"gssapi", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.mechanism, func(t *testing.T) {
+			dialer, err := newDialer(&config.Config{KafkaSaslMechanism: test.mechanism, KafkaUsername: "user", KafkaPassword: "secret", KafkaTLSEnabled: true})
+			if !test.valid {
+				if err == nil {
+					t.Fatal("expected the mechanism to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dialer.TLS == nil {
+				t.Fatal("expected TLS to be enabled")
+			}
+			if (dialer.SASLMechanism == nil) != (test.name == "") || (dialer.SASLMechanism != nil && dialer.SASLMechanism.Name() != test.name) {
+				t.Fatalf("expected mechanism %q, got %v", test.name, dialer.SASLMechanism)
+			}
+		})
+	}
+}
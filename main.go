@@ -12,14 +12,42 @@ import (
 	"r2-notify-server/data"
 	"r2-notify-server/event-hub/consumer"
 	"r2-notify-server/handlers"
+	"r2-notify-server/ingestion"
+	kafkaConsumer "r2-notify-server/kafka/consumer"
 	"r2-notify-server/logger"
 	"r2-notify-server/middleware"
+	rabbitMqConsumer "r2-notify-server/rabbitmq/consumer"
+	redisStreamConsumer "r2-notify-server/redis-stream/consumer"
+	appRepository "r2-notify-server/repository/app"
+	auditRepository "r2-notify-server/repository/audit"
+	chatRepository "r2-notify-server/repository/chat"
 	configurationRepository "r2-notify-server/repository/configuration"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	kindRepository "r2-notify-server/repository/kind"
+	leaseRepository "r2-notify-server/repository/lease"
 	notificationRepository "r2-notify-server/repository/notification"
+	pushRepository "r2-notify-server/repository/push"
+	sessionRepository "r2-notify-server/repository/session"
+	userRepository "r2-notify-server/repository/user"
+	webhookRepository "r2-notify-server/repository/webhook"
 	"r2-notify-server/router"
+	appService "r2-notify-server/services/app"
+	auditService "r2-notify-server/services/audit"
 	authenticationService "r2-notify-server/services/authentication"
+	chatService "r2-notify-server/services/chat"
 	configurationService "r2-notify-server/services/configuration"
+	deadLetterService "r2-notify-server/services/deadletter"
+	deliveryService "r2-notify-server/services/delivery"
+	digestService "r2-notify-server/services/digest"
+	emailService "r2-notify-server/services/email"
+	ingestionService "r2-notify-server/services/ingestion"
+	kindService "r2-notify-server/services/kind"
 	notificationService "r2-notify-server/services/notification"
+	pushService "r2-notify-server/services/push"
+	rateLimitService "r2-notify-server/services/ratelimit"
+	sessionService "r2-notify-server/services/session"
+	userService "r2-notify-server/services/user"
+	webhookService "r2-notify-server/services/webhook"
 	"r2-notify-server/utils"
 	"syscall"
 	"time"
@@ -57,8 +85,52 @@ func main() {
 	logger.Init()
 	defer logger.Log.Flush()
 
+	// Load the keys tokens are signed and verified with
+	if err := utils.InitTokenKeys(); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "TokenKeys",
+			Message:   "Failed to load token keys",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	// Load the keys the title and message of notifications are encrypted with
+	if err := utils.InitFieldKeys(); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "FieldKeys",
+			Message:   "Failed to load field encryption keys",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	auditRepository := auditRepository.NewAuditRepositoryImpl(mongoDb)
+	auditService, err := auditService.NewAuditServiceImpl(auditRepository)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "AuditService",
+			Message:   "Failed to initialize audit service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	webhookRepository := webhookRepository.NewWebhookRepositoryImpl(mongoDb)
+	webhookService, err := webhookService.NewWebhookServiceImpl(webhookRepository, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "WebhookService",
+			Message:   "Failed to initialize webhook service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
 	notificationRepository := notificationRepository.NewNotificationRepositoryImpl(mongoDb)
-	notificationService, err := notificationService.NewNotificationServiceImpl(notificationRepository, validate)
+	notificationService, err := notificationService.NewNotificationServiceImpl(notificationRepository, webhookService, auditService, validate)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Main",
@@ -69,7 +141,7 @@ func main() {
 		os.Exit(1)
 	}
 	configurationRepository := configurationRepository.NewConfigurationRepositoryImpl(mongoDb)
-	configurationService, err := configurationService.NewConfigurationServiceImpl(configurationRepository, validate)
+	configurationService, err := configurationService.NewConfigurationServiceImpl(configurationRepository, auditService, validate)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Main",
@@ -80,49 +152,251 @@ func main() {
 		os.Exit(1)
 	}
 
-	authenticationService, err := authenticationService.NewAuthenticationServiceImpl()
+	emailService, err := emailService.NewEmailServiceImpl(configurationService)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "EmailService",
+			Message:   "Failed to initialize email service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	pushRepository := pushRepository.NewPushRepositoryImpl(mongoDb)
+	pushService, err := pushService.NewPushServiceImpl(pushRepository, configurationService, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "PushService",
+			Message:   "Failed to initialize push service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	chatRepository := chatRepository.NewChatRepositoryImpl(mongoDb)
+	chatService, err := chatService.NewChatServiceImpl(chatRepository)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "ChatService",
+			Message:   "Failed to initialize chat service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	deliveryService, err := deliveryService.NewDeliveryServiceImpl(notificationService, configurationService,
+		deliveryService.NewWebSocketChannel(),
+		deliveryService.NewChatChannel(chatService, configurationService),
+		deliveryService.NewPushChannel(pushService),
+		deliveryService.NewEmailChannel(emailService),
+		deliveryService.NewWebhookChannel(webhookService),
+	)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "DeliveryService",
+			Message:   "Failed to initialize delivery service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	digestService, err := digestService.NewDigestServiceImpl(configurationService, notificationService, emailService)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "DigestService",
+			Message:   "Failed to initialize digest service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	sessionRepository := sessionRepository.NewSessionRepositoryImpl(mongoDb)
+	if err := sessionRepository.EnsureIndexes(); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "SessionRepository",
+			Message:   "Failed to create indexes of session repository",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	sessionService, err := sessionService.NewSessionServiceImpl(sessionRepository)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "SessionService",
+			Message:   "Failed to initialize session service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	authenticationService, err := authenticationService.NewAuthenticationServiceImpl(configurationService, sessionService)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "AuthenticationService",
+			Message:   "Failed to initialize authentication service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	userRepository := userRepository.NewUserRepositoryImpl(mongoDb)
+	userService, err := userService.NewUserServiceImpl(userRepository, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "UserService",
+			Message:   "Failed to initialize user service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	appRepository := appRepository.NewAppRepositoryImpl(mongoDb)
+	appService, err := appService.NewAppServiceImpl(appRepository, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "AppService",
+			Message:   "Failed to initialize app service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
 
-	// Start Event Hub consumer in a goroutuine to avoid blocking
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go func() {
-		if err := consumer.StartEventHubConsumer(ctx, notificationService); err != nil {
-			logger.Log.Error(logger.LogPayload{
-				Component: "Main",
-				Operation: "EventHubConsumer",
-				Message:   "Failed to start Event Hub consumer",
-				Error:     err,
-			})
-			os.Exit(1)
-		}
-	}()
+
+	// Start webhook delivery worker
+	go webhookService.Start(ctx)
+
+	// Start chat delivery worker
+	go chatService.Start(ctx)
+
+	// Start digest scheduler
+	go digestService.Start(ctx)
+
+	// Close the WebSocket connections of revoked sessions
+	go sessionService.Start(ctx)
+
+	leaseRepository := leaseRepository.NewLeaseRepositoryImpl(mongoDb)
+
+	deadLetterRepository := deadLetterRepository.NewDeadLetterRepositoryImpl(mongoDb)
+	deadLetterService, err := deadLetterService.NewDeadLetterServiceImpl(deadLetterRepository)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "DeadLetterService",
+			Message:   "Failed to initialize dead letter service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	kindRepository := kindRepository.NewKindRepositoryImpl(mongoDb)
+	kindService, err := kindService.NewKindServiceImpl(kindRepository, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "KindService",
+			Message:   "Failed to initialize notification kind service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	rateLimitService, err := rateLimitService.NewRateLimitServiceImpl()
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "RateLimitService",
+			Message:   "Failed to initialize rate limit service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+	ingestionService, err := ingestionService.NewIngestionServiceImpl(notificationService, deliveryService, kindService, appService, rateLimitService, validate)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Main",
+			Operation: "IngestionService",
+			Message:   "Failed to initialize ingestion service",
+			Error:     err,
+		})
+		os.Exit(1)
+	}
+
+	// Start dead letter retry worker
+	go deadLetterService.Start(ctx, ingestionService)
+
+	// Start the enabled ingestion sources, restarted with backoff when they fail
+	sources := ingestion.NewRegistry()
+	if config.LoadConfig().EnableEventHub {
+		sources.Register(consumer.NewEventHubConsumer(ingestionService, deadLetterService, leaseRepository))
+	}
+	if config.LoadConfig().EnableKafka {
+		sources.Register(kafkaConsumer.NewKafkaConsumer(ingestionService, deadLetterService))
+	}
+	if config.LoadConfig().EnableRedisStream {
+		sources.Register(redisStreamConsumer.NewRedisStreamConsumer(ingestionService, deadLetterService))
+	}
+	if config.LoadConfig().EnableRabbitMq {
+		sources.Register(rabbitMqConsumer.NewRabbitMqConsumer(ingestionService))
+	}
+	sources.Start(ctx)
 
 	// Create Notification Controller
-	notificationController := controller.NewNotificationController(notificationService)
-	authenticationController := controller.NewAuthController(authenticationService)
+	notificationController := controller.NewNotificationController(ingestionService)
+	authenticationController := controller.NewAuthController(authenticationService, sessionService)
+	webhookController := controller.NewWebhookController(webhookService, appService)
+	pushController := controller.NewPushController(pushService)
+	chatTargetController := controller.NewChatTargetController(configurationService, appService)
+	deadLetterController := controller.NewDeadLetterController(deadLetterService)
+	notificationKindController := controller.NewNotificationKindController(kindService)
+	adminController := controller.NewAdminController(appService, userService, notificationService, configurationService, sessionService, auditService)
 
 	// Register routes
 	router.RegisterNotificationRoutes(r, notificationController)
 	router.RegisterAuthenticationRoutes(r, authenticationController)
+	router.RegisterWebhookRoutes(r, webhookController, userService)
+	router.RegisterPushRoutes(r, pushController)
+	router.RegisterChatTargetRoutes(r, chatTargetController, userService)
+	router.RegisterDeadLetterRoutes(r, deadLetterController, userService, auditService)
+	router.RegisterNotificationKindRoutes(r, notificationKindController, userService, auditService)
+	router.RegisterAdminRoutes(r, adminController, userService, auditService)
 
 	// Health check route
 	r.GET("/health", func(c *gin.Context) {
+		status := "ok"
+		if !sources.Healthy() {
+			status = "degraded"
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
+			"status":  status,
 			"service": "r2-notify-server",
+			"sources": sources.Status(),
 		})
 	})
 
+	// Public keys of the tokens issued by the server
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, utils.TokenKeys.PublicJWKS())
+	})
+
 	// Register WebSocket route
 	r.GET("/ws", func(c *gin.Context) {
-		handlers.NewWebSocketHandler(notificationService, configurationService)(c.Writer, c.Request)
+		handlers.NewWebSocketHandler(notificationService, configurationService, pushService, sessionService, rateLimitService)(c.Writer, c.Request)
 	})
 
 	// Enable CORS for all origins and methods needed for REST/WS
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   utils.ProcessAllowedOrigins(config.LoadConfig().AllowedOrigins),
-		AllowedMethods:   []string{"POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "X-App-ID", "X-Correlation-ID", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "X-App-ID", "X-Correlation-ID", "Authorization", "ce-specversion", "ce-id", "ce-type", "ce-source", "ce-subject", "ce-time"},
 		AllowCredentials: true,
 		Debug:            true,
 	}).Handler(r)
@@ -162,6 +436,9 @@ func main() {
 	})
 	cancel()
 
+	// Wait for the ingestion sources to stop consuming
+	sources.Stop()
+
 	// Gracefully shutdown HTTP server
 	ctxShutdown, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	auditService "r2-notify-server/services/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditMiddleware records the requests it handles as audit events of an admin, with the route as
+// action, the path and query parameters as scope, and the response status. Reads are only recorded
+// when they are denied. It must be used after AuthenticationMiddleware and before RoleMiddleware, so
+// that denied requests are recorded as well.
+func AuditMiddleware(service auditService.AuditService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if isRead(c.Request.Method) && c.Writer.Status() != http.StatusForbidden {
+			return
+		}
+
+		scope := map[string]string{}
+		for _, param := range c.Params {
+			scope[param.Key] = param.Value
+		}
+		for key, values := range c.Request.URL.Query() {
+			if len(values) > 0 {
+				scope[key] = values[0]
+			}
+		}
+		if appId := c.GetHeader("X-App-ID"); appId != "" {
+			scope["appId"] = appId
+		}
+		event := data.AuditEvent{
+			TenantId:      c.GetString(data.TENANT_ID),
+			ActorId:       c.GetString(data.USER_ID),
+			ActorType:     data.AUDIT_ACTOR_ADMIN,
+			Action:        c.Request.Method + " " + c.FullPath(),
+			Scope:         scope,
+			Status:        c.Writer.Status(),
+			CorrelationId: c.GetString(data.CORRELATION_ID),
+		}
+		if access, ok := c.Get(data.USER_ACCESS); ok {
+			event.Roles = access.(data.UserAccess).Roles
+		}
+		if err := service.Record(event); err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component:     "Audit Middleware",
+				Operation:     "AuditMiddleware",
+				Message:       "Failed to record audit event: " + event.Action,
+				UserId:        event.ActorId,
+				CorrelationId: event.CorrelationId,
+				Error:         err,
+			})
+		}
+	}
+}
+
+// isRead reports whether a request of the given method leaves the data unchanged.
+func isRead(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
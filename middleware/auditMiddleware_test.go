@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	auditService "r2-notify-server/services/audit"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// recordingAuditService records the audit events of requests.
+type recordingAuditService struct {
+	auditService.AuditService
+	events *[]data.AuditEvent
+}
+
+func (s recordingAuditService) Record(event data.AuditEvent) error {
+	*s.events = append(*s.events, event)
+	return nil
+}
+
+func TestAuditMiddlewareRecordsChangesAndDeniedRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		action string
+	}{
+		{"allowed read", http.MethodGet, http.StatusOK, ""},
+		{"denied read", http.MethodGet, http.StatusForbidden, "GET /admin/users/:userId/sessions"},
+		{"allowed change", http.MethodDelete, http.StatusNoContent, "DELETE /admin/users/:userId/sessions"},
+		{"denied change", http.MethodDelete, http.StatusForbidden, "DELETE /admin/users/:userId/sessions"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var events []data.AuditEvent
+			engine := gin.New()
+			engine.Handle(test.method, "/admin/users/:userId/sessions", func(ctx *gin.Context) {
+				ctx.Set(data.TENANT_ID, "tenant-b")
+				ctx.Set(data.USER_ID, "s1")
+				ctx.Set(data.USER_ACCESS, data.UserAccess{UserId: "s1", Roles: []string{data.ROLE_SUPPORT}})
+			}, AuditMiddleware(recordingAuditService{events: &events}), func(ctx *gin.Context) {
+				ctx.Status(test.status)
+			})
+
+			request := httptest.NewRequest(test.method, "/admin/users/u1/sessions?reason=leaver", nil)
+			engine.ServeHTTP(httptest.NewRecorder(), request)
+
+			if test.action == "" {
+				if len(events) != 0 {
+					t.Fatalf("expected the allowed read not to be recorded, got %+v", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected one audit event, got %+v", events)
+			}
+			event := events[0]
+			if event.Action != test.action || event.Status != test.status || event.TenantId != "tenant-b" || event.ActorId != "s1" || event.ActorType != data.AUDIT_ACTOR_ADMIN {
+				t.Fatalf("expected the request to be recorded, got %+v", event)
+			}
+			if event.Scope["userId"] != "u1" || event.Scope["reason"] != "leaver" || len(event.Roles) != 1 {
+				t.Fatalf("expected the parameters and roles to be recorded, got %+v", event)
+			}
+		})
+	}
+}
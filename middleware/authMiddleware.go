@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	sessionService "r2-notify-server/services/session"
+	"r2-notify-server/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthenticationMiddleware validates the bearer token of the request and stores the
+// authenticated user ID in the gin.Context under data.USER_ID, its tenant under data.TENANT_ID,
+// and the claims of the token under data.TOKEN_CLAIMS. Requests without a valid
+// token, or with a token of a revoked session, are rejected with 401 Unauthorized.
+func AuthenticationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		correlationId := c.GetString(data.CORRELATION_ID)
+		authorization := c.GetHeader("Authorization")
+
+		bearerPrefix := "Bearer "
+		if !strings.HasPrefix(authorization, bearerPrefix) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		claims, err := utils.ParseToken(strings.TrimPrefix(authorization, bearerPrefix))
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component:     "Authentication Middleware",
+				Operation:     "AuthenticationMiddleware",
+				Message:       "Invalid token",
+				AppId:         c.GetHeader("X-App-ID"),
+				CorrelationId: correlationId,
+				Error:         err,
+			})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		revoked, err := sessionService.IsRevoked(claims)
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component:     "Authentication Middleware",
+				Operation:     "AuthenticationMiddleware",
+				Message:       "Failed to check the revocation of token",
+				UserId:        claims.Subject,
+				AppId:         c.GetHeader("X-App-ID"),
+				CorrelationId: correlationId,
+				Error:         err,
+			})
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to validate token"})
+			return
+		}
+		if revoked {
+			logger.Log.Warn(logger.LogPayload{
+				Component:     "Authentication Middleware",
+				Operation:     "AuthenticationMiddleware",
+				Message:       "Token of a revoked session",
+				UserId:        claims.Subject,
+				AppId:         c.GetHeader("X-App-ID"),
+				CorrelationId: correlationId,
+			})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		c.Set(data.USER_ID, claims.Subject)
+		c.Set(data.TENANT_ID, claims.Tenant())
+		c.Set(data.TOKEN_CLAIMS, claims)
+		c.Next()
+	}
+}
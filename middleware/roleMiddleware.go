@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	userService "r2-notify-server/services/user"
+	"r2-notify-server/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleMiddleware only lets through users having one of the given roles, super admins having every
+// role, and rejects everyone else with 403 Forbidden. The roles and apps of the user are stored in the
+// gin.Context under data.USER_ACCESS. It must be used after AuthenticationMiddleware.
+func RoleMiddleware(service userService.UserService, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get(data.TOKEN_CLAIMS)
+		access, err := service.FindAccess(claims.(*utils.TokenClaims))
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component:     "Role Middleware",
+				Operation:     "RoleMiddleware",
+				Message:       "Failed to fetch the roles of user",
+				UserId:        c.GetString(data.USER_ID),
+				CorrelationId: c.GetString(data.CORRELATION_ID),
+				Error:         err,
+			})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+			return
+		}
+		c.Set(data.USER_ACCESS, access)
+
+		if !userService.HasRole(access, roles...) {
+			logger.Log.Warn(logger.LogPayload{
+				Component:     "Role Middleware",
+				Operation:     "RoleMiddleware",
+				Message:       "Access denied, requires one of the roles: " + strings.Join(roles, ", "),
+				UserId:        access.UserId,
+				CorrelationId: c.GetString(data.CORRELATION_ID),
+			})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Role " + strings.Join(roles, " or ") + " required"})
+			return
+		}
+		c.Next()
+	}
+}
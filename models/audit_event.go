@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent is an entry of the append-only audit log.
+type AuditEvent struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId      string             `bson:"tenantId,omitempty"`
+	ActorId       string             `bson:"actorId"`
+	ActorType     string             `bson:"actorType"`
+	Roles         []string           `bson:"roles,omitempty"`
+	Action        string             `bson:"action"`
+	Scope         map[string]string  `bson:"scope,omitempty"`
+	Status        int                `bson:"status,omitempty"`
+	CorrelationId string             `bson:"correlationId,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt"`
+}
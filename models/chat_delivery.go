@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ChatDelivery struct {
+	Id             primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId       string             `bson:"tenantId,omitempty"`
+	AppId          string             `bson:"appId"`
+	UserId         string             `bson:"userId"`
+	NotificationId string             `bson:"notificationId"`
+	TargetType     string             `bson:"targetType"`
+	Url            string             `bson:"url"`
+	Body           string             `bson:"body"`
+	Status         string             `bson:"status"`
+	Attempts       int                `bson:"attempts"`
+	LastError      string             `bson:"lastError,omitempty"`
+	NextAttemptAt  time.Time          `bson:"nextAttemptAt"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt"`
+}
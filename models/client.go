@@ -4,6 +4,7 @@ import "time"
 
 type ClientInfo struct {
 	ID                 string    `json:"id"`
+	TenantId           string    `json:"tenantId"`
 	ConnectedAt        time.Time `json:"connectedAt"`
 	EnableNotification bool      `json:"enableNotification"`
 }
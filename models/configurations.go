@@ -1,11 +1,33 @@
 package models
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Configuration struct {
 	Id                  primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId            string             `bson:"tenantId,omitempty"`
 	UserId              string             `bson:"userId"`
 	EnableNotifications bool               `bson:"enableNotifications"`
+	Email               string             `bson:"email,omitempty"`
+	EmailNotifications  bool               `bson:"emailNotifications"`
+	DigestNotifications bool               `bson:"digestNotifications"`
+	DigestCursor        time.Time          `bson:"digestCursor,omitempty"`
+	ChatTargets         []ChatTarget       `bson:"chatTargets,omitempty"`
+}
+
+type AppConfiguration struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId    string             `bson:"tenantId,omitempty"`
+	AppId       string             `bson:"appId"`
+	ChatTargets []ChatTarget       `bson:"chatTargets,omitempty"`
+	UpdatedAt   time.Time          `bson:"updatedAt"`
+}
+
+type ChatTarget struct {
+	Type     string   `bson:"type"`
+	Url      string   `bson:"url"`
+	Statuses []string `bson:"statuses,omitempty"`
 }
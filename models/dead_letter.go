@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeadLetter is an ingestion event that could not be turned into a notification, stored with
+// the raw body and its position in the source so that it can be inspected and replayed. The body is
+// encrypted at rest under the data key of Encryption when field encryption is enabled.
+type DeadLetter struct {
+	Id             primitive.ObjectID `bson:"_id,omitempty"`
+	Source         string             `bson:"source"`
+	Topic          string             `bson:"topic,omitempty"`
+	ConsumerGroup  string             `bson:"consumerGroup,omitempty"`
+	Partition      string             `bson:"partition,omitempty"`
+	PartitionKey   string             `bson:"partitionKey,omitempty"`
+	Offset         string             `bson:"offset,omitempty"`
+	SequenceNumber int64              `bson:"sequenceNumber,omitempty"`
+	EnqueuedAt     *time.Time         `bson:"enqueuedAt,omitempty"`
+	Body           string             `bson:"body"`
+	Error          string             `bson:"error"`
+	Status         string             `bson:"status"`
+	Attempts       int                `bson:"attempts"`
+	NextAttemptAt  time.Time          `bson:"nextAttemptAt"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt"`
+	Encryption     *FieldEncryption   `bson:"encryption,omitempty"`
+}
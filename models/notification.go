@@ -7,13 +7,36 @@ import (
 )
 
 type Notification struct {
-	Id         primitive.ObjectID `bson:"_id,omitempty"`
-	AppId      string             `bson:"appId"`
-	UserId     string             `bson:"userId"`
-	GroupKey   string             `bson:"groupKey"`
-	Message    string             `bson:"message"`
-	Status     string             `bson:"status"`
-	ReadStatus bool               `bson:"readStatus"`
-	CreatedAt  time.Time          `bson:"createdAt"`
-	UpdatedAt  time.Time          `bson:"updatedAt"`
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId    string             `bson:"tenantId,omitempty"`
+	AppId       string             `bson:"appId"`
+	UserId      string             `bson:"userId"`
+	GroupKey    string             `bson:"groupKey"`
+	Title       string             `bson:"title,omitempty"`
+	Message     string             `bson:"message"`
+	ActionUrl   string             `bson:"actionUrl,omitempty"`
+	Status      string             `bson:"status"`
+	Priority    string             `bson:"priority"`
+	ReadStatus  bool               `bson:"readStatus"`
+	Channels    []string           `bson:"channels,omitempty"`
+	Source      string             `bson:"source,omitempty"`
+	Deliveries  []ChannelDelivery  `bson:"deliveries,omitempty"`
+	DeliveredAt *time.Time         `bson:"deliveredAt,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt"`
+	Encryption  *FieldEncryption   `bson:"encryption,omitempty"`
+}
+
+// FieldEncryption is the data key the title and message of a notification are encrypted with,
+// wrapped by the key-encryption key with the given ID. Notifications without it are in plaintext.
+type FieldEncryption struct {
+	KeyId   string `bson:"keyId"`
+	DataKey []byte `bson:"dataKey"`
+}
+
+type ChannelDelivery struct {
+	Channel     string    `bson:"channel"`
+	Status      string    `bson:"status"`
+	Error       string    `bson:"error,omitempty"`
+	AttemptedAt time.Time `bson:"attemptedAt"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// NotificationKind is a registered CloudEvents type, holding the defaults of the notifications
+// created from events of that type.
+type NotificationKind struct {
+	Type      string    `bson:"_id"`
+	GroupKey  string    `bson:"groupKey"`
+	Status    string    `bson:"status"`
+	Priority  string    `bson:"priority,omitempty"`
+	Channels  []string  `bson:"channels,omitempty"`
+	Title     string    `bson:"title,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+type PartitionLease struct {
+	Id            string              `bson:"_id"`
+	Hub           string              `bson:"hub"`
+	ConsumerGroup string              `bson:"consumerGroup"`
+	PartitionId   string              `bson:"partitionId"`
+	Owner         string              `bson:"owner"`
+	Token         string              `bson:"token"`
+	Epoch         int64               `bson:"epoch"`
+	ExpiresAt     time.Time           `bson:"expiresAt"`
+	Checkpoint    PartitionCheckpoint `bson:"checkpoint"`
+	UpdatedAt     time.Time           `bson:"updatedAt"`
+}
+
+type PartitionCheckpoint struct {
+	Offset         string    `bson:"offset"`
+	SequenceNumber int64     `bson:"sequenceNumber"`
+	EnqueueTime    time.Time `bson:"enqueueTime"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PushSubscription struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId  string             `bson:"tenantId,omitempty"`
+	UserId    string             `bson:"userId"`
+	DeviceId  string             `bson:"deviceId"`
+	Endpoint  string             `bson:"endpoint"`
+	P256dh    string             `bson:"p256dh"`
+	Auth      string             `bson:"auth"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+type VapidKeys struct {
+	Id         string    `bson:"_id"`
+	PublicKey  string    `bson:"publicKey"`
+	PrivateKey string    `bson:"privateKey"`
+	CreatedAt  time.Time `bson:"createdAt"`
+}
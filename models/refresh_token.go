@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a refresh token of a login session. Only the SHA-256 hash of the token is stored.
+// A token is rotated on use: it is marked as rotated and replaced by a new token of the same session.
+type RefreshToken struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	SessionId string             `bson:"sessionId"`
+	TenantId  string             `bson:"tenantId,omitempty"`
+	UserId    string             `bson:"userId"`
+	Name      string             `bson:"name"`
+	Email     string             `bson:"email"`
+	TokenHash string             `bson:"tokenHash"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	RotatedAt *time.Time         `bson:"rotatedAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User holds the roles granted to a user of a tenant, and the apps the user administers as an app-admin.
+// Users are keyed by their tenant and user ID, so that the same user ID in two tenants is two users.
+type User struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId  string             `bson:"tenantId,omitempty"`
+	UserId    string             `bson:"userId"`
+	Roles     []string           `bson:"roles"`
+	Apps      []string           `bson:"apps"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+// App is an application registered to send notifications, keyed by its app ID. The notifications
+// of the app belong to its tenant.
+type App struct {
+	Id          string    `bson:"_id"`
+	TenantId    string    `bson:"tenantId,omitempty"`
+	Name        string    `bson:"name"`
+	Description string    `bson:"description,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	UpdatedAt   time.Time `bson:"updatedAt"`
+}
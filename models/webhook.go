@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookSubscription struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId  string             `bson:"tenantId,omitempty"`
+	AppId     string             `bson:"appId"`
+	Url       string             `bson:"url"`
+	Secret    string             `bson:"secret"`
+	Events    []string           `bson:"events"`
+	Format    string             `bson:"format,omitempty"`
+	Active    bool               `bson:"active"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `bson:"_id,omitempty"`
+	TenantId       string             `bson:"tenantId,omitempty"`
+	SubscriptionId primitive.ObjectID `bson:"subscriptionId"`
+	AppId          string             `bson:"appId"`
+	Event          string             `bson:"event"`
+	Payload        string             `bson:"payload"`
+	Status         string             `bson:"status"`
+	Attempts       int                `bson:"attempts"`
+	ResponseCode   int                `bson:"responseCode"`
+	LastError      string             `bson:"lastError,omitempty"`
+	ReplayOf       primitive.ObjectID `bson:"replayOf,omitempty"`
+	Encryption     *FieldEncryption   `bson:"encryption,omitempty"`
+	NextAttemptAt  time.Time          `bson:"nextAttemptAt"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt"`
+}
@@ -0,0 +1,192 @@
+package consumer
+
+// Package consumer contains the code for the RabbitMQ notification event consumer.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/ingestion"
+	"r2-notify-server/logger"
+	deadLetterService "r2-notify-server/services/deadletter"
+	ingestionService "r2-notify-server/services/ingestion"
+	"r2-notify-server/utils"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMqConsumer is the ingestion source consuming notification events from a RabbitMQ queue.
+type RabbitMqConsumer struct {
+	ingestion.Lifecycle
+	processor deadLetterService.Processor
+}
+
+// NewRabbitMqConsumer returns a new instance of RabbitMqConsumer.
+// It requires a processor to be injected for its dependencies.
+func NewRabbitMqConsumer(processor deadLetterService.Processor) *RabbitMqConsumer {
+	return &RabbitMqConsumer{processor: processor}
+}
+
+func (t *RabbitMqConsumer) Name() string {
+	return data.SOURCE_RABBITMQ
+}
+
+// Start runs the RabbitMQ consumer for notification events until it is stopped. It declares the exchange,
+// binds the queue to it with the configured routing key and consumes the queue, sharing its messages
+// between the replicas. A message is acknowledged once the notification is stored. Messages that can
+// not be decoded are sent straight to the dead letter exchange, and messages failing with any other
+// error are requeued until the broker dead letters them after RABBITMQ_MAX_REQUEUES deliveries.
+// An error is returned when the connection is lost.
+func (t *RabbitMqConsumer) Start(ctx context.Context) error {
+
+	ctx = t.Begin(ctx)
+	cfg := config.LoadConfig()
+
+	if err := consume(ctx, cfg, t.processor); err != nil {
+		return err
+	}
+
+	logger.Log.Info(logger.LogPayload{
+		Message:   "Shutting down rabbitmq consumer",
+		Component: "RabbitMQ Consumer",
+		Operation: "Shutdown RabbitMQ Consumer",
+	})
+	return nil
+}
+
+// consume connects to the broker and handles deliveries until the context is cancelled or the
+// connection is lost.
+func consume(ctx context.Context, cfg *config.Config, processor deadLetterService.Processor) error {
+	connection, err := amqp.Dial(cfg.RabbitMqUrl)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	defer connection.Close()
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	defer channel.Close()
+
+	if err := declareTopology(channel, cfg); err != nil {
+		return err
+	}
+	if err := channel.Qos(cfg.RabbitMqPrefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set RabbitMQ prefetch: %w", err)
+	}
+
+	consumerTag := data.SERVICE_NAME + "-" + utils.GenerateUUID()
+	deliveries, err := channel.Consume(cfg.RabbitMqQueue, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume RabbitMQ queue %s: %w", cfg.RabbitMqQueue, err)
+	}
+	closed := connection.NotifyClose(make(chan *amqp.Error, 1))
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:   fmt.Sprintf("Consuming RabbitMQ queue %s bound to exchange %s", cfg.RabbitMqQueue, cfg.RabbitMqExchange),
+		Component: "RabbitMQ Consumer",
+		Operation: "Start",
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = channel.Cancel(consumerTag, false)
+			return nil
+		case amqpErr := <-closed:
+			if amqpErr == nil {
+				return errors.New("RabbitMQ connection closed")
+			}
+			return amqpErr
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return errors.New("RabbitMQ delivery channel closed")
+			}
+			handle(delivery, processor)
+		}
+	}
+}
+
+// declareTopology declares the exchange, the dead letter exchange with a queue keeping the dead
+// letters, and the consumed queue. The queue is a quorum queue, so that the broker counts the
+// deliveries of every message and dead letters it once the requeue limit is reached.
+func declareTopology(channel *amqp.Channel, cfg *config.Config) error {
+	if err := channel.ExchangeDeclare(cfg.RabbitMqExchange, cfg.RabbitMqExchangeType, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ exchange %s: %w", cfg.RabbitMqExchange, err)
+	}
+	if err := channel.ExchangeDeclare(cfg.RabbitMqDeadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ exchange %s: %w", cfg.RabbitMqDeadLetterExchange, err)
+	}
+	deadLetterQueue := cfg.RabbitMqQueue + ".dead-letter"
+	if _, err := channel.QueueDeclare(deadLetterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ queue %s: %w", deadLetterQueue, err)
+	}
+	if err := channel.QueueBind(deadLetterQueue, "", cfg.RabbitMqDeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind RabbitMQ queue %s: %w", deadLetterQueue, err)
+	}
+	_, err := channel.QueueDeclare(cfg.RabbitMqQueue, true, false, false, false, amqp.Table{
+		amqp.QueueTypeArg:        amqp.QueueTypeQuorum,
+		"x-delivery-limit":       cfg.RabbitMqMaxRequeues,
+		"x-dead-letter-exchange": cfg.RabbitMqDeadLetterExchange,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ queue %s: %w", cfg.RabbitMqQueue, err)
+	}
+	if err := channel.QueueBind(cfg.RabbitMqQueue, cfg.RabbitMqRoutingKey, cfg.RabbitMqExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind RabbitMQ queue %s: %w", cfg.RabbitMqQueue, err)
+	}
+	return nil
+}
+
+// handle processes a delivery and settles it with the broker.
+func handle(delivery amqp.Delivery, processor deadLetterService.Processor) {
+	correlationId := utils.GenerateUUID()
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:       fmt.Sprintf("Received message from RabbitMQ %s", string(delivery.Body)),
+		Component:     "RabbitMQ Consumer",
+		Operation:     "OnMessageReceived",
+		CorrelationId: correlationId,
+	})
+
+	attributes := make(map[string]string, len(delivery.Headers))
+	for key, value := range delivery.Headers {
+		attributes[key] = fmt.Sprint(value)
+	}
+	body := ingestionService.ToStructuredCloudEvent(attributes, delivery.ContentType, delivery.Body)
+
+	err := processor.Process(data.SOURCE_RABBITMQ, body)
+	if err == nil {
+		if err := delivery.Ack(false); err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Message:       "Failed to acknowledge RabbitMQ message",
+				Component:     "RabbitMQ Consumer",
+				Operation:     "OnMessageReceived",
+				Error:         err,
+				CorrelationId: correlationId,
+			})
+		}
+		return
+	}
+
+	requeue := !errors.Is(err, deadLetterService.ErrInvalidEvent)
+	logger.Log.Warn(logger.LogPayload{
+		Message:       fmt.Sprintf("Failed to process RabbitMQ message (delivery count %v), requeue: %t", delivery.Headers["x-delivery-count"], requeue),
+		Component:     "RabbitMQ Consumer",
+		Operation:     "OnMessageReceived",
+		Error:         err,
+		CorrelationId: correlationId,
+	})
+	if err := delivery.Nack(false, requeue); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Message:       "Failed to reject RabbitMQ message",
+			Component:     "RabbitMQ Consumer",
+			Operation:     "OnMessageReceived",
+			Error:         err,
+			CorrelationId: correlationId,
+		})
+	}
+}
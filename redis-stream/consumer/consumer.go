@@ -0,0 +1,265 @@
+package consumer
+
+// Package consumer contains the code for the Redis Streams notification event consumer.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/ingestion"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterService "r2-notify-server/services/deadletter"
+	ingestionService "r2-notify-server/services/ingestion"
+	"r2-notify-server/utils"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// payloadField is the stream entry field holding the JSON notification payload, or the data of a
+// binary mode CloudEvent whose attributes are held by ce_* fields.
+const payloadField = "payload"
+
+// RedisStreamConsumer is the ingestion source consuming notification events from a Redis Stream.
+type RedisStreamConsumer struct {
+	ingestion.Lifecycle
+	processor         deadLetterService.Processor
+	deadLetterService deadLetterService.DeadLetterService
+	stream            string
+	group             string
+	name              string
+	batchSize         int64
+	block             time.Duration
+	claimIdle         time.Duration
+	maxDeliveries     int64
+}
+
+// NewRedisStreamConsumer returns a new instance of RedisStreamConsumer.
+// It requires a processor and a deadLetterService to be injected for its dependencies.
+// Reads block for at least a second, as a block of 0 would wait for new entries forever and
+// never let the consumer claim stale entries or stop.
+func NewRedisStreamConsumer(processor deadLetterService.Processor, deadLetterService deadLetterService.DeadLetterService) *RedisStreamConsumer {
+	cfg := config.LoadConfig()
+	return &RedisStreamConsumer{
+		processor:         processor,
+		deadLetterService: deadLetterService,
+		stream:            cfg.RedisStreamKey,
+		group:             cfg.RedisStreamConsumerGroup,
+		name:              consumerName(),
+		batchSize:         int64(cfg.RedisStreamBatchSize),
+		block:             max(time.Duration(cfg.RedisStreamBlock)*time.Second, time.Second),
+		claimIdle:         time.Duration(cfg.RedisStreamClaimIdle) * time.Second,
+		maxDeliveries:     int64(cfg.RedisStreamMaxDeliveries),
+	}
+}
+
+func (t *RedisStreamConsumer) Name() string {
+	return data.SOURCE_REDIS
+}
+
+// Start runs the Redis Streams consumer for notification events until it is stopped. Every replica
+// reads the stream as its own consumer of the configured consumer group, so that each entry is
+// delivered to a single replica. An entry is acknowledged once it is stored, as a notification or
+// as a dead letter. Entries left pending by a failed attempt or a stopped replica are claimed again
+// once idle for REDIS_STREAM_CLAIM_IDLE seconds, and dead lettered after REDIS_STREAM_MAX_DELIVERIES.
+func (t *RedisStreamConsumer) Start(ctx context.Context) error {
+
+	ctx = t.Begin(ctx)
+
+	err := config.RDB.XGroupCreateMkStream(ctx, t.stream, t.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create Redis Stream consumer group: %w", err)
+	}
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:   fmt.Sprintf("Reading Redis Stream %s as %s in consumer group %s", t.stream, t.name, t.group),
+		Component: "Redis Stream Consumer",
+		Operation: "Start",
+	})
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= t.claimIdle/2 {
+			t.claimStale(ctx)
+			lastClaim = time.Now()
+		}
+		t.readNew(ctx)
+	}
+
+	logger.Log.Info(logger.LogPayload{
+		Message:   "Shutting down redis stream consumer",
+		Component: "Redis Stream Consumer",
+		Operation: "Shutdown Redis Stream Consumer",
+	})
+	return nil
+}
+
+// readNew reads and handles the entries never delivered to the consumer group.
+func (t *RedisStreamConsumer) readNew(ctx context.Context) {
+	streams, err := config.RDB.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    t.group,
+		Consumer: t.name,
+		Streams:  []string{t.stream, ">"},
+		Count:    t.batchSize,
+		Block:    t.block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		t.SetHealth(nil)
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			t.SetHealth(err)
+			logger.Log.Error(logger.LogPayload{
+				Message:   "Failed to read Redis Stream " + t.stream,
+				Component: "Redis Stream Consumer",
+				Operation: "ReadNew",
+				Error:     err,
+			})
+			t.pause(ctx)
+		}
+		return
+	}
+	t.SetHealth(nil)
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			t.handle(ctx, message, 1)
+		}
+	}
+}
+
+// claimStale takes over the entries pending for longer than the claim idle time, whichever
+// consumer they were delivered to, and handles them again.
+func (t *RedisStreamConsumer) claimStale(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := config.RDB.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   t.stream,
+			Group:    t.group,
+			Consumer: t.name,
+			MinIdle:  t.claimIdle,
+			Start:    start,
+			Count:    t.batchSize,
+		}).Result()
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Message:   "Failed to claim stale entries of Redis Stream " + t.stream,
+				Component: "Redis Stream Consumer",
+				Operation: "ClaimStale",
+				Error:     err,
+			})
+			return
+		}
+		for _, message := range messages {
+			t.handle(ctx, message, t.deliveries(ctx, message.ID))
+		}
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+// deliveries returns how many times the entry was delivered to the consumer group.
+func (t *RedisStreamConsumer) deliveries(ctx context.Context, id string) int64 {
+	pending, err := config.RDB.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: t.stream,
+		Group:  t.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 1
+	}
+	return pending[0].RetryCount
+}
+
+// handle processes an entry and acknowledges it once stored. Entries failing with a transient
+// error stay pending until they are claimed again, unless they reached the maximum number of
+// deliveries, in which case they are dead lettered.
+func (t *RedisStreamConsumer) handle(ctx context.Context, message redis.XMessage, deliveries int64) {
+	correlationId := utils.GenerateUUID()
+	body, ok := message.Values[payloadField].(string)
+	if !ok {
+		fields, _ := json.Marshal(message.Values)
+		body = string(fields)
+	} else {
+		attributes := make(map[string]string, len(message.Values))
+		for key, value := range message.Values {
+			attributes[key] = fmt.Sprint(value)
+		}
+		body = string(ingestionService.ToStructuredCloudEvent(attributes, "", []byte(body)))
+	}
+
+	logger.Log.Debug(logger.LogPayload{
+		Message:       fmt.Sprintf("Received entry %s from Redis Stream %s (delivery %d)", message.ID, body, deliveries),
+		Component:     "Redis Stream Consumer",
+		Operation:     "OnEntryReceived",
+		CorrelationId: correlationId,
+	})
+
+	var err error
+	if ok {
+		err = t.processor.Process(data.SOURCE_REDIS, []byte(body))
+	} else {
+		err = fmt.Errorf("%w: missing %q field", deadLetterService.ErrInvalidEvent, payloadField)
+	}
+
+	if err != nil {
+		if !errors.Is(err, deadLetterService.ErrInvalidEvent) && deliveries < t.maxDeliveries {
+			logger.Log.Warn(logger.LogPayload{
+				Message:       fmt.Sprintf("Entry %s of Redis Stream %s left pending after delivery %d", message.ID, t.stream, deliveries),
+				Component:     "Redis Stream Consumer",
+				Operation:     "OnEntryReceived",
+				Error:         err,
+				CorrelationId: correlationId,
+			})
+			return
+		}
+		deadLetter := models.DeadLetter{
+			Source:        data.SOURCE_REDIS,
+			Topic:         t.stream,
+			ConsumerGroup: t.group,
+			Offset:        message.ID,
+			Body:          body,
+			Attempts:      int(deliveries),
+		}
+		if t.deadLetterService.Record(deadLetter, err) != nil {
+			return
+		}
+	}
+
+	if err := config.RDB.XAck(ctx, t.stream, t.group, message.ID).Err(); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Message:       "Failed to acknowledge entry " + message.ID + " of Redis Stream " + t.stream,
+			Component:     "Redis Stream Consumer",
+			Operation:     "OnEntryReceived",
+			Error:         err,
+			CorrelationId: correlationId,
+		})
+	}
+}
+
+// pause waits before the next read after a failure, so that an unavailable Redis is not hammered.
+func (t *RedisStreamConsumer) pause(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
+
+// consumerName identifies the replica within the consumer group.
+func consumerName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "r2-notify-server"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,192 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterService "r2-notify-server/services/deadletter"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubProcessor fails the entries with err, and records the number of entries pending in the
+// consumer group when each entry is processed.
+type stubProcessor struct {
+	err       error
+	bodies    []string
+	pendingAt []int64
+}
+
+func (p *stubProcessor) Process(source string, body []byte) error {
+	p.bodies = append(p.bodies, string(body))
+	pending, _ := config.RDB.XPending(context.Background(), "app-notifications", "r2-notify-server").Result()
+	p.pendingAt = append(p.pendingAt, pending.Count)
+	return p.err
+}
+
+// stubDeadLetterService stores dead letters in memory, or fails to store them when err is set.
+type stubDeadLetterService struct {
+	deadLetterService.DeadLetterService
+	err         error
+	deadLetters []models.DeadLetter
+}
+
+func (s *stubDeadLetterService) Record(deadLetter models.DeadLetter, cause error) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.deadLetters = append(s.deadLetters, deadLetter)
+	return nil
+}
+
+// newConsumer returns a consumer of the stream of an in-memory Redis, with the given REDIS_STREAM_* variables.
+func newConsumer(t *testing.T, name string, processor *stubProcessor, deadLetters *stubDeadLetterService, env map[string]string) (*RedisStreamConsumer, *miniredis.Miniredis) {
+	t.Helper()
+	redisServer := miniredis.RunT(t)
+	previous := config.RDB
+	config.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { config.RDB = previous })
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	consumer := NewRedisStreamConsumer(processor, deadLetters)
+	consumer.name = name
+	if err := config.RDB.XGroupCreateMkStream(t.Context(), consumer.stream, consumer.group, "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	return consumer, redisServer
+}
+
+// add appends an entry with the given fields to the stream.
+func add(t *testing.T, consumer *RedisStreamConsumer, values map[string]any) {
+	t.Helper()
+	if err := config.RDB.XAdd(t.Context(), &redis.XAddArgs{Stream: consumer.stream, Values: values}).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pending returns the number of entries delivered to the consumer group and not acknowledged yet.
+func pending(t *testing.T, consumer *RedisStreamConsumer) int64 {
+	t.Helper()
+	summary, err := config.RDB.XPending(t.Context(), consumer.stream, consumer.group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary.Count
+}
+
+func TestEntriesAreAcknowledgedOnceStored(t *testing.T) {
+	processor := &stubProcessor{}
+	consumer, _ := newConsumer(t, "replica-a", processor, &stubDeadLetterService{}, nil)
+	add(t, consumer, map[string]any{payloadField: `{"appId":"app-a","userId":"u1","message":"main is red"}`})
+
+	consumer.readNew(t.Context())
+	if len(processor.bodies) != 1 || processor.pendingAt[0] != 1 {
+		t.Fatalf("expected the entry to be processed before it is acknowledged, got %v pending %v", processor.bodies, processor.pendingAt)
+	}
+	if count := pending(t, consumer); count != 0 {
+		t.Fatalf("expected the stored entry to be acknowledged, got %d pending", count)
+	}
+}
+
+func TestEntriesFailingTransientlyStayPendingUntilClaimed(t *testing.T) {
+	processor := &stubProcessor{err: errors.New("mongo is down")}
+	deadLetters := &stubDeadLetterService{}
+	consumer, redisServer := newConsumer(t, "replica-a", processor, deadLetters, map[string]string{"REDIS_STREAM_CLAIM_IDLE": "60"})
+	add(t, consumer, map[string]any{payloadField: `{"appId":"app-a","userId":"u1","message":"main is red"}`})
+
+	consumer.readNew(t.Context())
+	if count := pending(t, consumer); count != 1 || len(deadLetters.deadLetters) != 0 {
+		t.Fatalf("expected the entry to stay pending, got %d pending and %d dead letters", count, len(deadLetters.deadLetters))
+	}
+
+	// Entries pending for less than the claim idle time are left to the consumer they were delivered to.
+	processor.err = nil
+	survivor := NewRedisStreamConsumer(processor, deadLetters)
+	survivor.name = "replica-b"
+	survivor.claimStale(t.Context())
+	if len(processor.bodies) != 1 {
+		t.Fatalf("expected the entry not to be claimed before the claim idle time, got %v", processor.bodies)
+	}
+
+	redisServer.SetTime(time.Now().Add(2 * time.Minute))
+	survivor.claimStale(t.Context())
+	if len(processor.bodies) != 2 || processor.bodies[0] != processor.bodies[1] {
+		t.Fatalf("expected the entry to be delivered again, got %v", processor.bodies)
+	}
+	if count := pending(t, consumer); count != 0 {
+		t.Fatalf("expected the claimed entry to be acknowledged once stored, got %d pending", count)
+	}
+}
+
+func TestEntriesAreDeadLetteredAtTheMaximumDeliveries(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        map[string]any
+		deliveries    int
+		deadLetterErr error
+		attempts      int
+		pending       int64
+	}{
+		{"transient errors up to the maximum deliveries", map[string]any{payloadField: `{"appId":"app-a"}`}, 2, nil, 2, 0},
+		{"invalid entries at the first delivery", map[string]any{"message": "main is red"}, 1, nil, 1, 0},
+		{"entries failing to be dead lettered stay pending", map[string]any{payloadField: `{"appId":"app-a"}`}, 2, errors.New("mongo is down"), 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processor := &stubProcessor{err: errors.New("mongo is down")}
+			deadLetters := &stubDeadLetterService{err: test.deadLetterErr}
+			consumer, redisServer := newConsumer(t, "replica-a", processor, deadLetters, map[string]string{"REDIS_STREAM_MAX_DELIVERIES": "2", "REDIS_STREAM_CLAIM_IDLE": "60"})
+			add(t, consumer, test.values)
+
+			consumer.readNew(t.Context())
+			for delivery := 1; delivery < test.deliveries; delivery++ {
+				redisServer.SetTime(time.Now().Add(time.Duration(delivery) * 2 * time.Minute))
+				consumer.claimStale(t.Context())
+			}
+
+			if count := pending(t, consumer); count != test.pending {
+				t.Fatalf("expected %d pending entries, got %d", test.pending, count)
+			}
+			if test.attempts == 0 {
+				if len(deadLetters.deadLetters) != 0 {
+					t.Fatalf("expected no dead letter, got %+v", deadLetters.deadLetters)
+				}
+				return
+			}
+			if len(deadLetters.deadLetters) != 1 {
+				t.Fatalf("expected the entry to be dead lettered, got %+v", deadLetters.deadLetters)
+			}
+			deadLetter := deadLetters.deadLetters[0]
+			if deadLetter.Source != data.SOURCE_REDIS || deadLetter.Topic != consumer.stream || deadLetter.Offset == "" || deadLetter.Attempts != test.attempts {
+				t.Fatalf("expected a dead letter of the entry after %d deliveries, got %+v", test.attempts, deadLetter)
+			}
+		})
+	}
+}
+
+func TestReadsBlockForAtLeastASecond(t *testing.T) {
+	for _, block := range []string{"0", "-5"} {
+		t.Setenv("REDIS_STREAM_BLOCK", block)
+		if consumer := NewRedisStreamConsumer(&stubProcessor{}, &stubDeadLetterService{}); consumer.block != time.Second {
+			t.Fatalf("expected REDIS_STREAM_BLOCK=%s to block for a second, got %s", block, consumer.block)
+		}
+	}
+	t.Setenv("REDIS_STREAM_BLOCK", "5")
+	if consumer := NewRedisStreamConsumer(&stubProcessor{}, &stubDeadLetterService{}); consumer.block != 5*time.Second {
+		t.Fatalf("expected reads to block for 5 seconds, got %s", consumer.block)
+	}
+}
@@ -0,0 +1,10 @@
+package appRepository
+
+import "r2-notify-server/models"
+
+type AppRepository interface {
+	FindAll() ([]models.App, error)
+	FindById(appId string) (models.App, error)
+	Save(app models.App) error
+	Delete(appId string) error
+}
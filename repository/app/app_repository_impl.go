@@ -0,0 +1,115 @@
+package appRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAppNotFound is returned when no app is registered with the given ID.
+var ErrAppNotFound = errors.New("app not found")
+
+type AppRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewAppRepositoryImpl returns a new instance of AppRepositoryImpl.
+// Registered apps are stored in the "apps" collection, keyed by their app ID.
+func NewAppRepositoryImpl(Db *mongo.Database) AppRepository {
+	return &AppRepositoryImpl{Db: Db}
+}
+
+// FindAll returns every registered app, ordered by app ID.
+func (t *AppRepositoryImpl) FindAll() (apps []models.App, err error) {
+	cursor, err := t.Db.Collection("apps").Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "App Repository",
+			Operation: "FindAll",
+			Message:   "Failed to fetch apps",
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &apps); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "App Repository",
+			Operation: "FindAll",
+			Message:   "Failed to decode apps",
+			Error:     err,
+		})
+		return nil, err
+	}
+	return apps, nil
+}
+
+// FindById returns the app registered with the given ID.
+func (t *AppRepositoryImpl) FindById(appId string) (app models.App, err error) {
+	err = t.Db.Collection("apps").FindOne(context.Background(), bson.M{"_id": appId}).Decode(&app)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.App{}, ErrAppNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "App Repository",
+			Operation: "FindById",
+			Message:   "Failed to fetch app: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return models.App{}, err
+	}
+	return app, nil
+}
+
+// Save registers an app, replacing the tenant, name and description of an existing one.
+func (t *AppRepositoryImpl) Save(app models.App) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"tenantId":    app.TenantId,
+			"name":        app.Name,
+			"description": app.Description,
+			"updatedAt":   now,
+		},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	_, err := t.Db.Collection("apps").UpdateByID(context.Background(), app.Id, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "App Repository",
+			Operation: "Save",
+			Message:   "Failed to save app: " + app.Id,
+			Error:     err,
+			AppId:     app.Id,
+		})
+		return err
+	}
+	return nil
+}
+
+// Delete unregisters the app with the given ID.
+func (t *AppRepositoryImpl) Delete(appId string) error {
+	result, err := t.Db.Collection("apps").DeleteOne(context.Background(), bson.M{"_id": appId})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "App Repository",
+			Operation: "Delete",
+			Message:   "Failed to delete app: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAppNotFound
+	}
+	return nil
+}
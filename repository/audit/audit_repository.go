@@ -0,0 +1,11 @@
+package auditRepository
+
+import (
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+)
+
+type AuditRepository interface {
+	Create(event models.AuditEvent) error
+	Find(query data.AuditEventQuery) (events []models.AuditEvent, err error)
+}
@@ -0,0 +1,93 @@
+package auditRepository
+
+import (
+	"context"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditEventListLimit caps the number of audit events returned by a query.
+const auditEventListLimit = 500
+
+type AuditRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewAuditRepositoryImpl returns a new instance of AuditRepositoryImpl.
+// Audit events are appended to the "audit_events" collection, and never updated or deleted.
+func NewAuditRepositoryImpl(Db *mongo.Database) AuditRepository {
+	return &AuditRepositoryImpl{Db: Db}
+}
+
+// Create appends an audit event.
+func (t *AuditRepositoryImpl) Create(event models.AuditEvent) error {
+	_, err := t.Db.Collection("audit_events").InsertOne(context.Background(), event)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "Audit Repository",
+			Operation:     "Create",
+			Message:       "Failed to store audit event: " + event.Action,
+			Error:         err,
+			UserId:        event.ActorId,
+			CorrelationId: event.CorrelationId,
+		})
+		return err
+	}
+	return nil
+}
+
+// Find returns the latest audit events matching the query, most recent first.
+func (t *AuditRepositoryImpl) Find(query data.AuditEventQuery) (events []models.AuditEvent, err error) {
+	filter := bson.M{}
+	if query.TenantId != "" {
+		filter["tenantId"] = config.TenantFilter(query.TenantId)
+	}
+	if query.ActorId != "" {
+		filter["actorId"] = query.ActorId
+	}
+	if query.ActorType != "" {
+		filter["actorType"] = query.ActorType
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(auditEventListLimit)
+	cursor, err := t.Db.Collection("audit_events").Find(context.Background(), filter, findOptions)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Audit Repository",
+			Operation: "Find",
+			Message:   "Failed to fetch audit events",
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &events); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Audit Repository",
+			Operation: "Find",
+			Message:   "Failed to decode audit events",
+			Error:     err,
+		})
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,15 @@
+package chatRepository
+
+import (
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ChatRepository interface {
+	ForTenant(tenantId string) ChatRepository
+	CreateDelivery(delivery models.ChatDelivery) (primitive.ObjectID, error)
+	ClaimDueDelivery(now time.Time, leaseUntil time.Time) (models.ChatDelivery, error)
+	UpdateDelivery(delivery models.ChatDelivery) error
+}
@@ -0,0 +1,139 @@
+package chatRepository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoDueDelivery is returned by ClaimDueDelivery when no chat post is waiting to be attempted.
+var ErrNoDueDelivery = errors.New("no due chat delivery")
+
+type ChatRepositoryImpl struct {
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
+}
+
+// NewChatRepositoryImpl returns a new instance of ChatRepositoryImpl, scoped to the default tenant.
+// Every post of a notification to a Slack or Teams target is queued in the "chat_deliveries" collection.
+func NewChatRepositoryImpl(Db *mongo.Database) ChatRepository {
+	return &ChatRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the chat posts of the given tenant. Every filter of the returned
+// repository is restricted to the tenant, and every delivery it creates belongs to the tenant.
+func (t ChatRepositoryImpl) ForTenant(tenantId string) ChatRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &ChatRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the documents of the tenant of the repository.
+func (t ChatRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
+}
+
+// CreateDelivery queues a post to a chat target and returns its ObjectID.
+func (t *ChatRepositoryImpl) CreateDelivery(delivery models.ChatDelivery) (primitive.ObjectID, error) {
+	delivery.TenantId = t.TenantId
+	result, err := t.Db.Collection("chat_deliveries").InsertOne(context.Background(), delivery)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Chat Repository",
+			Operation: "CreateDelivery",
+			Message:   "Failed to queue chat delivery of notification " + delivery.NotificationId + " to " + delivery.TargetType,
+			Error:     err,
+			UserId:    delivery.UserId,
+			AppId:     delivery.AppId,
+		})
+		return primitive.NilObjectID, err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		convertErr := errors.New("failed to convert inserted ID to ObjectID")
+		logger.Log.Error(logger.LogPayload{
+			Component: "Chat Repository",
+			Operation: "CreateDelivery",
+			Message:   "Failed to convert inserted ID of notification " + delivery.NotificationId,
+			Error:     convertErr,
+			UserId:    delivery.UserId,
+			AppId:     delivery.AppId,
+		})
+		return primitive.NilObjectID, convertErr
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Chat Repository",
+		Operation: "CreateDelivery",
+		Message:   "Queued chat delivery of notification " + delivery.NotificationId + " to " + delivery.TargetType,
+		UserId:    delivery.UserId,
+		AppId:     delivery.AppId,
+	})
+	return id, nil
+}
+
+// ClaimDueDelivery atomically picks the oldest pending chat post of the tenant whose next attempt is due
+// and pushes its nextAttemptAt to leaseUntil, so that other replicas skip it while it is
+// being attempted. If the process dies mid-attempt the post becomes due again once the
+// lease expires. ErrNoDueDelivery is returned when nothing is due.
+func (t *ChatRepositoryImpl) ClaimDueDelivery(now time.Time, leaseUntil time.Time) (delivery models.ChatDelivery, err error) {
+	filter := t.scope(bson.M{
+		"status":        data.CHAT_DELIVERY_PENDING,
+		"nextAttemptAt": bson.M{"$lte": now},
+	})
+	update := bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}}
+	findOptions := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After)
+	err = t.Db.Collection("chat_deliveries").FindOneAndUpdate(context.Background(), filter, update, findOptions).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.ChatDelivery{}, ErrNoDueDelivery
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Chat Repository",
+			Operation: "ClaimDueDelivery",
+			Message:   "Failed to claim due chat delivery",
+			Error:     err,
+		})
+		return models.ChatDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// UpdateDelivery stores the outcome of an attempt to post to a chat target.
+func (t *ChatRepositoryImpl) UpdateDelivery(delivery models.ChatDelivery) error {
+	update := bson.M{"$set": bson.M{
+		"status":        delivery.Status,
+		"attempts":      delivery.Attempts,
+		"lastError":     delivery.LastError,
+		"nextAttemptAt": delivery.NextAttemptAt,
+		"updatedAt":     delivery.UpdatedAt,
+	}}
+	result, err := t.Db.Collection("chat_deliveries").UpdateOne(context.Background(), t.scope(bson.M{"_id": delivery.Id}), update)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Chat Repository",
+			Operation: "UpdateDelivery",
+			Message:   "Failed to update chat delivery: " + delivery.Id.Hex(),
+			Error:     err,
+			AppId:     delivery.AppId,
+		})
+		return err
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Chat Repository",
+		Operation: "UpdateDelivery",
+		Message:   "Updated chat delivery: " + delivery.Id.Hex() + " | Status: " + delivery.Status + " Matched: " + fmt.Sprintf("%d", result.MatchedCount),
+		AppId:     delivery.AppId,
+	})
+	return nil
+}
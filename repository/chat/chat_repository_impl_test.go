@@ -0,0 +1,62 @@
+package chatRepository
+
+import (
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestChatDeliveriesAreScopedToTheTenant(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-a,tenant-b")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+
+	mt.Run("every command carries the tenant", func(mt *mtest.T) {
+		repository := NewChatRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: id}, {Key: "tenantId", Value: "tenant-b"}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		// A delivery claiming another tenant is queued in the tenant of the repository.
+		if _, err := repository.CreateDelivery(models.ChatDelivery{TenantId: "tenant-a", AppId: "app-a"}); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(time.Minute)); err != nil {
+			mt.Fatal(err)
+		}
+		if err := repository.UpdateDelivery(models.ChatDelivery{Id: id, Status: data.CHAT_DELIVERY_SUCCEEDED}); err != nil {
+			mt.Fatal(err)
+		}
+		commands := mongotest.SentCommands(mt)
+		if len(commands) != 3 {
+			mt.Fatalf("expected 3 commands, got %d", len(commands))
+		}
+		mongotest.ExpectTenant(mt, commands, "tenant-b")
+	})
+
+	mt.Run("nothing is due for the other tenants", func(mt *mtest.T) {
+		repository := NewChatRepositoryImpl(mt.DB).ForTenant("tenant-a")
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		if _, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(time.Minute)); err != ErrNoDueDelivery {
+			mt.Fatalf("expected ErrNoDueDelivery, got %v", err)
+		}
+		mongotest.ExpectTenant(mt, mongotest.SentCommands(mt), "tenant-a")
+	})
+}
@@ -2,13 +2,21 @@ package configurationRepository
 
 import (
 	"r2-notify-server/models"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ConfigurationRepository interface {
+	ForTenant(tenantId string) ConfigurationRepository
 	FindByAppAndUser(userId string) (configurations models.Configuration, err error)
 	Create(configuration models.Configuration) (primitive.ObjectID, error)
 	Update(configuration models.Configuration) error
+	Patch(userId string, fields bson.M) error
+	FindDigestDue(before time.Time) ([]models.Configuration, error)
+	AdvanceDigestCursor(userId string, previous time.Time, next time.Time) (bool, error)
 	Delete(userId string) error
+	FindAppConfiguration(appId string) (models.AppConfiguration, error)
+	SetAppChatTargets(appId string, targets []models.ChatTarget) error
 }
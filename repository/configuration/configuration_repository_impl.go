@@ -3,23 +3,41 @@ package configurationRepository
 import (
 	"context"
 	"errors"
+	"r2-notify-server/config"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ConfigurationRepositoryImpl struct {
-	Db *mongo.Database
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
 }
 
 // NewConfigurationRepositoryImpl creates a new instance of ConfigurationRepositoryImpl
-// with the given mongo Db instance.
+// with the given mongo Db instance, scoped to the default tenant.
 func NewConfigurationRepositoryImpl(Db *mongo.Database) ConfigurationRepository {
-	return &ConfigurationRepositoryImpl{Db: Db}
+	return &ConfigurationRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the configurations of the given tenant. Every filter of the
+// returned repository is restricted to the tenant, and every configuration it creates belongs to the tenant.
+func (t ConfigurationRepositoryImpl) ForTenant(tenantId string) ConfigurationRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &ConfigurationRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the configurations of the tenant of the repository.
+func (t ConfigurationRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
 }
 
 // FindByAppAndUser retrieves a configuration document from the "configurations" collection
@@ -36,7 +54,7 @@ func (t ConfigurationRepositoryImpl) FindByAppAndUser(userId string) (models.Con
 	})
 	err := t.Db.Collection("configurations").FindOne(
 		context.Background(),
-		bson.M{"userId": userId},
+		t.scope(bson.M{"userId": userId}),
 	).Decode(&configuration)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -67,6 +85,7 @@ func (t *ConfigurationRepositoryImpl) Create(configuration models.Configuration)
 		Message:   "Creating configuration for userId: " + configuration.UserId,
 		UserId:    configuration.UserId,
 	})
+	configuration.TenantId = t.TenantId
 	result, err := t.Db.Collection("configurations").InsertOne(context.Background(), configuration)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -109,9 +128,10 @@ func (t *ConfigurationRepositoryImpl) Update(configuration models.Configuration)
 		Message:   "Updating configuration for userId: " + configuration.UserId,
 		UserId:    configuration.UserId,
 	})
-	filter := bson.M{
+	filter := t.scope(bson.M{
 		"userId": configuration.UserId,
-	}
+	})
+	configuration.TenantId = t.TenantId
 	update := bson.M{
 		"$set": configuration,
 	}
@@ -146,6 +166,98 @@ func (t *ConfigurationRepositoryImpl) Update(configuration models.Configuration)
 	return nil
 }
 
+// Patch sets only the given fields of the configuration document of the given userId,
+// leaving every other field untouched. If the user has no configuration yet, one is
+// created with notifications enabled. It returns an error if the operation fails.
+func (t *ConfigurationRepositoryImpl) Patch(userId string, fields bson.M) error {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Configuration Repository",
+		Operation: "Patch",
+		Message:   "Patching configuration for userId: " + userId,
+		UserId:    userId,
+	})
+	update := bson.M{"$set": fields}
+	setOnInsert := bson.M{"tenantId": t.TenantId}
+	if _, ok := fields["enableNotifications"]; !ok {
+		setOnInsert["enableNotifications"] = true
+	}
+	update["$setOnInsert"] = setOnInsert
+	_, err := t.Db.Collection("configurations").UpdateOne(context.Background(), t.scope(bson.M{"userId": userId}), update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "Patch",
+			Message:   "Failed to patch configuration for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Configuration Repository",
+		Operation: "Patch",
+		Message:   "Successfully patched configuration for userId: " + userId,
+		UserId:    userId,
+	})
+	return nil
+}
+
+// FindDigestDue returns the configurations of users that opted in to digests, have an email
+// address on record and whose digest cursor is older than the given time.
+func (t *ConfigurationRepositoryImpl) FindDigestDue(before time.Time) (configurations []models.Configuration, err error) {
+	filter := bson.M{
+		"digestNotifications": true,
+		"email":               bson.M{"$exists": true, "$ne": ""},
+		"$or": bson.A{
+			bson.M{"digestCursor": bson.M{"$exists": false}},
+			bson.M{"digestCursor": bson.M{"$lte": before}},
+		},
+	}
+	cursor, err := t.Db.Collection("configurations").Find(context.Background(), t.scope(filter))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "FindDigestDue",
+			Message:   "Failed to fetch configurations with a due digest",
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &configurations); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "FindDigestDue",
+			Message:   "Failed to decode configurations with a due digest",
+			Error:     err,
+		})
+		return nil, err
+	}
+	return configurations, nil
+}
+
+// AdvanceDigestCursor moves the digest cursor of the given user from previous to next. The update
+// only applies if the stored cursor still equals previous (or is missing when previous is zero),
+// so that a single replica wins the digest window. It returns true if the cursor was moved.
+func (t *ConfigurationRepositoryImpl) AdvanceDigestCursor(userId string, previous time.Time, next time.Time) (bool, error) {
+	filter := bson.M{"userId": userId, "digestCursor": previous}
+	if previous.IsZero() {
+		filter["digestCursor"] = bson.M{"$exists": false}
+	}
+	result, err := t.Db.Collection("configurations").UpdateOne(context.Background(), t.scope(filter), bson.M{"$set": bson.M{"digestCursor": next}})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "AdvanceDigestCursor",
+			Message:   "Failed to advance digest cursor for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
 // Delete deletes a configuration document from the "configurations" collection
 // for the given userId. It returns an error if the operation fails, or if no
 // document is found to delete.
@@ -156,9 +268,9 @@ func (t *ConfigurationRepositoryImpl) Delete(userId string) error {
 		Message:   "Deleting configuration for userId: " + userId,
 		UserId:    userId,
 	})
-	filter := bson.M{
+	filter := t.scope(bson.M{
 		"userId": userId,
-	}
+	})
 	result, err := t.Db.Collection("configurations").DeleteOne(context.Background(), filter)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -189,3 +301,50 @@ func (t *ConfigurationRepositoryImpl) Delete(userId string) error {
 	})
 	return nil
 }
+
+// FindAppConfiguration retrieves the configuration shared by every user of the given app from
+// the "app_configurations" collection. An empty configuration is returned if the app has none.
+func (t ConfigurationRepositoryImpl) FindAppConfiguration(appId string) (configuration models.AppConfiguration, err error) {
+	err = t.Db.Collection("app_configurations").FindOne(context.Background(), t.scope(bson.M{"appId": appId})).Decode(&configuration)
+	if err == mongo.ErrNoDocuments {
+		return models.AppConfiguration{TenantId: t.TenantId, AppId: appId}, nil
+	}
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "FindAppConfiguration",
+			Message:   "Failed to fetch app configuration for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return models.AppConfiguration{}, err
+	}
+	return configuration, nil
+}
+
+// SetAppChatTargets replaces the chat webhook targets of the given app, creating the app
+// configuration if it does not exist yet.
+func (t *ConfigurationRepositoryImpl) SetAppChatTargets(appId string, targets []models.ChatTarget) error {
+	update := bson.M{
+		"$set":         bson.M{"chatTargets": targets, "updatedAt": time.Now()},
+		"$setOnInsert": bson.M{"tenantId": t.TenantId},
+	}
+	_, err := t.Db.Collection("app_configurations").UpdateOne(context.Background(), t.scope(bson.M{"appId": appId}), update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Repository",
+			Operation: "SetAppChatTargets",
+			Message:   "Failed to update chat targets for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Configuration Repository",
+		Operation: "SetAppChatTargets",
+		Message:   "Successfully updated chat targets for appId: " + appId,
+		AppId:     appId,
+	})
+	return nil
+}
@@ -0,0 +1,44 @@
+package configurationRepository
+
+import (
+	"os"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestAppChatTargetsAreScopedToTheTenant(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("reads and writes carry the tenant", func(mt *mtest.T) {
+		repository := NewConfigurationRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".app_configurations", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		// The targets of the same app ID in another tenant are not matched, so none are found.
+		configuration, err := repository.FindAppConfiguration("app-a")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if configuration.TenantId != "tenant-b" || len(configuration.ChatTargets) != 0 {
+			mt.Fatalf("expected an empty configuration of tenant-b, got %+v", configuration)
+		}
+		if err := repository.SetAppChatTargets("app-a", []models.ChatTarget{{Type: "slack", Url: "https://hooks.slack.com/services/x"}}); err != nil {
+			mt.Fatal(err)
+		}
+		mongotest.ExpectTenant(mt, mongotest.SentCommands(mt), "tenant-b")
+	})
+}
@@ -0,0 +1,19 @@
+package deadLetterRepository
+
+import (
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeadLetterRepository interface {
+	FindDeadLetters(status string) ([]models.DeadLetter, error)
+	FindById(id primitive.ObjectID) (models.DeadLetter, error)
+	Create(deadLetter models.DeadLetter) (primitive.ObjectID, error)
+	ClaimDue(now time.Time, leaseUntil time.Time) (models.DeadLetter, error)
+	Update(deadLetter models.DeadLetter) error
+	Requeue(id primitive.ObjectID, now time.Time) (models.DeadLetter, error)
+	Delete(id primitive.ObjectID) error
+	RotateKeys() (int, error)
+}
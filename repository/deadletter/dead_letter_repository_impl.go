@@ -0,0 +1,252 @@
+package deadLetterRepository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/encryption"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoDueDeadLetter is returned by ClaimDue when no dead letter is waiting to be retried.
+var ErrNoDueDeadLetter = errors.New("no due dead letter")
+
+// ErrDeadLetterNotFound is returned when no dead letter matches the given ID.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// deadLetterListLimit caps the number of dead letters returned by FindDeadLetters.
+const deadLetterListLimit = 100
+
+type DeadLetterRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewDeadLetterRepositoryImpl returns a new instance of DeadLetterRepositoryImpl.
+// Dead letters are stored in the "dead_letters" collection.
+func NewDeadLetterRepositoryImpl(Db *mongo.Database) DeadLetterRepository {
+	return &DeadLetterRepositoryImpl{Db: Db}
+}
+
+// FindDeadLetters returns the most recent dead letters, newest first.
+// If status is not empty only dead letters in that status are returned.
+func (t *DeadLetterRepositoryImpl) FindDeadLetters(status string) (deadLetters []models.DeadLetter, err error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	findOptions := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(deadLetterListLimit)
+	cursor, err := t.Db.Collection("dead_letters").Find(context.Background(), filter, findOptions)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "FindDeadLetters",
+			Message:   "Failed to fetch dead letters",
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &deadLetters); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "FindDeadLetters",
+			Message:   "Failed to decode dead letters",
+			Error:     err,
+		})
+		return nil, err
+	}
+	for i := range deadLetters {
+		if err := decryptDeadLetter(&deadLetters[i]); err != nil {
+			return nil, err
+		}
+	}
+	return deadLetters, nil
+}
+
+// FindById retrieves a single dead letter.
+func (t *DeadLetterRepositoryImpl) FindById(id primitive.ObjectID) (deadLetter models.DeadLetter, err error) {
+	err = t.Db.Collection("dead_letters").FindOne(context.Background(), bson.M{"_id": id}).Decode(&deadLetter)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.DeadLetter{}, ErrDeadLetterNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "FindById",
+			Message:   "Failed to fetch dead letter: " + id.Hex(),
+			Error:     err,
+		})
+		return models.DeadLetter{}, err
+	}
+	if err := decryptDeadLetter(&deadLetter); err != nil {
+		return models.DeadLetter{}, err
+	}
+	return deadLetter, nil
+}
+
+// Create inserts a new dead letter and returns its ObjectID. The body is stored encrypted when field
+// encryption is enabled.
+func (t *DeadLetterRepositoryImpl) Create(deadLetter models.DeadLetter) (primitive.ObjectID, error) {
+	stored := deadLetter
+	var err error
+	if stored.Encryption, err = encryption.Encrypt(map[string]*string{"body": &stored.Body}); err != nil {
+		return primitive.NilObjectID, err
+	}
+	result, err := t.Db.Collection("dead_letters").InsertOne(context.Background(), stored)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "Create",
+			Message:   "Failed to store dead letter from " + deadLetter.Source + ": " + deadLetter.Body,
+			Error:     err,
+		})
+		return primitive.NilObjectID, err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		convertErr := errors.New("failed to convert inserted ID to ObjectID")
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "Create",
+			Message:   "Failed to convert inserted ID of dead letter",
+			Error:     convertErr,
+		})
+		return primitive.NilObjectID, convertErr
+	}
+	return id, nil
+}
+
+// ClaimDue atomically picks the oldest pending dead letter whose next attempt is due and pushes
+// its nextAttemptAt to leaseUntil, so that other replicas skip it while it is being retried.
+// ErrNoDueDeadLetter is returned when nothing is due.
+func (t *DeadLetterRepositoryImpl) ClaimDue(now time.Time, leaseUntil time.Time) (deadLetter models.DeadLetter, err error) {
+	filter := bson.M{
+		"status":        data.DEAD_LETTER_PENDING,
+		"nextAttemptAt": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}}
+	findOptions := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After)
+	err = t.Db.Collection("dead_letters").FindOneAndUpdate(context.Background(), filter, update, findOptions).Decode(&deadLetter)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.DeadLetter{}, ErrNoDueDeadLetter
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "ClaimDue",
+			Message:   "Failed to claim due dead letter",
+			Error:     err,
+		})
+		return models.DeadLetter{}, err
+	}
+	if err := decryptDeadLetter(&deadLetter); err != nil {
+		return models.DeadLetter{}, err
+	}
+	return deadLetter, nil
+}
+
+// Update stores the outcome of a processing attempt.
+func (t *DeadLetterRepositoryImpl) Update(deadLetter models.DeadLetter) error {
+	update := bson.M{"$set": bson.M{
+		"status":        deadLetter.Status,
+		"attempts":      deadLetter.Attempts,
+		"error":         deadLetter.Error,
+		"nextAttemptAt": deadLetter.NextAttemptAt,
+		"updatedAt":     deadLetter.UpdatedAt,
+	}}
+	_, err := t.Db.Collection("dead_letters").UpdateByID(context.Background(), deadLetter.Id, update)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "Update",
+			Message:   "Failed to update dead letter: " + deadLetter.Id.Hex(),
+			Error:     err,
+		})
+		return err
+	}
+	return nil
+}
+
+// Requeue makes a dead letter that was not processed yet due for another attempt.
+// ErrDeadLetterNotFound is returned when the dead letter does not exist or was already resolved.
+func (t *DeadLetterRepositoryImpl) Requeue(id primitive.ObjectID, now time.Time) (deadLetter models.DeadLetter, err error) {
+	filter := bson.M{"_id": id, "status": bson.M{"$ne": data.DEAD_LETTER_RESOLVED}}
+	update := bson.M{"$set": bson.M{
+		"status":        data.DEAD_LETTER_PENDING,
+		"nextAttemptAt": now,
+		"updatedAt":     now,
+	}}
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = t.Db.Collection("dead_letters").FindOneAndUpdate(context.Background(), filter, update, findOptions).Decode(&deadLetter)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.DeadLetter{}, ErrDeadLetterNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "Requeue",
+			Message:   "Failed to requeue dead letter: " + id.Hex(),
+			Error:     err,
+		})
+		return models.DeadLetter{}, err
+	}
+	if err := decryptDeadLetter(&deadLetter); err != nil {
+		return models.DeadLetter{}, err
+	}
+	return deadLetter, nil
+}
+
+// Delete removes a dead letter.
+func (t *DeadLetterRepositoryImpl) Delete(id primitive.ObjectID) error {
+	result, err := t.Db.Collection("dead_letters").DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "Delete",
+			Message:   "Failed to delete dead letter: " + id.Hex(),
+			Error:     err,
+		})
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDeadLetterNotFound
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Dead Letter Repository",
+		Operation: "Delete",
+		Message:   "Discarded dead letter: " + id.Hex(),
+	})
+	return nil
+}
+
+// RotateKeys moves the dead letters to the active key-encryption key, and encrypts the bodies stored
+// in plaintext. It returns the number of dead letters rotated.
+func (t *DeadLetterRepositoryImpl) RotateKeys() (int, error) {
+	rotated, err := encryption.RotateKeys(t.Db.Collection("dead_letters"), func(filter bson.M) bson.M { return filter }, "body")
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Repository",
+			Operation: "RotateKeys",
+			Message:   fmt.Sprintf("Failed to rotate the keys of dead letters after %d", rotated),
+			Error:     err,
+		})
+	}
+	return rotated, err
+}
+
+// decryptDeadLetter restores the body of an encrypted dead letter.
+func decryptDeadLetter(deadLetter *models.DeadLetter) error {
+	if err := encryption.Decrypt(deadLetter.Encryption, map[string]*string{"body": &deadLetter.Body}); err != nil {
+		return fmt.Errorf("dead letter %s: %w", deadLetter.Id.Hex(), err)
+	}
+	deadLetter.Encryption = nil
+	return nil
+}
@@ -0,0 +1,85 @@
+package deadLetterRepository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"r2-notify-server/utils"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestStoredDeadLettersHoldOnlyCiphertext(t *testing.T) {
+	keys, err := utils.ParseFieldKeys("k1="+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.FieldKeys = keys
+	t.Cleanup(func() { utils.FieldKeys = nil })
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	body := `{"appId":"app-a","userId":"u1","message":"main is red"}`
+
+	mt.Run("create and read", func(mt *mtest.T) {
+		repository := NewDeadLetterRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if _, err := repository.Create(models.DeadLetter{Source: data.SOURCE_KAFKA, Body: body, Status: data.DEAD_LETTER_PENDING}); err != nil {
+			mt.Fatal(err)
+		}
+		raw := mongotest.SentCommands(mt)[0].Document
+		if bytes.Contains(raw, []byte("main is red")) {
+			mt.Fatalf("expected the stored dead letter to hold no plaintext, got %s", raw)
+		}
+		if keyId, _ := raw.Lookup("encryption", "keyId").StringValueOK(); keyId != "k1" {
+			mt.Fatalf("expected the data key to be wrapped by k1, got %s", raw)
+		}
+
+		// The stored document is decrypted by every read
+		id := primitive.NewObjectID()
+		var stored bson.D
+		if err := bson.Unmarshal(raw, &stored); err != nil {
+			mt.Fatal(err)
+		}
+		stored = append(bson.D{{Key: "_id", Value: id}}, stored...)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".dead_letters", mtest.FirstBatch, stored),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".dead_letters", mtest.FirstBatch, stored),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: stored}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: stored}),
+		)
+		found, err := repository.FindDeadLetters("")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		deadLetter, err := repository.FindById(id)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		claimed, err := repository.ClaimDue(time.Now(), time.Now().Add(time.Minute))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		requeued, err := repository.Requeue(id, time.Now())
+		if err != nil {
+			mt.Fatal(err)
+		}
+		for _, read := range []models.DeadLetter{found[0], deadLetter, claimed, requeued} {
+			if read.Body != body || read.Encryption != nil {
+				mt.Fatalf("expected the dead letter to be decrypted, got %+v", read)
+			}
+		}
+	})
+}
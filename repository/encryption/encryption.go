@@ -0,0 +1,122 @@
+// Package encryption encrypts string fields of the documents of the repositories with utils.FieldKeys.
+// Every document is encrypted under its own data key, stored in its encryption field wrapped by the
+// active key-encryption key.
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/models"
+	"r2-notify-server/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Encrypt replaces the given fields, keyed by their name, with their ciphertext under a new data key,
+// and returns the data key wrapped by the active key-encryption key. When field encryption is disabled
+// the fields are left in plaintext and nil is returned.
+func Encrypt(fields map[string]*string) (*models.FieldEncryption, error) {
+	if utils.FieldKeys == nil {
+		return nil, nil
+	}
+	dataKey, wrapped, keyId, err := utils.FieldKeys.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	for field, value := range fields {
+		if *value, err = utils.EncryptField(dataKey, field, *value); err != nil {
+			return nil, err
+		}
+	}
+	return &models.FieldEncryption{KeyId: keyId, DataKey: wrapped}, nil
+}
+
+// Decrypt restores the given fields of a document encrypted under the given data key. The fields of
+// documents without a data key, stored before encryption was enabled, are left as they are.
+func Decrypt(encryption *models.FieldEncryption, fields map[string]*string) error {
+	if encryption == nil {
+		return nil
+	}
+	if utils.FieldKeys == nil {
+		return errors.New("document is encrypted but FIELD_ENCRYPTION_KEYS is not set")
+	}
+	dataKey, err := utils.FieldKeys.UnwrapDataKey(encryption.KeyId, encryption.DataKey)
+	if err != nil {
+		return err
+	}
+	for field, value := range fields {
+		if *value, err = utils.DecryptField(dataKey, field, *value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateKeys moves the documents of a collection to the active key-encryption key: the data keys of
+// documents wrapped by another key are wrapped again by the active key, and the given fields of
+// plaintext documents are encrypted. Every filter is restricted by scope, and every document is only
+// updated if it was not changed since it was read, so that the rotation can run next to the server.
+// It returns the number of documents rotated.
+func RotateKeys(collection *mongo.Collection, scope func(bson.M) bson.M, fields ...string) (int, error) {
+	if utils.FieldKeys == nil {
+		return 0, errors.New("field encryption is disabled: set FIELD_ENCRYPTION_KEYS")
+	}
+	activeKeyId := utils.FieldKeys.ActiveKeyId()
+	cursor, err := collection.Find(context.Background(), scope(bson.M{"encryption.keyId": bson.M{"$ne": activeKeyId}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	rotated := 0
+	for cursor.Next(context.Background()) {
+		var document struct {
+			Id         primitive.ObjectID      `bson:"_id"`
+			Encryption *models.FieldEncryption `bson:"encryption"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return rotated, err
+		}
+
+		filter := bson.M{"_id": document.Id}
+		var update bson.M
+		if document.Encryption == nil {
+			filter["encryption"] = bson.M{"$exists": false}
+			values := map[string]*string{}
+			for _, field := range fields {
+				value, _ := cursor.Current.Lookup(field).StringValueOK()
+				values[field] = &value
+			}
+			encryption, err := Encrypt(values)
+			if err != nil {
+				return rotated, err
+			}
+			set := bson.M{"encryption": encryption}
+			for field, value := range values {
+				set[field] = *value
+			}
+			update = bson.M{"$set": set}
+		} else {
+			filter["encryption.keyId"] = document.Encryption.KeyId
+			dataKey, err := utils.FieldKeys.UnwrapDataKey(document.Encryption.KeyId, document.Encryption.DataKey)
+			if err != nil {
+				return rotated, fmt.Errorf("document %s: %w", document.Id.Hex(), err)
+			}
+			wrapped, err := utils.FieldKeys.WrapDataKey(dataKey)
+			if err != nil {
+				return rotated, err
+			}
+			update = bson.M{"$set": bson.M{"encryption": models.FieldEncryption{KeyId: activeKeyId, DataKey: wrapped}}}
+		}
+
+		result, err := collection.UpdateOne(context.Background(), scope(filter), update)
+		if err != nil {
+			return rotated, err
+		}
+		rotated += int(result.ModifiedCount)
+	}
+	return rotated, cursor.Err()
+}
@@ -0,0 +1,10 @@
+package kindRepository
+
+import "r2-notify-server/models"
+
+type KindRepository interface {
+	FindAll() ([]models.NotificationKind, error)
+	FindByType(kindType string) (models.NotificationKind, error)
+	Save(kind models.NotificationKind) error
+	Delete(kindType string) error
+}
@@ -0,0 +1,119 @@
+package kindRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrKindNotFound is returned when no notification kind is registered for the given type.
+var ErrKindNotFound = errors.New("notification kind not found")
+
+type KindRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewKindRepositoryImpl returns a new instance of KindRepositoryImpl.
+// Notification kinds are stored in the "notification_kinds" collection, keyed by their type.
+func NewKindRepositoryImpl(Db *mongo.Database) KindRepository {
+	return &KindRepositoryImpl{Db: Db}
+}
+
+// FindAll returns every registered notification kind, ordered by type.
+func (t *KindRepositoryImpl) FindAll() (kinds []models.NotificationKind, err error) {
+	cursor, err := t.Db.Collection("notification_kinds").Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Kind Repository",
+			Operation: "FindAll",
+			Message:   "Failed to fetch notification kinds",
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &kinds); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Kind Repository",
+			Operation: "FindAll",
+			Message:   "Failed to decode notification kinds",
+			Error:     err,
+		})
+		return nil, err
+	}
+	return kinds, nil
+}
+
+// FindByType returns the notification kind registered for the given type.
+func (t *KindRepositoryImpl) FindByType(kindType string) (kind models.NotificationKind, err error) {
+	err = t.Db.Collection("notification_kinds").FindOne(context.Background(), bson.M{"_id": kindType}).Decode(&kind)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.NotificationKind{}, ErrKindNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Kind Repository",
+			Operation: "FindByType",
+			Message:   "Failed to fetch notification kind: " + kindType,
+			Error:     err,
+		})
+		return models.NotificationKind{}, err
+	}
+	return kind, nil
+}
+
+// Save registers a notification kind, replacing the defaults of an existing one.
+func (t *KindRepositoryImpl) Save(kind models.NotificationKind) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"groupKey":  kind.GroupKey,
+			"status":    kind.Status,
+			"priority":  kind.Priority,
+			"channels":  kind.Channels,
+			"title":     kind.Title,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	_, err := t.Db.Collection("notification_kinds").UpdateByID(context.Background(), kind.Type, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Kind Repository",
+			Operation: "Save",
+			Message:   "Failed to save notification kind: " + kind.Type,
+			Error:     err,
+		})
+		return err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Kind Repository",
+		Operation: "Save",
+		Message:   "Saved notification kind: " + kind.Type,
+	})
+	return nil
+}
+
+// Delete removes the notification kind registered for the given type.
+func (t *KindRepositoryImpl) Delete(kindType string) error {
+	result, err := t.Db.Collection("notification_kinds").DeleteOne(context.Background(), bson.M{"_id": kindType})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Kind Repository",
+			Operation: "Delete",
+			Message:   "Failed to delete notification kind: " + kindType,
+			Error:     err,
+		})
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrKindNotFound
+	}
+	return nil
+}
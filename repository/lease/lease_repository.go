@@ -0,0 +1,17 @@
+package leaseRepository
+
+import (
+	"r2-notify-server/models"
+	"time"
+)
+
+type LeaseRepository interface {
+	FindLease(id string) (models.PartitionLease, error)
+	EnsureLease(lease models.PartitionLease) (models.PartitionLease, error)
+	AcquireLease(id string, owner string, token string, expiresAt time.Time) (models.PartitionLease, error)
+	RenewLease(id string, token string, expiresAt time.Time) (bool, error)
+	ReleaseLease(id string, token string) (bool, error)
+	UpdateCheckpoint(id string, token string, checkpoint models.PartitionCheckpoint) (bool, error)
+	DeleteLease(id string) error
+	DeleteLeases(hub string, consumerGroup string) error
+}
@@ -0,0 +1,164 @@
+package leaseRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLeaseNotFound is returned by FindLease and AcquireLease when the partition has no lease document.
+var ErrLeaseNotFound = errors.New("partition lease not found")
+
+type LeaseRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewLeaseRepositoryImpl returns a new instance of LeaseRepositoryImpl.
+// Partition ownership and the last processed position of every partition are stored
+// together in the "partition_leases" collection, one document per hub, consumer group
+// and partition.
+func NewLeaseRepositoryImpl(Db *mongo.Database) LeaseRepository {
+	return &LeaseRepositoryImpl{Db: Db}
+}
+
+// FindLease retrieves the lease of a partition by its ID.
+func (t LeaseRepositoryImpl) FindLease(id string) (lease models.PartitionLease, err error) {
+	err = t.Db.Collection("partition_leases").FindOne(context.Background(), bson.M{"_id": id}).Decode(&lease)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.PartitionLease{}, ErrLeaseNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: "FindLease",
+			Message:   "Failed to fetch partition lease: " + id,
+			Error:     err,
+		})
+		return models.PartitionLease{}, err
+	}
+	return lease, nil
+}
+
+// EnsureLease creates the lease document of a partition if it does not exist yet and returns
+// the stored lease. An existing lease, including its owner and checkpoint, is left untouched.
+func (t *LeaseRepositoryImpl) EnsureLease(lease models.PartitionLease) (models.PartitionLease, error) {
+	update := bson.M{"$setOnInsert": bson.M{
+		"hub":           lease.Hub,
+		"consumerGroup": lease.ConsumerGroup,
+		"partitionId":   lease.PartitionId,
+		"owner":         "",
+		"token":         "",
+		"epoch":         int64(0),
+		"expiresAt":     time.Time{},
+		"checkpoint":    lease.Checkpoint,
+		"updatedAt":     time.Now(),
+	}}
+	findOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var stored models.PartitionLease
+	err := t.Db.Collection("partition_leases").FindOneAndUpdate(context.Background(), bson.M{"_id": lease.Id}, update, findOptions).Decode(&stored)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: "EnsureLease",
+			Message:   "Failed to ensure partition lease: " + lease.Id,
+			Error:     err,
+		})
+		return models.PartitionLease{}, err
+	}
+	return stored, nil
+}
+
+// AcquireLease makes the given owner the holder of a partition lease until expiresAt and
+// increments its epoch, so that Event Hubs disconnects any receiver of the previous owner.
+// The lease is taken over even if it is still held, which is how partitions are rebalanced
+// between replicas.
+func (t *LeaseRepositoryImpl) AcquireLease(id string, owner string, token string, expiresAt time.Time) (lease models.PartitionLease, err error) {
+	update := bson.M{
+		"$set": bson.M{"owner": owner, "token": token, "expiresAt": expiresAt, "updatedAt": time.Now()},
+		"$inc": bson.M{"epoch": 1},
+	}
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = t.Db.Collection("partition_leases").FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, findOptions).Decode(&lease)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.PartitionLease{}, ErrLeaseNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: "AcquireLease",
+			Message:   "Failed to acquire partition lease: " + id,
+			Error:     err,
+		})
+		return models.PartitionLease{}, err
+	}
+	return lease, nil
+}
+
+// RenewLease extends a lease held with the given token until expiresAt. It returns false
+// if the lease was taken over by another owner.
+func (t *LeaseRepositoryImpl) RenewLease(id string, token string, expiresAt time.Time) (bool, error) {
+	return t.updateOwned("RenewLease", id, token, bson.M{"expiresAt": expiresAt})
+}
+
+// ReleaseLease gives up a lease held with the given token so that another owner can acquire
+// it immediately. It returns false if the lease was taken over by another owner.
+func (t *LeaseRepositoryImpl) ReleaseLease(id string, token string) (bool, error) {
+	return t.updateOwned("ReleaseLease", id, token, bson.M{"owner": "", "token": "", "expiresAt": time.Time{}})
+}
+
+// UpdateCheckpoint stores the last processed position of a partition whose lease is held with
+// the given token. It returns false if the lease was taken over by another owner, in which case
+// the checkpoint is not written.
+func (t *LeaseRepositoryImpl) UpdateCheckpoint(id string, token string, checkpoint models.PartitionCheckpoint) (bool, error) {
+	return t.updateOwned("UpdateCheckpoint", id, token, bson.M{"checkpoint": checkpoint})
+}
+
+// DeleteLease removes the lease document of a partition.
+func (t *LeaseRepositoryImpl) DeleteLease(id string) error {
+	_, err := t.Db.Collection("partition_leases").DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: "DeleteLease",
+			Message:   "Failed to delete partition lease: " + id,
+			Error:     err,
+		})
+	}
+	return err
+}
+
+// DeleteLeases removes every lease document of the given hub and consumer group.
+func (t *LeaseRepositoryImpl) DeleteLeases(hub string, consumerGroup string) error {
+	_, err := t.Db.Collection("partition_leases").DeleteMany(context.Background(), bson.M{"hub": hub, "consumerGroup": consumerGroup})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: "DeleteLeases",
+			Message:   "Failed to delete partition leases of hub: " + hub + ", consumer group: " + consumerGroup,
+			Error:     err,
+		})
+	}
+	return err
+}
+
+// updateOwned sets the given fields of a lease if it is still held with the given token.
+func (t *LeaseRepositoryImpl) updateOwned(operation string, id string, token string, fields bson.M) (bool, error) {
+	fields["updatedAt"] = time.Now()
+	result, err := t.Db.Collection("partition_leases").UpdateOne(context.Background(), bson.M{"_id": id, "token": token}, bson.M{"$set": fields})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Lease Repository",
+			Operation: operation,
+			Message:   "Failed to update partition lease: " + id,
+			Error:     err,
+		})
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
@@ -0,0 +1,67 @@
+// Package mongotest helps the repository tests check the commands sent to a mocked MongoDB deployment.
+package mongotest
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Command is a command sent to the deployment, with the filter it selects documents with and the update
+// it applies to them, or the document it inserts.
+type Command struct {
+	Name     string
+	Filter   bson.Raw
+	Update   bson.Raw
+	Document bson.Raw
+}
+
+// SentCommands returns the commands sent since the last call, in order. An insert of several documents
+// is returned as one command per document.
+func SentCommands(mt *mtest.T) []Command {
+	var commands []Command
+	for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+		command := Command{Name: event.CommandName}
+		switch event.CommandName {
+		case "find":
+			command.Filter = event.Command.Lookup("filter").Document()
+		case "findAndModify":
+			command.Filter = event.Command.Lookup("query").Document()
+		case "update":
+			command.Filter = event.Command.Lookup("updates", "0", "q").Document()
+			command.Update = event.Command.Lookup("updates", "0", "u").Document()
+		case "delete":
+			command.Filter = event.Command.Lookup("deletes", "0", "q").Document()
+		case "aggregate":
+			command.Filter = event.Command.Lookup("pipeline", "0", "$match").Document()
+		case "insert":
+			documents, _ := event.Command.Lookup("documents").Array().Values()
+			for _, document := range documents {
+				commands = append(commands, Command{Name: event.CommandName, Document: document.Document()})
+			}
+			continue
+		default:
+			continue
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// ExpectTenant fails the test unless every command only selects the documents of the given tenant,
+// and every inserted document belongs to it. The tenant must not be the default tenant, whose filters
+// also match the documents stored without a tenant.
+func ExpectTenant(mt *mtest.T, commands []Command, tenantId string) {
+	mt.Helper()
+	if len(commands) == 0 {
+		mt.Fatal("expected commands to be sent")
+	}
+	for _, command := range commands {
+		document := command.Filter
+		if command.Document != nil {
+			document = command.Document
+		}
+		if value, ok := document.Lookup("tenantId").StringValueOK(); !ok || value != tenantId {
+			mt.Errorf("expected the %s command %s to be restricted to %s", command.Name, document, tenantId)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package notificationRepository
+
+import (
+	"fmt"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/encryption"
+)
+
+// encrypt replaces the title and message of a notification with their ciphertext, under a new data key
+// wrapped by the active key-encryption key. Notifications are left in plaintext when field encryption is
+// disabled.
+func encrypt(notification *models.Notification) (err error) {
+	notification.Encryption, err = encryption.Encrypt(map[string]*string{"title": &notification.Title, "message": &notification.Message})
+	return err
+}
+
+// decrypt restores the title and message of an encrypted notification. Plaintext notifications, stored
+// before encryption was enabled, are returned as they are.
+func decrypt(notification *models.Notification) error {
+	if err := encryption.Decrypt(notification.Encryption, map[string]*string{"title": &notification.Title, "message": &notification.Message}); err != nil {
+		return err
+	}
+	notification.Encryption = nil
+	return nil
+}
+
+// decryptAll decrypts the given notifications in place.
+func decryptAll(notifications []models.Notification) error {
+	for i := range notifications {
+		if err := decrypt(&notifications[i]); err != nil {
+			return fmt.Errorf("notification %s: %w", notifications[i].Id.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// RotateKeys moves the notifications of the tenant to the active key-encryption key: the data keys of
+// notifications wrapped by another key are wrapped again by the active key, and plaintext notifications
+// are encrypted. Every notification is updated only if it was not changed since it was read, so that
+// the rotation can run next to the server. It returns the number of notifications rotated.
+func (t NotificationRepositoryImpl) RotateKeys() (int, error) {
+	rotated, err := encryption.RotateKeys(t.Db.Collection("notifications"), t.scope, "title", "message")
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "RotateKeys",
+			Message:   fmt.Sprintf("Failed to rotate the keys of notifications after %d", rotated),
+			Error:     err,
+		})
+	}
+	return rotated, err
+}
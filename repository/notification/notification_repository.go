@@ -2,20 +2,29 @@ package notificationRepository
 
 import (
 	"r2-notify-server/models"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type NotificationRepository interface {
+	ForTenant(tenantId string) NotificationRepository
 	FindAll(userId string) ([]models.Notification, error)
 	FindById(id primitive.ObjectID, userId string) (models.Notification, error)
+	FindMatching(userId string, appId string, groupKey string, unreadOnly bool) ([]models.Notification, error)
+	FindUndelivered(userId string) ([]models.Notification, error)
+	FindUnreadBetween(userId string, from time.Time, to time.Time) ([]models.Notification, error)
 	Create(notification models.Notification) (primitive.ObjectID, error)
-	MarkAsRead(clientId string) error
-	MarkAppAsRead(clientId string, appId string) error
-	MarkGroupAsRead(clientId string, appId string, groupKey string) error
+	CreateMany(notifications []models.Notification) ([]primitive.ObjectID, error)
+	MarkDelivered(userId string, notificationIds []primitive.ObjectID) error
+	RecordDeliveries(userId string, notificationId primitive.ObjectID, deliveries []models.ChannelDelivery) error
+	MarkAsRead(clientId string) (int64, error)
+	MarkAppAsRead(clientId string, appId string) (int64, error)
+	MarkGroupAsRead(clientId string, appId string, groupKey string) (int64, error)
 	MarkNotificationAsRead(clientId string, notificationId string) error
-	DeleteNotifications(clientId string) error
-	DeleteAppNotifications(clientId string, appId string) error
-	DeleteGroupNotifications(clientId string, appId string, groupKey string) error
+	DeleteNotifications(clientId string) (int64, error)
+	DeleteAppNotifications(clientId string, appId string) (int64, error)
+	DeleteGroupNotifications(clientId string, appId string, groupKey string) (int64, error)
 	DeleteNotification(clientId string, notificationId string) error
+	RotateKeys() (rotated int, err error)
 }
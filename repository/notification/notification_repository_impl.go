@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"r2-notify-server/config"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
 	"strings"
@@ -13,17 +14,33 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type NotificationRepositoryImpl struct {
-	Db *mongo.Database
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
 }
 
 // NewNotificationRepositoryImpl returns a new instance of NotificationRepositoryImpl.
 // It takes a pointer to a mongo.Database as an argument, which is used to interact with the database.
-// The returned NotificationRepositoryImpl is safe to use concurrently.
+// The returned NotificationRepositoryImpl is scoped to the default tenant, and is safe to use concurrently.
 func NewNotificationRepositoryImpl(Db *mongo.Database) NotificationRepository {
-	return &NotificationRepositoryImpl{Db: Db}
+	return &NotificationRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the notifications of the given tenant. Every filter of the
+// returned repository is restricted to the tenant, and every notification it creates belongs to the tenant.
+func (t NotificationRepositoryImpl) ForTenant(tenantId string) NotificationRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &NotificationRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the notifications of the tenant of the repository.
+func (t NotificationRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
 }
 
 // FindAll finds all unread notifications for a given user.
@@ -36,7 +53,7 @@ func (t NotificationRepositoryImpl) FindAll(userId string) (notifications []mode
 		Message:   "Fetching all unread notifications for userId: " + userId,
 		UserId:    userId,
 	})
-	cursor, err := t.Db.Collection("notifications").Find(context.Background(), bson.M{"userId": userId, "readStatus": false})
+	cursor, err := t.Db.Collection("notifications").Find(context.Background(), t.scope(bson.M{"userId": userId, "readStatus": false}))
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -61,6 +78,16 @@ func (t NotificationRepositoryImpl) FindAll(userId string) (notifications []mode
 			})
 			return nil, err
 		}
+		if err := decrypt(&notification); err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Notification Repository",
+				Operation: "FindAll",
+				Message:   "Failed to decrypt notification " + notification.Id.Hex() + " for userId: " + userId,
+				Error:     err,
+				UserId:    userId,
+			})
+			return nil, err
+		}
 		notifications = append(notifications, notification)
 	}
 
@@ -92,7 +119,7 @@ func (t NotificationRepositoryImpl) FindById(notificationId primitive.ObjectID,
 		Message:   "Fetching notification by ID for userId: " + userId,
 		UserId:    userId,
 	})
-	result := t.Db.Collection("notifications").FindOne(context.Background(), bson.M{"_id": notificationId, "userId": userId})
+	result := t.Db.Collection("notifications").FindOne(context.Background(), t.scope(bson.M{"_id": notificationId, "userId": userId}))
 	if err := result.Err(); err != nil {
 		if err == mongo.ErrNoDocuments {
 			notFoundErr := errors.New("notification not found")
@@ -124,6 +151,16 @@ func (t NotificationRepositoryImpl) FindById(notificationId primitive.ObjectID,
 		})
 		return models.Notification{}, err
 	}
+	if err := decrypt(&notification); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "FindById",
+			Message:   "Failed to decrypt notification for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return models.Notification{}, err
+	}
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Notification Repository",
 		Operation: "FindById",
@@ -133,7 +170,81 @@ func (t NotificationRepositoryImpl) FindById(notificationId primitive.ObjectID,
 	return notification, nil
 }
 
+// FindMatching finds the notifications of a given user that match the given appId and groupKey.
+// Empty appId or groupKey values are not used as filters. If unreadOnly is true only unread
+// notifications are returned. It is used to capture the notifications affected by a bulk operation.
+func (t NotificationRepositoryImpl) FindMatching(userId string, appId string, groupKey string, unreadOnly bool) (notifications []models.Notification, err error) {
+	appId = strings.Trim(strings.TrimSpace(appId), `"'`)
+	groupKey = strings.Trim(strings.TrimSpace(groupKey), `"'`)
+	filter := bson.M{"userId": userId}
+	if appId != "" {
+		filter["appId"] = appId
+	}
+	if groupKey != "" {
+		filter["groupKey"] = groupKey
+	}
+	if unreadOnly {
+		filter["readStatus"] = false
+	}
+	return t.find("FindMatching", userId, filter)
+}
+
+// FindUndelivered finds the unread notifications of a given user that have not been delivered yet.
+func (t NotificationRepositoryImpl) FindUndelivered(userId string) (notifications []models.Notification, err error) {
+	return t.find("FindUndelivered", userId, bson.M{"userId": userId, "readStatus": false, "deliveredAt": bson.M{"$exists": false}})
+}
+
+// FindUnreadBetween finds the unread notifications of a given user created after from and
+// up to and including to.
+func (t NotificationRepositoryImpl) FindUnreadBetween(userId string, from time.Time, to time.Time) (notifications []models.Notification, err error) {
+	return t.find("FindUnreadBetween", userId, bson.M{"userId": userId, "readStatus": false, "createdAt": bson.M{"$gt": from, "$lte": to}})
+}
+
+// find runs the given filter against the notifications collection and decodes every match.
+func (t NotificationRepositoryImpl) find(operation string, userId string, filter bson.M) (notifications []models.Notification, err error) {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Notification Repository",
+		Operation: operation,
+		Message:   "Fetching notifications for userId: " + userId,
+		UserId:    userId,
+	})
+	cursor, err := t.Db.Collection("notifications").Find(context.Background(), t.scope(filter))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: operation,
+			Message:   "Failed to fetch notifications for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &notifications); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: operation,
+			Message:   "Failed to decode notifications for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	if err := decryptAll(notifications); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: operation,
+			Message:   "Failed to decrypt notifications for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	return notifications, nil
+}
+
 // Create creates a new notification document in the database and returns the ID of the newly created document, or an error if the creation fails.
+// The title and message are encrypted when field encryption is enabled.
 func (t *NotificationRepositoryImpl) Create(notification models.Notification) (primitive.ObjectID, error) {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Notification Repository",
@@ -141,6 +252,17 @@ func (t *NotificationRepositoryImpl) Create(notification models.Notification) (p
 		Message:   "Creating notification for userId: " + notification.UserId,
 		UserId:    notification.UserId,
 	})
+	notification.TenantId = t.TenantId
+	if err := encrypt(&notification); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "Create",
+			Message:   "Failed to encrypt notification for userId: " + notification.UserId,
+			Error:     err,
+			UserId:    notification.UserId,
+		})
+		return primitive.NilObjectID, err
+	}
 	result, err := t.Db.Collection("notifications").InsertOne(context.Background(), notification)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -173,18 +295,115 @@ func (t *NotificationRepositoryImpl) Create(notification models.Notification) (p
 	return id, nil
 }
 
+// CreateMany inserts the given notifications with a single unordered bulk write, so that a notification
+// rejected by the database does not prevent the others from being stored. The IDs are assigned before the
+// write and returned in the order of the notifications. When only some of the notifications are stored,
+// the bulk write error is returned together with the IDs, the ID of every notification not stored being nil.
+func (t *NotificationRepositoryImpl) CreateMany(notifications []models.Notification) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, len(notifications))
+	documents := make([]interface{}, len(notifications))
+	for i, notification := range notifications {
+		notification.Id = primitive.NewObjectID()
+		notification.TenantId = t.TenantId
+		if err := encrypt(&notification); err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Notification Repository",
+				Operation: "CreateMany",
+				Message:   "Failed to encrypt notification for userId: " + notification.UserId,
+				Error:     err,
+				UserId:    notification.UserId,
+			})
+			return nil, err
+		}
+		ids[i] = notification.Id
+		documents[i] = notification
+	}
+	_, err := t.Db.Collection("notifications").InsertMany(context.Background(), documents, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "CreateMany",
+			Message:   fmt.Sprintf("Failed to create notifications of a batch of %d", len(notifications)),
+			Error:     err,
+		})
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+			return nil, err
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			ids[writeErr.Index] = primitive.NilObjectID
+		}
+		return ids, err
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Notification Repository",
+		Operation: "CreateMany",
+		Message:   fmt.Sprintf("Successfully created a batch of %d notifications", len(notifications)),
+	})
+	return ids, nil
+}
+
+// MarkDelivered sets the deliveredAt timestamp of the given notifications of a user.
+// Notifications that already carry a deliveredAt timestamp keep their original value.
+func (t *NotificationRepositoryImpl) MarkDelivered(userId string, notificationIds []primitive.ObjectID) error {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Notification Repository",
+		Operation: "MarkDelivered",
+		Message:   "Marking notifications as delivered for userId: " + userId,
+		UserId:    userId,
+	})
+	filter := bson.M{"userId": userId, "_id": bson.M{"$in": notificationIds}, "deliveredAt": bson.M{"$exists": false}}
+	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), t.scope(filter), bson.M{"$set": bson.M{"deliveredAt": primitive.NewDateTimeFromTime(time.Now())}})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "MarkDelivered",
+			Message:   "Failed to mark notifications as delivered for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Notification Repository",
+		Operation: "MarkDelivered",
+		Message:   "Marked notifications as delivered for userId: " + userId + " | Matched: " + fmt.Sprintf("%d", updatedResults.MatchedCount) + " Modified: " + fmt.Sprintf("%d", updatedResults.ModifiedCount),
+		UserId:    userId,
+	})
+	return nil
+}
+
+// RecordDeliveries appends the outcome of each delivery channel attempted for the given
+// notification to its deliveries list.
+func (t *NotificationRepositoryImpl) RecordDeliveries(userId string, notificationId primitive.ObjectID, deliveries []models.ChannelDelivery) error {
+	filter := bson.M{"userId": userId, "_id": notificationId}
+	update := bson.M{"$push": bson.M{"deliveries": bson.M{"$each": deliveries}}}
+	_, err := t.Db.Collection("notifications").UpdateOne(context.Background(), t.scope(filter), update)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Repository",
+			Operation: "RecordDeliveries",
+			Message:   "Failed to record channel deliveries of notification: " + notificationId.Hex(),
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	return nil
+}
+
 // MarkAsRead marks all unread notifications for a given user as read.
 // It trims and removes any double quotes from the clientId,
 // and then updates all relevant notifications in the database with the current time and sets the readStatus to true.
-// It returns an error if there is an issue with the database query.
-func (t *NotificationRepositoryImpl) MarkAsRead(clientId string) error {
+// It returns the number of notifications marked as read, or an error if there is an issue with the database query.
+func (t *NotificationRepositoryImpl) MarkAsRead(clientId string) (int64, error) {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Notification Repository",
 		Operation: "MarkAsRead",
 		Message:   "Marking all notifications as read for userId: " + clientId,
 		UserId:    clientId,
 	})
-	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), bson.M{"userId": clientId}, bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
+	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), t.scope(bson.M{"userId": clientId, "readStatus": false}), bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -193,7 +412,7 @@ func (t *NotificationRepositoryImpl) MarkAsRead(clientId string) error {
 			Error:     err,
 			UserId:    clientId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -201,11 +420,12 @@ func (t *NotificationRepositoryImpl) MarkAsRead(clientId string) error {
 		Message:   "Marked notifications as read for userId: " + clientId + " | Matched: " + fmt.Sprintf("%d", updatedResults.MatchedCount) + " Modified: " + fmt.Sprintf("%d", updatedResults.ModifiedCount),
 		UserId:    clientId,
 	})
-	return nil
+	return updatedResults.ModifiedCount, nil
 }
 
-// MarkAppAsRead marks all unread notifications for a given user and appId as read.
-func (t *NotificationRepositoryImpl) MarkAppAsRead(clientId string, appId string) error {
+// MarkAppAsRead marks all unread notifications for a given user and appId as read,
+// and returns the number of notifications marked as read.
+func (t *NotificationRepositoryImpl) MarkAppAsRead(clientId string, appId string) (int64, error) {
 	appId = strings.TrimSpace(appId)
 	appId = strings.Trim(appId, `"'`)
 	logger.Log.Debug(logger.LogPayload{
@@ -215,7 +435,7 @@ func (t *NotificationRepositoryImpl) MarkAppAsRead(clientId string, appId string
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), bson.M{"userId": clientId, "appId": appId}, bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
+	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), t.scope(bson.M{"userId": clientId, "appId": appId, "readStatus": false}), bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -225,7 +445,7 @@ func (t *NotificationRepositoryImpl) MarkAppAsRead(clientId string, appId string
 			UserId:    clientId,
 			AppId:     appId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -234,13 +454,14 @@ func (t *NotificationRepositoryImpl) MarkAppAsRead(clientId string, appId string
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	return nil
+	return updatedResults.ModifiedCount, nil
 }
 
 // MarkGroupAsRead marks all unread notifications for a given user, appId and groupKey as read.
 // It trims the appId and groupKey of any whitespace and removes any double quotes from the strings.
-// It then updates the relevant notifications in the database with the current time and sets the readStatus to true.
-func (t *NotificationRepositoryImpl) MarkGroupAsRead(clientId string, appId string, groupKey string) error {
+// It then updates the relevant notifications in the database with the current time and sets the readStatus to true,
+// and returns the number of notifications marked as read.
+func (t *NotificationRepositoryImpl) MarkGroupAsRead(clientId string, appId string, groupKey string) (int64, error) {
 	appId = strings.TrimSpace(appId)
 	groupKey = strings.TrimSpace(groupKey)
 	appId = strings.Trim(appId, `"'`)
@@ -252,7 +473,7 @@ func (t *NotificationRepositoryImpl) MarkGroupAsRead(clientId string, appId stri
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), bson.M{"userId": clientId, "appId": appId, "groupKey": groupKey}, bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
+	updatedResults, err := t.Db.Collection("notifications").UpdateMany(context.Background(), t.scope(bson.M{"userId": clientId, "appId": appId, "groupKey": groupKey, "readStatus": false}), bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -262,7 +483,7 @@ func (t *NotificationRepositoryImpl) MarkGroupAsRead(clientId string, appId stri
 			UserId:    clientId,
 			AppId:     appId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -271,7 +492,7 @@ func (t *NotificationRepositoryImpl) MarkGroupAsRead(clientId string, appId stri
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	return nil
+	return updatedResults.ModifiedCount, nil
 }
 
 // MarkNotificationAsRead marks a notification as read for a given user.
@@ -298,7 +519,7 @@ func (t *NotificationRepositoryImpl) MarkNotificationAsRead(clientId string, not
 		})
 		return err
 	}
-	updatedResults, err := t.Db.Collection("notifications").UpdateByID(context.Background(), objID, bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
+	updatedResults, err := t.Db.Collection("notifications").UpdateOne(context.Background(), t.scope(bson.M{"_id": objID, "userId": clientId}), bson.M{"$set": bson.M{"readStatus": true, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}})
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -321,15 +542,15 @@ func (t *NotificationRepositoryImpl) MarkNotificationAsRead(clientId string, not
 // DeleteAllNotifications deletes all notifications for a given user.
 // It trims and removes any double quotes from the clientId,
 // and then deletes all relevant notifications in the database.
-// It returns an error if there is an issue with the database query.
-func (t *NotificationRepositoryImpl) DeleteNotifications(clientId string) error {
+// It returns the number of notifications deleted, or an error if there is an issue with the database query.
+func (t *NotificationRepositoryImpl) DeleteNotifications(clientId string) (int64, error) {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Notification Repository",
 		Operation: "DeleteNotifications",
 		Message:   "Deleting all notifications for userId: " + clientId,
 		UserId:    clientId,
 	})
-	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), bson.M{"userId": clientId})
+	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), t.scope(bson.M{"userId": clientId}))
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -338,7 +559,7 @@ func (t *NotificationRepositoryImpl) DeleteNotifications(clientId string) error
 			Error:     err,
 			UserId:    clientId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -346,11 +567,12 @@ func (t *NotificationRepositoryImpl) DeleteNotifications(clientId string) error
 		Message:   "Deleted notifications for userId: " + clientId + " | Deleted: " + fmt.Sprintf("%d", deleteResult.DeletedCount),
 		UserId:    clientId,
 	})
-	return nil
+	return deleteResult.DeletedCount, nil
 }
 
-// DeleteAppNotifications deletes all notifications for a given user and appId.
-func (t *NotificationRepositoryImpl) DeleteAppNotifications(clientId string, appId string) error {
+// DeleteAppNotifications deletes all notifications for a given user and appId,
+// and returns the number of notifications deleted.
+func (t *NotificationRepositoryImpl) DeleteAppNotifications(clientId string, appId string) (int64, error) {
 	appId = strings.TrimSpace(appId)
 	appId = strings.Trim(appId, `"'`)
 	logger.Log.Debug(logger.LogPayload{
@@ -360,7 +582,7 @@ func (t *NotificationRepositoryImpl) DeleteAppNotifications(clientId string, app
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), bson.M{"userId": clientId, "appId": appId})
+	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), t.scope(bson.M{"userId": clientId, "appId": appId}))
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -370,7 +592,7 @@ func (t *NotificationRepositoryImpl) DeleteAppNotifications(clientId string, app
 			UserId:    clientId,
 			AppId:     appId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -379,13 +601,13 @@ func (t *NotificationRepositoryImpl) DeleteAppNotifications(clientId string, app
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	return nil
+	return deleteResult.DeletedCount, nil
 }
 
 // DeleteGroupNotifications deletes all notifications for a given user, appId and groupKey.
 // It trims the appId and groupKey of any whitespace and removes any double quotes from the strings.
-// It then deletes the relevant notifications in the database.
-func (t *NotificationRepositoryImpl) DeleteGroupNotifications(clientId string, appId string, groupKey string) error {
+// It then deletes the relevant notifications in the database, and returns the number of notifications deleted.
+func (t *NotificationRepositoryImpl) DeleteGroupNotifications(clientId string, appId string, groupKey string) (int64, error) {
 	appId = strings.TrimSpace(appId)
 	groupKey = strings.TrimSpace(groupKey)
 	appId = strings.Trim(appId, `"'`)
@@ -397,7 +619,7 @@ func (t *NotificationRepositoryImpl) DeleteGroupNotifications(clientId string, a
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), bson.M{"userId": clientId, "appId": appId, "groupKey": groupKey})
+	deleteResult, err := t.Db.Collection("notifications").DeleteMany(context.Background(), t.scope(bson.M{"userId": clientId, "appId": appId, "groupKey": groupKey}))
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -407,7 +629,7 @@ func (t *NotificationRepositoryImpl) DeleteGroupNotifications(clientId string, a
 			UserId:    clientId,
 			AppId:     appId,
 		})
-		return err
+		return 0, err
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Repository",
@@ -416,7 +638,7 @@ func (t *NotificationRepositoryImpl) DeleteGroupNotifications(clientId string, a
 		UserId:    clientId,
 		AppId:     appId,
 	})
-	return nil
+	return deleteResult.DeletedCount, nil
 }
 
 // DeleteNotification deletes a notification for a given user.
@@ -443,7 +665,7 @@ func (t *NotificationRepositoryImpl) DeleteNotification(clientId string, notific
 		})
 		return err
 	}
-	deleteResult, err := t.Db.Collection("notifications").DeleteOne(context.Background(), bson.M{"userId": clientId, "_id": objID})
+	deleteResult, err := t.Db.Collection("notifications").DeleteOne(context.Background(), t.scope(bson.M{"userId": clientId, "_id": objID}))
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Repository",
@@ -0,0 +1,259 @@
+package notificationRepository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"r2-notify-server/utils"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// useFieldKeys enables field encryption with the given keys until the test ends.
+func useFieldKeys(t *testing.T, value string, activeKeyId string) *utils.FieldKeySet {
+	t.Helper()
+	keys, err := utils.ParseFieldKeys(value, activeKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.FieldKeys = keys
+	t.Cleanup(func() { utils.FieldKeys = nil })
+	return keys
+}
+
+// k1 and k2 are key-encryption keys filled with 1 and 2.
+var (
+	k1 = "k1=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k2 = "k2=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+func testNotification(message string) models.Notification {
+	return models.Notification{
+		AppId:     "app-a",
+		UserId:    "u1",
+		GroupKey:  "builds",
+		Title:     "Build failed",
+		Message:   message,
+		Status:    "error",
+		Priority:  "high",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// document returns the BSON document MongoDB would return for the notification.
+func document(t *testing.T, notification models.Notification) bson.D {
+	t.Helper()
+	raw, err := bson.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document bson.D
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+func TestStoredNotificationsHoldOnlyCiphertext(t *testing.T) {
+	useFieldKeys(t, k1, "")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("create and create many", func(mt *mtest.T) {
+		repository := NewNotificationRepositoryImpl(mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+		)
+
+		if _, err := repository.Create(testNotification("main is red")); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.CreateMany([]models.Notification{testNotification("api is red"), testNotification("web is red")}); err != nil {
+			mt.Fatal(err)
+		}
+
+		commands := mongotest.SentCommands(mt)
+		if len(commands) != 3 {
+			mt.Fatalf("expected 3 inserted documents, got %d", len(commands))
+		}
+		for i, message := range []string{"main is red", "api is red", "web is red"} {
+			raw := commands[i].Document
+			if bytes.Contains(raw, []byte(message)) || bytes.Contains(raw, []byte("Build failed")) {
+				mt.Fatalf("expected the stored document to hold no plaintext, got %s", raw)
+			}
+			if keyId, _ := raw.Lookup("encryption", "keyId").StringValueOK(); keyId != "k1" {
+				mt.Fatalf("expected the data key to be wrapped by k1, got %s", raw)
+			}
+			if appId, _ := raw.Lookup("appId").StringValueOK(); appId != "app-a" {
+				mt.Fatalf("expected the other fields to be stored as they are, got %s", raw)
+			}
+
+			var stored models.Notification
+			if err := bson.Unmarshal(raw, &stored); err != nil {
+				mt.Fatal(err)
+			}
+			if err := decrypt(&stored); err != nil {
+				mt.Fatal(err)
+			}
+			if stored.Title != "Build failed" || stored.Message != message || stored.Encryption != nil {
+				mt.Fatalf("expected the stored document to decrypt to the notification, got %+v", stored)
+			}
+		}
+	})
+
+	mt.Run("plaintext without keys", func(mt *mtest.T) {
+		utils.FieldKeys = nil
+		defer func() { useFieldKeys(t, k1, "") }()
+		repository := NewNotificationRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		if _, err := repository.Create(testNotification("main is red")); err != nil {
+			mt.Fatal(err)
+		}
+		raw := mongotest.SentCommands(mt)[0].Document
+		if message, _ := raw.Lookup("message").StringValueOK(); message != "main is red" || raw.Lookup("encryption").Validate() == nil {
+			mt.Fatalf("expected the notification to be stored in plaintext, got %s", raw)
+		}
+	})
+}
+
+func TestEncryptedNotificationsAreDecryptedOnRead(t *testing.T) {
+	useFieldKeys(t, k1, "")
+	encrypted := testNotification("main is red")
+	encrypted.Id = primitive.NewObjectID()
+	if err := encrypt(&encrypted); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := testNotification("api is red")
+	plaintext.Id = primitive.NewObjectID()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("encrypted and plaintext notifications", func(mt *mtest.T) {
+		repository := NewNotificationRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".notifications", mtest.FirstBatch, document(t, encrypted), document(t, plaintext)))
+
+		notifications, err := repository.FindAll("u1")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(notifications) != 2 || notifications[0].Message != "main is red" || notifications[0].Title != "Build failed" || notifications[1].Message != "api is red" {
+			mt.Fatalf("expected the notifications in plaintext, got %+v", notifications)
+		}
+	})
+
+	mt.Run("data key of an unknown key", func(mt *mtest.T) {
+		useFieldKeys(t, k2, "")
+		defer func() { useFieldKeys(t, k1, "") }()
+		repository := NewNotificationRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".notifications", mtest.FirstBatch, document(t, encrypted)))
+
+		if _, err := repository.FindAll("u1"); err == nil {
+			mt.Fatal("expected a notification wrapped by an unknown key to fail")
+		}
+	})
+
+	mt.Run("encrypted notification without keys", func(mt *mtest.T) {
+		utils.FieldKeys = nil
+		defer func() { useFieldKeys(t, k1, "") }()
+		repository := NewNotificationRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".notifications", mtest.FirstBatch, document(t, encrypted)))
+
+		if _, err := repository.FindAll("u1"); err == nil {
+			mt.Fatal("expected an encrypted notification to fail without keys")
+		}
+	})
+}
+
+func TestRotateKeys(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	before := useFieldKeys(t, k1, "")
+	wrappedByK1 := testNotification("main is red")
+	wrappedByK1.Id = primitive.NewObjectID()
+	if err := encrypt(&wrappedByK1); err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := before.UnwrapDataKey("k1", wrappedByK1.Encryption.DataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := testNotification("api is red")
+	plaintext.Id = primitive.NewObjectID()
+	after := useFieldKeys(t, k1+","+k2, "k2")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("rewraps data keys and encrypts plaintext", func(mt *mtest.T) {
+		repository := NewNotificationRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".notifications", mtest.FirstBatch, document(t, wrappedByK1), document(t, plaintext)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		rotated, err := repository.RotateKeys()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if rotated != 2 {
+			mt.Fatalf("expected 2 notifications to be rotated, got %d", rotated)
+		}
+
+		commands := mongotest.SentCommands(mt)
+		if len(commands) != 3 {
+			mt.Fatalf("expected a find and 2 updates, got %d commands", len(commands))
+		}
+		mongotest.ExpectTenant(mt, commands, "tenant-b")
+		if keyId, _ := commands[0].Filter.Lookup("encryption.keyId", "$ne").StringValueOK(); keyId != "k2" {
+			mt.Fatalf("expected the notifications not wrapped by k2 to be selected, got %s", commands[0].Filter)
+		}
+
+		rewrap := commands[1]
+		if keyId, _ := rewrap.Filter.Lookup("encryption.keyId").StringValueOK(); keyId != "k1" {
+			mt.Fatalf("expected the update to require the notification to still be wrapped by k1, got %s", rewrap.Filter)
+		}
+		var encryption models.FieldEncryption
+		if err := rewrap.Update.Lookup("$set", "encryption").Unmarshal(&encryption); err != nil {
+			mt.Fatal(err)
+		}
+		if encryption.KeyId != "k2" {
+			mt.Fatalf("expected the data key to be wrapped by k2, got %s", encryption.KeyId)
+		}
+		if unwrapped, err := after.UnwrapDataKey("k2", encryption.DataKey); err != nil || !bytes.Equal(unwrapped, dataKey) {
+			mt.Fatalf("expected the same data key to be wrapped by k2, got %v", err)
+		}
+		if rewrap.Update.Lookup("$set", "message").Validate() == nil {
+			mt.Fatalf("expected the ciphertext to be left as it is, got %s", rewrap.Update)
+		}
+
+		encryptPlaintext := commands[2]
+		if exists, _ := encryptPlaintext.Filter.Lookup("encryption", "$exists").BooleanOK(); exists {
+			mt.Fatalf("expected the update to require the notification to still be in plaintext, got %s", encryptPlaintext.Filter)
+		}
+		if bytes.Contains(encryptPlaintext.Update, []byte("api is red")) || bytes.Contains(encryptPlaintext.Update, []byte("Build failed")) {
+			mt.Fatalf("expected the plaintext notification to be encrypted, got %s", encryptPlaintext.Update)
+		}
+		if keyId, _ := encryptPlaintext.Update.Lookup("$set", "encryption", "keyId").StringValueOK(); keyId != "k2" {
+			mt.Fatalf("expected the plaintext notification to be encrypted under k2, got %s", encryptPlaintext.Update)
+		}
+	})
+
+	mt.Run("requires keys", func(mt *mtest.T) {
+		utils.FieldKeys = nil
+		if _, err := NewNotificationRepositoryImpl(mt.DB).RotateKeys(); err == nil {
+			mt.Fatal("expected the rotation to fail without keys")
+		}
+	})
+}
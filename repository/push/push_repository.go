@@ -0,0 +1,17 @@
+package pushRepository
+
+import (
+	"r2-notify-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PushRepository interface {
+	ForTenant(tenantId string) PushRepository
+	FindSubscriptions(userId string) ([]models.PushSubscription, error)
+	SaveSubscription(subscription models.PushSubscription) error
+	DeleteSubscription(userId string, endpoint string) error
+	DeleteSubscriptionById(id primitive.ObjectID) error
+	FindVapidKeys() (models.VapidKeys, error)
+	CreateVapidKeys(keys models.VapidKeys) (models.VapidKeys, error)
+}
@@ -0,0 +1,206 @@
+package pushRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoVapidKeys is returned by FindVapidKeys when no key pair has been stored yet.
+var ErrNoVapidKeys = errors.New("no vapid keys stored")
+
+// vapidKeysId is the id of the single VAPID key pair document shared by every replica.
+const vapidKeysId = "vapid"
+
+type PushRepositoryImpl struct {
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
+}
+
+// NewPushRepositoryImpl returns a new instance of PushRepositoryImpl, scoped to the default tenant.
+// Browser push subscriptions are stored in the "push_subscriptions" collection and
+// the VAPID key pair generated by the server, shared by every tenant, is stored in the "vapid_keys" collection.
+func NewPushRepositoryImpl(Db *mongo.Database) PushRepository {
+	return &PushRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the push subscriptions of the given tenant. Every filter of the
+// returned repository is restricted to the tenant, and every subscription it saves belongs to the tenant.
+func (t PushRepositoryImpl) ForTenant(tenantId string) PushRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &PushRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the push subscriptions of the tenant of the repository.
+func (t PushRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
+}
+
+// FindSubscriptions returns every push subscription registered by the given user of the tenant.
+func (t PushRepositoryImpl) FindSubscriptions(userId string) (subscriptions []models.PushSubscription, err error) {
+	cursor, err := t.Db.Collection("push_subscriptions").Find(context.Background(), t.scope(bson.M{"userId": userId}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "FindSubscriptions",
+			Message:   "Failed to fetch push subscriptions for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &subscriptions); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "FindSubscriptions",
+			Message:   "Failed to decode push subscriptions for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// SaveSubscription inserts or refreshes a push subscription of the tenant keyed by its endpoint. When the
+// subscription carries a deviceId, older subscriptions of the same user and device are removed,
+// since a browser only keeps one active subscription per service worker.
+func (t *PushRepositoryImpl) SaveSubscription(subscription models.PushSubscription) error {
+	now := time.Now()
+	filter := t.scope(bson.M{"endpoint": subscription.Endpoint})
+	update := bson.M{
+		"$set": bson.M{
+			"userId":    subscription.UserId,
+			"deviceId":  subscription.DeviceId,
+			"p256dh":    subscription.P256dh,
+			"auth":      subscription.Auth,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{"tenantId": t.TenantId, "createdAt": now},
+	}
+	_, err := t.Db.Collection("push_subscriptions").UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "SaveSubscription",
+			Message:   "Failed to save push subscription for userId: " + subscription.UserId,
+			Error:     err,
+			UserId:    subscription.UserId,
+		})
+		return err
+	}
+	if subscription.DeviceId != "" {
+		stale := t.scope(bson.M{
+			"userId":   subscription.UserId,
+			"deviceId": subscription.DeviceId,
+			"endpoint": bson.M{"$ne": subscription.Endpoint},
+		})
+		if _, err := t.Db.Collection("push_subscriptions").DeleteMany(context.Background(), stale); err != nil {
+			logger.Log.Warn(logger.LogPayload{
+				Component: "Push Repository",
+				Operation: "SaveSubscription",
+				Message:   "Failed to remove stale push subscriptions for userId: " + subscription.UserId + ", deviceId: " + subscription.DeviceId,
+				Error:     err,
+				UserId:    subscription.UserId,
+			})
+		}
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Push Repository",
+		Operation: "SaveSubscription",
+		Message:   "Saved push subscription for userId: " + subscription.UserId,
+		UserId:    subscription.UserId,
+	})
+	return nil
+}
+
+// DeleteSubscription removes the push subscription of the given user of the tenant with the given endpoint.
+// It returns an error if no matching subscription exists.
+func (t *PushRepositoryImpl) DeleteSubscription(userId string, endpoint string) error {
+	result, err := t.Db.Collection("push_subscriptions").DeleteOne(context.Background(), t.scope(bson.M{"userId": userId, "endpoint": endpoint}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "DeleteSubscription",
+			Message:   "Failed to delete push subscription for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("push subscription not found")
+	}
+	return nil
+}
+
+// DeleteSubscriptionById removes a push subscription of the tenant by its ID. It is used to prune
+// subscriptions that the push service reports as expired.
+func (t *PushRepositoryImpl) DeleteSubscriptionById(id primitive.ObjectID) error {
+	_, err := t.Db.Collection("push_subscriptions").DeleteOne(context.Background(), t.scope(bson.M{"_id": id}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "DeleteSubscriptionById",
+			Message:   "Failed to delete push subscription: " + id.Hex(),
+			Error:     err,
+		})
+		return err
+	}
+	return nil
+}
+
+// FindVapidKeys returns the stored VAPID key pair, or ErrNoVapidKeys if none was stored yet.
+func (t PushRepositoryImpl) FindVapidKeys() (keys models.VapidKeys, err error) {
+	err = t.root.Collection("vapid_keys").FindOne(context.Background(), bson.M{"_id": vapidKeysId}).Decode(&keys)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.VapidKeys{}, ErrNoVapidKeys
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "FindVapidKeys",
+			Message:   "Failed to fetch vapid keys",
+			Error:     err,
+		})
+		return models.VapidKeys{}, err
+	}
+	return keys, nil
+}
+
+// CreateVapidKeys stores the given VAPID key pair unless another replica stored one first,
+// in which case the stored key pair is returned instead so that every replica signs with
+// the same keys.
+func (t *PushRepositoryImpl) CreateVapidKeys(keys models.VapidKeys) (models.VapidKeys, error) {
+	keys.Id = vapidKeysId
+	_, err := t.root.Collection("vapid_keys").InsertOne(context.Background(), keys)
+	if mongo.IsDuplicateKeyError(err) {
+		return t.FindVapidKeys()
+	}
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Repository",
+			Operation: "CreateVapidKeys",
+			Message:   "Failed to store vapid keys",
+			Error:     err,
+		})
+		return models.VapidKeys{}, err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Push Repository",
+		Operation: "CreateVapidKeys",
+		Message:   "Stored newly generated vapid keys",
+	})
+	return keys, nil
+}
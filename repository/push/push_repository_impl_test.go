@@ -0,0 +1,54 @@
+package pushRepository
+
+import (
+	"os"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestSubscriptionsAreScopedToTheTenant(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("every filter carries the tenant", func(mt *mtest.T) {
+		repository := NewPushRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".push_subscriptions", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		if _, err := repository.FindSubscriptions("u1"); err != nil {
+			mt.Fatal(err)
+		}
+		if err := repository.SaveSubscription(models.PushSubscription{UserId: "u1", DeviceId: "laptop", Endpoint: "https://push.example/1"}); err != nil {
+			mt.Fatal(err)
+		}
+		if err := repository.DeleteSubscription("u1", "https://push.example/1"); err != nil {
+			mt.Fatal(err)
+		}
+		if err := repository.DeleteSubscriptionById(primitive.NewObjectID()); err != nil {
+			mt.Fatal(err)
+		}
+
+		commands := mongotest.SentCommands(mt)
+		if len(commands) != 5 {
+			mt.Fatalf("expected 5 commands, got %d", len(commands))
+		}
+		mongotest.ExpectTenant(mt, commands, "tenant-b")
+	})
+}
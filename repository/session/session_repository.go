@@ -0,0 +1,15 @@
+package sessionRepository
+
+import (
+	"r2-notify-server/models"
+	"time"
+)
+
+type SessionRepository interface {
+	EnsureIndexes() error
+	CreateRefreshToken(token models.RefreshToken) error
+	FindRefreshToken(tokenHash string) (models.RefreshToken, error)
+	RotateRefreshToken(tokenHash string, rotatedAt time.Time) (models.RefreshToken, error)
+	DeleteSession(sessionId string) error
+	DeleteUserSessions(tenantId string, userId string) error
+}
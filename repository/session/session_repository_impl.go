@@ -0,0 +1,146 @@
+package sessionRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned for refresh tokens that were never issued or whose session ended.
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenReused is returned by RotateRefreshToken for a token that was already rotated.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+type SessionRepositoryImpl struct {
+	Db *mongo.Database
+}
+
+// NewSessionRepositoryImpl returns a new instance of SessionRepositoryImpl.
+// The hashed refresh tokens of login sessions are stored in the "refresh_tokens" collection,
+// the tokens rotated out of a session being kept until it ends to detect their reuse.
+func NewSessionRepositoryImpl(Db *mongo.Database) SessionRepository {
+	return &SessionRepositoryImpl{Db: Db}
+}
+
+// EnsureIndexes creates the indexes of the "refresh_tokens" collection: a unique index on the token
+// hash, and a TTL index on the expiry which removes the tokens of expired sessions.
+func (t *SessionRepositoryImpl) EnsureIndexes() error {
+	_, err := t.Db.Collection("refresh_tokens").Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "EnsureIndexes",
+			Message:   "Failed to create indexes of refresh tokens",
+			Error:     err,
+		})
+		return err
+	}
+	return nil
+}
+
+// CreateRefreshToken stores a new refresh token.
+func (t *SessionRepositoryImpl) CreateRefreshToken(token models.RefreshToken) error {
+	token.CreatedAt = time.Now()
+	_, err := t.Db.Collection("refresh_tokens").InsertOne(context.Background(), token)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "CreateRefreshToken",
+			Message:   "Failed to store refresh token of session: " + token.SessionId,
+			Error:     err,
+			UserId:    token.UserId,
+		})
+		return err
+	}
+	return nil
+}
+
+// FindRefreshToken retrieves an unexpired refresh token by its hash, whether it was rotated or not.
+func (t SessionRepositoryImpl) FindRefreshToken(tokenHash string) (token models.RefreshToken, err error) {
+	filter := bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err = t.Db.Collection("refresh_tokens").FindOne(context.Background(), filter).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.RefreshToken{}, ErrRefreshTokenNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "FindRefreshToken",
+			Message:   "Failed to fetch refresh token",
+			Error:     err,
+		})
+		return models.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks an unexpired refresh token as rotated and returns it. Only one of
+// concurrent rotations of a token succeeds, the others get ErrRefreshTokenReused along with the token.
+func (t *SessionRepositoryImpl) RotateRefreshToken(tokenHash string, rotatedAt time.Time) (models.RefreshToken, error) {
+	filter := bson.M{"tokenHash": tokenHash, "rotatedAt": nil, "expiresAt": bson.M{"$gt": rotatedAt}}
+	update := bson.M{"$set": bson.M{"rotatedAt": rotatedAt}}
+	var token models.RefreshToken
+	err := t.Db.Collection("refresh_tokens").FindOneAndUpdate(context.Background(), filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&token)
+	if err == nil {
+		return token, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "RotateRefreshToken",
+			Message:   "Failed to rotate refresh token",
+			Error:     err,
+		})
+		return models.RefreshToken{}, err
+	}
+
+	token, err = t.FindRefreshToken(tokenHash)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	return token, ErrRefreshTokenReused
+}
+
+// DeleteSession deletes every refresh token of a session.
+func (t *SessionRepositoryImpl) DeleteSession(sessionId string) error {
+	_, err := t.Db.Collection("refresh_tokens").DeleteMany(context.Background(), bson.M{"sessionId": sessionId})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "DeleteSession",
+			Message:   "Failed to delete refresh tokens of session: " + sessionId,
+			Error:     err,
+		})
+		return err
+	}
+	return nil
+}
+
+// DeleteUserSessions deletes the refresh tokens of every session of a user of a tenant.
+func (t *SessionRepositoryImpl) DeleteUserSessions(tenantId string, userId string) error {
+	filter := bson.M{"userId": userId, "tenantId": config.TenantFilter(tenantId)}
+	_, err := t.Db.Collection("refresh_tokens").DeleteMany(context.Background(), filter)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Repository",
+			Operation: "DeleteUserSessions",
+			Message:   "Failed to delete refresh tokens of userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	return nil
+}
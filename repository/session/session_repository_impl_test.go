@@ -0,0 +1,55 @@
+package sessionRepository
+
+import (
+	"os"
+	"r2-notify-server/logger"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestEnsureIndexesCreatesTheRefreshTokenIndexes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("unique token hash and expiry TTL", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if err := NewSessionRepositoryImpl(mt.DB).EnsureIndexes(); err != nil {
+			mt.Fatal(err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if collection, _ := command.Lookup("createIndexes").StringValueOK(); collection != "refresh_tokens" {
+			mt.Fatalf("expected the indexes to be created on refresh_tokens, got %s", command)
+		}
+		var indexes struct {
+			Indexes []bson.M `bson:"indexes"`
+		}
+		if err := bson.Unmarshal(command, &indexes); err != nil {
+			mt.Fatal(err)
+		}
+		if len(indexes.Indexes) != 2 {
+			mt.Fatalf("expected 2 indexes, got %s", command)
+		}
+		hash, expiry := indexes.Indexes[0], indexes.Indexes[1]
+		if hash["key"].(bson.M)["tokenHash"] != int32(1) || hash["unique"] != true {
+			mt.Fatalf("expected a unique index on tokenHash, got %v", hash)
+		}
+		if expiry["key"].(bson.M)["expiresAt"] != int32(1) || expiry["expireAfterSeconds"] != int32(0) {
+			mt.Fatalf("expected a TTL index on expiresAt, got %v", expiry)
+		}
+	})
+
+	mt.Run("failure", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 85, Message: "IndexOptionsConflict"}))
+		if err := NewSessionRepositoryImpl(mt.DB).EnsureIndexes(); err == nil {
+			mt.Fatal("expected the error of the index creation")
+		}
+	})
+}
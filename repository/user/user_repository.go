@@ -0,0 +1,10 @@
+package userRepository
+
+import "r2-notify-server/models"
+
+type UserRepository interface {
+	ForTenant(tenantId string) UserRepository
+	FindAll() ([]models.User, error)
+	FindById(userId string) (models.User, error)
+	Save(user models.User) error
+}
@@ -0,0 +1,111 @@
+package userRepository
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrUserNotFound is returned when no roles were granted to the given user.
+var ErrUserNotFound = errors.New("user not found")
+
+type UserRepositoryImpl struct {
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
+}
+
+// NewUserRepositoryImpl returns a new instance of UserRepositoryImpl, scoped to the default tenant.
+// The roles granted to users are stored in the "users" collection, keyed by tenant and user ID.
+func NewUserRepositoryImpl(Db *mongo.Database) UserRepository {
+	return &UserRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the users of the given tenant. Every filter of the returned
+// repository is restricted to the tenant, and every user it saves belongs to the tenant.
+func (t UserRepositoryImpl) ForTenant(tenantId string) UserRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &UserRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the users of the tenant of the repository.
+func (t UserRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
+}
+
+// FindAll returns every user of the tenant with granted roles, ordered by user ID.
+func (t *UserRepositoryImpl) FindAll() (users []models.User, err error) {
+	cursor, err := t.Db.Collection("users").Find(context.Background(), t.scope(bson.M{}), options.Find().SetSort(bson.M{"userId": 1}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "User Repository",
+			Operation: "FindAll",
+			Message:   "Failed to fetch users of tenant: " + t.TenantId,
+			Error:     err,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &users); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "User Repository",
+			Operation: "FindAll",
+			Message:   "Failed to decode users of tenant: " + t.TenantId,
+			Error:     err,
+		})
+		return nil, err
+	}
+	return users, nil
+}
+
+// FindById returns the roles granted to the given user of the tenant.
+func (t *UserRepositoryImpl) FindById(userId string) (user models.User, err error) {
+	err = t.Db.Collection("users").FindOne(context.Background(), t.scope(bson.M{"userId": userId})).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.User{}, ErrUserNotFound
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "User Repository",
+			Operation: "FindById",
+			Message:   "Failed to fetch user: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// Save replaces the roles and apps of a user of the tenant.
+func (t *UserRepositoryImpl) Save(user models.User) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"roles":     user.Roles,
+			"apps":      user.Apps,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{"tenantId": t.TenantId, "createdAt": now},
+	}
+	_, err := t.Db.Collection("users").UpdateOne(context.Background(), t.scope(bson.M{"userId": user.UserId}), update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "User Repository",
+			Operation: "Save",
+			Message:   "Failed to save user: " + user.UserId,
+			Error:     err,
+			UserId:    user.UserId,
+		})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package webhookRepository
+
+import (
+	"r2-notify-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookRepository interface {
+	ForTenant(tenantId string) WebhookRepository
+	FindSubscriptions(appId string) ([]models.WebhookSubscription, error)
+	FindSubscriptionById(id primitive.ObjectID) (models.WebhookSubscription, error)
+	FindActiveSubscriptions(appId string, event string) ([]models.WebhookSubscription, error)
+	HasActiveSubscriptions(appId string, event string) (bool, error)
+	CreateSubscription(subscription models.WebhookSubscription) (primitive.ObjectID, error)
+	DeleteSubscription(appId string, id primitive.ObjectID) error
+	FindDeliveries(appId string, status string) ([]models.WebhookDelivery, error)
+	FindDeliveryById(appId string, id primitive.ObjectID) (models.WebhookDelivery, error)
+	CreateDelivery(delivery models.WebhookDelivery) (primitive.ObjectID, error)
+	ClaimDueDelivery(now time.Time, leaseUntil time.Time) (models.WebhookDelivery, error)
+	UpdateDelivery(delivery models.WebhookDelivery) error
+	RotateKeys() (int, error)
+}
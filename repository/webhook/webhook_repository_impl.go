@@ -0,0 +1,409 @@
+package webhookRepository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/encryption"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoDueDelivery is returned by ClaimDueDelivery when no delivery is waiting to be attempted.
+var ErrNoDueDelivery = errors.New("no due webhook delivery")
+
+// deliveryListLimit caps the number of deliveries returned by FindDeliveries.
+const deliveryListLimit = 100
+
+type WebhookRepositoryImpl struct {
+	Db       *mongo.Database
+	TenantId string
+	root     *mongo.Database
+}
+
+// NewWebhookRepositoryImpl returns a new instance of WebhookRepositoryImpl, scoped to the default tenant.
+// Subscriptions are stored in the "webhook_subscriptions" collection and every
+// delivery attempt is logged in the "webhook_deliveries" collection.
+func NewWebhookRepositoryImpl(Db *mongo.Database) WebhookRepository {
+	return &WebhookRepositoryImpl{Db: Db, TenantId: config.LoadConfig().DefaultTenant, root: Db}
+}
+
+// ForTenant returns the repository of the webhooks of the given tenant. Every filter of the returned
+// repository is restricted to the tenant, and every subscription and delivery it creates belongs to the tenant.
+func (t WebhookRepositoryImpl) ForTenant(tenantId string) WebhookRepository {
+	tenantId = config.ResolveTenant(tenantId)
+	return &WebhookRepositoryImpl{Db: config.TenantDatabase(t.root, tenantId), TenantId: tenantId, root: t.root}
+}
+
+// scope restricts a filter to the documents of the tenant of the repository.
+func (t WebhookRepositoryImpl) scope(filter bson.M) bson.M {
+	filter["tenantId"] = config.TenantFilter(t.TenantId)
+	return filter
+}
+
+// FindSubscriptions returns every webhook subscription registered for the given appId.
+func (t WebhookRepositoryImpl) FindSubscriptions(appId string) (subscriptions []models.WebhookSubscription, err error) {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "FindSubscriptions",
+		Message:   "Fetching webhook subscriptions for appId: " + appId,
+		AppId:     appId,
+	})
+	cursor, err := t.Db.Collection("webhook_subscriptions").Find(context.Background(), t.scope(bson.M{"appId": appId}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindSubscriptions",
+			Message:   "Failed to fetch webhook subscriptions for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &subscriptions); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindSubscriptions",
+			Message:   "Failed to decode webhook subscriptions for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// FindSubscriptionById retrieves a single webhook subscription by its ID.
+func (t WebhookRepositoryImpl) FindSubscriptionById(id primitive.ObjectID) (subscription models.WebhookSubscription, err error) {
+	err = t.Db.Collection("webhook_subscriptions").FindOne(context.Background(), t.scope(bson.M{"_id": id})).Decode(&subscription)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = errors.New("webhook subscription not found")
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindSubscriptionById",
+			Message:   "Failed to fetch webhook subscription: " + id.Hex(),
+			Error:     err,
+		})
+		return models.WebhookSubscription{}, err
+	}
+	return subscription, nil
+}
+
+// FindActiveSubscriptions returns the active subscriptions of the given appId
+// that are subscribed to the given lifecycle event.
+func (t WebhookRepositoryImpl) FindActiveSubscriptions(appId string, event string) (subscriptions []models.WebhookSubscription, err error) {
+	cursor, err := t.Db.Collection("webhook_subscriptions").Find(context.Background(), t.scope(bson.M{"appId": appId, "events": event, "active": true}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindActiveSubscriptions",
+			Message:   "Failed to fetch active webhook subscriptions for appId: " + appId + ", event: " + event,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &subscriptions); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindActiveSubscriptions",
+			Message:   "Failed to decode active webhook subscriptions for appId: " + appId + ", event: " + event,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// HasActiveSubscriptions reports whether an active subscription of the given appId, or of any
+// application when appId is empty, is subscribed to the given lifecycle event.
+func (t WebhookRepositoryImpl) HasActiveSubscriptions(appId string, event string) (bool, error) {
+	filter := bson.M{"events": event, "active": true}
+	if appId != "" {
+		filter["appId"] = appId
+	}
+	count, err := t.Db.Collection("webhook_subscriptions").CountDocuments(context.Background(), t.scope(filter), options.Count().SetLimit(1))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "HasActiveSubscriptions",
+			Message:   "Failed to count active webhook subscriptions for appId: " + appId + ", event: " + event,
+			Error:     err,
+			AppId:     appId,
+		})
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateSubscription inserts a new webhook subscription and returns its ObjectID.
+func (t *WebhookRepositoryImpl) CreateSubscription(subscription models.WebhookSubscription) (primitive.ObjectID, error) {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "CreateSubscription",
+		Message:   "Creating webhook subscription for appId: " + subscription.AppId,
+		AppId:     subscription.AppId,
+	})
+	subscription.TenantId = t.TenantId
+	result, err := t.Db.Collection("webhook_subscriptions").InsertOne(context.Background(), subscription)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "CreateSubscription",
+			Message:   "Failed to create webhook subscription for appId: " + subscription.AppId,
+			Error:     err,
+			AppId:     subscription.AppId,
+		})
+		return primitive.NilObjectID, err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		convertErr := errors.New("failed to convert inserted ID to ObjectID")
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "CreateSubscription",
+			Message:   "Failed to convert inserted ID for appId: " + subscription.AppId,
+			Error:     convertErr,
+			AppId:     subscription.AppId,
+		})
+		return primitive.NilObjectID, convertErr
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "CreateSubscription",
+		Message:   "Successfully created webhook subscription for appId: " + subscription.AppId,
+		AppId:     subscription.AppId,
+	})
+	return id, nil
+}
+
+// DeleteSubscription removes a webhook subscription owned by the given appId.
+// It returns an error if no matching subscription exists.
+func (t *WebhookRepositoryImpl) DeleteSubscription(appId string, id primitive.ObjectID) error {
+	result, err := t.Db.Collection("webhook_subscriptions").DeleteOne(context.Background(), t.scope(bson.M{"_id": id, "appId": appId}))
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "DeleteSubscription",
+			Message:   "Failed to delete webhook subscription for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return err
+	}
+	if result.DeletedCount == 0 {
+		notFoundErr := errors.New("webhook subscription not found")
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "DeleteSubscription",
+			Message:   "No webhook subscription found to delete for appId: " + appId,
+			Error:     notFoundErr,
+			AppId:     appId,
+		})
+		return notFoundErr
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "DeleteSubscription",
+		Message:   "Successfully deleted webhook subscription for appId: " + appId,
+		AppId:     appId,
+	})
+	return nil
+}
+
+// FindDeliveries returns the most recent deliveries for the given appId, newest first.
+// If status is not empty only deliveries in that status are returned.
+func (t WebhookRepositoryImpl) FindDeliveries(appId string, status string) (deliveries []models.WebhookDelivery, err error) {
+	filter := t.scope(bson.M{"appId": appId})
+	if status != "" {
+		filter["status"] = status
+	}
+	findOptions := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(deliveryListLimit)
+	cursor, err := t.Db.Collection("webhook_deliveries").Find(context.Background(), filter, findOptions)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindDeliveries",
+			Message:   "Failed to fetch webhook deliveries for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &deliveries); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindDeliveries",
+			Message:   "Failed to decode webhook deliveries for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return nil, err
+	}
+	for i := range deliveries {
+		if err := decryptDelivery(&deliveries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
+
+// FindDeliveryById retrieves a single delivery owned by the given appId.
+func (t WebhookRepositoryImpl) FindDeliveryById(appId string, id primitive.ObjectID) (delivery models.WebhookDelivery, err error) {
+	err = t.Db.Collection("webhook_deliveries").FindOne(context.Background(), t.scope(bson.M{"_id": id, "appId": appId})).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = errors.New("webhook delivery not found")
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "FindDeliveryById",
+			Message:   "Failed to fetch webhook delivery for appId: " + appId,
+			Error:     err,
+			AppId:     appId,
+		})
+		return models.WebhookDelivery{}, err
+	}
+	if err := decryptDelivery(&delivery); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// CreateDelivery inserts a new delivery log entry and returns its ObjectID. The payload is stored
+// encrypted when field encryption is enabled.
+func (t *WebhookRepositoryImpl) CreateDelivery(delivery models.WebhookDelivery) (primitive.ObjectID, error) {
+	delivery.TenantId = t.TenantId
+	var err error
+	if delivery.Encryption, err = encryption.Encrypt(map[string]*string{"payload": &delivery.Payload}); err != nil {
+		return primitive.NilObjectID, err
+	}
+	result, err := t.Db.Collection("webhook_deliveries").InsertOne(context.Background(), delivery)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "CreateDelivery",
+			Message:   "Failed to create webhook delivery for appId: " + delivery.AppId + ", event: " + delivery.Event,
+			Error:     err,
+			AppId:     delivery.AppId,
+		})
+		return primitive.NilObjectID, err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		convertErr := errors.New("failed to convert inserted ID to ObjectID")
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "CreateDelivery",
+			Message:   "Failed to convert inserted ID for appId: " + delivery.AppId,
+			Error:     convertErr,
+			AppId:     delivery.AppId,
+		})
+		return primitive.NilObjectID, convertErr
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "CreateDelivery",
+		Message:   "Queued webhook delivery for appId: " + delivery.AppId + ", event: " + delivery.Event,
+		AppId:     delivery.AppId,
+	})
+	return id, nil
+}
+
+// ClaimDueDelivery atomically picks the oldest pending delivery of the tenant whose next attempt is due
+// and pushes its nextAttemptAt to leaseUntil, so that other replicas skip it while it is
+// being attempted. If the process dies mid-attempt the delivery becomes due again once the
+// lease expires. ErrNoDueDelivery is returned when nothing is due.
+func (t *WebhookRepositoryImpl) ClaimDueDelivery(now time.Time, leaseUntil time.Time) (delivery models.WebhookDelivery, err error) {
+	filter := t.scope(bson.M{
+		"status":        data.WEBHOOK_DELIVERY_PENDING,
+		"nextAttemptAt": bson.M{"$lte": now},
+	})
+	update := bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}}
+	findOptions := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After)
+	err = t.Db.Collection("webhook_deliveries").FindOneAndUpdate(context.Background(), filter, update, findOptions).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.WebhookDelivery{}, ErrNoDueDelivery
+		}
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "ClaimDueDelivery",
+			Message:   "Failed to claim due webhook delivery",
+			Error:     err,
+		})
+		return models.WebhookDelivery{}, err
+	}
+	if err := decryptDelivery(&delivery); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// UpdateDelivery stores the outcome of a delivery attempt.
+func (t *WebhookRepositoryImpl) UpdateDelivery(delivery models.WebhookDelivery) error {
+	update := bson.M{"$set": bson.M{
+		"status":        delivery.Status,
+		"attempts":      delivery.Attempts,
+		"responseCode":  delivery.ResponseCode,
+		"lastError":     delivery.LastError,
+		"nextAttemptAt": delivery.NextAttemptAt,
+		"updatedAt":     delivery.UpdatedAt,
+	}}
+	result, err := t.Db.Collection("webhook_deliveries").UpdateOne(context.Background(), t.scope(bson.M{"_id": delivery.Id}), update)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "UpdateDelivery",
+			Message:   "Failed to update webhook delivery: " + delivery.Id.Hex(),
+			Error:     err,
+			AppId:     delivery.AppId,
+		})
+		return err
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Webhook Repository",
+		Operation: "UpdateDelivery",
+		Message:   "Updated webhook delivery: " + delivery.Id.Hex() + " | Status: " + delivery.Status + " Matched: " + fmt.Sprintf("%d", result.MatchedCount),
+		AppId:     delivery.AppId,
+	})
+	return nil
+}
+
+// RotateKeys moves the deliveries of the tenant to the active key-encryption key, and encrypts the
+// payloads stored in plaintext. It returns the number of deliveries rotated.
+func (t *WebhookRepositoryImpl) RotateKeys() (int, error) {
+	rotated, err := encryption.RotateKeys(t.Db.Collection("webhook_deliveries"), t.scope, "payload")
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Webhook Repository",
+			Operation: "RotateKeys",
+			Message:   fmt.Sprintf("Failed to rotate the keys of webhook deliveries after %d", rotated),
+			Error:     err,
+		})
+	}
+	return rotated, err
+}
+
+// decryptDelivery restores the payload of an encrypted delivery.
+func decryptDelivery(delivery *models.WebhookDelivery) error {
+	if err := encryption.Decrypt(delivery.Encryption, map[string]*string{"payload": &delivery.Payload}); err != nil {
+		return fmt.Errorf("webhook delivery %s: %w", delivery.Id.Hex(), err)
+	}
+	delivery.Encryption = nil
+	return nil
+}
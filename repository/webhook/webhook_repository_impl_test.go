@@ -0,0 +1,157 @@
+package webhookRepository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	"r2-notify-server/repository/mongotest"
+	"r2-notify-server/utils"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+func TestWebhooksAreScopedToTheTenant(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-a,tenant-b")
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+
+	mt.Run("reads", func(mt *mtest.T) {
+		repository := NewWebhookRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		subscriptions := mt.DB.Name() + ".webhook_subscriptions"
+		deliveries := mt.DB.Name() + ".webhook_deliveries"
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, subscriptions, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, subscriptions, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "tenantId", Value: "tenant-b"}}),
+			mtest.CreateCursorResponse(0, subscriptions, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, subscriptions, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+			mtest.CreateCursorResponse(0, deliveries, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, deliveries, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "tenantId", Value: "tenant-b"}}),
+		)
+
+		if _, err := repository.FindSubscriptions("app-a"); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.FindSubscriptionById(id); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.FindActiveSubscriptions("app-a", data.WEBHOOK_EVENT_CREATED); err != nil {
+			mt.Fatal(err)
+		}
+		if subscribed, err := repository.HasActiveSubscriptions("", data.WEBHOOK_EVENT_READ); err != nil || !subscribed {
+			mt.Fatalf("expected an active subscription, got %v, %v", subscribed, err)
+		}
+		if _, err := repository.FindDeliveries("app-a", ""); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.FindDeliveryById("app-a", id); err != nil {
+			mt.Fatal(err)
+		}
+		mongotest.ExpectTenant(mt, mongotest.SentCommands(mt), "tenant-b")
+	})
+
+	mt.Run("writes", func(mt *mtest.T) {
+		repository := NewWebhookRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: id}, {Key: "tenantId", Value: "tenant-b"}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		// A subscription or delivery claiming another tenant is stored in the tenant of the repository.
+		if _, err := repository.CreateSubscription(models.WebhookSubscription{TenantId: "tenant-a", AppId: "app-a"}); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.CreateDelivery(models.WebhookDelivery{TenantId: "tenant-a", AppId: "app-a"}); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(time.Minute)); err != nil {
+			mt.Fatal(err)
+		}
+		if err := repository.UpdateDelivery(models.WebhookDelivery{Id: id, Status: data.WEBHOOK_DELIVERY_SUCCEEDED}); err != nil {
+			mt.Fatal(err)
+		}
+		mongotest.ExpectTenant(mt, mongotest.SentCommands(mt), "tenant-b")
+	})
+
+	mt.Run("deletes", func(mt *mtest.T) {
+		repository := NewWebhookRepositoryImpl(mt.DB).ForTenant("tenant-b")
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+
+		// The subscription of another tenant is not matched, so it is reported as not found.
+		if err := repository.DeleteSubscription("app-a", id); err == nil {
+			mt.Fatal("expected the subscription of another tenant not to be found")
+		}
+		mongotest.ExpectTenant(mt, mongotest.SentCommands(mt), "tenant-b")
+	})
+}
+
+func TestStoredDeliveriesHoldOnlyCiphertext(t *testing.T) {
+	keys, err := utils.ParseFieldKeys("k1="+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.FieldKeys = keys
+	t.Cleanup(func() { utils.FieldKeys = nil })
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	payload := `{"event":"notification.created","data":{"message":"main is red"}}`
+
+	mt.Run("create and read", func(mt *mtest.T) {
+		repository := NewWebhookRepositoryImpl(mt.DB)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if _, err := repository.CreateDelivery(models.WebhookDelivery{AppId: "app-a", Payload: payload}); err != nil {
+			mt.Fatal(err)
+		}
+		raw := mongotest.SentCommands(mt)[0].Document
+		if bytes.Contains(raw, []byte("main is red")) {
+			mt.Fatalf("expected the stored delivery to hold no plaintext, got %s", raw)
+		}
+		if keyId, _ := raw.Lookup("encryption", "keyId").StringValueOK(); keyId != "k1" {
+			mt.Fatalf("expected the data key to be wrapped by k1, got %s", raw)
+		}
+
+		// The stored document is decrypted by every read
+		id := primitive.NewObjectID()
+		var stored bson.D
+		if err := bson.Unmarshal(raw, &stored); err != nil {
+			mt.Fatal(err)
+		}
+		stored = append(bson.D{{Key: "_id", Value: id}}, stored...)
+		deliveries := mt.DB.Name() + ".webhook_deliveries"
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, deliveries, mtest.FirstBatch, stored),
+			mtest.CreateCursorResponse(0, deliveries, mtest.FirstBatch, stored),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: stored}),
+		)
+		found, err := repository.FindDeliveries("app-a", "")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		delivery, err := repository.FindDeliveryById("app-a", id)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		claimed, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(time.Minute))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		for _, read := range []models.WebhookDelivery{found[0], delivery, claimed} {
+			if read.Payload != payload || read.Encryption != nil {
+				mt.Fatalf("expected the delivery to be decrypted, got %+v", read)
+			}
+		}
+	})
+}
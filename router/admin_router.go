@@ -0,0 +1,31 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/data"
+	"r2-notify-server/middleware"
+	auditService "r2-notify-server/services/audit"
+	userService "r2-notify-server/services/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAdminRoutes(r *gin.Engine, adminController *controller.AdminController, userService userService.UserService, auditService auditService.AuditService) {
+	adminRoute := r.Group("/admin", middleware.AuthenticationMiddleware(), middleware.AuditMiddleware(auditService))
+
+	adminRoute.GET("apps", middleware.RoleMiddleware(userService, data.ROLE_APP_ADMIN, data.ROLE_SUPPORT), adminController.ListApps)
+	adminRoute.PUT("apps/:appId", middleware.RoleMiddleware(userService, data.ROLE_APP_ADMIN), adminController.SaveApp)
+	adminRoute.DELETE("apps/:appId", middleware.RoleMiddleware(userService, data.ROLE_SUPER_ADMIN), adminController.DeleteApp)
+
+	adminRoute.GET("users", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.ListUsers)
+	adminRoute.GET("users/:userId", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.GetUser)
+	adminRoute.PUT("users/:userId", middleware.RoleMiddleware(userService, data.ROLE_SUPER_ADMIN), adminController.SaveUser)
+	adminRoute.GET("users/:userId/notifications", middleware.RoleMiddleware(userService, data.ROLE_APP_ADMIN, data.ROLE_SUPPORT), adminController.GetUserNotifications)
+	adminRoute.GET("users/:userId/configuration", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.GetUserConfiguration)
+	adminRoute.GET("users/:userId/sessions", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.GetUserSessions)
+	adminRoute.DELETE("users/:userId/sessions", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.RevokeUserSessions)
+
+	adminRoute.GET("sessions", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.ListSessions)
+
+	adminRoute.GET("audit-events", middleware.RoleMiddleware(userService, data.ROLE_SUPPORT), adminController.ListAuditEvents)
+}
@@ -2,11 +2,17 @@ package router
 
 import (
 	"r2-notify-server/controller"
+	"r2-notify-server/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterAuthenticationRoutes(r *gin.Engine, authController *controller.AuthenticationController) {
 	notificationRoute := r.Group("/auth")
-	notificationRoute.POST("google", authController.GoogleAuthHandler)
+	notificationRoute.POST("refresh", authController.RefreshHandler)
+	notificationRoute.POST("logout", authController.LogoutHandler)
+	notificationRoute.POST(":provider", authController.AuthHandler)
+
+	ticketRoute := r.Group("/ws", middleware.AuthenticationMiddleware())
+	ticketRoute.POST("ticket", authController.TicketHandler)
 }
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/data"
+	"r2-notify-server/middleware"
+	userService "r2-notify-server/services/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterChatTargetRoutes(r *gin.Engine, chatTargetController *controller.ChatTargetController, userService userService.UserService) {
+	chatTargetRoute := r.Group("/chat-targets", middleware.AuthenticationMiddleware(), middleware.RoleMiddleware(userService, data.ROLE_APP_ADMIN))
+	chatTargetRoute.GET("", chatTargetController.ListChatTargets)
+	chatTargetRoute.PUT("", chatTargetController.SetChatTargets)
+}
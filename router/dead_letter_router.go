@@ -0,0 +1,19 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/data"
+	"r2-notify-server/middleware"
+	auditService "r2-notify-server/services/audit"
+	userService "r2-notify-server/services/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterDeadLetterRoutes(r *gin.Engine, deadLetterController *controller.DeadLetterController, userService userService.UserService, auditService auditService.AuditService) {
+	deadLetterRoute := r.Group("/admin/dead-letters", middleware.AuthenticationMiddleware(), middleware.AuditMiddleware(auditService), middleware.RoleMiddleware(userService, data.ROLE_SUPER_ADMIN))
+	deadLetterRoute.GET("", deadLetterController.ListDeadLetters)
+	deadLetterRoute.GET(":id", deadLetterController.GetDeadLetter)
+	deadLetterRoute.POST(":id/replay", deadLetterController.ReplayDeadLetter)
+	deadLetterRoute.DELETE(":id", deadLetterController.DiscardDeadLetter)
+}
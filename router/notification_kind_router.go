@@ -0,0 +1,18 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/data"
+	"r2-notify-server/middleware"
+	auditService "r2-notify-server/services/audit"
+	userService "r2-notify-server/services/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterNotificationKindRoutes(r *gin.Engine, notificationKindController *controller.NotificationKindController, userService userService.UserService, auditService auditService.AuditService) {
+	kindRoute := r.Group("/admin/notification-kinds", middleware.AuthenticationMiddleware(), middleware.AuditMiddleware(auditService), middleware.RoleMiddleware(userService, data.ROLE_SUPER_ADMIN))
+	kindRoute.GET("", notificationKindController.ListKinds)
+	kindRoute.PUT(":type", notificationKindController.SaveKind)
+	kindRoute.DELETE(":type", notificationKindController.DeleteKind)
+}
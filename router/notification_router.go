@@ -2,11 +2,12 @@ package router
 
 import (
 	"r2-notify-server/controller"
+	"r2-notify-server/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterNotificationRoutes(r *gin.Engine, notificationController *controller.NotificationController) {
-	notificationRoute := r.Group("/notification")
+	notificationRoute := r.Group("/notification", middleware.AuthenticationMiddleware())
 	notificationRoute.POST("", notificationController.CreateNotification)
 }
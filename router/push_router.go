@@ -0,0 +1,15 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterPushRoutes(r *gin.Engine, pushController *controller.PushController) {
+	pushRoute := r.Group("/push")
+	pushRoute.GET("vapid-public-key", pushController.GetVapidPublicKey)
+	pushRoute.POST("subscriptions", middleware.AuthenticationMiddleware(), pushController.CreateSubscription)
+	pushRoute.DELETE("subscriptions", middleware.AuthenticationMiddleware(), pushController.DeleteSubscription)
+}
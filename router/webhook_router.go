@@ -0,0 +1,19 @@
+package router
+
+import (
+	"r2-notify-server/controller"
+	"r2-notify-server/data"
+	"r2-notify-server/middleware"
+	userService "r2-notify-server/services/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWebhookRoutes(r *gin.Engine, webhookController *controller.WebhookController, userService userService.UserService) {
+	webhookRoute := r.Group("/webhooks", middleware.AuthenticationMiddleware(), middleware.RoleMiddleware(userService, data.ROLE_APP_ADMIN))
+	webhookRoute.POST("", webhookController.CreateSubscription)
+	webhookRoute.GET("", webhookController.ListSubscriptions)
+	webhookRoute.DELETE(":id", webhookController.DeleteSubscription)
+	webhookRoute.GET("deliveries", webhookController.ListDeliveries)
+	webhookRoute.POST("deliveries/:id/replay", webhookController.ReplayDelivery)
+}
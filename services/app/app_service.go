@@ -0,0 +1,11 @@
+package appService
+
+import "r2-notify-server/data"
+
+type AppService interface {
+	FindAll() (apps []data.App, err error)
+	FindById(appId string) (app data.App, err error)
+	FindTenant(appId string) (tenantId string, err error)
+	Save(app data.App) (data.App, error)
+	Delete(appId string) error
+}
@@ -0,0 +1,124 @@
+package appService
+
+import (
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+	appRepository "r2-notify-server/repository/app"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// tenantCacheDuration is how long the tenant of an app is cached by FindTenant.
+const tenantCacheDuration = time.Minute
+
+type AppServiceImpl struct {
+	AppRepository appRepository.AppRepository
+	Validate      *validator.Validate
+	mu            sync.Mutex
+	tenants       map[string]cachedTenant
+}
+
+// cachedTenant is the tenant of an app, as cached by FindTenant.
+type cachedTenant struct {
+	tenantId  string
+	expiresAt time.Time
+}
+
+// NewAppServiceImpl returns a new instance of AppService with the provided AppRepository and
+// validator.Validate instance. If the validator instance is nil, an error is returned.
+func NewAppServiceImpl(appRepository appRepository.AppRepository, validate *validator.Validate) (service AppService, err error) {
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	return &AppServiceImpl{AppRepository: appRepository, Validate: validate, tenants: map[string]cachedTenant{}}, nil
+}
+
+// FindAll returns every registered app.
+func (t *AppServiceImpl) FindAll() ([]data.App, error) {
+	result, err := t.AppRepository.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	apps := []data.App{}
+	for _, app := range result {
+		apps = append(apps, toAppData(app))
+	}
+	return apps, nil
+}
+
+// FindById returns the app registered with the given ID.
+func (t *AppServiceImpl) FindById(appId string) (data.App, error) {
+	app, err := t.AppRepository.FindById(appId)
+	if err != nil {
+		return data.App{}, err
+	}
+	return toAppData(app), nil
+}
+
+// FindTenant returns the tenant of the app registered with the given ID. Apps that are not registered,
+// or registered without a tenant, belong to the default tenant. Tenants are cached for a minute, as they
+// are looked up for every ingested notification.
+func (t *AppServiceImpl) FindTenant(appId string) (string, error) {
+	t.mu.Lock()
+	cached, ok := t.tenants[appId]
+	t.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.tenantId, nil
+	}
+
+	app, err := t.AppRepository.FindById(appId)
+	if err != nil && !errors.Is(err, appRepository.ErrAppNotFound) {
+		return "", err
+	}
+	tenantId := config.ResolveTenant(app.TenantId)
+
+	t.mu.Lock()
+	t.tenants[appId] = cachedTenant{tenantId: tenantId, expiresAt: time.Now().Add(tenantCacheDuration)}
+	t.mu.Unlock()
+	return tenantId, nil
+}
+
+// Save validates and registers an app, replacing an existing one with the same ID.
+// The app belongs to the default tenant when saved without a tenant.
+func (t *AppServiceImpl) Save(app data.App) (data.App, error) {
+	if err := t.Validate.Struct(app); err != nil {
+		return data.App{}, err
+	}
+	app.TenantId = config.ResolveTenant(app.TenantId)
+	if err := t.AppRepository.Save(models.App{Id: app.Id, TenantId: app.TenantId, Name: app.Name, Description: app.Description}); err != nil {
+		return data.App{}, err
+	}
+	t.forget(app.Id)
+	app.UpdatedAt = time.Now()
+	return app, nil
+}
+
+// Delete unregisters an app. The notifications of the app are kept.
+func (t *AppServiceImpl) Delete(appId string) error {
+	if err := t.AppRepository.Delete(appId); err != nil {
+		return err
+	}
+	t.forget(appId)
+	return nil
+}
+
+// forget drops the cached tenant of an app. Other replicas keep theirs until it expires.
+func (t *AppServiceImpl) forget(appId string) {
+	t.mu.Lock()
+	delete(t.tenants, appId)
+	t.mu.Unlock()
+}
+
+func toAppData(app models.App) data.App {
+	return data.App{
+		Id:          app.Id,
+		TenantId:    config.ResolveTenant(app.TenantId),
+		Name:        app.Name,
+		Description: app.Description,
+		UpdatedAt:   app.UpdatedAt,
+	}
+}
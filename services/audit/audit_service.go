@@ -0,0 +1,9 @@
+package auditService
+
+import "r2-notify-server/data"
+
+type AuditService interface {
+	Record(event data.AuditEvent) error
+	RecordMutation(actor data.AuditActor, tenantId string, action string, scope map[string]string)
+	Find(query data.AuditEventQuery) (events []data.AuditEvent, err error)
+}
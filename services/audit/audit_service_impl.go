@@ -0,0 +1,99 @@
+package auditService
+
+import (
+	"errors"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	auditRepository "r2-notify-server/repository/audit"
+	"time"
+)
+
+// systemActorId is the actor of the mutations the server performs on its own, such as storing the
+// configuration of a user that has none.
+const systemActorId = "r2-notify-server"
+
+type AuditServiceImpl struct {
+	AuditRepository auditRepository.AuditRepository
+}
+
+// NewAuditServiceImpl returns a new instance of AuditService which appends audit events to the
+// AuditRepository. If the repository is nil, an error is returned.
+func NewAuditServiceImpl(auditRepository auditRepository.AuditRepository) (service AuditService, err error) {
+	if auditRepository == nil {
+		return nil, errors.New("audit repository cannot be nil")
+	}
+	return &AuditServiceImpl{AuditRepository: auditRepository}, nil
+}
+
+// Record appends an audit event, timestamped now.
+func (t *AuditServiceImpl) Record(event data.AuditEvent) error {
+	return t.AuditRepository.Create(models.AuditEvent{
+		TenantId:      event.TenantId,
+		ActorId:       event.ActorId,
+		ActorType:     event.ActorType,
+		Roles:         event.Roles,
+		Action:        event.Action,
+		Scope:         event.Scope,
+		Status:        event.Status,
+		CorrelationId: event.CorrelationId,
+		CreatedAt:     time.Now(),
+	})
+}
+
+// RecordMutation appends the audit event of a mutation performed by a service on behalf of an actor,
+// the system when the actor is not set. Scope entries with empty values are dropped. A failure to record
+// the event is logged, and does not fail the mutation, which already happened.
+func (t *AuditServiceImpl) RecordMutation(actor data.AuditActor, tenantId string, action string, scope map[string]string) {
+	if actor.Type == "" {
+		actor = data.AuditActor{Id: systemActorId, Type: data.AUDIT_ACTOR_SYSTEM, CorrelationId: actor.CorrelationId}
+	}
+	for key, value := range scope {
+		if value == "" {
+			delete(scope, key)
+		}
+	}
+	event := data.AuditEvent{
+		TenantId:      tenantId,
+		ActorId:       actor.Id,
+		ActorType:     actor.Type,
+		Action:        action,
+		Scope:         scope,
+		CorrelationId: actor.CorrelationId,
+	}
+	if err := t.Record(event); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "Audit Service",
+			Operation:     "RecordMutation",
+			Message:       "Failed to record audit event: " + action,
+			UserId:        scope["userId"],
+			AppId:         scope["appId"],
+			CorrelationId: actor.CorrelationId,
+			Error:         err,
+		})
+	}
+}
+
+// Find returns the latest audit events matching the query, most recent first.
+func (t *AuditServiceImpl) Find(query data.AuditEventQuery) ([]data.AuditEvent, error) {
+	result, err := t.AuditRepository.Find(query)
+	if err != nil {
+		return nil, err
+	}
+	events := []data.AuditEvent{}
+	for _, event := range result {
+		events = append(events, data.AuditEvent{
+			Id:            event.Id.Hex(),
+			TenantId:      event.TenantId,
+			ActorId:       event.ActorId,
+			ActorType:     event.ActorType,
+			Roles:         event.Roles,
+			Action:        event.Action,
+			Scope:         event.Scope,
+			Status:        event.Status,
+			CorrelationId: event.CorrelationId,
+			CreatedAt:     event.CreatedAt,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,88 @@
+package auditService
+
+import (
+	"errors"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	auditRepository "r2-notify-server/repository/audit"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubAuditRepository stores audit events in memory, or fails to store them when err is set.
+type stubAuditRepository struct {
+	auditRepository.AuditRepository
+	err    error
+	events []models.AuditEvent
+}
+
+func (r *stubAuditRepository) Create(event models.AuditEvent) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestRecordMutation(t *testing.T) {
+	tests := []struct {
+		name      string
+		actor     data.AuditActor
+		actorId   string
+		actorType string
+	}{
+		{"on behalf of a user", data.AuditActor{Id: "u1", Type: data.AUDIT_ACTOR_USER, CorrelationId: "c1"}, "u1", data.AUDIT_ACTOR_USER},
+		{"on behalf of an ingestion source", data.AuditActor{Id: data.SOURCE_KAFKA, Type: data.AUDIT_ACTOR_SERVICE, CorrelationId: "c1"}, data.SOURCE_KAFKA, data.AUDIT_ACTOR_SERVICE},
+		{"without actor", data.AuditActor{CorrelationId: "c1"}, systemActorId, data.AUDIT_ACTOR_SYSTEM},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := &stubAuditRepository{}
+			service, err := NewAuditServiceImpl(repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			service.RecordMutation(test.actor, "tenant-b", "notification.MarkAppAsRead", map[string]string{"userId": "u1", "appId": "app-a", "groupKey": "", "count": "2"})
+			if len(repository.events) != 1 {
+				t.Fatalf("expected one audit event, got %+v", repository.events)
+			}
+			event := repository.events[0]
+			if event.ActorId != test.actorId || event.ActorType != test.actorType || event.CorrelationId != "c1" {
+				t.Fatalf("expected the mutation to be recorded as performed by %s %s, got %+v", test.actorType, test.actorId, event)
+			}
+			if event.TenantId != "tenant-b" || event.Action != "notification.MarkAppAsRead" || event.CreatedAt.IsZero() {
+				t.Fatalf("expected the action of the tenant to be recorded, got %+v", event)
+			}
+			if _, ok := event.Scope["groupKey"]; ok || len(event.Scope) != 3 || event.Scope["count"] != "2" {
+				t.Fatalf("expected the scope without empty values, got %v", event.Scope)
+			}
+		})
+	}
+}
+
+func TestRecordMutationLogsTheEventsItFailsToRecord(t *testing.T) {
+	sink := logger.NewTestSink(zapcore.DebugLevel)
+	previous := logger.Log
+	logger.Log = sink.Logger
+	t.Cleanup(func() { logger.Log = previous })
+	service, err := NewAuditServiceImpl(&stubAuditRepository{err: errors.New("mongo is down")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mutation already happened, the failure is only logged.
+	service.RecordMutation(data.AuditActor{}, "tenant-b", "configuration.SetEmailNotificationStatus", map[string]string{"userId": "u1"})
+	if !strings.Contains(sink.Buffer.String(), "Failed to record audit event: configuration.SetEmailNotificationStatus") {
+		t.Fatalf("expected the failure to be logged, got %s", sink.Buffer.String())
+	}
+}
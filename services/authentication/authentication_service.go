@@ -1,9 +1,13 @@
 package authenticationService
 
 import (
+	"errors"
 	"r2-notify-server/data"
 )
 
+// ErrUnknownProvider is returned for logins with a provider that is not configured.
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
 type AuthenticationService interface {
-	GoogleAuthenticate(token string) (user data.UserInfo, jwt string, err error)
+	Authenticate(provider string, token string) (user data.UserInfo, tokens data.SessionTokens, err error)
 }
@@ -2,74 +2,115 @@ package authenticationService
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"r2-notify-server/config"
 	"r2-notify-server/data"
 	"r2-notify-server/logger"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-	"google.golang.org/api/idtoken"
+	configurationService "r2-notify-server/services/configuration"
+	sessionService "r2-notify-server/services/session"
 )
 
 type AuthenticationServiceImpl struct {
-	context context.Context
+	context              context.Context
+	ConfigurationService configurationService.ConfigurationService
+	SessionService       sessionService.SessionService
+	providers            map[string]IdentityProvider
 }
 
-func NewAuthenticationServiceImpl() (service AuthenticationService, err error) {
+// NewAuthenticationServiceImpl returns a new instance of AuthenticationService. The
+// ConfigurationService is used to record the email address of users as they sign in,
+// and the SessionService issues the tokens of their session.
+// Google login is enabled by GOOGLE_CLIENT_ID, and an OpenID Connect login by every provider of OIDC_PROVIDERS.
+func NewAuthenticationServiceImpl(configurationService configurationService.ConfigurationService, sessionService sessionService.SessionService) (service AuthenticationService, err error) {
+	if sessionService == nil {
+		return nil, errors.New("session service cannot be nil")
+	}
+	cfg := config.LoadConfig()
+	providers := map[string]IdentityProvider{}
+	if cfg.GoogleClientId != "" {
+		providers["google"] = googleProvider{clientId: cfg.GoogleClientId}
+	}
+	for _, providerConfig := range cfg.OidcProviders {
+		if _, exists := providers[providerConfig.Name]; exists {
+			return nil, fmt.Errorf("identity provider %s is configured twice", providerConfig.Name)
+		}
+		provider, err := newOidcProvider(providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		providers[provider.Name()] = provider
+	}
+
 	return &AuthenticationServiceImpl{
-		context: context.Background(),
+		context:              context.Background(),
+		ConfigurationService: configurationService,
+		SessionService:       sessionService,
+		providers:            providers,
 	}, err
 }
 
-func (t AuthenticationServiceImpl) GoogleAuthenticate(token string) (data.UserInfo, string, error) {
+// Authenticate verifies the ID token of an identity provider and starts a session for its user.
+// The user ID is namespaced by the provider as <provider>|<ID>.
+func (t AuthenticationServiceImpl) Authenticate(providerName string, token string) (data.UserInfo, data.SessionTokens, error) {
 
-	clientID := config.LoadConfig().GoogleClientId
-	payload, err := idtoken.Validate(t.context, token, clientID)
+	provider, ok := t.providers[providerName]
+	if !ok {
+		return data.UserInfo{}, data.SessionTokens{}, ErrUnknownProvider
+	}
+	user, err := provider.Verify(t.context, token)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Authentication Service",
-			Operation: "GoogleAuthenticate",
-			Message:   "Invalid Google token",
+			Operation: "Authenticate",
+			Message:   "Invalid " + providerName + " token",
 			Error:     err,
 			UserId:    "",
 		})
-		return data.UserInfo{}, "", fmt.Errorf("Invalid Google token")
+		return data.UserInfo{}, data.SessionTokens{}, fmt.Errorf("Invalid %s token", providerName)
 	}
-
-	user := data.UserInfo{
-		ID:     payload.Subject,
-		Name:   payload.Claims["name"].(string),
-		Email:  payload.Claims["email"].(string),
-		Avatar: payload.Claims["picture"].(string),
+	user.ID = localUserId(providerName, user.ID)
+	user.TenantId = config.ResolveTenant(user.TenantId)
+	if !config.IsKnownTenant(user.TenantId) {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Authentication Service",
+			Operation: "Authenticate",
+			Message:   "Unknown tenant " + user.TenantId + " of " + providerName + " token",
+			UserId:    user.ID,
+		})
+		return data.UserInfo{}, data.SessionTokens{}, fmt.Errorf("Invalid %s token", providerName)
 	}
 
-	// Issue JWT
-	claims := jwt.MapClaims{
-		"sub":   user.ID,
-		"email": user.Email,
-		"name":  user.Name,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+	// Keep the email address on record for the email channel
+	if user.Email != "" {
+		if err := t.ConfigurationService.ForTenant(user.TenantId).As(data.AuditActor{Id: user.ID, Type: data.AUDIT_ACTOR_USER}).SetEmail(user.ID, user.Email); err != nil {
+			logger.Log.Warn(logger.LogPayload{
+				Component: "Authentication Service",
+				Operation: "Authenticate",
+				Message:   "Failed to store email address of user",
+				Error:     err,
+				UserId:    user.ID,
+			})
+		}
 	}
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signed, err := jwtToken.SignedString([]byte(config.LoadConfig().JwtSecret))
+	// Start a session with an access and a refresh token
+	tokens, err := t.SessionService.Issue(user)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Authentication Service",
-			Operation: "GoogleAuthenticate",
+			Operation: "Authenticate",
 			Message:   "Token generation failed",
 			Error:     err,
 			UserId:    "",
 		})
-		return data.UserInfo{}, "", fmt.Errorf("Token generation failed")
+		return data.UserInfo{}, data.SessionTokens{}, fmt.Errorf("Token generation failed")
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Authentication Service",
-		Operation: "GoogleAuthenticate",
-		Message:   "Successfully authenticated user with Google",
+		Operation: "Authenticate",
+		Message:   "Successfully authenticated user with " + providerName,
 		UserId:    user.ID,
 	})
-	return user, signed, nil
+	return user, tokens, nil
 }
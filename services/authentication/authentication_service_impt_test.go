@@ -0,0 +1,210 @@
+package authenticationService
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	configurationService "r2-notify-server/services/configuration"
+	sessionService "r2-notify-server/services/session"
+	"r2-notify-server/utils"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubIssuer is an OpenID Connect issuer serving its discovery and JWKS documents, which signs
+// ID tokens with a locally generated key.
+type stubIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &stubIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(openIdConfiguration{Issuer: issuer.server.URL, JwksUri: issuer.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(utils.JsonWebKeySet{Keys: []utils.JsonWebKey{{
+			Kty: "RSA",
+			Kid: "test-key",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// token returns an ID token of the issuer for the client with the given claims, signed with the key.
+func (s *stubIssuer) token(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{"iss": s.server.URL, "aud": "client-a", "exp": time.Now().Add(time.Minute).Unix()}
+	for name, value := range claims {
+		all[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, all)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// recordingConfigurationService records the email addresses stored as users sign in.
+type recordingConfigurationService struct {
+	configurationService.ConfigurationService
+	emails map[string]string
+}
+
+func (s recordingConfigurationService) ForTenant(string) configurationService.ConfigurationService {
+	return s
+}
+
+func (s recordingConfigurationService) As(data.AuditActor) configurationService.ConfigurationService {
+	return s
+}
+
+func (s recordingConfigurationService) SetEmail(userId string, email string) error {
+	s.emails[userId] = email
+	return nil
+}
+
+// stubSessionService issues sessions for the subject of the user.
+type stubSessionService struct {
+	sessionService.SessionService
+}
+
+func (s stubSessionService) Issue(user data.UserInfo) (data.SessionTokens, error) {
+	return data.SessionTokens{Jwt: "access-" + user.ID}, nil
+}
+
+func TestAuthenticateWithAnOidcProvider(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	issuer := newStubIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      *rsa.PrivateKey
+		claims   jwt.MapClaims
+		valid    bool
+		userId   string
+		tenantId string
+		email    string
+	}{
+		{"user id is namespaced by the provider", issuer.key, jwt.MapClaims{"sub": "1234"}, true, "entra|1234", "default", ""},
+		{"verified email is kept", issuer.key, jwt.MapClaims{"sub": "1234", "email": "a@example.com", "email_verified": true}, true, "entra|1234", "default", "a@example.com"},
+		{"verified email as a string is kept", issuer.key, jwt.MapClaims{"sub": "1234", "email": "a@example.com", "email_verified": "true"}, true, "entra|1234", "default", "a@example.com"},
+		{"unverified email is dropped", issuer.key, jwt.MapClaims{"sub": "1234", "email": "ceo@example.com", "email_verified": false}, true, "entra|1234", "default", ""},
+		{"email without verification is dropped", issuer.key, jwt.MapClaims{"sub": "1234", "email": "ceo@example.com"}, true, "entra|1234", "default", ""},
+		{"tenant claim is mapped", issuer.key, jwt.MapClaims{"sub": "1234", "tid": "72f988bf-86f1-41af-91ab-2d7cd011db47"}, true, "entra|1234", "tenant-b", ""},
+		{"tenant claim mapped to the default tenant", issuer.key, jwt.MapClaims{"sub": "1234", "tid": "9188040d-6c67-4c5b-b112-36a304b66dad"}, true, "entra|1234", "default", ""},
+		{"unmapped tenant is rejected", issuer.key, jwt.MapClaims{"sub": "1234", "tid": "00000000-0000-0000-0000-000000000000"}, false, "", "", ""},
+		{"token signed with another key is rejected", otherKey, jwt.MapClaims{"sub": "1234"}, false, "", "", ""},
+		{"token without subject is rejected", issuer.key, jwt.MapClaims{}, false, "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, err := newOidcProvider(config.OidcProviderConfig{
+				Name:     "entra",
+				Issuer:   issuer.server.URL,
+				ClientId: "client-a",
+				Claims:   "tenant=tid",
+				Tenants:  "72f988bf-86f1-41af-91ab-2d7cd011db47=tenant-b,9188040d-6c67-4c5b-b112-36a304b66dad=default",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			emails := map[string]string{}
+			service := AuthenticationServiceImpl{
+				context:              context.Background(),
+				ConfigurationService: recordingConfigurationService{emails: emails},
+				SessionService:       stubSessionService{},
+				providers:            map[string]IdentityProvider{"entra": provider},
+			}
+
+			user, tokens, err := service.Authenticate("entra", issuer.token(t, test.key, test.claims))
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected the token to be rejected, signed in %+v", user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != test.userId || user.TenantId != test.tenantId || user.Email != test.email {
+				t.Fatalf("expected user %s of %s with email %q, got %+v", test.userId, test.tenantId, test.email, user)
+			}
+			if tokens.Jwt != "access-"+test.userId {
+				t.Fatalf("expected the session to be issued for %s, got %s", test.userId, tokens.Jwt)
+			}
+			if email, stored := emails[test.userId]; stored != (test.email != "") || email != test.email {
+				t.Fatalf("expected email %q to be stored, stored %v", test.email, emails)
+			}
+		})
+	}
+}
+
+func TestSameSubjectOfTwoProvidersIsTwoUsers(t *testing.T) {
+	first, second := newStubIssuer(t), newStubIssuer(t)
+	providers := map[string]IdentityProvider{}
+	for name, issuer := range map[string]*stubIssuer{"first": first, "second": second} {
+		provider, err := newOidcProvider(config.OidcProviderConfig{Name: name, Issuer: issuer.server.URL, ClientId: "client-a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers[name] = provider
+	}
+	service := AuthenticationServiceImpl{
+		context:              context.Background(),
+		ConfigurationService: recordingConfigurationService{emails: map[string]string{}},
+		SessionService:       stubSessionService{},
+		providers:            providers,
+	}
+
+	firstUser, _, err := service.Authenticate("first", first.token(t, first.key, jwt.MapClaims{"sub": "admin"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondUser, _, err := service.Authenticate("second", second.token(t, second.key, jwt.MapClaims{"sub": "admin"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstUser.ID == secondUser.ID {
+		t.Fatalf("expected the subject admin of two providers to be two users, both are %s", firstUser.ID)
+	}
+	if _, _, err := service.Authenticate("first", second.token(t, second.key, jwt.MapClaims{"sub": "admin"})); err == nil {
+		t.Fatal("expected a token of the second issuer to be rejected by the first provider")
+	}
+}
@@ -0,0 +1,25 @@
+package authenticationService
+
+import (
+	"context"
+	"r2-notify-server/data"
+
+	"google.golang.org/api/idtoken"
+)
+
+// googleProvider verifies Google ID tokens issued to GOOGLE_CLIENT_ID.
+type googleProvider struct {
+	clientId string
+}
+
+func (p googleProvider) Name() string {
+	return "google"
+}
+
+func (p googleProvider) Verify(ctx context.Context, token string) (data.UserInfo, error) {
+	payload, err := idtoken.Validate(ctx, token, p.clientId)
+	if err != nil {
+		return data.UserInfo{}, err
+	}
+	return defaultClaims.user(payload.Claims)
+}
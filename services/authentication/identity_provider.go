@@ -0,0 +1,101 @@
+package authenticationService
+
+import (
+	"context"
+	"fmt"
+	"r2-notify-server/data"
+	"strings"
+)
+
+// IdentityProvider verifies the ID tokens of an identity provider users sign in with.
+type IdentityProvider interface {
+	Name() string
+	Verify(ctx context.Context, token string) (data.UserInfo, error)
+}
+
+// claimMapping names the token claims the fields of a user are read from. The tenant is only read
+// from a claim when one is mapped.
+type claimMapping struct {
+	ID     string
+	Name   string
+	Email  string
+	Avatar string
+	Tenant string
+}
+
+// defaultClaims are the standard OpenID Connect claims of a user.
+var defaultClaims = claimMapping{ID: "sub", Name: "name", Email: "email", Avatar: "picture"}
+
+// parseClaimMapping overrides the default claims with a comma separated list of <field>=<claim>
+// entries, where field is one of id, name, email, avatar and tenant.
+func parseClaimMapping(value string) (claimMapping, error) {
+	mapping := defaultClaims
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		field, claim, ok := strings.Cut(entry, "=")
+		claim = strings.TrimSpace(claim)
+		if !ok || claim == "" {
+			return claimMapping{}, fmt.Errorf("invalid claim mapping %q, expected <field>=<claim>", entry)
+		}
+		switch strings.TrimSpace(field) {
+		case "id":
+			mapping.ID = claim
+		case "name":
+			mapping.Name = claim
+		case "email":
+			mapping.Email = claim
+		case "avatar":
+			mapping.Avatar = claim
+		case "tenant":
+			mapping.Tenant = claim
+		default:
+			return claimMapping{}, fmt.Errorf("unknown user field %q in claim mapping", field)
+		}
+	}
+	return mapping, nil
+}
+
+// user reads the fields of a user from the claims of a token. Missing claims and claims that are
+// not strings are left empty, except for the ID which is required. The email address is only read
+// when the email_verified claim of the token is true, since most providers let users pick any address.
+func (m claimMapping) user(claims map[string]interface{}) (data.UserInfo, error) {
+	user := data.UserInfo{
+		ID:     claimString(claims, m.ID),
+		Name:   claimString(claims, m.Name),
+		Avatar: claimString(claims, m.Avatar),
+	}
+	if emailVerified(claims) {
+		user.Email = claimString(claims, m.Email)
+	}
+	if m.Tenant != "" {
+		user.TenantId = claimString(claims, m.Tenant)
+	}
+	if user.ID == "" {
+		return data.UserInfo{}, fmt.Errorf("token has no %s claim", m.ID)
+	}
+	return user, nil
+}
+
+// emailVerified reports whether the email_verified claim is true. Some providers send it as a string.
+func emailVerified(claims map[string]interface{}) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return strings.EqualFold(verified, "true")
+	}
+	return false
+}
+
+// localUserId returns the ID of the user of a provider on the server, <provider>|<ID>, so that two
+// providers issuing the same subject can not sign in as the same user.
+func localUserId(provider string, id string) string {
+	return provider + "|" + id
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
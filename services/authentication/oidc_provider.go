@@ -0,0 +1,214 @@
+package authenticationService
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcKeysMaxAge is the time the signing keys of an issuer are cached.
+	oidcKeysMaxAge = time.Hour
+	// oidcMinRefresh is the minimum time between two fetches of the discovery or JWKS document of an
+	// issuer, so that tokens with forged key IDs can not be used to flood the identity provider.
+	oidcMinRefresh = time.Minute
+	// oidcLeeway is the clock skew tolerated on the time based claims of an ID token.
+	oidcLeeway = 30 * time.Second
+)
+
+// oidcProvider verifies the ID tokens of an OpenID Connect issuer. The JWKS document of the issuer is
+// found through discovery, and cached until it is older than an hour or a token names an unknown key.
+// Its users belong to the tenant read from the mapped tenant claim, or else to the tenant of the provider.
+// When the provider has a tenant map, the tenant claim is the ID of a tenant of the provider, such as the
+// directory ID of Entra, which is translated to a tenant of the server by the map.
+type oidcProvider struct {
+	name       string
+	issuer     string
+	clientId   string
+	tenant     string
+	tenants    map[string]string
+	claims     claimMapping
+	httpClient *http.Client
+	mu         sync.Mutex
+	jwksUri    string
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+}
+
+// openIdConfiguration is the part of an OpenID discovery document used to verify ID tokens.
+type openIdConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JwksUri string `json:"jwks_uri"`
+}
+
+func newOidcProvider(cfg config.OidcProviderConfig) (*oidcProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientId == "" {
+		return nil, fmt.Errorf("OIDC provider %s requires an issuer and a client ID", cfg.Name)
+	}
+	claims, err := parseClaimMapping(cfg.Claims)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC provider %s: %w", cfg.Name, err)
+	}
+	if cfg.Tenant != "" && !config.IsKnownTenant(cfg.Tenant) {
+		return nil, fmt.Errorf("OIDC provider %s: unknown tenant %q", cfg.Name, cfg.Tenant)
+	}
+	tenants, err := parseTenantMap(cfg.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC provider %s: %w", cfg.Name, err)
+	}
+	return &oidcProvider{
+		name:       cfg.Name,
+		issuer:     cfg.Issuer,
+		clientId:   cfg.ClientId,
+		tenant:     cfg.Tenant,
+		tenants:    tenants,
+		claims:     claims,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *oidcProvider) Name() string {
+	return p.name
+}
+
+// Verify checks the signature, issuer, audience and expiry of an ID token, and maps its claims to a user.
+func (p *oidcProvider) Verify(ctx context.Context, token string) (data.UserInfo, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(oidcLeeway),
+	)
+	if err != nil {
+		return data.UserInfo{}, err
+	}
+	user, err := p.claims.user(claims)
+	if err != nil {
+		return data.UserInfo{}, err
+	}
+	if user.TenantId == "" {
+		user.TenantId = p.tenant
+	} else if p.tenants != nil {
+		tenantId, ok := p.tenants[user.TenantId]
+		if !ok {
+			return data.UserInfo{}, fmt.Errorf("tenant %q of the token is not mapped to a tenant", user.TenantId)
+		}
+		user.TenantId = tenantId
+	}
+	return user, nil
+}
+
+// parseTenantMap reads a comma separated list of <provider tenant>=<tenantId> entries. It returns nil
+// for an empty list, in which case the tenant claim is used as the tenant ID.
+func parseTenantMap(value string) (map[string]string, error) {
+	var tenants map[string]string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		providerTenant, tenantId, ok := strings.Cut(entry, "=")
+		providerTenant, tenantId = strings.TrimSpace(providerTenant), strings.TrimSpace(tenantId)
+		if !ok || providerTenant == "" || tenantId == "" {
+			return nil, fmt.Errorf("invalid tenant mapping %q, expected <provider tenant>=<tenantId>", entry)
+		}
+		if !config.IsKnownTenant(tenantId) {
+			return nil, fmt.Errorf("unknown tenant %q in tenant mapping", tenantId)
+		}
+		if tenants == nil {
+			tenants = map[string]string{}
+		}
+		tenants[providerTenant] = tenantId
+	}
+	return tenants, nil
+}
+
+func (p *oidcProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := p.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	return utils.MatchSigningMethod(token, key)
+}
+
+// key returns the signing key of a key ID, fetching the keys of the issuer again when they are
+// stale or the key is unknown. Without a key ID, the only key of the issuer is used.
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || time.Since(p.fetchedAt) >= oidcKeysMaxAge {
+		if err := p.refresh(); err != nil && p.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := p.find(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.fetchedAt) >= oidcMinRefresh {
+		if err := p.refresh(); err != nil {
+			return nil, err
+		}
+		if key, ok := p.find(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *oidcProvider) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// refresh discovers the JWKS document of the issuer, once, and fetches its keys. It must be called
+// with the lock held.
+func (p *oidcProvider) refresh() error {
+	p.fetchedAt = time.Now()
+	if p.jwksUri == "" {
+		document, err := utils.Download(p.httpClient, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration")
+		if err != nil {
+			return fmt.Errorf("failed to discover OIDC provider %s: %w", p.name, err)
+		}
+		var configuration openIdConfiguration
+		if err := json.Unmarshal(document, &configuration); err != nil {
+			return fmt.Errorf("invalid discovery document of OIDC provider %s: %w", p.name, err)
+		}
+		if configuration.Issuer != p.issuer {
+			return fmt.Errorf("OIDC provider %s reports issuer %q instead of %q", p.name, configuration.Issuer, p.issuer)
+		}
+		if configuration.JwksUri == "" {
+			return errors.New("discovery document of OIDC provider " + p.name + " has no jwks_uri")
+		}
+		p.jwksUri = configuration.JwksUri
+	}
+
+	document, err := utils.Download(p.httpClient, p.jwksUri)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the keys of OIDC provider %s: %w", p.name, err)
+	}
+	keys, err := utils.ParseJwks(document)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS document of OIDC provider %s: %w", p.name, err)
+	}
+	p.keys = keys
+	return nil
+}
@@ -0,0 +1,11 @@
+package chatService
+
+import (
+	"context"
+	"r2-notify-server/data"
+)
+
+type ChatService interface {
+	Send(target data.ChatTarget, notification data.Notification) error
+	Start(ctx context.Context)
+}
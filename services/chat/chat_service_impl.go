@@ -0,0 +1,203 @@
+package chatService
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	chatRepository "r2-notify-server/repository/chat"
+	"r2-notify-server/utils"
+	"time"
+)
+
+// maxRetryDelay caps the exponential backoff between two attempts to post to a chat target.
+const maxRetryDelay = time.Hour
+
+type ChatServiceImpl struct {
+	ChatRepository chatRepository.ChatRepository
+	httpClient     *http.Client
+	wake           chan struct{}
+}
+
+// NewChatServiceImpl returns a new instance of ChatService which posts notifications to Slack
+// and Microsoft Teams incoming webhooks. If the chatRepository is nil, an error is returned.
+// Posts are queued and only attempted once Start has been called.
+func NewChatServiceImpl(chatRepository chatRepository.ChatRepository) (service ChatService, err error) {
+	if chatRepository == nil {
+		return nil, errors.New("chat repository cannot be nil")
+	}
+	return &ChatServiceImpl{
+		ChatRepository: chatRepository,
+		httpClient:     utils.NewOutboundHttpClient(time.Duration(config.LoadConfig().ChatTimeout) * time.Second),
+		wake:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Send renders the notification for the target's chat platform and queues the post to the target's
+// incoming webhook in the notification's tenant. The post is attempted by the worker started with
+// Start, so that a slow or failing chat platform never holds up the delivery of the notification.
+func (t *ChatServiceImpl) Send(target data.ChatTarget, notification data.Notification) error {
+	var message interface{}
+	switch target.Type {
+	case data.CHAT_TARGET_SLACK:
+		message = renderSlack(notification)
+	case data.CHAT_TARGET_TEAMS:
+		message = renderTeams(notification)
+	default:
+		return errors.New("unsupported chat target type: " + target.Type)
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = t.ChatRepository.ForTenant(notification.TenantId).CreateDelivery(models.ChatDelivery{
+		AppId:          notification.AppId,
+		UserId:         notification.UserID,
+		NotificationId: notification.Id,
+		TargetType:     target.Type,
+		Url:            target.Url,
+		Body:           string(body),
+		Status:         data.CHAT_DELIVERY_PENDING,
+		NextAttemptAt:  time.Now(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	t.notifyWorker()
+	return nil
+}
+
+// Start runs the chat worker until the context is cancelled. The worker attempts due posts of every
+// tenant whenever new ones are queued and on every poll interval, which also picks up retries and
+// posts left behind by other replicas.
+func (t *ChatServiceImpl) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(config.LoadConfig().ChatPollInterval) * time.Second)
+	defer ticker.Stop()
+	logger.Log.Info(logger.LogPayload{
+		Component: "Chat Service",
+		Operation: "Start",
+		Message:   "Chat delivery worker started",
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(logger.LogPayload{
+				Component: "Chat Service",
+				Operation: "Start",
+				Message:   "Chat delivery worker stopped",
+			})
+			return
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.processDueDeliveries(ctx)
+	}
+}
+
+// notifyWorker wakes the chat worker without blocking if a wake up is already pending.
+func (t *ChatServiceImpl) notifyWorker() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processDueDeliveries claims and attempts the due posts of every tenant until none are left.
+func (t *ChatServiceImpl) processDueDeliveries(ctx context.Context) {
+	lease := t.httpClient.Timeout * 2
+	for _, tenantId := range config.Tenants() {
+		repository := t.ChatRepository.ForTenant(tenantId)
+		for ctx.Err() == nil {
+			delivery, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(lease))
+			if err != nil {
+				break
+			}
+			t.attempt(ctx, repository, delivery)
+		}
+	}
+}
+
+// attempt posts a queued message to its chat target and records the outcome. Failed posts are
+// rescheduled with exponential backoff up to CHAT_MAX_ATTEMPTS times; client errors other than
+// 429 are not retried.
+func (t *ChatServiceImpl) attempt(ctx context.Context, repository chatRepository.ChatRepository, delivery models.ChatDelivery) {
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now()
+	retry, err := t.post(ctx, delivery.Url, []byte(delivery.Body))
+
+	switch {
+	case err == nil:
+		delivery.Status = data.CHAT_DELIVERY_SUCCEEDED
+		delivery.LastError = ""
+		logger.Log.Debug(logger.LogPayload{
+			Component: "Chat Service",
+			Operation: "Attempt",
+			Message:   "Posted notification " + delivery.NotificationId + " to " + delivery.TargetType,
+			UserId:    delivery.UserId,
+			AppId:     delivery.AppId,
+		})
+	case !retry || delivery.Attempts >= config.LoadConfig().ChatMaxAttempts:
+		delivery.Status = data.CHAT_DELIVERY_FAILED
+		delivery.LastError = err.Error()
+		logger.Log.Error(logger.LogPayload{
+			Component: "Chat Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Failed to post notification %s to %s after %d attempts", delivery.NotificationId, delivery.TargetType, delivery.Attempts),
+			Error:     err,
+			UserId:    delivery.UserId,
+			AppId:     delivery.AppId,
+		})
+	default:
+		delivery.Status = data.CHAT_DELIVERY_PENDING
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(retryDelay(delivery.Attempts))
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Chat Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Attempt %d to post notification %s to %s failed, retrying", delivery.Attempts, delivery.NotificationId, delivery.TargetType),
+			Error:     err,
+			UserId:    delivery.UserId,
+			AppId:     delivery.AppId,
+		})
+	}
+	_ = repository.UpdateDelivery(delivery)
+}
+
+// post sends one request to the incoming webhook and reports whether a failure is worth retrying.
+func (t *ChatServiceImpl) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := t.httpClient.Do(request)
+	if err != nil {
+		return !errors.Is(err, utils.ErrForbiddenUrl), err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	err = fmt.Errorf("chat webhook responded with status %d: %s", response.StatusCode, string(detail))
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+// retryDelay returns the backoff before the next attempt, doubling the configured base delay
+// after every failed attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(config.LoadConfig().ChatRetryBaseDelay) * time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
@@ -0,0 +1,267 @@
+package chatService
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	chatRepository "r2-notify-server/repository/chat"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// memoryChatRepository keeps the queued posts of every tenant in memory.
+type memoryChatRepository struct {
+	tenantId   string
+	mutex      *sync.Mutex
+	deliveries *[]models.ChatDelivery
+}
+
+func newMemoryChatRepository() memoryChatRepository {
+	return memoryChatRepository{tenantId: "default", mutex: &sync.Mutex{}, deliveries: &[]models.ChatDelivery{}}
+}
+
+func (r memoryChatRepository) ForTenant(tenantId string) chatRepository.ChatRepository {
+	if tenantId == "" {
+		tenantId = "default"
+	}
+	r.tenantId = tenantId
+	return r
+}
+
+func (r memoryChatRepository) CreateDelivery(delivery models.ChatDelivery) (primitive.ObjectID, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delivery.Id = primitive.NewObjectID()
+	delivery.TenantId = r.tenantId
+	*r.deliveries = append(*r.deliveries, delivery)
+	return delivery.Id, nil
+}
+
+func (r memoryChatRepository) ClaimDueDelivery(now time.Time, leaseUntil time.Time) (models.ChatDelivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, delivery := range *r.deliveries {
+		if delivery.TenantId == r.tenantId && delivery.Status == data.CHAT_DELIVERY_PENDING && !delivery.NextAttemptAt.After(now) {
+			(*r.deliveries)[i].NextAttemptAt = leaseUntil
+			return (*r.deliveries)[i], nil
+		}
+	}
+	return models.ChatDelivery{}, chatRepository.ErrNoDueDelivery
+}
+
+func (r memoryChatRepository) UpdateDelivery(delivery models.ChatDelivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i := range *r.deliveries {
+		if (*r.deliveries)[i].Id == delivery.Id {
+			(*r.deliveries)[i] = delivery
+		}
+	}
+	return nil
+}
+
+func (r memoryChatRepository) only(t *testing.T) models.ChatDelivery {
+	t.Helper()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(*r.deliveries) != 1 {
+		t.Fatalf("expected one queued chat delivery, got %d", len(*r.deliveries))
+	}
+	return (*r.deliveries)[0]
+}
+
+// chatStandIn is an incoming webhook of Slack or Teams answering with the given statuses in turn,
+// and 200 once they are used up.
+type chatStandIn struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	bodies   []map[string]interface{}
+}
+
+func newChatStandIn(t *testing.T, statuses ...int) *chatStandIn {
+	standIn := &chatStandIn{statuses: statuses}
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		standIn.mutex.Lock()
+		defer standIn.mutex.Unlock()
+		if request.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a json post, got content type %q", request.Header.Get("Content-Type"))
+		}
+		raw, _ := io.ReadAll(request.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Errorf("expected a json body, got %s", raw)
+		}
+		standIn.bodies = append(standIn.bodies, body)
+		status := http.StatusOK
+		if len(standIn.statuses) > 0 {
+			status, standIn.statuses = standIn.statuses[0], standIn.statuses[1:]
+		}
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte("ok"))
+	}))
+	t.Cleanup(standIn.server.Close)
+	return standIn
+}
+
+func (s *chatStandIn) received() []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]map[string]interface{}{}, s.bodies...)
+}
+
+func newTestChatService(t *testing.T, repository chatRepository.ChatRepository) *ChatServiceImpl {
+	t.Helper()
+	service, err := NewChatServiceImpl(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.(*ChatServiceImpl)
+}
+
+var testNotification = data.Notification{
+	Id:       "n1",
+	TenantId: "tenant-b",
+	AppId:    "app-a",
+	UserID:   "u1",
+	GroupKey: "builds",
+	Title:    "Build failed",
+	Message:  "main is red",
+	Status:   "error",
+}
+
+func TestSendQueuesAndTheWorkerPostsToSlackAndTeams(t *testing.T) {
+	t.Setenv("ALLOW_PRIVATE_OUTBOUND_URLS", "true")
+	t.Setenv("TENANT_IDS", "tenant-b")
+	tests := []struct {
+		targetType string
+		check      func(t *testing.T, body map[string]interface{})
+	}{
+		{data.CHAT_TARGET_SLACK, func(t *testing.T, body map[string]interface{}) {
+			if body["text"] != "Build failed: main is red" {
+				t.Errorf("expected the slack fallback text, got %v", body["text"])
+			}
+		}},
+		{data.CHAT_TARGET_TEAMS, func(t *testing.T, body map[string]interface{}) {
+			attachments, _ := body["attachments"].([]interface{})
+			if body["type"] != "message" || len(attachments) != 1 {
+				t.Fatalf("expected a teams message with one card, got %v", body)
+			}
+			if attachments[0].(map[string]interface{})["contentType"] != "application/vnd.microsoft.card.adaptive" {
+				t.Errorf("expected an adaptive card, got %v", attachments[0])
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.targetType, func(t *testing.T) {
+			standIn := newChatStandIn(t)
+			repository := newMemoryChatRepository()
+			service := newTestChatService(t, repository)
+
+			if err := service.Send(data.ChatTarget{Type: test.targetType, Url: standIn.server.URL}, testNotification); err != nil {
+				t.Fatal(err)
+			}
+			if len(standIn.received()) != 0 {
+				t.Fatal("expected Send to only queue the post")
+			}
+			queued := repository.only(t)
+			if queued.TenantId != "tenant-b" || queued.Status != data.CHAT_DELIVERY_PENDING {
+				t.Fatalf("expected a pending post in the notification's tenant, got %+v", queued)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				service.Start(ctx)
+				close(done)
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for repository.only(t).Status == data.CHAT_DELIVERY_PENDING && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			if delivery := repository.only(t); delivery.Status != data.CHAT_DELIVERY_SUCCEEDED || delivery.Attempts != 1 {
+				t.Fatalf("expected the post to succeed on the first attempt, got %+v", delivery)
+			}
+			bodies := standIn.received()
+			if len(bodies) != 1 {
+				t.Fatalf("expected one post, got %d", len(bodies))
+			}
+			test.check(t, bodies[0])
+		})
+	}
+}
+
+func TestFailedPostsAreRetriedByTheWorker(t *testing.T) {
+	t.Setenv("ALLOW_PRIVATE_OUTBOUND_URLS", "true")
+	t.Setenv("CHAT_RETRY_BASE_DELAY", "0")
+	t.Setenv("CHAT_MAX_ATTEMPTS", "3")
+	t.Setenv("TENANT_IDS", "tenant-b")
+	tests := []struct {
+		name     string
+		statuses []int
+		status   string
+		attempts int
+	}{
+		{"server errors are retried", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, data.CHAT_DELIVERY_SUCCEEDED, 3},
+		{"throttled posts are retried", []int{http.StatusTooManyRequests}, data.CHAT_DELIVERY_SUCCEEDED, 2},
+		{"attempts are capped", []int{500, 500, 500, 500}, data.CHAT_DELIVERY_FAILED, 3},
+		{"client errors are not retried", []int{http.StatusNotFound}, data.CHAT_DELIVERY_FAILED, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := newChatStandIn(t, test.statuses...)
+			repository := newMemoryChatRepository()
+			service := newTestChatService(t, repository)
+			if err := service.Send(data.ChatTarget{Type: data.CHAT_TARGET_SLACK, Url: standIn.server.URL}, testNotification); err != nil {
+				t.Fatal(err)
+			}
+
+			service.processDueDeliveries(context.Background())
+
+			delivery := repository.only(t)
+			if delivery.Status != test.status || delivery.Attempts != test.attempts {
+				t.Fatalf("expected status %s after %d attempts, got %s after %d", test.status, test.attempts, delivery.Status, delivery.Attempts)
+			}
+			if len(standIn.received()) != test.attempts {
+				t.Fatalf("expected %d posts, got %d", test.attempts, len(standIn.received()))
+			}
+		})
+	}
+}
+
+func TestPostsToInternalAddressesAreRefused(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	standIn := newChatStandIn(t)
+	repository := newMemoryChatRepository()
+	service := newTestChatService(t, repository)
+	if err := service.Send(data.ChatTarget{Type: data.CHAT_TARGET_TEAMS, Url: standIn.server.URL}, testNotification); err != nil {
+		t.Fatal(err)
+	}
+
+	service.processDueDeliveries(context.Background())
+
+	if delivery := repository.only(t); delivery.Status != data.CHAT_DELIVERY_FAILED || delivery.Attempts != 1 {
+		t.Fatalf("expected the post to fail without retries, got %+v", delivery)
+	}
+	if len(standIn.received()) != 0 {
+		t.Fatal("expected the loopback stand-in not to be reached")
+	}
+}
@@ -0,0 +1,119 @@
+package chatService
+
+import (
+	"r2-notify-server/data"
+	"strings"
+)
+
+// statusStyle holds the colour of a notification status as a hex value for Slack attachments
+// and as a named colour for Teams Adaptive Cards.
+type statusStyle struct {
+	hex   string
+	teams string
+}
+
+var statusStyles = map[string]statusStyle{
+	"error":   {hex: "#D32F2F", teams: "Attention"},
+	"warning": {hex: "#F9A825", teams: "Warning"},
+	"success": {hex: "#2E7D32", teams: "Good"},
+	"info":    {hex: "#1976D2", teams: "Accent"},
+}
+
+func styleOf(status string) statusStyle {
+	if style, ok := statusStyles[strings.ToLower(status)]; ok {
+		return style
+	}
+	return statusStyle{hex: "#607D8B", teams: "Default"}
+}
+
+// titleOf returns the title of the notification, falling back to its group key.
+func titleOf(notification data.Notification) string {
+	if notification.Title != "" {
+		return notification.Title
+	}
+	return notification.GroupKey
+}
+
+// renderSlack renders the notification as a Slack incoming-webhook message using Block Kit.
+// The blocks are wrapped in an attachment so that the status colour is shown as a side bar.
+func renderSlack(notification data.Notification) map[string]interface{} {
+	title := titleOf(notification)
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": title},
+		},
+		{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": notification.Message},
+		},
+		{
+			"type": "context",
+			"elements": []map[string]interface{}{
+				{"type": "mrkdwn", "text": "*App:* " + notification.AppId + " | *Group:* " + notification.GroupKey + " | *Status:* " + notification.Status},
+			},
+		},
+	}
+	if notification.ActionUrl != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []map[string]interface{}{
+				{
+					"type": "button",
+					"text": map[string]interface{}{"type": "plain_text", "text": "Open"},
+					"url":  notification.ActionUrl,
+				},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"text": title + ": " + notification.Message,
+		"attachments": []map[string]interface{}{
+			{"color": styleOf(notification.Status).hex, "blocks": blocks},
+		},
+	}
+}
+
+// renderTeams renders the notification as a Microsoft Teams incoming-webhook message carrying
+// an Adaptive Card.
+func renderTeams(notification data.Notification) map[string]interface{} {
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{
+				"type":   "TextBlock",
+				"text":   titleOf(notification),
+				"weight": "Bolder",
+				"size":   "Medium",
+				"color":  styleOf(notification.Status).teams,
+				"wrap":   true,
+			},
+			{
+				"type": "TextBlock",
+				"text": notification.Message,
+				"wrap": true,
+			},
+			{
+				"type": "FactSet",
+				"facts": []map[string]interface{}{
+					{"title": "App", "value": notification.AppId},
+					{"title": "Group", "value": notification.GroupKey},
+					{"title": "Status", "value": notification.Status},
+				},
+			},
+		},
+	}
+	if notification.ActionUrl != "" {
+		card["actions"] = []map[string]interface{}{
+			{"type": "Action.OpenUrl", "title": "Open", "url": notification.ActionUrl},
+		}
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	}
+}
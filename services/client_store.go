@@ -3,36 +3,76 @@ package clientStore
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
 	"r2-notify-server/config"
 	"r2-notify-server/data"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
+	"r2-notify-server/utils"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 var (
-	clients      = make(map[string][]*websocket.Conn) // userID -> []connection
+	clients      = make(map[string][]*websocket.Conn)              // tenantID:userID -> []connection
+	connections  = make(map[*websocket.Conn]data.ConnectedSession) // connection -> its ID and the session of its token
 	clientsMutex sync.RWMutex
 )
 
-// StoreClient adds a new connection to the list of connections for the given user
-// and stores the updated models.ClientInfo struct in Redis.
+// lastSeenTTL is how long the last seen timestamp of a disconnected user is kept in Redis.
+const lastSeenTTL = 30 * 24 * time.Hour
+
+// connectionTTL is how long the records of the open connections of a user are kept in Redis without updates.
+const connectionTTL = 24 * time.Hour
+
+// clientKey identifies a user of a tenant in the clients map and in the Redis keys of the store,
+// so that users of different tenants sharing an ID never see each other's connections.
+func clientKey(tenantId string, userId string) string {
+	return tenantId + ":" + userId
+}
+
+// replica identifies this server in the records of its open connections.
+var replica = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "r2-notify-server"
+	}
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}()
+
+// StoreClient adds a new connection to the list of connections for the user and tenant of the given info
+// and stores the updated models.ClientInfo struct in Redis. The sessionId is the login
+// session of the token the connection was opened with, if any, so that the connection
+// can be closed when the session is revoked.
 // It is safe to call this function concurrently from multiple goroutines.
-func StoreClient(info models.ClientInfo, conn *websocket.Conn) error {
+func StoreClient(info models.ClientInfo, conn *websocket.Conn, sessionId string) error {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "StoreClient",
 		Message:   "Storing client in memory for clientID: " + info.ID,
 		UserId:    info.ID,
 	})
+	key := clientKey(info.TenantId, info.ID)
 	clientsMutex.Lock()
-	clients[info.ID] = append(clients[info.ID], conn)
+	clients[key] = append(clients[key], conn)
+	session := data.ConnectedSession{
+		ConnectionId: utils.GenerateUUID(),
+		TenantId:     info.TenantId,
+		UserId:       info.ID,
+		SessionId:    sessionId,
+		Replica:      replica,
+		ConnectedAt:  info.ConnectedAt,
+	}
+	connections[conn] = session
 	clientsMutex.Unlock()
+	storeSession(session)
 	// Marshal and store the updated ClientInfo struct in Redis
 	data, _ := json.Marshal(info)
-	err := config.RDB.Set(config.Ctx, "client:"+info.ID, data, 0).Err()
+	err := config.RDB.Set(config.Ctx, "client:"+key, data, 0).Err()
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Client Store",
@@ -52,19 +92,26 @@ func StoreClient(info models.ClientInfo, conn *websocket.Conn) error {
 	return nil
 }
 
-// DeleteClient removes the client with the given ID from the in-memory map and from Redis, where the client's info is stored.
+// DeleteClient removes the client with the given ID of the given tenant from the in-memory map and from Redis,
+// where the client's info is stored.
 // It is safe to call this function concurrently from multiple goroutines.
-func DeleteClient(id string) error {
+func DeleteClient(tenantId string, id string) error {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "DeleteClient",
 		Message:   "Deleting client for clientID: " + id,
 		UserId:    id,
 	})
+	key := clientKey(tenantId, id)
 	clientsMutex.Lock()
-	delete(clients, id)
+	for _, conn := range clients[key] {
+		delete(connections, conn)
+	}
+	delete(clients, key)
 	clientsMutex.Unlock()
-	err := config.RDB.Del(config.Ctx, "client:"+id).Err()
+	setLastSeen(key)
+	_ = config.RDB.Del(config.Ctx, "connections:"+key).Err()
+	err := config.RDB.Del(config.Ctx, "client:"+key).Err()
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Client Store",
@@ -84,20 +131,25 @@ func DeleteClient(id string) error {
 	return nil
 }
 
-// RemoveConnection removes a single connection from the list of connections for the given user.
+// RemoveConnection removes a single connection from the list of connections for the given user of the given tenant.
 // If the last connection is removed, it also removes the user from the in-memory map and from Redis.
 // It is safe to call this function concurrently from multiple goroutines.
-func RemoveConnection(userId string, conn *websocket.Conn) {
+func RemoveConnection(tenantId string, userId string, conn *websocket.Conn) {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "RemoveConnection",
 		Message:   "Removing connection for userId: " + userId,
 		UserId:    userId,
 	})
+	key := clientKey(tenantId, userId)
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
 
-	conns, exists := clients[userId]
+	if session, ok := connections[conn]; ok {
+		delete(connections, conn)
+		_ = config.RDB.HDel(config.Ctx, "connections:"+key, session.ConnectionId).Err()
+	}
+	conns, exists := clients[key]
 	if !exists {
 		logger.Log.Warn(logger.LogPayload{
 			Component: "Client Store",
@@ -118,8 +170,9 @@ func RemoveConnection(userId string, conn *websocket.Conn) {
 
 	if len(remaining) == 0 {
 		// No connections left, clean up completely
-		delete(clients, userId)
-		_ = config.RDB.Del(config.Ctx, "client:"+userId).Err()
+		delete(clients, key)
+		_ = config.RDB.Del(config.Ctx, "client:"+key).Err()
+		setLastSeen(key)
 		logger.Log.Info(logger.LogPayload{
 			Component: "Client Store",
 			Operation: "RemoveConnection",
@@ -127,7 +180,7 @@ func RemoveConnection(userId string, conn *websocket.Conn) {
 			UserId:    userId,
 		})
 	} else {
-		clients[userId] = remaining
+		clients[key] = remaining
 		logger.Log.Debug(logger.LogPayload{
 			Component: "Client Store",
 			Operation: "RemoveConnection",
@@ -137,17 +190,165 @@ func RemoveConnection(userId string, conn *websocket.Conn) {
 	}
 }
 
-// GetClientInfo fetches the client information from Redis by the given user ID.
+// CloseSessions closes the connections of the given user of the given tenant opened with a token of the
+// given session, or every connection of the user when sessionId is empty. It returns the number of connections closed.
+// The connections are removed from the store by their read loop once closed.
+// It is safe to call this function concurrently from multiple goroutines.
+func CloseSessions(tenantId string, userId string, sessionId string) int {
+	clientsMutex.RLock()
+	var closing []*websocket.Conn
+	for _, conn := range clients[clientKey(tenantId, userId)] {
+		if sessionId == "" || connections[conn].SessionId == sessionId {
+			closing = append(closing, conn)
+		}
+	}
+	clientsMutex.RUnlock()
+
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked")
+	for _, conn := range closing {
+		_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		conn.Close()
+	}
+	if len(closing) > 0 {
+		logger.Log.Info(logger.LogPayload{
+			Component: "Client Store",
+			Operation: "CloseSessions",
+			Message:   fmt.Sprintf("Closed %d revoked connections for userId: %s", len(closing), userId),
+			UserId:    userId,
+		})
+	}
+	return len(closing)
+}
+
+// SetConnectionSession replaces the session of a connection after it was reauthenticated with a new token.
+// It is safe to call this function concurrently from multiple goroutines.
+func SetConnectionSession(conn *websocket.Conn, sessionId string) {
+	clientsMutex.Lock()
+	session, ok := connections[conn]
+	if ok {
+		session.SessionId = sessionId
+		connections[conn] = session
+	}
+	clientsMutex.Unlock()
+	if ok {
+		storeSession(session)
+	}
+}
+
+// FindSessions returns the open connections of a user of a tenant, on every replica.
+func FindSessions(tenantId string, userId string) ([]data.ConnectedSession, error) {
+	return findSessions("connections:" + clientKey(tenantId, userId))
+}
+
+// FindAllSessions returns the open connections of every user of a tenant, on every replica.
+func FindAllSessions(tenantId string) ([]data.ConnectedSession, error) {
+	sessions := []data.ConnectedSession{}
+	iterator := config.RDB.Scan(config.Ctx, 0, "connections:"+tenantId+":*", 1000).Iterator()
+	for iterator.Next(config.Ctx) {
+		userSessions, err := findSessions(iterator.Val())
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, userSessions...)
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// findSessions reads the open connections recorded in the given Redis hash.
+func findSessions(key string) ([]data.ConnectedSession, error) {
+	values, err := config.RDB.HVals(config.Ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []data.ConnectedSession{}
+	for _, value := range values {
+		var session data.ConnectedSession
+		if err := json.Unmarshal([]byte(value), &session); err == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// storeSession records an open connection in Redis, so that the connections of every replica can be listed.
+// The records expire after a day without updates, in case a replica stops without removing them.
+func storeSession(session data.ConnectedSession) {
+	value, _ := json.Marshal(session)
+	key := "connections:" + clientKey(session.TenantId, session.UserId)
+	pipe := config.RDB.TxPipeline()
+	pipe.HSet(config.Ctx, key, session.ConnectionId, value)
+	pipe.Expire(config.Ctx, key, connectionTTL)
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Client Store",
+			Operation: "StoreSession",
+			Message:   "Failed to record connection for userId: " + session.UserId,
+			Error:     err,
+			UserId:    session.UserId,
+		})
+	}
+}
+
+// SendToConnection sends a payload to a single connection. The clients map is locked for writing,
+// so that the payload is not written concurrently with the payloads sent to the user.
+// It is safe to call this function concurrently from multiple goroutines.
+func SendToConnection(conn *websocket.Conn, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetWriteDeadline(time.Time{})
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// IsConnected reports whether the given user of the given tenant has at least one open websocket connection.
+// It is safe to call this function concurrently from multiple goroutines.
+func IsConnected(tenantId string, userId string) bool {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return len(clients[clientKey(tenantId, userId)]) > 0
+}
+
+// GetLastSeen returns the time the given user of the given tenant closed their last websocket connection.
+// It returns redis.Nil if the user has never been seen or was last seen before lastSeenTTL.
+func GetLastSeen(tenantId string, userId string) (time.Time, error) {
+	val, err := config.RDB.Get(config.Ctx, "lastSeen:"+clientKey(tenantId, userId)).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// setLastSeen records the current time as the last time the user of the given client key was connected.
+func setLastSeen(key string) {
+	err := config.RDB.Set(config.Ctx, "lastSeen:"+key, time.Now().Format(time.RFC3339), lastSeenTTL).Err()
+	if err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Client Store",
+			Operation: "SetLastSeen",
+			Message:   "Failed to store last seen time for client: " + key,
+			Error:     err,
+		})
+	}
+}
+
+// GetClientInfo fetches the client information from Redis by the given tenant and user ID.
 // It returns the models.ClientInfo struct and an error if the client does not exist.
 // It is safe to call this function concurrently from multiple goroutines.
-func GetClientInfo(id string) (models.ClientInfo, error) {
+func GetClientInfo(tenantId string, id string) (models.ClientInfo, error) {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "GetClientInfo",
 		Message:   "Fetching client info for clientID: " + id,
 		UserId:    id,
 	})
-	val, err := config.RDB.Get(config.Ctx, "client:"+id).Result()
+	val, err := config.RDB.Get(config.Ctx, "client:"+clientKey(tenantId, id)).Result()
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Client Store",
@@ -179,7 +380,7 @@ func GetClientInfo(id string) (models.ClientInfo, error) {
 }
 
 // UpdateClientInfo updates the client information stored in Redis for the given ClientInfo.
-// It serializes the ClientInfo struct to JSON and stores it under the key "client:<ID>".
+// It serializes the ClientInfo struct to JSON and stores it under the key "client:<TenantId>:<ID>".
 // Returns an error if the operation fails.
 func UpdateClientInfo(info models.ClientInfo) error {
 	logger.Log.Debug(logger.LogPayload{
@@ -189,7 +390,7 @@ func UpdateClientInfo(info models.ClientInfo) error {
 		UserId:    info.ID,
 	})
 	data, _ := json.Marshal(info)
-	err := config.RDB.Set(config.Ctx, "client:"+info.ID, data, 0).Err()
+	err := config.RDB.Set(config.Ctx, "client:"+clientKey(info.TenantId, info.ID), data, 0).Err()
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Client Store",
@@ -215,48 +416,48 @@ func UpdateClientInfo(info models.ClientInfo) error {
 // If bypassStatusCheck is true, it will skip the notification status check.
 // notifications, the function will return an error.
 func SendNotificationToUser(payload data.EventNotification, bypassStatusCheck bool) error {
-	return sendToUser(payload.Data.UserID, payload, bypassStatusCheck)
+	return sendToUser(config.ResolveTenant(payload.Data.TenantId), payload.Data.UserID, payload, bypassStatusCheck)
 }
 
-// SendConfigurationToUser sends the user configuration to the user identified by the UserIdD field
+// SendConfigurationToUser sends the user configuration to the user of the given tenant identified by the UserIdD field
 // in the given data.Configuration struct. If bypassNotificationCheck is true, the function will not
 // check the user's notification status before sending the configuration. Otherwise, it will check
 // the user's notification status and return an error if notifications are disabled.
-func SendConfigurationToUser(payload data.Configuration, bypassNotificationCheck bool) error {
-	return sendToUser(payload.Data.UserID, payload, bypassNotificationCheck)
+func SendConfigurationToUser(tenantId string, payload data.Configuration, bypassNotificationCheck bool) error {
+	return sendToUser(tenantId, payload.Data.UserID, payload, bypassNotificationCheck)
 }
 
-// SendNotificationListToUser sends a list of notifications to a user identified by the given userID.
+// SendNotificationListToUser sends a list of notifications to a user of the given tenant identified by the given userID.
 // It uses the NotificationList struct to encapsulate the notifications data.
 // The function will check the user's notification status before sending.
 // If bypassStatusCheck is true, it will skip the notification status check.
 // Returns an error if the user is not connected or if notifications are disabled.
-func SendNotificationListToUser(userID string, notifications data.NotificationList, bypassStatusCheck bool) error {
-	return sendToUser(userID, notifications, bypassStatusCheck)
+func SendNotificationListToUser(tenantId string, userID string, notifications data.NotificationList, bypassStatusCheck bool) error {
+	return sendToUser(tenantId, userID, notifications, bypassStatusCheck)
 }
 
-// getConnAndInfo retrieves the websocket connections and the client information for the given user ID.
+// getConnAndInfo retrieves the websocket connections and the client information for the given tenant and user ID.
 // If the user is not connected, it returns an error. Otherwise, it returns the connections and the client
 // information.
-func getConnAndInfo(userID string) ([]*websocket.Conn, *models.ClientInfo, error) {
-	conns, ok := clients[userID]
+func getConnAndInfo(tenantId string, userID string) ([]*websocket.Conn, *models.ClientInfo, error) {
+	conns, ok := clients[clientKey(tenantId, userID)]
 	if !ok {
 		return nil, nil, errors.New("user not connected")
 	}
-	clientInfo, err := GetClientInfo(userID)
+	clientInfo, err := GetClientInfo(tenantId, userID)
 	if err != nil {
 		return nil, nil, err
 	}
 	return conns, &clientInfo, nil
 }
 
-// sendToUser sends a payload to all active websocket connections for a specified user.
+// sendToUser sends a payload to all active websocket connections for a specified user of a tenant.
 // It locks the clients map for reading and retrieves the user's connections and client information.
 // If notifications are disabled for the user and bypassNotificationCheck is false, it returns an error.
 // It serializes the payload to JSON and attempts to write it to each connection.
 // Connections that fail to receive the message are removed from the active list.
 // Returns an error if the user is not connected or if JSON marshalling fails.
-func sendToUser(userID string, payload interface{}, bypassNotificationCheck bool) error {
+func sendToUser(tenantId string, userID string, payload interface{}, bypassNotificationCheck bool) error {
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "SendToUser",
@@ -265,7 +466,7 @@ func sendToUser(userID string, payload interface{}, bypassNotificationCheck bool
 	})
 	clientsMutex.RLock()
 	defer clientsMutex.RUnlock()
-	conns, clientInfo, err := getConnAndInfo(userID)
+	conns, clientInfo, err := getConnAndInfo(tenantId, userID)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Client Store",
@@ -312,7 +513,7 @@ func sendToUser(userID string, payload interface{}, bypassNotificationCheck bool
 		activeConns = append(activeConns, conn)
 	}
 	// Update with only active connections
-	clients[userID] = activeConns
+	clients[clientKey(tenantId, userID)] = activeConns
 	logger.Log.Debug(logger.LogPayload{
 		Component: "Client Store",
 		Operation: "SendToUser",
@@ -3,13 +3,25 @@ package configurationService
 import (
 	"r2-notify-server/data"
 	"r2-notify-server/models"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ConfigurationService interface {
+	ForTenant(tenantId string) ConfigurationService
+	As(actor data.AuditActor) ConfigurationService
 	FindByAppAndUser(userId string) (configuration data.Configuration, err error)
 	Create(configuration models.Configuration) (primitive.ObjectID, error)
 	Update(configuration models.Configuration) error
+	SetNotificationStatus(userId string, enabled bool) error
+	SetEmail(userId string, email string) error
+	SetEmailNotificationStatus(userId string, enabled bool) error
+	SetDigestNotificationStatus(userId string, enabled bool) error
+	FindDigestDue(before time.Time) (configurations []data.NotificationConfig, err error)
+	AdvanceDigestCursor(userId string, previous time.Time, next time.Time) (bool, error)
+	SetChatTargets(userId string, targets []data.ChatTarget) error
+	FindAppChatTargets(appId string) (targets []data.ChatTarget, err error)
+	SetAppChatTargets(appId string, targets []data.ChatTarget) error
 	Delete(userId string) error
 }
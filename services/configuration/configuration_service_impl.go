@@ -2,34 +2,63 @@ package configurationService
 
 import (
 	"errors"
+	"r2-notify-server/config"
 	"r2-notify-server/data"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
 	configurationRepository "r2-notify-server/repository/configuration"
+	auditService "r2-notify-server/services/audit"
+	"r2-notify-server/utils"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ConfigurationServiceImpl struct {
 	ConfigurationRepository configurationRepository.ConfigurationRepository
+	AuditService            auditService.AuditService
 	Validate                *validator.Validate
+	TenantId                string
+	Actor                   data.AuditActor
 }
 
 // NewConfigurationServiceImpl returns a new instance of ConfigurationService, which is used to manage application configurations of users.
 // The first parameter is the ConfigurationRepository, which is used to interact with the database to store and retrieve the configurations.
-// The second parameter is an instance of validator.Validate, which is used to validate the configuration struct before saving to or retrieving from the database.
-// If the second parameter is nil, the function will return an error.
-func NewConfigurationServiceImpl(configurationRepository configurationRepository.ConfigurationRepository, validate *validator.Validate) (service ConfigurationService, err error) {
+// The second parameter is the AuditService, which records every change of a configuration in the audit log.
+// The third parameter is an instance of validator.Validate, which is used to validate the configuration struct before saving to or retrieving from the database.
+// If the second or third parameter is nil, the function will return an error.
+func NewConfigurationServiceImpl(configurationRepository configurationRepository.ConfigurationRepository, auditService auditService.AuditService, validate *validator.Validate) (service ConfigurationService, err error) {
+	if auditService == nil {
+		return nil, errors.New("audit service cannot be nil")
+	}
 	if validate == nil {
 		return nil, errors.New("validator instance cannot be nil")
 	}
 	return &ConfigurationServiceImpl{
 		ConfigurationRepository: configurationRepository,
+		AuditService:            auditService,
 		Validate:                validate,
+		TenantId:                config.LoadConfig().DefaultTenant,
 	}, err
 }
 
+// ForTenant returns the service of the configurations of the given tenant, the default tenant when it is empty.
+func (t ConfigurationServiceImpl) ForTenant(tenantId string) ConfigurationService {
+	t.ConfigurationRepository = t.ConfigurationRepository.ForTenant(tenantId)
+	t.TenantId = config.ResolveTenant(tenantId)
+	return &t
+}
+
+// As returns the service recording the configuration changes in the audit log on behalf of the given actor.
+// Changes made by a service without actor are recorded as made by the system.
+func (t ConfigurationServiceImpl) As(actor data.AuditActor) ConfigurationService {
+	t.Actor = actor
+	return &t
+}
+
 // FindByAppAndUser retrieves the configuration for a specific user based on their user ID.
 // It returns a data.Configuration object containing the user's configuration details,
 // including the configuration ID, user ID, and notification enablement status.
@@ -55,11 +84,7 @@ func (t ConfigurationServiceImpl) FindByAppAndUser(userId string) (data.Configur
 
 	configuration := data.Configuration{
 		Event: data.Event{Event: data.LIST_CONFIGURATIONS},
-		Data: data.NotificationConfig{
-			Id:                 result.Id.Hex(),
-			UserID:             result.UserId,
-			EnableNotification: result.EnableNotifications,
-		},
+		Data:  toNotificationConfig(result),
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Configuration Service",
@@ -90,6 +115,10 @@ func (t *ConfigurationServiceImpl) Create(configuration models.Configuration) (p
 		})
 		return primitive.NilObjectID, err
 	}
+	t.audit("Create", map[string]string{
+		"userId":              configuration.UserId,
+		"enableNotifications": strconv.FormatBool(configuration.EnableNotifications),
+	})
 	logger.Log.Info(logger.LogPayload{
 		Component: "Configuration Service",
 		Operation: "Create",
@@ -119,6 +148,10 @@ func (t *ConfigurationServiceImpl) Update(configuration models.Configuration) er
 		})
 		return err
 	}
+	t.audit("Update", map[string]string{
+		"userId":              configuration.UserId,
+		"enableNotifications": strconv.FormatBool(configuration.EnableNotifications),
+	})
 	logger.Log.Info(logger.LogPayload{
 		Component: "Configuration Service",
 		Operation: "Update",
@@ -128,6 +161,130 @@ func (t *ConfigurationServiceImpl) Update(configuration models.Configuration) er
 	return nil
 }
 
+// SetNotificationStatus enables or disables notifications for the given user without
+// touching any other configuration field. It returns an error if the update fails.
+func (t *ConfigurationServiceImpl) SetNotificationStatus(userId string, enabled bool) error {
+	return t.patch("SetNotificationStatus", userId, bson.M{"enableNotifications": enabled}, map[string]string{"enableNotifications": strconv.FormatBool(enabled)})
+}
+
+// SetEmail stores the email address of the given user, as captured at login, so that it can be
+// used by the email channel. It returns an error if the update fails. The address itself is not
+// recorded in the audit log.
+func (t *ConfigurationServiceImpl) SetEmail(userId string, email string) error {
+	if err := t.Validate.Var(email, "required,email"); err != nil {
+		return err
+	}
+	return t.patch("SetEmail", userId, bson.M{"email": email}, nil)
+}
+
+// SetEmailNotificationStatus opts the given user in or out of email delivery for notifications
+// received while offline. It returns an error if the update fails.
+func (t *ConfigurationServiceImpl) SetEmailNotificationStatus(userId string, enabled bool) error {
+	return t.patch("SetEmailNotificationStatus", userId, bson.M{"emailNotifications": enabled}, map[string]string{"emailNotifications": strconv.FormatBool(enabled)})
+}
+
+// SetDigestNotificationStatus opts the given user in or out of periodic digests of unread
+// notifications. Opting in starts the first digest window at the current time.
+func (t *ConfigurationServiceImpl) SetDigestNotificationStatus(userId string, enabled bool) error {
+	fields := bson.M{"digestNotifications": enabled}
+	if enabled {
+		fields["digestCursor"] = time.Now()
+	}
+	return t.patch("SetDigestNotificationStatus", userId, fields, map[string]string{"digestNotifications": strconv.FormatBool(enabled)})
+}
+
+// FindDigestDue returns the configurations of the users whose digest window ended before the
+// given time.
+func (t *ConfigurationServiceImpl) FindDigestDue(before time.Time) ([]data.NotificationConfig, error) {
+	result, err := t.ConfigurationRepository.FindDigestDue(before)
+	if err != nil {
+		return nil, err
+	}
+	configurations := []data.NotificationConfig{}
+	for _, value := range result {
+		configurations = append(configurations, toNotificationConfig(value))
+	}
+	return configurations, nil
+}
+
+// AdvanceDigestCursor moves the digest cursor of the given user from previous to next and
+// returns true if this call won the update.
+func (t *ConfigurationServiceImpl) AdvanceDigestCursor(userId string, previous time.Time, next time.Time) (bool, error) {
+	return t.ConfigurationRepository.AdvanceDigestCursor(userId, previous, next)
+}
+
+// SetChatTargets replaces the Slack and Teams incoming-webhook targets of the given user.
+// It returns an error if a target is invalid, its URL is forbidden or the update fails.
+func (t *ConfigurationServiceImpl) SetChatTargets(userId string, targets []data.ChatTarget) error {
+	if err := t.validateChatTargets(targets); err != nil {
+		return err
+	}
+	return t.patch("SetChatTargets", userId, bson.M{"chatTargets": toChatTargetModels(targets)}, map[string]string{"chatTargets": strconv.Itoa(len(targets))})
+}
+
+// FindAppChatTargets returns the Slack and Teams incoming-webhook targets of the given app.
+func (t *ConfigurationServiceImpl) FindAppChatTargets(appId string) ([]data.ChatTarget, error) {
+	configuration, err := t.ConfigurationRepository.FindAppConfiguration(appId)
+	if err != nil {
+		return nil, err
+	}
+	return toChatTargets(configuration.ChatTargets), nil
+}
+
+// SetAppChatTargets replaces the Slack and Teams incoming-webhook targets of the given app.
+// It returns an error if a target is invalid, its URL is forbidden or the update fails.
+func (t *ConfigurationServiceImpl) SetAppChatTargets(appId string, targets []data.ChatTarget) error {
+	if err := t.validateChatTargets(targets); err != nil {
+		return err
+	}
+	if err := t.ConfigurationRepository.SetAppChatTargets(appId, toChatTargetModels(targets)); err != nil {
+		return err
+	}
+	t.audit("SetAppChatTargets", map[string]string{"appId": appId, "chatTargets": strconv.Itoa(len(targets))})
+	return nil
+}
+
+// validateChatTargets validates the chat targets and checks their URLs with utils.ValidateOutboundUrl,
+// so that targets can not be used to make the server post to internal services.
+func (t *ConfigurationServiceImpl) validateChatTargets(targets []data.ChatTarget) error {
+	if err := t.Validate.Struct(data.ChatTargetsRequest{ChatTargets: targets}); err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := utils.ValidateOutboundUrl(target.Url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patch updates the given configuration fields of a user and logs the outcome for the operation.
+// The change is recorded in the audit log with the given values.
+func (t *ConfigurationServiceImpl) patch(operation string, userId string, fields bson.M, values map[string]string) error {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Configuration Service",
+		Operation: operation,
+		Message:   "Updating configuration for userId: " + userId,
+		UserId:    userId,
+	})
+	if err := t.ConfigurationRepository.Patch(userId, fields); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Configuration Service",
+			Operation: operation,
+			Message:   "Failed to update configuration for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	scope := map[string]string{"userId": userId}
+	for key, value := range values {
+		scope[key] = value
+	}
+	t.audit(operation, scope)
+	return nil
+}
+
 // Delete deletes the configuration for a user identified by the configuration's UserId field.
 // It returns an error if the deletion fails.
 func (t *ConfigurationServiceImpl) Delete(userId string) error {
@@ -148,6 +305,7 @@ func (t *ConfigurationServiceImpl) Delete(userId string) error {
 		})
 		return err
 	}
+	t.audit("Delete", map[string]string{"userId": userId})
 	logger.Log.Info(logger.LogPayload{
 		Component: "Configuration Service",
 		Operation: "Delete",
@@ -156,3 +314,41 @@ func (t *ConfigurationServiceImpl) Delete(userId string) error {
 	})
 	return nil
 }
+
+// audit records a change of configuration in the audit log, on behalf of the actor of the service.
+func (t *ConfigurationServiceImpl) audit(operation string, scope map[string]string) {
+	t.AuditService.RecordMutation(t.Actor, t.TenantId, "configuration."+operation, scope)
+}
+
+func toNotificationConfig(configuration models.Configuration) data.NotificationConfig {
+	result := data.NotificationConfig{
+		Id:                 configuration.Id.Hex(),
+		UserID:             configuration.UserId,
+		EnableNotification: configuration.EnableNotifications,
+		Email:              configuration.Email,
+		EmailNotification:  configuration.EmailNotifications,
+		DigestNotification: configuration.DigestNotifications,
+		ChatTargets:        toChatTargets(configuration.ChatTargets),
+	}
+	if !configuration.DigestCursor.IsZero() {
+		cursor := configuration.DigestCursor
+		result.DigestCursor = &cursor
+	}
+	return result
+}
+
+func toChatTargets(targets []models.ChatTarget) []data.ChatTarget {
+	result := []data.ChatTarget{}
+	for _, target := range targets {
+		result = append(result, data.ChatTarget{Type: target.Type, Url: target.Url, Statuses: target.Statuses})
+	}
+	return result
+}
+
+func toChatTargetModels(targets []data.ChatTarget) []models.ChatTarget {
+	result := []models.ChatTarget{}
+	for _, target := range targets {
+		result = append(result, models.ChatTarget{Type: target.Type, Url: target.Url, Statuses: target.Statuses})
+	}
+	return result
+}
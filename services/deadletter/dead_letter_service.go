@@ -0,0 +1,26 @@
+package deadLetterService
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+)
+
+// ErrInvalidEvent marks processing errors caused by the event itself, such as a malformed body.
+// Dead letters failing with it are rejected instead of being retried automatically.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// Processor turns the raw body of an event received from the given source into a notification.
+type Processor interface {
+	Process(source string, body []byte) error
+}
+
+type DeadLetterService interface {
+	Record(deadLetter models.DeadLetter, cause error) error
+	FindDeadLetters(status string) (deadLetters []data.DeadLetter, err error)
+	FindById(id string) (deadLetter data.DeadLetter, err error)
+	Replay(id string) (deadLetter data.DeadLetter, err error)
+	Discard(id string) error
+	Start(ctx context.Context, processor Processor)
+}
@@ -0,0 +1,248 @@
+package deadLetterService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxRetryDelay caps the exponential backoff between two processing attempts.
+const maxRetryDelay = time.Hour
+
+// attemptLease is how long a claimed dead letter is hidden from other replicas while it is retried.
+const attemptLease = time.Minute
+
+type DeadLetterServiceImpl struct {
+	DeadLetterRepository deadLetterRepository.DeadLetterRepository
+	wake                 chan struct{}
+}
+
+// NewDeadLetterServiceImpl returns a new instance of DeadLetterService with the provided
+// DeadLetterRepository. If the repository is nil, an error is returned. Dead letters are only
+// retried once Start has been called.
+func NewDeadLetterServiceImpl(deadLetterRepository deadLetterRepository.DeadLetterRepository) (service DeadLetterService, err error) {
+	if deadLetterRepository == nil {
+		return nil, errors.New("dead letter repository cannot be nil")
+	}
+	return &DeadLetterServiceImpl{
+		DeadLetterRepository: deadLetterRepository,
+		wake:                 make(chan struct{}, 1),
+	}, nil
+}
+
+// Record stores an ingestion event that failed. Attempts defaults to 1 and may be set by sources
+// that already redelivered the event, which then counts towards the retry budget. Events rejected with
+// ErrInvalidEvent are kept for inspection only, any other failure is retried with backoff.
+// Failures to store the dead letter are logged together with the body and returned, so that
+// sources able to redeliver the event can hold it back.
+func (t *DeadLetterServiceImpl) Record(deadLetter models.DeadLetter, cause error) error {
+	deadLetter.Attempts = max(deadLetter.Attempts, 1)
+	deadLetter.Error = cause.Error()
+	deadLetter.CreatedAt = time.Now()
+	deadLetter.UpdatedAt = time.Now()
+	switch {
+	case errors.Is(cause, ErrInvalidEvent):
+		deadLetter.Status = data.DEAD_LETTER_REJECTED
+	case deadLetter.Attempts >= config.LoadConfig().DeadLetterMaxAttempts:
+		deadLetter.Status = data.DEAD_LETTER_FAILED
+	default:
+		deadLetter.Status = data.DEAD_LETTER_PENDING
+		deadLetter.NextAttemptAt = time.Now().Add(retryDelay(deadLetter.Attempts))
+	}
+
+	id, err := t.DeadLetterRepository.Create(deadLetter)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Dead Letter Service",
+			Operation: "Record",
+			Message:   fmt.Sprintf("Failed to store dead letter from %s, caused by %s: %s", deadLetter.Source, cause, deadLetter.Body),
+			Error:     err,
+		})
+		return err
+	}
+	logger.Log.Warn(logger.LogPayload{
+		Component: "Dead Letter Service",
+		Operation: "Record",
+		Message:   fmt.Sprintf("Stored dead letter %s from %s, status: %s", id.Hex(), deadLetter.Source, deadLetter.Status),
+		Error:     cause,
+	})
+	return nil
+}
+
+// FindDeadLetters returns the most recent dead letters, optionally filtered by status.
+func (t *DeadLetterServiceImpl) FindDeadLetters(status string) ([]data.DeadLetter, error) {
+	result, err := t.DeadLetterRepository.FindDeadLetters(status)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := []data.DeadLetter{}
+	for _, value := range result {
+		deadLetters = append(deadLetters, toDeadLetterData(value))
+	}
+	return deadLetters, nil
+}
+
+// FindById returns a single dead letter, including its raw body.
+func (t *DeadLetterServiceImpl) FindById(id string) (data.DeadLetter, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return data.DeadLetter{}, err
+	}
+	deadLetter, err := t.DeadLetterRepository.FindById(objectId)
+	if err != nil {
+		return data.DeadLetter{}, err
+	}
+	return toDeadLetterData(deadLetter), nil
+}
+
+// Replay queues a new attempt of a dead letter that was not resolved yet, whatever its status.
+// The attempt is made by the worker of any replica.
+func (t *DeadLetterServiceImpl) Replay(id string) (data.DeadLetter, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return data.DeadLetter{}, err
+	}
+	deadLetter, err := t.DeadLetterRepository.Requeue(objectId, time.Now())
+	if err != nil {
+		return data.DeadLetter{}, err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Dead Letter Service",
+		Operation: "Replay",
+		Message:   "Queued replay of dead letter " + id,
+	})
+	t.notifyWorker()
+	return toDeadLetterData(deadLetter), nil
+}
+
+// Discard removes a dead letter without processing it.
+func (t *DeadLetterServiceImpl) Discard(id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	return t.DeadLetterRepository.Delete(objectId)
+}
+
+// Start runs the retry worker until the context is cancelled. The worker retries due dead
+// letters with the given processor on every poll interval and whenever a replay is queued.
+func (t *DeadLetterServiceImpl) Start(ctx context.Context, processor Processor) {
+	ticker := time.NewTicker(time.Duration(config.LoadConfig().DeadLetterPollInterval) * time.Second)
+	defer ticker.Stop()
+	logger.Log.Info(logger.LogPayload{
+		Component: "Dead Letter Service",
+		Operation: "Start",
+		Message:   "Dead letter worker started",
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(logger.LogPayload{
+				Component: "Dead Letter Service",
+				Operation: "Start",
+				Message:   "Dead letter worker stopped",
+			})
+			return
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.processDueDeadLetters(ctx, processor)
+	}
+}
+
+// notifyWorker wakes the retry worker without blocking if a wake up is already pending.
+func (t *DeadLetterServiceImpl) notifyWorker() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processDueDeadLetters claims and retries due dead letters until none are left.
+func (t *DeadLetterServiceImpl) processDueDeadLetters(ctx context.Context, processor Processor) {
+	for ctx.Err() == nil {
+		deadLetter, err := t.DeadLetterRepository.ClaimDue(time.Now(), time.Now().Add(attemptLease))
+		if err != nil {
+			return
+		}
+		t.attempt(deadLetter, processor)
+	}
+}
+
+// attempt processes the body of a dead letter again and records the outcome. Failed attempts
+// are rescheduled with exponential backoff until the configured maximum number of attempts.
+func (t *DeadLetterServiceImpl) attempt(deadLetter models.DeadLetter, processor Processor) {
+	deadLetter.Attempts++
+	deadLetter.UpdatedAt = time.Now()
+
+	err := processor.Process(deadLetter.Source, []byte(deadLetter.Body))
+	switch {
+	case err == nil:
+		deadLetter.Status = data.DEAD_LETTER_RESOLVED
+	case errors.Is(err, ErrInvalidEvent):
+		deadLetter.Status = data.DEAD_LETTER_REJECTED
+		deadLetter.Error = err.Error()
+	case deadLetter.Attempts >= config.LoadConfig().DeadLetterMaxAttempts:
+		deadLetter.Status = data.DEAD_LETTER_FAILED
+		deadLetter.Error = err.Error()
+	default:
+		deadLetter.Status = data.DEAD_LETTER_PENDING
+		deadLetter.Error = err.Error()
+		deadLetter.NextAttemptAt = time.Now().Add(retryDelay(deadLetter.Attempts))
+	}
+
+	if err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Dead Letter Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Dead letter %s attempt %d failed, status: %s", deadLetter.Id.Hex(), deadLetter.Attempts, deadLetter.Status),
+			Error:     err,
+		})
+	} else {
+		logger.Log.Info(logger.LogPayload{
+			Component: "Dead Letter Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Dead letter %s resolved after %d attempt(s)", deadLetter.Id.Hex(), deadLetter.Attempts),
+		})
+	}
+	_ = t.DeadLetterRepository.Update(deadLetter)
+}
+
+// retryDelay returns the backoff before the next attempt, doubling the configured base delay
+// after every failed attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(config.LoadConfig().DeadLetterRetryBaseDelay) * time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func toDeadLetterData(deadLetter models.DeadLetter) data.DeadLetter {
+	return data.DeadLetter{
+		Id:             deadLetter.Id.Hex(),
+		Source:         deadLetter.Source,
+		Topic:          deadLetter.Topic,
+		ConsumerGroup:  deadLetter.ConsumerGroup,
+		Partition:      deadLetter.Partition,
+		PartitionKey:   deadLetter.PartitionKey,
+		Offset:         deadLetter.Offset,
+		SequenceNumber: deadLetter.SequenceNumber,
+		EnqueuedAt:     deadLetter.EnqueuedAt,
+		Body:           deadLetter.Body,
+		Error:          deadLetter.Error,
+		Status:         deadLetter.Status,
+		Attempts:       deadLetter.Attempts,
+		NextAttemptAt:  deadLetter.NextAttemptAt,
+		CreatedAt:      deadLetter.CreatedAt,
+		UpdatedAt:      deadLetter.UpdatedAt,
+	}
+}
@@ -0,0 +1,97 @@
+package deadLetterService
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	deadLetterRepository "r2-notify-server/repository/deadletter"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubDeadLetterRepository stores dead letters in memory, or fails to store them when err is set.
+type stubDeadLetterRepository struct {
+	deadLetterRepository.DeadLetterRepository
+	err         error
+	deadLetters []models.DeadLetter
+}
+
+func (r *stubDeadLetterRepository) Create(deadLetter models.DeadLetter) (primitive.ObjectID, error) {
+	if r.err != nil {
+		return primitive.NilObjectID, r.err
+	}
+	r.deadLetters = append(r.deadLetters, deadLetter)
+	return primitive.NewObjectID(), nil
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		cause    error
+		attempts int
+		status   string
+	}{
+		{"invalid event is rejected", ErrInvalidEvent, 0, data.DEAD_LETTER_REJECTED},
+		{"failed event is retried", errors.New("mongo is down"), 0, data.DEAD_LETTER_PENDING},
+		{"redelivered event exhausts its retries", errors.New("mongo is down"), 100, data.DEAD_LETTER_FAILED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := &stubDeadLetterRepository{}
+			service, err := NewDeadLetterServiceImpl(repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := service.Record(models.DeadLetter{Source: data.SOURCE_KAFKA, Body: `{"message":"main is red"}`, Attempts: test.attempts}, test.cause); err != nil {
+				t.Fatal(err)
+			}
+			if len(repository.deadLetters) != 1 {
+				t.Fatalf("expected the dead letter to be stored, got %d", len(repository.deadLetters))
+			}
+			deadLetter := repository.deadLetters[0]
+			if deadLetter.Status != test.status || deadLetter.Error != test.cause.Error() || deadLetter.Attempts < 1 {
+				t.Fatalf("expected a %s dead letter, got %+v", test.status, deadLetter)
+			}
+			if (test.status == data.DEAD_LETTER_PENDING) == deadLetter.NextAttemptAt.IsZero() {
+				t.Fatalf("expected only pending dead letters to have a next attempt, got %s", deadLetter.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestRecordLogsTheBodyOfDeadLettersItFailsToStore(t *testing.T) {
+	sink := logger.NewTestSink(zapcore.DebugLevel)
+	previous := logger.Log
+	logger.Log = sink.Logger
+	t.Cleanup(func() { logger.Log = previous })
+	service, err := NewDeadLetterServiceImpl(&stubDeadLetterRepository{err: errors.New("mongo is down")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = service.Record(models.DeadLetter{Source: data.SOURCE_KAFKA, Body: `{"message":"main is red"}`}, ErrInvalidEvent)
+	if err == nil || err.Error() != "mongo is down" {
+		t.Fatalf("expected the error of the repository to be returned, got %v", err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(sink.Buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single log entry, got %s", sink.Buffer.String())
+	}
+	if entry["level"] != "error" || entry["component"] != "Dead Letter Service" || entry["operation"] != "Record" {
+		t.Fatalf("expected an error of the Record operation of the dead letter service, got %s", sink.Buffer.String())
+	}
+	if message, _ := entry["msg"].(string); !strings.Contains(message, `{"message":"main is red"}`) {
+		t.Fatalf("expected the body of the event to be logged, got %s", sink.Buffer.String())
+	}
+}
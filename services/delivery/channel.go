@@ -0,0 +1,24 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+)
+
+// Recipient describes the presence and preferences of the user a notification is addressed to,
+// resolved once per dispatch and shared by every channel.
+type Recipient struct {
+	UserId        string
+	Connected     bool
+	Configuration data.NotificationConfig
+}
+
+// Channel is a way of delivering a notification. Route tells the dispatcher when the channel is
+// considered (data.ROUTE_PRIMARY, data.ROUTE_FALLBACK or data.ROUTE_ON_REQUEST) and ReachesRecipient
+// whether a successful send counts as the notification being delivered to the user. Deliver
+// returns false without an error when the channel's own policy declines the notification.
+type Channel interface {
+	Name() string
+	Route() string
+	ReachesRecipient() bool
+	Deliver(notification data.Notification, recipient Recipient) (sent bool, err error)
+}
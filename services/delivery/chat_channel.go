@@ -0,0 +1,76 @@
+package deliveryService
+
+import (
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	chatService "r2-notify-server/services/chat"
+	configurationService "r2-notify-server/services/configuration"
+	"slices"
+	"strings"
+)
+
+type ChatChannel struct {
+	ChatService          chatService.ChatService
+	ConfigurationService configurationService.ConfigurationService
+}
+
+// NewChatChannel returns the primary channel which mirrors notifications to the Slack and Teams
+// incoming webhooks configured by the recipient and by the producing app.
+func NewChatChannel(chatService chatService.ChatService, configurationService configurationService.ConfigurationService) Channel {
+	return &ChatChannel{ChatService: chatService, ConfigurationService: configurationService}
+}
+
+func (t *ChatChannel) Name() string {
+	return data.CHANNEL_CHAT
+}
+
+func (t *ChatChannel) Route() string {
+	return data.ROUTE_PRIMARY
+}
+
+// ReachesRecipient is false because chat targets mirror notifications to shared rooms, so the
+// notification is still pending for the user.
+func (t *ChatChannel) ReachesRecipient() bool {
+	return false
+}
+
+// Deliver posts the notification to every chat target whose status filter matches. The
+// recipient's own targets are only used while the recipient has notifications enabled.
+func (t *ChatChannel) Deliver(notification data.Notification, recipient Recipient) (bool, error) {
+	if !config.LoadConfig().EnableChat {
+		return false, nil
+	}
+	targets := []data.ChatTarget{}
+	if recipient.Configuration.EnableNotification {
+		targets = append(targets, recipient.Configuration.ChatTargets...)
+	}
+	appTargets, err := t.ConfigurationService.ForTenant(notification.TenantId).FindAppChatTargets(notification.AppId)
+	if err != nil {
+		return false, err
+	}
+	targets = append(targets, appTargets...)
+
+	sent := false
+	var errs []error
+	for _, target := range targets {
+		if !matchesStatus(target, notification.Status) {
+			continue
+		}
+		if err := t.ChatService.Send(target, notification); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent = true
+	}
+	return sent, errors.Join(errs...)
+}
+
+// matchesStatus reports whether the target accepts notifications with the given status.
+// Targets without a status filter accept every notification.
+func matchesStatus(target data.ChatTarget, status string) bool {
+	if len(target.Statuses) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(target.Statuses, func(s string) bool { return strings.EqualFold(s, status) })
+}
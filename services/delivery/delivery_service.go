@@ -0,0 +1,9 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+)
+
+type DeliveryService interface {
+	Dispatch(notification data.Notification) []data.ChannelDelivery
+}
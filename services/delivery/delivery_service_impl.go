@@ -0,0 +1,148 @@
+package deliveryService
+
+import (
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	clientStore "r2-notify-server/services"
+	configurationService "r2-notify-server/services/configuration"
+	notificationService "r2-notify-server/services/notification"
+	"slices"
+	"time"
+)
+
+type DeliveryServiceImpl struct {
+	NotificationService  notificationService.NotificationService
+	ConfigurationService configurationService.ConfigurationService
+	channels             []Channel
+}
+
+// NewDeliveryServiceImpl returns a new instance of DeliveryService which routes notifications
+// over the given channels. Channels are attempted in the order they are given within each route.
+func NewDeliveryServiceImpl(notificationService notificationService.NotificationService, configurationService configurationService.ConfigurationService, channels ...Channel) (service DeliveryService, err error) {
+	if notificationService == nil || configurationService == nil {
+		return nil, errors.New("delivery service dependencies cannot be nil")
+	}
+	return &DeliveryServiceImpl{
+		NotificationService:  notificationService,
+		ConfigurationService: configurationService,
+		channels:             channels,
+	}, nil
+}
+
+// Dispatch delivers a stored notification to its recipient. Primary channels are attempted first;
+// fallback channels are attempted only when no primary channel reached the user and the notification
+// is not low priority; on-request channels are attempted only when the producer listed them. When the
+// producer lists channels, no other channel is used. The outcome of every attempted channel is
+// recorded on the notification, and the notification is marked as delivered if any channel that
+// reaches the user succeeded. The recipient is looked up in the tenant of the notification.
+func (t *DeliveryServiceImpl) Dispatch(notification data.Notification) []data.ChannelDelivery {
+	notification.TenantId = config.ResolveTenant(notification.TenantId)
+	recipient := t.recipient(notification.TenantId, notification.UserID)
+	deliveries := []data.ChannelDelivery{}
+	reached := false
+	for _, route := range []string{data.ROUTE_PRIMARY, data.ROUTE_FALLBACK, data.ROUTE_ON_REQUEST} {
+		for _, channel := range t.channels {
+			if channel.Route() != route || !t.selects(channel, notification, recipient, reached) {
+				continue
+			}
+			delivery := attempt(channel, notification, recipient)
+			if delivery.Status == data.CHANNEL_DELIVERY_SENT && channel.ReachesRecipient() {
+				reached = true
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Delivery Service",
+		Operation: "Dispatch",
+		Message:   "Dispatched notification " + notification.Id + " over " + describe(deliveries),
+		UserId:    notification.UserID,
+		AppId:     notification.AppId,
+	})
+	notificationService := t.NotificationService.ForTenant(notification.TenantId)
+	_ = notificationService.RecordDeliveries(notification.UserID, notification.Id, deliveries)
+	if reached {
+		_ = notificationService.MarkDelivered(notification.UserID, notification.Id)
+	}
+	return deliveries
+}
+
+// recipient resolves the presence and configuration of the given user of a tenant. If the configuration can
+// not be loaded, notifications are assumed to be enabled and each channel applies its own checks.
+func (t *DeliveryServiceImpl) recipient(tenantId string, userId string) Recipient {
+	recipient := Recipient{
+		UserId:        userId,
+		Connected:     clientStore.IsConnected(tenantId, userId),
+		Configuration: data.NotificationConfig{UserID: userId, EnableNotification: true},
+	}
+	configuration, err := t.ConfigurationService.ForTenant(tenantId).FindByAppAndUser(userId)
+	if err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Delivery Service",
+			Operation: "Recipient",
+			Message:   "Failed to load configuration, using defaults for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return recipient
+	}
+	recipient.Configuration = configuration.Data
+	return recipient
+}
+
+// selects reports whether the channel should be attempted for the notification.
+func (t *DeliveryServiceImpl) selects(channel Channel, notification data.Notification, recipient Recipient, reached bool) bool {
+	requested := len(notification.Channels) > 0
+	if requested && !slices.Contains(notification.Channels, channel.Name()) {
+		return false
+	}
+	switch channel.Route() {
+	case data.ROUTE_ON_REQUEST:
+		if !requested {
+			return false
+		}
+	case data.ROUTE_FALLBACK:
+		if reached || (!requested && notification.Priority == data.PRIORITY_LOW) {
+			return false
+		}
+	}
+	if channel.ReachesRecipient() && !recipient.Configuration.EnableNotification {
+		return false
+	}
+	return true
+}
+
+// attempt delivers the notification over one channel and describes the outcome.
+func attempt(channel Channel, notification data.Notification, recipient Recipient) data.ChannelDelivery {
+	delivery := data.ChannelDelivery{Channel: channel.Name(), AttemptedAt: time.Now()}
+	sent, err := channel.Deliver(notification, recipient)
+	switch {
+	case sent:
+		delivery.Status = data.CHANNEL_DELIVERY_SENT
+	case err != nil:
+		delivery.Status = data.CHANNEL_DELIVERY_FAILED
+	default:
+		delivery.Status = data.CHANNEL_DELIVERY_SKIPPED
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
+
+func describe(deliveries []data.ChannelDelivery) string {
+	if len(deliveries) == 0 {
+		return "no channel"
+	}
+	description := ""
+	for i, delivery := range deliveries {
+		if i > 0 {
+			description += ", "
+		}
+		description += delivery.Channel + "=" + delivery.Status
+	}
+	return description
+}
@@ -0,0 +1,32 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+	emailService "r2-notify-server/services/email"
+)
+
+type EmailChannel struct {
+	EmailService emailService.EmailService
+}
+
+// NewEmailChannel returns the fallback channel which emails notifications to offline recipients.
+func NewEmailChannel(emailService emailService.EmailService) Channel {
+	return &EmailChannel{EmailService: emailService}
+}
+
+func (t *EmailChannel) Name() string {
+	return data.CHANNEL_EMAIL
+}
+
+func (t *EmailChannel) Route() string {
+	return data.ROUTE_FALLBACK
+}
+
+func (t *EmailChannel) ReachesRecipient() bool {
+	return true
+}
+
+// Deliver emails the notification when the email service's offline policy allows it.
+func (t *EmailChannel) Deliver(notification data.Notification, recipient Recipient) (bool, error) {
+	return t.EmailService.NotifyOfflineUser(notification)
+}
@@ -0,0 +1,33 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+	pushService "r2-notify-server/services/push"
+)
+
+type PushChannel struct {
+	PushService pushService.PushService
+}
+
+// NewPushChannel returns the fallback channel which sends notifications to the browsers of
+// offline recipients through Web Push.
+func NewPushChannel(pushService pushService.PushService) Channel {
+	return &PushChannel{PushService: pushService}
+}
+
+func (t *PushChannel) Name() string {
+	return data.CHANNEL_PUSH
+}
+
+func (t *PushChannel) Route() string {
+	return data.ROUTE_FALLBACK
+}
+
+func (t *PushChannel) ReachesRecipient() bool {
+	return true
+}
+
+// Deliver pushes the notification to every browser subscription of an offline recipient.
+func (t *PushChannel) Deliver(notification data.Notification, recipient Recipient) (bool, error) {
+	return t.PushService.NotifyOfflineUser(notification)
+}
@@ -0,0 +1,57 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+	webhookService "r2-notify-server/services/webhook"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookChannel struct {
+	WebhookService webhookService.WebhookService
+}
+
+// NewWebhookChannel returns the on-request channel which forwards notifications to the webhook
+// subscriptions of the producing app as notification.dispatched events.
+func NewWebhookChannel(webhookService webhookService.WebhookService) Channel {
+	return &WebhookChannel{WebhookService: webhookService}
+}
+
+func (t *WebhookChannel) Name() string {
+	return data.CHANNEL_WEBHOOK
+}
+
+func (t *WebhookChannel) Route() string {
+	return data.ROUTE_ON_REQUEST
+}
+
+func (t *WebhookChannel) ReachesRecipient() bool {
+	return false
+}
+
+// Deliver queues a notification.dispatched event for the app's webhook subscriptions. The
+// event is delivered asynchronously by the webhook worker, which owns retries.
+func (t *WebhookChannel) Deliver(notification data.Notification, recipient Recipient) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(notification.Id)
+	if err != nil {
+		return false, err
+	}
+	t.WebhookService.Publish(data.WEBHOOK_EVENT_DISPATCHED, []models.Notification{{
+		Id:        id,
+		TenantId:  notification.TenantId,
+		AppId:     notification.AppId,
+		UserId:    notification.UserID,
+		GroupKey:  notification.GroupKey,
+		Title:     notification.Title,
+		ActionUrl: notification.ActionUrl,
+		Message:   notification.Message,
+		Status:    notification.Status,
+		Priority:  notification.Priority,
+		Channels:  notification.Channels,
+		Source:    notification.Source,
+		CreatedAt: notification.CreatedAt,
+		UpdatedAt: notification.UpdatedAt,
+	}})
+	return true, nil
+}
@@ -0,0 +1,39 @@
+package deliveryService
+
+import (
+	"r2-notify-server/data"
+	clientStore "r2-notify-server/services"
+)
+
+type WebSocketChannel struct{}
+
+// NewWebSocketChannel returns the primary channel which writes notifications to the open
+// websocket connections of the recipient.
+func NewWebSocketChannel() Channel {
+	return &WebSocketChannel{}
+}
+
+func (t *WebSocketChannel) Name() string {
+	return data.CHANNEL_WEBSOCKET
+}
+
+func (t *WebSocketChannel) Route() string {
+	return data.ROUTE_PRIMARY
+}
+
+func (t *WebSocketChannel) ReachesRecipient() bool {
+	return true
+}
+
+// Deliver sends the notification to the connected client. Recipients without an open
+// connection are skipped.
+func (t *WebSocketChannel) Deliver(notification data.Notification, recipient Recipient) (bool, error) {
+	if !recipient.Connected {
+		return false, nil
+	}
+	err := clientStore.SendNotificationToUser(data.EventNotification{
+		Event: data.Event{Event: data.NEW_NOTIFICATION},
+		Data:  notification,
+	}, false)
+	return err == nil, err
+}
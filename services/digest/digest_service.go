@@ -0,0 +1,10 @@
+package digestService
+
+import (
+	"context"
+)
+
+type DigestService interface {
+	Start(ctx context.Context)
+	SendDueDigests()
+}
@@ -0,0 +1,244 @@
+package digestService
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	configurationService "r2-notify-server/services/configuration"
+	emailService "r2-notify-server/services/email"
+	notificationService "r2-notify-server/services/notification"
+	"sort"
+	textTemplate "text/template"
+	"time"
+)
+
+//go:embed templates/digest.html templates/digest.txt
+var templateFiles embed.FS
+
+type DigestServiceImpl struct {
+	ConfigurationService configurationService.ConfigurationService
+	NotificationService  notificationService.NotificationService
+	EmailService         emailService.EmailService
+	htmlTemplate         *htmlTemplate.Template
+	textTemplate         *textTemplate.Template
+}
+
+type digestGroup struct {
+	GroupKey      string
+	Notifications []data.Notification
+}
+
+type digestApp struct {
+	AppId  string
+	Groups []digestGroup
+}
+
+type digestContent struct {
+	From  time.Time
+	To    time.Time
+	Total int
+	Apps  []digestApp
+}
+
+// NewDigestServiceImpl returns a new instance of DigestService which periodically emails each
+// opted-in user a summary of the notifications they have not read since their last digest.
+// It returns an error if the embedded digest templates can not be parsed.
+func NewDigestServiceImpl(configurationService configurationService.ConfigurationService, notificationService notificationService.NotificationService, emailService emailService.EmailService) (service DigestService, err error) {
+	if configurationService == nil || notificationService == nil || emailService == nil {
+		return nil, errors.New("digest service dependencies cannot be nil")
+	}
+	html, err := htmlTemplate.ParseFS(templateFiles, "templates/digest.html")
+	if err != nil {
+		return nil, err
+	}
+	text, err := textTemplate.ParseFS(templateFiles, "templates/digest.txt")
+	if err != nil {
+		return nil, err
+	}
+	return &DigestServiceImpl{
+		ConfigurationService: configurationService,
+		NotificationService:  notificationService,
+		EmailService:         emailService,
+		htmlTemplate:         html,
+		textTemplate:         text,
+	}, nil
+}
+
+// Start runs the digest scheduler until the context is cancelled. Due digests are sent on start
+// and then every DIGEST_CHECK_INTERVAL minutes. The scheduler does nothing unless ENABLE_DIGEST is set.
+func (t *DigestServiceImpl) Start(ctx context.Context) {
+	cfg := config.LoadConfig()
+	if !cfg.EnableDigest {
+		logger.Log.Info(logger.LogPayload{
+			Component: "Digest Service",
+			Operation: "Start",
+			Message:   "Digest scheduler is disabled",
+		})
+		return
+	}
+	ticker := time.NewTicker(time.Duration(cfg.DigestCheckInterval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		t.SendDueDigests()
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(logger.LogPayload{
+				Component: "Digest Service",
+				Operation: "Start",
+				Message:   "Digest scheduler stopped",
+			})
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDueDigests sends a digest to every opted-in user whose last digest is older than
+// DIGEST_INTERVAL hours, one tenant after the other.
+func (t *DigestServiceImpl) SendDueDigests() {
+	now := time.Now()
+	for _, tenantId := range config.Tenants() {
+		tenant := t.forTenant(tenantId)
+		due, err := tenant.ConfigurationService.FindDigestDue(now.Add(-time.Duration(config.LoadConfig().DigestInterval) * time.Hour))
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Digest Service",
+				Operation: "SendDueDigests",
+				Message:   "Failed to fetch users with a due digest in tenant: " + tenantId,
+				Error:     err,
+			})
+			continue
+		}
+		for _, configuration := range due {
+			tenant.sendDigest(configuration, now)
+		}
+	}
+}
+
+// forTenant returns a digest service reading the configurations and notifications of the given tenant.
+func (t *DigestServiceImpl) forTenant(tenantId string) *DigestServiceImpl {
+	return &DigestServiceImpl{
+		ConfigurationService: t.ConfigurationService.ForTenant(tenantId),
+		NotificationService:  t.NotificationService.ForTenant(tenantId),
+		EmailService:         t.EmailService,
+		htmlTemplate:         t.htmlTemplate,
+		textTemplate:         t.textTemplate,
+	}
+}
+
+// sendDigest claims the digest window of a user by moving their cursor to now, then emails the
+// unread notifications created in that window. Users with nothing new are skipped. If the mail
+// can not be sent the cursor is moved back so that the window is retried on the next run.
+func (t *DigestServiceImpl) sendDigest(configuration data.NotificationConfig, now time.Time) {
+	userId := configuration.UserID
+	var previous time.Time
+	if configuration.DigestCursor != nil {
+		previous = *configuration.DigestCursor
+	}
+	claimed, err := t.ConfigurationService.AdvanceDigestCursor(userId, previous, now)
+	if err != nil || !claimed {
+		return
+	}
+
+	notifications, err := t.NotificationService.FindUnreadBetween(userId, previous, now)
+	if err != nil {
+		t.releaseWindow(userId, previous, now)
+		return
+	}
+	if len(notifications) == 0 {
+		logger.Log.Debug(logger.LogPayload{
+			Component: "Digest Service",
+			Operation: "SendDigest",
+			Message:   "No new unread notifications, skipping digest for userId: " + userId,
+			UserId:    userId,
+		})
+		return
+	}
+
+	content := groupNotifications(notifications, previous, now)
+	var html, text bytes.Buffer
+	if err := t.htmlTemplate.Execute(&html, content); err != nil {
+		t.logRenderError(userId, err)
+		t.releaseWindow(userId, previous, now)
+		return
+	}
+	if err := t.textTemplate.Execute(&text, content); err != nil {
+		t.logRenderError(userId, err)
+		t.releaseWindow(userId, previous, now)
+		return
+	}
+
+	err = t.EmailService.Send(data.EmailMessage{
+		To:      configuration.Email,
+		Subject: fmt.Sprintf("You have %d unread notifications", content.Total),
+		Text:    text.String(),
+		Html:    html.String(),
+	})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Digest Service",
+			Operation: "SendDigest",
+			Message:   "Failed to send digest to userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		t.releaseWindow(userId, previous, now)
+		return
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Digest Service",
+		Operation: "SendDigest",
+		Message:   fmt.Sprintf("Sent digest of %d notifications to userId: %s", content.Total, userId),
+		UserId:    userId,
+	})
+}
+
+// releaseWindow moves the digest cursor of the user back to its previous value. Windows that
+// started without a cursor can not be released and are skipped.
+func (t *DigestServiceImpl) releaseWindow(userId string, previous time.Time, claimed time.Time) {
+	if previous.IsZero() {
+		return
+	}
+	_, _ = t.ConfigurationService.AdvanceDigestCursor(userId, claimed, previous)
+}
+
+func (t *DigestServiceImpl) logRenderError(userId string, err error) {
+	logger.Log.Error(logger.LogPayload{
+		Component: "Digest Service",
+		Operation: "SendDigest",
+		Message:   "Failed to render digest for userId: " + userId,
+		Error:     err,
+		UserId:    userId,
+	})
+}
+
+// groupNotifications groups the given notifications by app and then by groupKey, both sorted
+// alphabetically, keeping the notifications of a group in creation order.
+func groupNotifications(notifications []data.Notification, from time.Time, to time.Time) digestContent {
+	grouped := map[string]map[string][]data.Notification{}
+	for _, notification := range notifications {
+		if grouped[notification.AppId] == nil {
+			grouped[notification.AppId] = map[string][]data.Notification{}
+		}
+		grouped[notification.AppId][notification.GroupKey] = append(grouped[notification.AppId][notification.GroupKey], notification)
+	}
+
+	content := digestContent{From: from, To: to, Total: len(notifications)}
+	for appId, groups := range grouped {
+		app := digestApp{AppId: appId}
+		for groupKey, items := range groups {
+			sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+			app.Groups = append(app.Groups, digestGroup{GroupKey: groupKey, Notifications: items})
+		}
+		sort.Slice(app.Groups, func(i, j int) bool { return app.Groups[i].GroupKey < app.Groups[j].GroupKey })
+		content.Apps = append(content.Apps, app)
+	}
+	sort.Slice(content.Apps, func(i, j int) bool { return content.Apps[i].AppId < content.Apps[j].AppId })
+	return content
+}
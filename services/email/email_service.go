@@ -0,0 +1,10 @@
+package emailService
+
+import (
+	"r2-notify-server/data"
+)
+
+type EmailService interface {
+	Send(message data.EmailMessage) error
+	NotifyOfflineUser(notification data.Notification) (sent bool, err error)
+}
@@ -0,0 +1,196 @@
+package emailService
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	clientStore "r2-notify-server/services"
+	configurationService "r2-notify-server/services/configuration"
+	"strconv"
+	"time"
+)
+
+type EmailServiceImpl struct {
+	ConfigurationService configurationService.ConfigurationService
+	sendMail             func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailServiceImpl returns a new instance of EmailService which delivers mails through the
+// SMTP server given by the SMTP_* configuration. The ConfigurationService is used to look up the
+// email address and the email opt-in of a user.
+func NewEmailServiceImpl(configurationService configurationService.ConfigurationService) (service EmailService, err error) {
+	if configurationService == nil {
+		return nil, errors.New("configuration service cannot be nil")
+	}
+	return &EmailServiceImpl{
+		ConfigurationService: configurationService,
+		sendMail:             smtp.SendMail,
+	}, err
+}
+
+// Send delivers the given message through the configured SMTP server. The message is sent as
+// text/plain, or as multipart/alternative when an HTML body is provided. STARTTLS is used when
+// the server advertises it, and PLAIN authentication when SMTP_USERNAME is set.
+func (t *EmailServiceImpl) Send(message data.EmailMessage) error {
+	cfg := config.LoadConfig()
+	body, err := buildMessage(cfg.SmtpFrom, message)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Email Service",
+			Operation: "Send",
+			Message:   "Failed to build email message",
+			Error:     err,
+		})
+		return err
+	}
+	var auth smtp.Auth
+	if cfg.SmtpUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SmtpUsername, cfg.SmtpPassword, cfg.SmtpHost)
+	}
+	addr := cfg.SmtpHost + ":" + strconv.Itoa(cfg.SmtpPort)
+	if err := t.sendMail(addr, auth, cfg.SmtpFrom, []string{message.To}, body); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Email Service",
+			Operation: "Send",
+			Message:   "Failed to send email through " + addr,
+			Error:     err,
+		})
+		return err
+	}
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Email Service",
+		Operation: "Send",
+		Message:   "Sent email: " + message.Subject,
+	})
+	return nil
+}
+
+// NotifyOfflineUser emails the given notification to its recipient when the email channel is
+// enabled, the user is not connected, has opted in to email notifications and has an email
+// address on record. Unless the notification is high priority, the user must also have been
+// offline for longer than EMAIL_OFFLINE_GRACE_PERIOD minutes. It returns true if the mail was sent.
+func (t *EmailServiceImpl) NotifyOfflineUser(notification data.Notification) (bool, error) {
+	cfg := config.LoadConfig()
+	userId := notification.UserID
+	tenantId := config.ResolveTenant(notification.TenantId)
+	if !cfg.EnableEmail || clientStore.IsConnected(tenantId, userId) {
+		return false, nil
+	}
+	if notification.Priority != data.PRIORITY_HIGH {
+		lastSeen, err := clientStore.GetLastSeen(tenantId, userId)
+		if err == nil && time.Since(lastSeen) < time.Duration(cfg.EmailOfflineGracePeriod)*time.Minute {
+			logger.Log.Debug(logger.LogPayload{
+				Component: "Email Service",
+				Operation: "NotifyOfflineUser",
+				Message:   "User is within the offline grace period, skipping email for userId: " + userId,
+				UserId:    userId,
+				AppId:     notification.AppId,
+			})
+			return false, nil
+		}
+	}
+	configuration, err := t.ConfigurationService.ForTenant(tenantId).FindByAppAndUser(userId)
+	if err != nil {
+		return false, err
+	}
+	if !configuration.Data.EnableNotification || !configuration.Data.EmailNotification || configuration.Data.Email == "" {
+		logger.Log.Debug(logger.LogPayload{
+			Component: "Email Service",
+			Operation: "NotifyOfflineUser",
+			Message:   "Email notifications are not enabled for userId: " + userId,
+			UserId:    userId,
+			AppId:     notification.AppId,
+		})
+		return false, nil
+	}
+	err = t.Send(data.EmailMessage{
+		To:      configuration.Data.Email,
+		Subject: fmt.Sprintf("[%s] %s", notification.AppId, notification.GroupKey),
+		Text: fmt.Sprintf("%s\n\nApp: %s\nGroup: %s\nStatus: %s\nReceived: %s\n",
+			notification.Message,
+			notification.AppId,
+			notification.GroupKey,
+			notification.Status,
+			notification.CreatedAt.Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Email Service",
+			Operation: "NotifyOfflineUser",
+			Message:   "Failed to email notification to userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+			AppId:     notification.AppId,
+		})
+		return false, err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Email Service",
+		Operation: "NotifyOfflineUser",
+		Message:   "Emailed notification to offline userId: " + userId,
+		UserId:    userId,
+		AppId:     notification.AppId,
+	})
+	return true, nil
+}
+
+// buildMessage renders the RFC 5322 message for the given email. Bodies are quoted-printable
+// encoded; a multipart/alternative body is produced when both text and HTML are present.
+func buildMessage(from string, message data.EmailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+
+	if message.Html == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, message.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.Html},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(partWriter, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes the given body to w using quoted-printable encoding.
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,294 @@
+package emailService
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	configurationService "r2-notify-server/services/configuration"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// smtpMail is a mail received by the SMTP stub.
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStub is an SMTP server accepting mails for every recipient except the rejected one.
+type smtpStub struct {
+	listener net.Listener
+	rejected string
+	mutex    sync.Mutex
+	mails    []smtpMail
+}
+
+// newSmtpStub starts an SMTP stub and points the SMTP_HOST and SMTP_PORT configuration at it.
+func newSmtpStub(t *testing.T, rejected string) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener, rejected: rejected}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	t.Setenv("SMTP_HOST", "127.0.0.1")
+	t.Setenv("SMTP_PORT", strconv.Itoa(listener.Addr().(*net.TCPAddr).Port))
+	t.Setenv("SMTP_FROM", "notify@example.com")
+	return stub
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	mail := smtpMail{}
+	_ = text.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			_ = text.PrintfLine("250 stub")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			_ = text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient := strings.Trim(line[len("RCPT TO:"):], "<>")
+			if recipient == s.rejected {
+				_ = text.PrintfLine("550 no such user")
+				continue
+			}
+			mail.to = append(mail.to, recipient)
+			_ = text.PrintfLine("250 OK")
+		case command == "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			body, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			mail.data = string(body)
+			s.mutex.Lock()
+			s.mails = append(s.mails, mail)
+			s.mutex.Unlock()
+			mail = smtpMail{}
+			_ = text.PrintfLine("250 OK")
+		case command == "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpStub) received() []smtpMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]smtpMail{}, s.mails...)
+}
+
+func decodeQuotedPrintable(t *testing.T, body io.Reader) string {
+	t.Helper()
+	decoded, err := io.ReadAll(quotedprintable.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+func TestSendDeliversThroughSmtp(t *testing.T) {
+	tests := []struct {
+		name    string
+		message data.EmailMessage
+	}{
+		{"plain text", data.EmailMessage{To: "a@example.com", Subject: "Build failed ✗", Text: "main is red, see https://ci.example/builds/1?tab=logs"}},
+		{"text and html", data.EmailMessage{To: "a@example.com", Subject: "Build failed", Text: "main is red", Html: "<p>main is <b>red</b></p>"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newSmtpStub(t, "")
+			service, err := NewEmailServiceImpl(stubConfigurationService{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := service.Send(test.message); err != nil {
+				t.Fatal(err)
+			}
+
+			mails := stub.received()
+			if len(mails) != 1 {
+				t.Fatalf("expected one mail, got %d", len(mails))
+			}
+			if mails[0].from != "notify@example.com" || len(mails[0].to) != 1 || mails[0].to[0] != test.message.To {
+				t.Fatalf("expected a mail from notify@example.com to %s, got %+v", test.message.To, mails[0])
+			}
+			message, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+			if err != nil || subject != test.message.Subject {
+				t.Fatalf("expected subject %q, got %q (%v)", test.message.Subject, subject, err)
+			}
+			if message.Header.Get("From") != "notify@example.com" || message.Header.Get("To") != test.message.To {
+				t.Fatalf("expected the From and To headers to be set, got %v", message.Header)
+			}
+
+			mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.message.Html == "" {
+				if mediaType != "text/plain" {
+					t.Fatalf("expected a text/plain mail, got %s", mediaType)
+				}
+				// The line break ending the DATA of an unterminated body is added by the SMTP client
+				if body := decodeQuotedPrintable(t, message.Body); strings.TrimSuffix(body, "\n") != test.message.Text {
+					t.Fatalf("expected body %q, got %q", test.message.Text, body)
+				}
+				return
+			}
+			if mediaType != "multipart/alternative" {
+				t.Fatalf("expected a multipart/alternative mail, got %s", mediaType)
+			}
+			reader := multipart.NewReader(message.Body, params["boundary"])
+			for _, expected := range []struct{ contentType, body string }{
+				{"text/plain", test.message.Text},
+				{"text/html", test.message.Html},
+			} {
+				part, err := reader.NextRawPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); contentType != expected.contentType {
+					t.Fatalf("expected a %s part, got %s", expected.contentType, contentType)
+				}
+				if body := decodeQuotedPrintable(t, part); body != expected.body {
+					t.Fatalf("expected %s body %q, got %q", expected.contentType, expected.body, body)
+				}
+			}
+		})
+	}
+}
+
+func TestSendReturnsTheErrorOfTheSmtpServer(t *testing.T) {
+	stub := newSmtpStub(t, "gone@example.com")
+	service, err := NewEmailServiceImpl(stubConfigurationService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.Send(data.EmailMessage{To: "gone@example.com", Subject: "Hi", Text: "Hi"}); err == nil {
+		t.Fatal("expected the rejected recipient to fail the send")
+	}
+	if len(stub.received()) != 0 {
+		t.Fatal("expected no mail to be delivered")
+	}
+}
+
+// stubConfigurationService returns the same configuration for every user and tenant.
+type stubConfigurationService struct {
+	configurationService.ConfigurationService
+	config data.NotificationConfig
+}
+
+func (s stubConfigurationService) ForTenant(string) configurationService.ConfigurationService {
+	return s
+}
+
+func (s stubConfigurationService) FindByAppAndUser(userId string) (data.Configuration, error) {
+	return data.Configuration{Data: s.config}, nil
+}
+
+func TestNotifyOfflineUser(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	config.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	optedIn := data.NotificationConfig{EnableNotification: true, EmailNotification: true, Email: "a@example.com"}
+	tests := []struct {
+		name     string
+		enabled  bool
+		config   data.NotificationConfig
+		lastSeen time.Duration
+		priority string
+		sent     bool
+	}{
+		{"offline user who opted in", true, optedIn, 0, data.PRIORITY_NORMAL, true},
+		{"email channel is disabled", false, optedIn, 0, data.PRIORITY_NORMAL, false},
+		{"user did not opt in", true, data.NotificationConfig{EnableNotification: true, Email: "a@example.com"}, 0, data.PRIORITY_NORMAL, false},
+		{"user muted notifications", true, data.NotificationConfig{EmailNotification: true, Email: "a@example.com"}, 0, data.PRIORITY_NORMAL, false},
+		{"user has no email address", true, data.NotificationConfig{EnableNotification: true, EmailNotification: true}, 0, data.PRIORITY_NORMAL, false},
+		{"user within the grace period", true, optedIn, 5 * time.Minute, data.PRIORITY_NORMAL, false},
+		{"user past the grace period", true, optedIn, 20 * time.Minute, data.PRIORITY_NORMAL, true},
+		{"high priority within the grace period", true, optedIn, 5 * time.Minute, data.PRIORITY_HIGH, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ENABLE_EMAIL", strconv.FormatBool(test.enabled))
+			t.Setenv("EMAIL_OFFLINE_GRACE_PERIOD", "15")
+			stub := newSmtpStub(t, "")
+			redisServer.FlushAll()
+			if test.lastSeen > 0 {
+				redisServer.Set("lastSeen:default:u1", time.Now().Add(-test.lastSeen).Format(time.RFC3339))
+			}
+			service, err := NewEmailServiceImpl(stubConfigurationService{config: test.config})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sent, err := service.NotifyOfflineUser(data.Notification{UserID: "u1", AppId: "app-a", GroupKey: "builds", Message: "main is red", Priority: test.priority})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent != test.sent || len(stub.received()) != map[bool]int{true: 1, false: 0}[test.sent] {
+				t.Fatalf("expected sent to be %v, got %v with %d mails", test.sent, sent, len(stub.received()))
+			}
+			if !test.sent {
+				return
+			}
+			message, err := mail.ReadMessage(strings.NewReader(stub.received()[0].data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Header.Get("Subject") != "[app-a] builds" || message.Header.Get("To") != "a@example.com" {
+				t.Fatalf("expected the notification to be mailed to a@example.com, got %v", message.Header)
+			}
+			if body := decodeQuotedPrintable(t, message.Body); !strings.HasPrefix(body, "main is red\n") {
+				t.Fatalf("expected the body to start with the message, got %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package ingestionService
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// cloudEventAttributePrefixes are the prefixes of the CloudEvents attributes of a binary mode
+// event: HTTP and Kafka headers, and AMQP application properties (RabbitMQ, Event Hubs).
+var cloudEventAttributePrefixes = []string{"ce-", "ce_", "cloudevents:", "cloudevents_"}
+
+// ToStructuredCloudEvent converts a binary mode CloudEvent, whose attributes travel as headers or
+// properties of the message, into a structured mode CloudEvent. The body becomes the event data.
+// The body is returned unchanged when the attributes carry no CloudEvent. Converting at the edge
+// keeps dead letters replayable from their body alone.
+func ToStructuredCloudEvent(attributes map[string]string, contentType string, body []byte) []byte {
+	event := map[string]any{}
+	for key, value := range attributes {
+		name := strings.ToLower(key)
+		for _, prefix := range cloudEventAttributePrefixes {
+			if strings.HasPrefix(name, prefix) {
+				event[strings.TrimPrefix(name, prefix)] = value
+				break
+			}
+		}
+	}
+	if _, ok := event["specversion"]; !ok {
+		return body
+	}
+	if _, ok := event["datacontenttype"]; !ok && contentType != "" {
+		event["datacontenttype"] = contentType
+	}
+	if json.Valid(body) {
+		event["data"] = json.RawMessage(body)
+	} else if len(body) > 0 {
+		event["data"] = string(body)
+	}
+	structured, err := json.Marshal(event)
+	if err != nil {
+		return body
+	}
+	return structured
+}
+
+// isCloudEvent reports whether a JSON body is a structured mode CloudEvent.
+func isCloudEvent(body []byte) bool {
+	var probe struct {
+		SpecVersion *string `json:"specversion"`
+	}
+	return json.Unmarshal(body, &probe) == nil && probe.SpecVersion != nil
+}
+
+// firstNonEmpty returns the first of the values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package ingestionService
+
+import (
+	"errors"
+	"r2-notify-server/data"
+	deadLetterService "r2-notify-server/services/deadletter"
+)
+
+// ErrInvalidNotification is returned for payloads that can not be decoded or fail validation.
+// It is the dead letter ErrInvalidEvent, so that such events are rejected instead of retried.
+var ErrInvalidNotification = deadLetterService.ErrInvalidEvent
+
+// ErrTenantMismatch is returned when a notification is posted for an app of another tenant than the caller's.
+var ErrTenantMismatch = errors.New("app belongs to another tenant")
+
+// errNotificationDropped is returned for notifications of message sources dropped by the rate limit,
+// which are reported to the source as processed.
+var errNotificationDropped = errors.New("notification dropped by the rate limit")
+
+// IngestionService is the single entry point through which notifications enter the server,
+// whichever source they come from. It implements deadLetterService.Processor.
+type IngestionService interface {
+	Ingest(payload data.EventHubNotificationPayload, source string, correlationId string) (notification data.Notification, err error)
+	IngestCloudEvent(event data.CloudEvent, source string, correlationId string) (notification data.Notification, err error)
+	Process(source string, body []byte) error
+	ProcessBatch(source string, bodies [][]byte) (errs []error)
+}
@@ -0,0 +1,382 @@
+package ingestionService
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	kindRepository "r2-notify-server/repository/kind"
+	appService "r2-notify-server/services/app"
+	deliveryService "r2-notify-server/services/delivery"
+	kindService "r2-notify-server/services/kind"
+	notificationService "r2-notify-server/services/notification"
+	rateLimitService "r2-notify-server/services/ratelimit"
+	"r2-notify-server/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type IngestionServiceImpl struct {
+	NotificationService notificationService.NotificationService
+	DeliveryService     deliveryService.DeliveryService
+	KindService         kindService.KindService
+	AppService          appService.AppService
+	RateLimitService    rateLimitService.RateLimitService
+	Validate            *validator.Validate
+}
+
+// NewIngestionServiceImpl returns a new instance of IngestionService with the provided
+// NotificationService, DeliveryService, KindService, AppService, RateLimitService and validator.Validate
+// instance. If any of them is nil, an error is returned.
+func NewIngestionServiceImpl(notificationService notificationService.NotificationService, deliveryService deliveryService.DeliveryService, kindService kindService.KindService, appService appService.AppService, rateLimitService rateLimitService.RateLimitService, validate *validator.Validate) (service IngestionService, err error) {
+	if notificationService == nil || deliveryService == nil || kindService == nil || appService == nil || rateLimitService == nil {
+		return nil, errors.New("notification, delivery, kind, app and rate limit services cannot be nil")
+	}
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	return &IngestionServiceImpl{
+		NotificationService: notificationService,
+		DeliveryService:     deliveryService,
+		KindService:         kindService,
+		AppService:          appService,
+		RateLimitService:    rateLimitService,
+		Validate:            validate,
+	}, nil
+}
+
+// Ingest normalizes and validates the payload, stores the notification tagged with its source
+// and routes it over the delivery channels. The stored notification is returned together with
+// the outcome of every delivery channel. Invalid payloads are reported with ErrInvalidNotification,
+// payloads of a caller whose tenant does not own the app with ErrTenantMismatch, and notifications
+// exceeding the rate limit of their app or recipient with a rateLimitService.LimitExceededError.
+func (t *IngestionServiceImpl) Ingest(payload data.EventHubNotificationPayload, source string, correlationId string) (data.Notification, error) {
+	m, err := t.toModel(payload, source, correlationId)
+	if err != nil {
+		return data.Notification{}, err
+	}
+	if err := t.admit(m, source, correlationId); err != nil {
+		return data.Notification{}, err
+	}
+	recordId, err := t.NotificationService.ForTenant(m.TenantId).As(sourceActor(source, correlationId)).Create(m)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component:     "Ingestion Service",
+			Operation:     "Ingest",
+			Message:       "Failed to store notification from " + source,
+			Error:         err,
+			UserId:        m.UserId,
+			AppId:         m.AppId,
+			CorrelationId: correlationId,
+		})
+		return data.Notification{}, err
+	}
+	m.Id = recordId
+	return t.dispatch(m, correlationId), nil
+}
+
+// IngestCloudEvent ingests a CloudEvent. The source of the event is the appId, its subject the userId,
+// and its type must be a registered notification kind, whose defaults complete the event data.
+// The data is either a JSON object with the notification fields, or a JSON string used as the message.
+func (t *IngestionServiceImpl) IngestCloudEvent(event data.CloudEvent, source string, correlationId string) (data.Notification, error) {
+	payload, err := t.cloudEventPayload(event)
+	if err != nil {
+		return data.Notification{}, err
+	}
+	payload.TenantId = event.TenantId
+	return t.Ingest(payload, source, correlationId)
+}
+
+// Process decodes a JSON notification payload or a structured CloudEvent received from a message
+// source and ingests it. Notifications dropped by the rate limit are reported as processed.
+func (t *IngestionServiceImpl) Process(source string, body []byte) error {
+	correlationId := utils.GenerateUUID()
+	payload, err := t.decode(source, body, correlationId)
+	if err != nil {
+		return err
+	}
+	_, err = t.Ingest(payload, source, correlationId)
+	if errors.Is(err, errNotificationDropped) {
+		return nil
+	}
+	return err
+}
+
+// ProcessBatch ingests a batch of events received from a message source. The valid notifications are
+// stored with a single write per tenant and routed over the delivery channels once the writes completed,
+// grouped by user. The outcome of every event is returned in the order of the bodies, nil meaning it was stored.
+func (t *IngestionServiceImpl) ProcessBatch(source string, bodies [][]byte) []error {
+	correlationId := utils.GenerateUUID()
+	errs := make([]error, len(bodies))
+	batches := map[string][]models.Notification{}
+	positions := map[string][]int{}
+	for i, body := range bodies {
+		payload, err := t.decode(source, body, correlationId)
+		if err == nil {
+			var m models.Notification
+			if m, err = t.toModel(payload, source, correlationId); err == nil {
+				err = t.admit(m, source, correlationId)
+			}
+			if err == nil {
+				batches[m.TenantId] = append(batches[m.TenantId], m)
+				positions[m.TenantId] = append(positions[m.TenantId], i)
+			}
+		}
+		if errors.Is(err, errNotificationDropped) {
+			err = nil
+		}
+		errs[i] = err
+	}
+
+	var stored []models.Notification
+	for tenantId, batch := range batches {
+		ids, err := t.NotificationService.ForTenant(tenantId).As(sourceActor(source, correlationId)).CreateMany(batch)
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component:     "Ingestion Service",
+				Operation:     "ProcessBatch",
+				Message:       fmt.Sprintf("Failed to store notifications of tenant %s of a batch of %d from %s", tenantId, len(batch), source),
+				Error:         err,
+				CorrelationId: correlationId,
+			})
+		}
+		for i, m := range batch {
+			if ids == nil || ids[i].IsZero() {
+				errs[positions[tenantId][i]] = err
+				continue
+			}
+			m.Id = ids[i]
+			stored = append(stored, m)
+		}
+	}
+
+	// Notifications of the same user are dispatched one after the other, in the order they were received.
+	slices.SortStableFunc(stored, func(a, b models.Notification) int {
+		return strings.Compare(a.UserId, b.UserId)
+	})
+	for _, m := range stored {
+		t.dispatch(m, correlationId)
+	}
+	return errs
+}
+
+// decode reads a JSON notification payload, or the payload carried by a structured CloudEvent.
+func (t *IngestionServiceImpl) decode(source string, body []byte, correlationId string) (data.EventHubNotificationPayload, error) {
+	if isCloudEvent(body) {
+		event := data.CloudEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return data.EventHubNotificationPayload{}, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+		return t.cloudEventPayload(event)
+	}
+	payload := data.EventHubNotificationPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "Ingestion Service",
+			Operation:     "Process",
+			Message:       "Invalid message format from " + source,
+			Error:         err,
+			CorrelationId: correlationId,
+		})
+		return data.EventHubNotificationPayload{}, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	return payload, nil
+}
+
+// cloudEventPayload resolves the notification payload of a CloudEvent from its registered kind and its data.
+func (t *IngestionServiceImpl) cloudEventPayload(event data.CloudEvent) (data.EventHubNotificationPayload, error) {
+	if err := t.Validate.Struct(event); err != nil {
+		return data.EventHubNotificationPayload{}, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if event.DataContentType != "" && !strings.Contains(event.DataContentType, "json") {
+		return data.EventHubNotificationPayload{}, fmt.Errorf("%w: unsupported datacontenttype %q", ErrInvalidNotification, event.DataContentType)
+	}
+	kind, err := t.KindService.FindByType(event.Type)
+	if errors.Is(err, kindRepository.ErrKindNotFound) {
+		return data.EventHubNotificationPayload{}, fmt.Errorf("%w: unregistered notification type %q", ErrInvalidNotification, event.Type)
+	}
+	if err != nil {
+		return data.EventHubNotificationPayload{}, err
+	}
+
+	var eventData data.CloudEventNotificationData
+	if len(event.Data) > 0 && event.Data[0] == '"' {
+		err = json.Unmarshal(event.Data, &eventData.Message)
+	} else if len(event.Data) > 0 {
+		err = json.Unmarshal(event.Data, &eventData)
+	}
+	if err != nil {
+		return data.EventHubNotificationPayload{}, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	channels := eventData.Channels
+	if len(channels) == 0 {
+		channels = kind.Channels
+	}
+	return data.EventHubNotificationPayload{
+		AppId:     event.Source,
+		UserId:    event.Subject,
+		GroupKey:  firstNonEmpty(eventData.GroupKey, kind.GroupKey),
+		Title:     firstNonEmpty(eventData.Title, kind.Title),
+		Message:   eventData.Message,
+		ActionUrl: eventData.ActionUrl,
+		Status:    firstNonEmpty(eventData.Status, kind.Status),
+		Priority:  firstNonEmpty(eventData.Priority, kind.Priority),
+		Channels:  channels,
+	}, nil
+}
+
+// toModel normalizes and validates a payload into the notification to store.
+func (t *IngestionServiceImpl) toModel(payload data.EventHubNotificationPayload, source string, correlationId string) (models.Notification, error) {
+	payload = normalize(payload)
+	if err := t.Validate.Struct(payload); err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "Ingestion Service",
+			Operation:     "Ingest",
+			Message:       "Rejected invalid notification from " + source,
+			Error:         err,
+			UserId:        payload.UserId,
+			AppId:         payload.AppId,
+			CorrelationId: correlationId,
+		})
+		return models.Notification{}, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	tenantId, err := t.AppService.FindTenant(payload.AppId)
+	if err != nil {
+		return models.Notification{}, err
+	}
+	if payload.TenantId != "" && payload.TenantId != tenantId {
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "Ingestion Service",
+			Operation:     "Ingest",
+			Message:       "Rejected notification of tenant " + payload.TenantId + " for an app of tenant " + tenantId,
+			UserId:        payload.UserId,
+			AppId:         payload.AppId,
+			CorrelationId: correlationId,
+		})
+		return models.Notification{}, ErrTenantMismatch
+	}
+
+	now := time.Now()
+	return models.Notification{
+		TenantId:   tenantId,
+		UserId:     payload.UserId,
+		AppId:      payload.AppId,
+		GroupKey:   payload.GroupKey,
+		Title:      payload.Title,
+		ActionUrl:  payload.ActionUrl,
+		Message:    payload.Message,
+		Status:     payload.Status,
+		Priority:   payload.Priority,
+		Channels:   payload.Channels,
+		Source:     source,
+		ReadStatus: false,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+// admit takes a token from the rate limits of the app and the recipient of a notification. Notifications
+// posted over REST are rejected as soon as a limit is exceeded. Those of the message sources overflow
+// as configured by RATE_LIMIT_OVERFLOW: they are returned with the error to the source, which stores them
+// as dead letters to be retried later, dropped with errNotificationDropped, or delayed until a token is
+// available, holding back the source, for up to RATE_LIMIT_MAX_DELAY seconds before they are returned
+// to the source as well.
+func (t *IngestionServiceImpl) admit(m models.Notification, source string, correlationId string) error {
+	cfg := config.LoadConfig()
+	deadline := time.Now().Add(time.Duration(cfg.RateLimitMaxDelay) * time.Second)
+	for {
+		err := t.RateLimitService.AllowNotification(m.TenantId, m.AppId, m.UserId)
+		var limited *rateLimitService.LimitExceededError
+		if !errors.As(err, &limited) || source == data.SOURCE_REST {
+			return err
+		}
+		if cfg.RateLimitOverflow == data.RATE_LIMIT_OVERFLOW_DELAY && time.Now().Add(limited.RetryAfter).Before(deadline) {
+			time.Sleep(limited.RetryAfter)
+			continue
+		}
+
+		logger.Log.Warn(logger.LogPayload{
+			Component:     "Ingestion Service",
+			Operation:     "Admit",
+			Message:       fmt.Sprintf("Notification from %s exceeded the rate limit, overflow: %s", source, cfg.RateLimitOverflow),
+			Error:         err,
+			UserId:        m.UserId,
+			AppId:         m.AppId,
+			CorrelationId: correlationId,
+		})
+		if cfg.RateLimitOverflow == data.RATE_LIMIT_OVERFLOW_DROP {
+			return errNotificationDropped
+		}
+		return err
+	}
+}
+
+// dispatch routes a stored notification over the delivery channels and returns it together with
+// the outcome of every delivery channel.
+func (t *IngestionServiceImpl) dispatch(m models.Notification, correlationId string) data.Notification {
+	notification := data.Notification{
+		Id:        m.Id.Hex(),
+		TenantId:  m.TenantId,
+		UserID:    m.UserId,
+		AppId:     m.AppId,
+		GroupKey:  m.GroupKey,
+		Title:     m.Title,
+		ActionUrl: m.ActionUrl,
+		Message:   m.Message,
+		Status:    m.Status,
+		Priority:  m.Priority,
+		Channels:  m.Channels,
+		Source:    m.Source,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+	}
+	notification.Deliveries = t.DeliveryService.Dispatch(notification)
+
+	logger.Log.Info(logger.LogPayload{
+		Component:     "Ingestion Service",
+		Operation:     "Ingest",
+		Message:       "Ingested notification " + notification.Id + " from " + m.Source,
+		UserId:        notification.UserID,
+		AppId:         notification.AppId,
+		CorrelationId: correlationId,
+	})
+	return notification
+}
+
+// sourceActor is the actor notifications of a source are created on behalf of in the audit log.
+func sourceActor(source string, correlationId string) data.AuditActor {
+	return data.AuditActor{Id: source, Type: data.AUDIT_ACTOR_SERVICE, CorrelationId: correlationId}
+}
+
+// normalize trims the payload fields, lower cases the enumerations and applies the default
+// priority, so that equivalent payloads are stored the same way whatever their source.
+func normalize(payload data.EventHubNotificationPayload) data.EventHubNotificationPayload {
+	payload.AppId = strings.TrimSpace(payload.AppId)
+	payload.UserId = strings.TrimSpace(payload.UserId)
+	payload.GroupKey = strings.TrimSpace(payload.GroupKey)
+	payload.Title = strings.TrimSpace(payload.Title)
+	payload.Message = strings.TrimSpace(payload.Message)
+	payload.ActionUrl = strings.TrimSpace(payload.ActionUrl)
+	payload.Status = strings.ToLower(strings.TrimSpace(payload.Status))
+	payload.Priority = strings.ToLower(strings.TrimSpace(payload.Priority))
+	if payload.Priority == "" {
+		payload.Priority = data.PRIORITY_NORMAL
+	}
+	var channels []string
+	for _, channel := range payload.Channels {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if !slices.Contains(channels, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	payload.Channels = channels
+	return payload
+}
@@ -0,0 +1,10 @@
+package kindService
+
+import "r2-notify-server/data"
+
+type KindService interface {
+	FindAll() (kinds []data.NotificationKind, err error)
+	FindByType(kindType string) (kind data.NotificationKind, err error)
+	Save(kind data.NotificationKind) (data.NotificationKind, error)
+	Delete(kindType string) error
+}
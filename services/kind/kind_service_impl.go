@@ -0,0 +1,84 @@
+package kindService
+
+import (
+	"errors"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+	kindRepository "r2-notify-server/repository/kind"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type KindServiceImpl struct {
+	KindRepository kindRepository.KindRepository
+	Validate       *validator.Validate
+}
+
+// NewKindServiceImpl returns a new instance of KindService with the provided KindRepository and
+// validator.Validate instance. If the validator instance is nil, an error is returned.
+func NewKindServiceImpl(kindRepository kindRepository.KindRepository, validate *validator.Validate) (service KindService, err error) {
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	return &KindServiceImpl{KindRepository: kindRepository, Validate: validate}, nil
+}
+
+// FindAll returns every registered notification kind.
+func (t *KindServiceImpl) FindAll() ([]data.NotificationKind, error) {
+	result, err := t.KindRepository.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	kinds := []data.NotificationKind{}
+	for _, value := range result {
+		kinds = append(kinds, toKindData(value))
+	}
+	return kinds, nil
+}
+
+// FindByType returns the notification kind registered for a CloudEvents type.
+func (t *KindServiceImpl) FindByType(kindType string) (data.NotificationKind, error) {
+	kind, err := t.KindRepository.FindByType(kindType)
+	if err != nil {
+		return data.NotificationKind{}, err
+	}
+	return toKindData(kind), nil
+}
+
+// Save validates and registers a notification kind, replacing an existing one of the same type.
+func (t *KindServiceImpl) Save(kind data.NotificationKind) (data.NotificationKind, error) {
+	if err := t.Validate.Struct(kind); err != nil {
+		return data.NotificationKind{}, err
+	}
+	err := t.KindRepository.Save(models.NotificationKind{
+		Type:     kind.Type,
+		GroupKey: kind.GroupKey,
+		Status:   kind.Status,
+		Priority: kind.Priority,
+		Channels: kind.Channels,
+		Title:    kind.Title,
+	})
+	if err != nil {
+		return data.NotificationKind{}, err
+	}
+	kind.UpdatedAt = time.Now()
+	return kind, nil
+}
+
+// Delete unregisters the notification kind of a CloudEvents type.
+func (t *KindServiceImpl) Delete(kindType string) error {
+	return t.KindRepository.Delete(kindType)
+}
+
+func toKindData(kind models.NotificationKind) data.NotificationKind {
+	return data.NotificationKind{
+		Type:      kind.Type,
+		GroupKey:  kind.GroupKey,
+		Status:    kind.Status,
+		Priority:  kind.Priority,
+		Channels:  kind.Channels,
+		Title:     kind.Title,
+		UpdatedAt: kind.UpdatedAt,
+	}
+}
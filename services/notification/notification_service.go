@@ -3,14 +3,19 @@ package notificationService
 import (
 	"r2-notify-server/data"
 	"r2-notify-server/models"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type NotificationService interface {
+	ForTenant(tenantId string) NotificationService
+	As(actor data.AuditActor) NotificationService
 	FindAll(userId string) (notifications []data.Notification, err error)
 	FindById(id primitive.ObjectID, userId string) (notification data.Notification, err error)
+	FindUnreadBetween(userId string, from time.Time, to time.Time) (notifications []data.Notification, err error)
 	Create(notification models.Notification) (primitive.ObjectID, error)
+	CreateMany(notifications []models.Notification) ([]primitive.ObjectID, error)
 	MarkAsRead(userId string) error
 	MarkAppAsRead(userId string, appId string) error
 	MarkGroupAsRead(userId string, appId string, groupKey string) error
@@ -19,4 +24,7 @@ type NotificationService interface {
 	DeleteAppNotifications(userId string, appId string) error
 	DeleteGroupNotifications(userId string, appId string, groupKey string) error
 	DeleteNotification(userId string, notificationId string) error
+	MarkDelivered(userId string, notificationId string) error
+	MarkPendingAsDelivered(userId string) error
+	RecordDeliveries(userId string, notificationId string, deliveries []data.ChannelDelivery) error
 }
@@ -2,10 +2,16 @@ package notificationService
 
 import (
 	"errors"
+	"r2-notify-server/config"
 	"r2-notify-server/data"
 	"r2-notify-server/logger"
 	"r2-notify-server/models"
 	notificationRepository "r2-notify-server/repository/notification"
+	auditService "r2-notify-server/services/audit"
+	webhookService "r2-notify-server/services/webhook"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,22 +19,50 @@ import (
 
 type NotificationServiceImpl struct {
 	NotificationRepository notificationRepository.NotificationRepository
+	WebhookService         webhookService.WebhookService
+	AuditService           auditService.AuditService
 	Validate               *validator.Validate
+	TenantId               string
+	Actor                  data.AuditActor
 }
 
 // NewNotificationServiceImpl returns a new instance of NotificationService
-// with the provided NotificationRepository and validator.Validate instance.
-// If the validator instance is nil, an error is returned.
-func NewNotificationServiceImpl(notificationRepository notificationRepository.NotificationRepository, validate *validator.Validate) (service NotificationService, err error) {
+// with the provided NotificationRepository, WebhookService, AuditService and validator.Validate instance.
+// The WebhookService receives the created, delivered, read and deleted lifecycle events, and the
+// AuditService the created, read and deleted notifications.
+// If the audit service or the validator instance is nil, an error is returned.
+func NewNotificationServiceImpl(notificationRepository notificationRepository.NotificationRepository, webhookService webhookService.WebhookService, auditService auditService.AuditService, validate *validator.Validate) (service NotificationService, err error) {
+	if auditService == nil {
+		return nil, errors.New("audit service cannot be nil")
+	}
 	if validate == nil {
 		return nil, errors.New("validator instance cannot be nil")
 	}
 	return &NotificationServiceImpl{
 		NotificationRepository: notificationRepository,
+		WebhookService:         webhookService,
+		AuditService:           auditService,
 		Validate:               validate,
+		TenantId:               config.LoadConfig().DefaultTenant,
 	}, err
 }
 
+// ForTenant returns the service of the notifications of the given tenant, the default tenant when it is empty.
+func (t *NotificationServiceImpl) ForTenant(tenantId string) NotificationService {
+	service := *t
+	service.NotificationRepository = t.NotificationRepository.ForTenant(tenantId)
+	service.TenantId = config.ResolveTenant(tenantId)
+	return &service
+}
+
+// As returns the service recording its mutations in the audit log on behalf of the given actor.
+// Mutations of a service without actor are recorded as performed by the system.
+func (t *NotificationServiceImpl) As(actor data.AuditActor) NotificationService {
+	service := *t
+	service.Actor = actor
+	return &service
+}
+
 // FindAll returns a list of notifications for the given user ID. If no
 // notifications are found for the user, an empty list is returned with a nil
 // error. If an error occurs while fetching the notifications, the error is
@@ -54,15 +88,23 @@ func (t NotificationServiceImpl) FindAll(userId string) (notifications []data.No
 
 	for _, value := range result {
 		notification := data.Notification{
-			Id:         value.Id.Hex(),
-			AppId:      value.AppId,
-			GroupKey:   value.GroupKey,
-			Message:    value.Message,
-			ReadStatus: value.ReadStatus,
-			UserID:     value.UserId,
-			Status:     value.Status,
-			CreatedAt:  value.CreatedAt,
-			UpdatedAt:  value.UpdatedAt,
+			Id:          value.Id.Hex(),
+			TenantId:    value.TenantId,
+			AppId:       value.AppId,
+			GroupKey:    value.GroupKey,
+			Title:       value.Title,
+			ActionUrl:   value.ActionUrl,
+			Message:     value.Message,
+			ReadStatus:  value.ReadStatus,
+			UserID:      value.UserId,
+			Status:      value.Status,
+			Priority:    value.Priority,
+			Channels:    value.Channels,
+			Source:      value.Source,
+			Deliveries:  toChannelDeliveries(value.Deliveries),
+			DeliveredAt: value.DeliveredAt,
+			CreatedAt:   value.CreatedAt,
+			UpdatedAt:   value.UpdatedAt,
 		}
 		notifications = append(notifications, notification)
 	}
@@ -84,6 +126,45 @@ func (t NotificationServiceImpl) FindAll(userId string) (notifications []data.No
 	return notifications, nil
 }
 
+// FindUnreadBetween returns the unread notifications of the given user created in the window
+// (from, to], ordered as stored. It is used to build digests of unread notifications.
+func (t *NotificationServiceImpl) FindUnreadBetween(userId string, from time.Time, to time.Time) ([]data.Notification, error) {
+	result, err := t.NotificationRepository.FindUnreadBetween(userId, from, to)
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Service",
+			Operation: "FindUnreadBetween",
+			Message:   "Failed to fetch unread notifications for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return nil, err
+	}
+	notifications := []data.Notification{}
+	for _, value := range result {
+		notifications = append(notifications, data.Notification{
+			Id:          value.Id.Hex(),
+			TenantId:    value.TenantId,
+			AppId:       value.AppId,
+			GroupKey:    value.GroupKey,
+			Title:       value.Title,
+			ActionUrl:   value.ActionUrl,
+			Message:     value.Message,
+			ReadStatus:  value.ReadStatus,
+			UserID:      value.UserId,
+			Status:      value.Status,
+			Priority:    value.Priority,
+			Channels:    value.Channels,
+			Source:      value.Source,
+			Deliveries:  toChannelDeliveries(value.Deliveries),
+			DeliveredAt: value.DeliveredAt,
+			CreatedAt:   value.CreatedAt,
+			UpdatedAt:   value.UpdatedAt,
+		})
+	}
+	return notifications, nil
+}
+
 // FindById retrieves a notification by its ID and user ID from the data store.
 // It returns the notification as a data.Notification struct. If the notification
 // is not found or an error occurs during the retrieval, it returns an empty
@@ -108,15 +189,23 @@ func (t *NotificationServiceImpl) FindById(id primitive.ObjectID, userId string)
 	}
 
 	notification = data.Notification{
-		Id:         notificationModel.Id.Hex(),
-		AppId:      notification.AppId,
-		GroupKey:   notificationModel.GroupKey,
-		Message:    notificationModel.Message,
-		ReadStatus: notificationModel.ReadStatus,
-		UserID:     notificationModel.UserId,
-		Status:     notificationModel.Status,
-		CreatedAt:  notificationModel.CreatedAt,
-		UpdatedAt:  notificationModel.UpdatedAt,
+		Id:          notificationModel.Id.Hex(),
+		TenantId:    notificationModel.TenantId,
+		AppId:       notificationModel.AppId,
+		GroupKey:    notificationModel.GroupKey,
+		Title:       notificationModel.Title,
+		ActionUrl:   notificationModel.ActionUrl,
+		Message:     notificationModel.Message,
+		ReadStatus:  notificationModel.ReadStatus,
+		UserID:      notificationModel.UserId,
+		Status:      notificationModel.Status,
+		Priority:    notificationModel.Priority,
+		Channels:    notificationModel.Channels,
+		Source:      notificationModel.Source,
+		Deliveries:  toChannelDeliveries(notificationModel.Deliveries),
+		DeliveredAt: notificationModel.DeliveredAt,
+		CreatedAt:   notificationModel.CreatedAt,
+		UpdatedAt:   notificationModel.UpdatedAt,
 	}
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Service",
@@ -148,6 +237,9 @@ func (t *NotificationServiceImpl) Create(notification models.Notification) (prim
 		})
 		return primitive.NilObjectID, err
 	}
+	notification.Id = recordId
+	t.WebhookService.Publish(data.WEBHOOK_EVENT_CREATED, []models.Notification{notification})
+	t.auditCreated(notification)
 	logger.Log.Info(logger.LogPayload{
 		Component: "Notification Service",
 		Operation: "Create",
@@ -157,6 +249,28 @@ func (t *NotificationServiceImpl) Create(notification models.Notification) (prim
 	return recordId, nil
 }
 
+// CreateMany creates a batch of notifications with a single write and returns their IDs in the same order.
+// When only some of them could be stored, the error is returned together with the IDs, the ID of every
+// notification not stored being nil. The created event is published and audited for the stored notifications.
+func (t *NotificationServiceImpl) CreateMany(notifications []models.Notification) ([]primitive.ObjectID, error) {
+	ids, err := t.NotificationRepository.CreateMany(notifications)
+	if ids == nil {
+		return nil, err
+	}
+	var created []models.Notification
+	for i, notification := range notifications {
+		if !ids[i].IsZero() {
+			notification.Id = ids[i]
+			created = append(created, notification)
+			t.auditCreated(notification)
+		}
+	}
+	if len(created) > 0 {
+		t.WebhookService.Publish(data.WEBHOOK_EVENT_CREATED, created)
+	}
+	return ids, err
+}
+
 // MarkAppAsRead marks all notifications of a given application as read for a user
 // given by the user ID. If an error occurs during the operation, the error is
 // returned.
@@ -168,7 +282,8 @@ func (t *NotificationServiceImpl) MarkAppAsRead(userId string, appId string) (er
 		UserId:    userId,
 		AppId:     appId,
 	})
-	err = t.NotificationRepository.MarkAppAsRead(userId, appId)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_READ, userId, appId, "", true)
+	count, err := t.NotificationRepository.MarkAppAsRead(userId, appId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -178,8 +293,11 @@ func (t *NotificationServiceImpl) MarkAppAsRead(userId string, appId string) (er
 			UserId:    userId,
 			AppId:     appId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_READ, affected)
+	t.audit("MarkAppAsRead", map[string]string{"userId": userId, "appId": appId, "count": strconv.FormatInt(count, 10)})
+	return nil
 }
 
 // DeleteAppNotifications deletes all notifications of a given application for a user
@@ -193,7 +311,8 @@ func (t *NotificationServiceImpl) DeleteAppNotifications(userId string, appId st
 		UserId:    userId,
 		AppId:     appId,
 	})
-	err = t.NotificationRepository.DeleteAppNotifications(userId, appId)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_DELETED, userId, appId, "", false)
+	count, err := t.NotificationRepository.DeleteAppNotifications(userId, appId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -203,8 +322,11 @@ func (t *NotificationServiceImpl) DeleteAppNotifications(userId string, appId st
 			UserId:    userId,
 			AppId:     appId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_DELETED, affected)
+	t.audit("DeleteAppNotifications", map[string]string{"userId": userId, "appId": appId, "count": strconv.FormatInt(count, 10)})
+	return nil
 }
 
 // MarkGroupAsRead marks all notifications of a given application and group key
@@ -218,7 +340,8 @@ func (t *NotificationServiceImpl) MarkGroupAsRead(userId string, appId string, g
 		UserId:    userId,
 		AppId:     appId,
 	})
-	err = t.NotificationRepository.MarkGroupAsRead(userId, appId, groupKey)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_READ, userId, appId, groupKey, true)
+	count, err := t.NotificationRepository.MarkGroupAsRead(userId, appId, groupKey)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -228,8 +351,11 @@ func (t *NotificationServiceImpl) MarkGroupAsRead(userId string, appId string, g
 			UserId:    userId,
 			AppId:     appId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_READ, affected)
+	t.audit("MarkGroupAsRead", map[string]string{"userId": userId, "appId": appId, "groupKey": groupKey, "count": strconv.FormatInt(count, 10)})
+	return nil
 }
 
 // DeleteGroupNotifications deletes all notifications of a given application and group key
@@ -242,7 +368,8 @@ func (t *NotificationServiceImpl) DeleteGroupNotifications(userId string, appId
 		UserId:    userId,
 		AppId:     appId,
 	})
-	err = t.NotificationRepository.DeleteGroupNotifications(userId, appId, groupKey)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_DELETED, userId, appId, groupKey, false)
+	count, err := t.NotificationRepository.DeleteGroupNotifications(userId, appId, groupKey)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -252,8 +379,11 @@ func (t *NotificationServiceImpl) DeleteGroupNotifications(userId string, appId
 			UserId:    userId,
 			AppId:     appId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_DELETED, affected)
+	t.audit("DeleteGroupNotifications", map[string]string{"userId": userId, "appId": appId, "groupKey": groupKey, "count": strconv.FormatInt(count, 10)})
+	return nil
 }
 
 // MarkNotificationAsRead marks a specific notification as read for a user given by the user ID
@@ -265,6 +395,7 @@ func (t *NotificationServiceImpl) MarkNotificationAsRead(userId string, notifica
 		Message:   "Marking notification as read for userId: " + userId,
 		UserId:    userId,
 	})
+	affected := t.affectedNotification(userId, notificationId, true)
 	err = t.NotificationRepository.MarkNotificationAsRead(userId, notificationId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -274,8 +405,11 @@ func (t *NotificationServiceImpl) MarkNotificationAsRead(userId string, notifica
 			Error:     err,
 			UserId:    userId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_READ, affected)
+	t.audit("MarkNotificationAsRead", map[string]string{"userId": userId, "notificationId": notificationId})
+	return nil
 }
 
 // DeleteNotification deletes a specific notification for a user given by the user ID
@@ -287,6 +421,7 @@ func (t *NotificationServiceImpl) DeleteNotification(userId string, notification
 		Message:   "Deleting notification for userId: " + userId,
 		UserId:    userId,
 	})
+	affected := t.affectedNotification(userId, notificationId, false)
 	err = t.NotificationRepository.DeleteNotification(userId, notificationId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
@@ -296,8 +431,11 @@ func (t *NotificationServiceImpl) DeleteNotification(userId string, notification
 			Error:     err,
 			UserId:    userId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_DELETED, affected)
+	t.audit("DeleteNotification", map[string]string{"userId": userId, "notificationId": notificationId})
+	return nil
 }
 
 // DeleteAllNotifications deletes all notifications for a given user ID.
@@ -309,7 +447,8 @@ func (t *NotificationServiceImpl) DeleteNotifications(userId string) (err error)
 		Message:   "Deleting all notifications for userId: " + userId,
 		UserId:    userId,
 	})
-	err = t.NotificationRepository.DeleteNotifications(userId)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_DELETED, userId, "", "", false)
+	count, err := t.NotificationRepository.DeleteNotifications(userId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -318,8 +457,11 @@ func (t *NotificationServiceImpl) DeleteNotifications(userId string) (err error)
 			Error:     err,
 			UserId:    userId,
 		})
+		return err
 	}
-	return err
+	t.publish(data.WEBHOOK_EVENT_DELETED, affected)
+	t.audit("DeleteNotifications", map[string]string{"userId": userId, "count": strconv.FormatInt(count, 10)})
+	return nil
 }
 
 // MarkAsRead marks all notifications for a given user ID as read. If an error
@@ -331,7 +473,8 @@ func (t *NotificationServiceImpl) MarkAsRead(userId string) (err error) {
 		Message:   "Marking all notifications as read for userId: " + userId,
 		UserId:    userId,
 	})
-	err = t.NotificationRepository.MarkAsRead(userId)
+	affected := t.affectedNotifications(data.WEBHOOK_EVENT_READ, userId, "", "", true)
+	count, err := t.NotificationRepository.MarkAsRead(userId)
 	if err != nil {
 		logger.Log.Error(logger.LogPayload{
 			Component: "Notification Service",
@@ -340,6 +483,157 @@ func (t *NotificationServiceImpl) MarkAsRead(userId string) (err error) {
 			Error:     err,
 			UserId:    userId,
 		})
+		return err
+	}
+	t.publish(data.WEBHOOK_EVENT_READ, affected)
+	t.audit("MarkAsRead", map[string]string{"userId": userId, "count": strconv.FormatInt(count, 10)})
+	return nil
+}
+
+// MarkDelivered records that the given notification reached the user and publishes the
+// delivered lifecycle event. Notifications that were already delivered are left untouched.
+func (t *NotificationServiceImpl) MarkDelivered(userId string, notificationId string) error {
+	affected := t.affectedNotification(userId, notificationId, false)
+	if len(affected) == 0 || affected[0].DeliveredAt != nil {
+		return nil
+	}
+	return t.markDelivered(userId, affected)
+}
+
+// MarkPendingAsDelivered records every unread notification of the user that has not been
+// delivered yet as delivered, and publishes the delivered lifecycle event for each of them.
+// It is used when a user connects and receives the notifications stored while offline.
+func (t *NotificationServiceImpl) MarkPendingAsDelivered(userId string) error {
+	pending, err := t.NotificationRepository.FindUndelivered(userId)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	return t.markDelivered(userId, pending)
+}
+
+// markDelivered sets the deliveredAt timestamp of the given notifications and publishes the
+// delivered lifecycle event once the update succeeded.
+func (t *NotificationServiceImpl) markDelivered(userId string, notifications []models.Notification) error {
+	logger.Log.Debug(logger.LogPayload{
+		Component: "Notification Service",
+		Operation: "MarkDelivered",
+		Message:   "Marking notifications as delivered for userId: " + userId,
+		UserId:    userId,
+	})
+	ids := make([]primitive.ObjectID, 0, len(notifications))
+	for _, notification := range notifications {
+		ids = append(ids, notification.Id)
+	}
+	if err := t.NotificationRepository.MarkDelivered(userId, ids); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Notification Service",
+			Operation: "MarkDelivered",
+			Message:   "Failed to mark notifications as delivered for userId: " + userId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	deliveredAt := time.Now()
+	for i := range notifications {
+		notifications[i].DeliveredAt = &deliveredAt
+	}
+	t.publish(data.WEBHOOK_EVENT_DELIVERED, notifications)
+	return nil
+}
+
+// RecordDeliveries stores the per-channel delivery outcome of the given notification.
+func (t *NotificationServiceImpl) RecordDeliveries(userId string, notificationId string, deliveries []data.ChannelDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(notificationId)
+	if err != nil {
+		return err
+	}
+	records := make([]models.ChannelDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		records = append(records, models.ChannelDelivery{
+			Channel:     delivery.Channel,
+			Status:      delivery.Status,
+			Error:       delivery.Error,
+			AttemptedAt: delivery.AttemptedAt,
+		})
+	}
+	return t.NotificationRepository.RecordDeliveries(userId, id, records)
+}
+
+// affectedNotifications captures the notifications a bulk operation is about to change, so that
+// the lifecycle event can be published once the operation succeeded. Nothing is captured when no
+// webhook of the tenant receives the event, sparing the lookup and the decryption of the notifications.
+// Lookup failures are logged by the repository and result in no event being published.
+func (t *NotificationServiceImpl) affectedNotifications(event string, userId string, appId string, groupKey string, unreadOnly bool) []models.Notification {
+	if !t.WebhookService.Subscribed(t.TenantId, strings.Trim(strings.TrimSpace(appId), `"'`), event) {
+		return nil
+	}
+	notifications, err := t.NotificationRepository.FindMatching(userId, appId, groupKey, unreadOnly)
+	if err != nil {
+		return nil
+	}
+	return notifications
+}
+
+// affectedNotification captures the single notification an operation is about to change.
+// If unreadOnly is true and the notification is already read, nothing is returned.
+func (t *NotificationServiceImpl) affectedNotification(userId string, notificationId string, unreadOnly bool) []models.Notification {
+	id, err := primitive.ObjectIDFromHex(strings.Trim(strings.TrimSpace(notificationId), `"'`))
+	if err != nil {
+		return nil
+	}
+	notification, err := t.NotificationRepository.FindById(id, userId)
+	if err != nil || (unreadOnly && notification.ReadStatus) {
+		return nil
+	}
+	return []models.Notification{notification}
+}
+
+// publish forwards a lifecycle event for the given notifications to the webhook service.
+// Read events carry the notifications with their new read status.
+func (t *NotificationServiceImpl) publish(event string, notifications []models.Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	if event == data.WEBHOOK_EVENT_READ {
+		for i := range notifications {
+			notifications[i].ReadStatus = true
+		}
+	}
+	t.WebhookService.Publish(event, notifications)
+}
+
+// audit records a mutation of the notifications of a user in the audit log, on behalf of the actor of the service.
+func (t *NotificationServiceImpl) audit(operation string, scope map[string]string) {
+	t.AuditService.RecordMutation(t.Actor, t.TenantId, "notification."+operation, scope)
+}
+
+// auditCreated records a created notification in the audit log.
+func (t *NotificationServiceImpl) auditCreated(notification models.Notification) {
+	t.audit("Create", map[string]string{
+		"userId":         notification.UserId,
+		"appId":          notification.AppId,
+		"groupKey":       notification.GroupKey,
+		"notificationId": notification.Id.Hex(),
+		"source":         notification.Source,
+	})
+}
+
+func toChannelDeliveries(deliveries []models.ChannelDelivery) []data.ChannelDelivery {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	result := make([]data.ChannelDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		result = append(result, data.ChannelDelivery{
+			Channel:     delivery.Channel,
+			Status:      delivery.Status,
+			Error:       delivery.Error,
+			AttemptedAt: delivery.AttemptedAt,
+		})
 	}
-	return err
+	return result
 }
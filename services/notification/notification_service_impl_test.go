@@ -0,0 +1,126 @@
+package notificationService
+
+import (
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	notificationRepository "r2-notify-server/repository/notification"
+	auditService "r2-notify-server/services/audit"
+	webhookService "r2-notify-server/services/webhook"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// stubNotificationRepository holds the notifications of one app, and counts the lookups of the
+// notifications affected by bulk operations.
+type stubNotificationRepository struct {
+	notificationRepository.NotificationRepository
+	notifications []models.Notification
+	lookups       int
+}
+
+func (r *stubNotificationRepository) ForTenant(tenantId string) notificationRepository.NotificationRepository {
+	return r
+}
+
+func (r *stubNotificationRepository) FindMatching(userId string, appId string, groupKey string, unreadOnly bool) ([]models.Notification, error) {
+	r.lookups++
+	return r.notifications, nil
+}
+
+func (r *stubNotificationRepository) MarkAppAsRead(clientId string, appId string) (int64, error) {
+	return int64(len(r.notifications)), nil
+}
+
+func (r *stubNotificationRepository) DeleteNotifications(clientId string) (int64, error) {
+	return int64(len(r.notifications)), nil
+}
+
+// stubWebhookService reports whether webhooks are subscribed, and records the published events.
+type stubWebhookService struct {
+	webhookService.WebhookService
+	subscribed bool
+	queried    []string
+	published  map[string][]models.Notification
+}
+
+func (s *stubWebhookService) Subscribed(tenantId string, appId string, event string) bool {
+	s.queried = append(s.queried, tenantId+":"+appId+":"+event)
+	return s.subscribed
+}
+
+func (s *stubWebhookService) Publish(event string, notifications []models.Notification) {
+	s.published[event] = notifications
+}
+
+// recordingAuditService records the scope of the recorded mutations by action.
+type recordingAuditService struct {
+	auditService.AuditService
+	mutations map[string]map[string]string
+}
+
+func (s *recordingAuditService) RecordMutation(actor data.AuditActor, tenantId string, action string, scope map[string]string) {
+	s.mutations[action] = scope
+}
+
+func TestBulkOperationsOnlyCaptureNotificationsForSubscribedWebhooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		subscribed bool
+	}{
+		{"without webhook the notifications are not looked up", false},
+		{"with a webhook the changed notifications are published", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := &stubNotificationRepository{notifications: []models.Notification{
+				{Id: primitive.NewObjectID(), AppId: "app-a", UserId: "u1", Message: "main is red"},
+				{Id: primitive.NewObjectID(), AppId: "app-a", UserId: "u1", Message: "main is green"},
+			}}
+			webhooks := &stubWebhookService{subscribed: test.subscribed, published: map[string][]models.Notification{}}
+			audit := &recordingAuditService{mutations: map[string]map[string]string{}}
+			service, err := NewNotificationServiceImpl(repository, webhooks, audit, validator.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			service = service.ForTenant("tenant-b")
+
+			if err := service.MarkAppAsRead("u1", ` "app-a" `); err != nil {
+				t.Fatal(err)
+			}
+			if err := service.DeleteNotifications("u1"); err != nil {
+				t.Fatal(err)
+			}
+
+			expectedQueries := []string{"tenant-b:app-a:" + data.WEBHOOK_EVENT_READ, "tenant-b::" + data.WEBHOOK_EVENT_DELETED}
+			if len(webhooks.queried) != 2 || webhooks.queried[0] != expectedQueries[0] || webhooks.queried[1] != expectedQueries[1] {
+				t.Fatalf("expected the subscriptions %v to be queried, got %v", expectedQueries, webhooks.queried)
+			}
+			expectedLookups := 0
+			if test.subscribed {
+				expectedLookups = 2
+			}
+			if repository.lookups != expectedLookups {
+				t.Fatalf("expected %d lookups of the affected notifications, got %d", expectedLookups, repository.lookups)
+			}
+			read, deleted := webhooks.published[data.WEBHOOK_EVENT_READ], webhooks.published[data.WEBHOOK_EVENT_DELETED]
+			if test.subscribed != (len(read) == 2 && len(deleted) == 2) || (test.subscribed && !read[0].ReadStatus) {
+				t.Fatalf("expected the events to be published only to subscribed webhooks, got read %+v and deleted %+v", read, deleted)
+			}
+			for _, action := range []string{"notification.MarkAppAsRead", "notification.DeleteNotifications"} {
+				if audit.mutations[action]["count"] != "2" {
+					t.Fatalf("expected %s to be audited with the number of changed notifications, got %v", action, audit.mutations[action])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package pushService
+
+import (
+	"r2-notify-server/data"
+)
+
+type PushService interface {
+	ForTenant(tenantId string) PushService
+	PublicKey() string
+	Subscribe(userId string, request data.PushSubscriptionRequest) error
+	Unsubscribe(userId string, endpoint string) error
+	NotifyOfflineUser(notification data.Notification) (sent bool, err error)
+}
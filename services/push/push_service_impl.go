@@ -0,0 +1,239 @@
+package pushService
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	pushRepository "r2-notify-server/repository/push"
+	clientStore "r2-notify-server/services"
+	configurationService "r2-notify-server/services/configuration"
+	"strings"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/go-playground/validator/v10"
+)
+
+type PushServiceImpl struct {
+	PushRepository       pushRepository.PushRepository
+	ConfigurationService configurationService.ConfigurationService
+	Validate             *validator.Validate
+	TenantId             string
+	httpClient           *http.Client
+	keys                 models.VapidKeys
+}
+
+// NewPushServiceImpl returns a new instance of PushService which delivers notifications to
+// browsers through the Web Push protocol. When push is enabled the VAPID key pair is taken from
+// VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY, or else loaded from MongoDB, generating and storing a
+// new pair on first start so that browser subscriptions survive restarts.
+// The returned service manages the push subscriptions of the default tenant.
+func NewPushServiceImpl(pushRepository pushRepository.PushRepository, configurationService configurationService.ConfigurationService, validate *validator.Validate) (service PushService, err error) {
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	cfg := config.LoadConfig()
+	impl := &PushServiceImpl{
+		PushRepository:       pushRepository,
+		ConfigurationService: configurationService,
+		Validate:             validate,
+		TenantId:             cfg.DefaultTenant,
+		httpClient:           &http.Client{Timeout: time.Duration(cfg.PushTimeout) * time.Second},
+	}
+	if cfg.EnablePush {
+		if impl.keys, err = loadVapidKeys(pushRepository); err != nil {
+			return nil, err
+		}
+	}
+	return impl, nil
+}
+
+// loadVapidKeys resolves the VAPID key pair from the configuration or the repository,
+// generating a new pair when neither has one.
+func loadVapidKeys(repository pushRepository.PushRepository) (models.VapidKeys, error) {
+	cfg := config.LoadConfig()
+	if cfg.VapidPublicKey != "" && cfg.VapidPrivateKey != "" {
+		return models.VapidKeys{PublicKey: cfg.VapidPublicKey, PrivateKey: cfg.VapidPrivateKey}, nil
+	}
+	keys, err := repository.FindVapidKeys()
+	if err == nil {
+		return keys, nil
+	}
+	if !errors.Is(err, pushRepository.ErrNoVapidKeys) {
+		return models.VapidKeys{}, err
+	}
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Service",
+			Operation: "LoadVapidKeys",
+			Message:   "Failed to generate vapid keys",
+			Error:     err,
+		})
+		return models.VapidKeys{}, err
+	}
+	return repository.CreateVapidKeys(models.VapidKeys{
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// ForTenant returns the service of the push subscriptions of the given tenant, the default tenant when it is empty.
+func (t *PushServiceImpl) ForTenant(tenantId string) PushService {
+	service := *t
+	service.PushRepository = t.PushRepository.ForTenant(tenantId)
+	service.TenantId = config.ResolveTenant(tenantId)
+	return &service
+}
+
+// PublicKey returns the VAPID public key browsers need as applicationServerKey when subscribing.
+// It is empty when push is disabled.
+func (t *PushServiceImpl) PublicKey() string {
+	return t.keys.PublicKey
+}
+
+// Subscribe stores the browser push subscription of the given user of the tenant.
+func (t *PushServiceImpl) Subscribe(userId string, request data.PushSubscriptionRequest) error {
+	if err := t.Validate.Struct(request); err != nil {
+		return err
+	}
+	return t.PushRepository.SaveSubscription(models.PushSubscription{
+		UserId:   userId,
+		DeviceId: request.DeviceId,
+		Endpoint: request.Endpoint,
+		P256dh:   request.Keys.P256dh,
+		Auth:     request.Keys.Auth,
+	})
+}
+
+// Unsubscribe removes the browser push subscription of the given user of the tenant with the given endpoint.
+func (t *PushServiceImpl) Unsubscribe(userId string, endpoint string) error {
+	return t.PushRepository.DeleteSubscription(userId, endpoint)
+}
+
+// NotifyOfflineUser pushes the given notification to every browser subscription its recipient
+// registered in the notification's tenant when push is enabled, the user is not connected and has notifications enabled. Subscriptions
+// the push service reports as gone (404 or 410) are pruned. It returns true if at least one push
+// service accepted the message.
+func (t *PushServiceImpl) NotifyOfflineUser(notification data.Notification) (bool, error) {
+	cfg := config.LoadConfig()
+	userId := notification.UserID
+	tenantId := config.ResolveTenant(notification.TenantId)
+	if !cfg.EnablePush || clientStore.IsConnected(tenantId, userId) {
+		return false, nil
+	}
+	configuration, err := t.ConfigurationService.ForTenant(tenantId).FindByAppAndUser(userId)
+	if err != nil {
+		return false, err
+	}
+	if !configuration.Data.EnableNotification {
+		return false, nil
+	}
+	repository := t.PushRepository.ForTenant(tenantId)
+	subscriptions, err := repository.FindSubscriptions(userId)
+	if err != nil || len(subscriptions) == 0 {
+		return false, err
+	}
+	message, err := json.Marshal(data.PushMessage{
+		Id:        notification.Id,
+		AppId:     notification.AppId,
+		GroupKey:  notification.GroupKey,
+		Title:     notification.Title,
+		ActionUrl: notification.ActionUrl,
+		Message:   notification.Message,
+		Status:    notification.Status,
+		Priority:  notification.Priority,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	sent := false
+	var lastErr error
+	for _, subscription := range subscriptions {
+		if err := t.send(repository, subscription, message, notification.Priority); err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		logger.Log.Info(logger.LogPayload{
+			Component: "Push Service",
+			Operation: "NotifyOfflineUser",
+			Message:   "Pushed notification to offline userId: " + userId,
+			UserId:    userId,
+			AppId:     notification.AppId,
+		})
+		return true, nil
+	}
+	return false, lastErr
+}
+
+// send delivers one message to one browser subscription and prunes the subscription when
+// the push service no longer knows it.
+func (t *PushServiceImpl) send(repository pushRepository.PushRepository, subscription models.PushSubscription, message []byte, priority string) error {
+	cfg := config.LoadConfig()
+	response, err := webpush.SendNotification(message, &webpush.Subscription{
+		Endpoint: subscription.Endpoint,
+		Keys:     webpush.Keys{P256dh: subscription.P256dh, Auth: subscription.Auth},
+	}, &webpush.Options{
+		HTTPClient:      t.httpClient,
+		Subscriber:      strings.TrimPrefix(cfg.VapidSubject, "mailto:"),
+		TTL:             cfg.PushTTL,
+		Urgency:         urgency(priority),
+		VAPIDPublicKey:  t.keys.PublicKey,
+		VAPIDPrivateKey: t.keys.PrivateKey,
+	})
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Push Service",
+			Operation: "Send",
+			Message:   "Failed to push notification to userId: " + subscription.UserId,
+			Error:     err,
+			UserId:    subscription.UserId,
+		})
+		return err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		logger.Log.Info(logger.LogPayload{
+			Component: "Push Service",
+			Operation: "Send",
+			Message:   "Pruning expired push subscription for userId: " + subscription.UserId,
+			UserId:    subscription.UserId,
+		})
+		_ = repository.DeleteSubscriptionById(subscription.Id)
+		return fmt.Errorf("push subscription expired with status %d", response.StatusCode)
+	case response.StatusCode < 200 || response.StatusCode >= 300:
+		err := fmt.Errorf("push service responded with status %d", response.StatusCode)
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Push Service",
+			Operation: "Send",
+			Message:   "Push service rejected notification for userId: " + subscription.UserId,
+			Error:     err,
+			UserId:    subscription.UserId,
+		})
+		return err
+	}
+	return nil
+}
+
+// urgency maps a notification priority to the Web Push Urgency header.
+func urgency(priority string) webpush.Urgency {
+	switch priority {
+	case data.PRIORITY_HIGH:
+		return webpush.UrgencyHigh
+	case data.PRIORITY_LOW:
+		return webpush.UrgencyLow
+	}
+	return webpush.UrgencyNormal
+}
@@ -0,0 +1,379 @@
+package pushService
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	pushRepository "r2-notify-server/repository/push"
+	configurationService "r2-notify-server/services/configuration"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// memoryPushRepository keeps the subscriptions and vapid keys in memory.
+type memoryPushRepository struct {
+	mutex         *sync.Mutex
+	subscriptions *[]models.PushSubscription
+	keys          *models.VapidKeys
+}
+
+func newMemoryPushRepository() memoryPushRepository {
+	return memoryPushRepository{mutex: &sync.Mutex{}, subscriptions: &[]models.PushSubscription{}, keys: &models.VapidKeys{}}
+}
+
+func (r memoryPushRepository) ForTenant(string) pushRepository.PushRepository {
+	return r
+}
+
+func (r memoryPushRepository) FindSubscriptions(userId string) ([]models.PushSubscription, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	subscriptions := []models.PushSubscription{}
+	for _, subscription := range *r.subscriptions {
+		if subscription.UserId == userId {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (r memoryPushRepository) SaveSubscription(subscription models.PushSubscription) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	subscription.Id = primitive.NewObjectID()
+	*r.subscriptions = append(*r.subscriptions, subscription)
+	return nil
+}
+
+func (r memoryPushRepository) DeleteSubscription(userId string, endpoint string) error {
+	return errors.New("not implemented")
+}
+
+func (r memoryPushRepository) DeleteSubscriptionById(id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	kept := []models.PushSubscription{}
+	for _, subscription := range *r.subscriptions {
+		if subscription.Id != id {
+			kept = append(kept, subscription)
+		}
+	}
+	*r.subscriptions = kept
+	return nil
+}
+
+func (r memoryPushRepository) FindVapidKeys() (models.VapidKeys, error) {
+	if r.keys.PublicKey == "" {
+		return models.VapidKeys{}, pushRepository.ErrNoVapidKeys
+	}
+	return *r.keys, nil
+}
+
+func (r memoryPushRepository) CreateVapidKeys(keys models.VapidKeys) (models.VapidKeys, error) {
+	*r.keys = keys
+	return keys, nil
+}
+
+func (r memoryPushRepository) endpoints() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	endpoints := []string{}
+	for _, subscription := range *r.subscriptions {
+		endpoints = append(endpoints, subscription.Endpoint)
+	}
+	return endpoints
+}
+
+// stubConfigurationService returns the same configuration for every user and tenant.
+type stubConfigurationService struct {
+	configurationService.ConfigurationService
+	enabled bool
+}
+
+func (s stubConfigurationService) ForTenant(string) configurationService.ConfigurationService {
+	return s
+}
+
+func (s stubConfigurationService) FindByAppAndUser(userId string) (data.Configuration, error) {
+	return data.Configuration{Data: data.NotificationConfig{EnableNotification: s.enabled}}, nil
+}
+
+// browser holds the keys a browser subscribes with, and decrypts the messages pushed to it.
+type browser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newBrowser(t *testing.T) browser {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return browser{key: key, auth: auth}
+}
+
+func hmacSha256(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+// decrypt opens an aes128gcm encrypted push message (RFC 8188 and RFC 8291) of a single record.
+func (b browser) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("message is too short")
+	}
+	salt, keyIdLength := body[:16], int(body[20])
+	if binary.BigEndian.Uint32(body[16:20]) < 18 || len(body) < 21+keyIdLength {
+		return nil, errors.New("invalid header")
+	}
+	serverKey, err := ecdh.P256().NewPublicKey(body[21 : 21+keyIdLength])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := b.key.ECDH(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	info := append(append([]byte("WebPush: info\x00"), b.key.PublicKey().Bytes()...), serverKey.Bytes()...)
+	ikm := hmacSha256(hmacSha256(b.auth, secret), info, []byte{1})
+	prk := hmacSha256(salt, ikm)
+	contentKey := hmacSha256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16]
+	nonce := hmacSha256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12]
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, body[21+keyIdLength:], nil)
+	if err != nil {
+		return nil, err
+	}
+	// The last record ends with the delimiter 2, followed by zero padding
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 2 {
+		return nil, errors.New("missing record delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// pushRequest is a message received by the push service stand-in.
+type pushRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// pushStandIn is a Web Push service answering each subscription path with the given status, 201 by default.
+type pushStandIn struct {
+	server   *httptest.Server
+	statuses map[string]int
+	mutex    sync.Mutex
+	requests []pushRequest
+}
+
+func newPushStandIn(t *testing.T, statuses map[string]int) *pushStandIn {
+	standIn := &pushStandIn{statuses: statuses}
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		standIn.mutex.Lock()
+		standIn.requests = append(standIn.requests, pushRequest{path: request.URL.Path, header: request.Header.Clone(), body: body})
+		standIn.mutex.Unlock()
+		status, ok := standIn.statuses[request.URL.Path]
+		if !ok {
+			status = http.StatusCreated
+		}
+		writer.WriteHeader(status)
+	}))
+	t.Cleanup(standIn.server.Close)
+	return standIn
+}
+
+func (s *pushStandIn) received() []pushRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]pushRequest{}, s.requests...)
+}
+
+// subscribe registers a subscription of the browser for the user at the given path of the stand-in.
+func subscribe(t *testing.T, service PushService, standIn *pushStandIn, userId string, path string, browser browser) {
+	t.Helper()
+	err := service.Subscribe(userId, data.PushSubscriptionRequest{
+		DeviceId: path,
+		Endpoint: standIn.server.URL + path,
+		Keys: data.PushSubscriptionKeys{
+			P256dh: base64.RawURLEncoding.EncodeToString(browser.key.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(browser.auth),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestPushService(t *testing.T, repository pushRepository.PushRepository, enabled bool) PushService {
+	t.Helper()
+	service, err := NewPushServiceImpl(repository, stubConfigurationService{enabled: enabled}, validator.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+var testNotification = data.Notification{
+	Id:       "n1",
+	AppId:    "app-a",
+	UserID:   "u1",
+	GroupKey: "builds",
+	Title:    "Build failed",
+	Message:  "main is red",
+	Status:   "error",
+	Priority: data.PRIORITY_HIGH,
+}
+
+func TestNotifyOfflineUserPushesAnEncryptedMessage(t *testing.T) {
+	t.Setenv("ENABLE_PUSH", "true")
+	t.Setenv("VAPID_SUBJECT", "mailto:ops@example.com")
+	t.Setenv("PUSH_TTL", "3600")
+	standIn := newPushStandIn(t, nil)
+	repository := newMemoryPushRepository()
+	service := newTestPushService(t, repository, true)
+	if service.PublicKey() == "" {
+		t.Fatal("expected a vapid key pair to be generated")
+	}
+	laptop, phone := newBrowser(t), newBrowser(t)
+	subscribe(t, service, standIn, "u1", "/laptop", laptop)
+	subscribe(t, service, standIn, "u1", "/phone", phone)
+	subscribe(t, service, standIn, "u2", "/other", newBrowser(t))
+
+	sent, err := service.NotifyOfflineUser(testNotification)
+	if err != nil || !sent {
+		t.Fatalf("expected the notification to be pushed, got %v %v", sent, err)
+	}
+
+	requests := standIn.received()
+	if len(requests) != 2 {
+		t.Fatalf("expected a push to each of the 2 browsers of u1, got %d", len(requests))
+	}
+	for _, request := range requests {
+		browser := map[string]browser{"/laptop": laptop, "/phone": phone}[request.path]
+		if request.header.Get("Content-Encoding") != "aes128gcm" || request.header.Get("TTL") != "3600" || request.header.Get("Urgency") != "high" {
+			t.Fatalf("expected an aes128gcm push with TTL 3600 and high urgency, got %v", request.header)
+		}
+		if authorization := request.header.Get("Authorization"); !strings.HasPrefix(authorization, "vapid t=") || !strings.HasSuffix(authorization, "k="+service.PublicKey()) {
+			t.Fatalf("expected a vapid authorization with the public key, got %q", authorization)
+		}
+		plaintext, err := browser.decrypt(request.body)
+		if err != nil {
+			t.Fatalf("expected the push to %s to be encrypted for its browser: %v", request.path, err)
+		}
+		var message data.PushMessage
+		if err := json.Unmarshal(plaintext, &message); err != nil {
+			t.Fatal(err)
+		}
+		expected := data.PushMessage{Id: "n1", AppId: "app-a", GroupKey: "builds", Title: "Build failed", Message: "main is red", Status: "error", Priority: data.PRIORITY_HIGH}
+		if message != expected {
+			t.Fatalf("expected message %+v, got %+v", expected, message)
+		}
+	}
+}
+
+func TestNotifyOfflineUserHandlesTheResponsesOfThePushService(t *testing.T) {
+	t.Setenv("ENABLE_PUSH", "true")
+	tests := []struct {
+		name     string
+		statuses map[string]int
+		sent     bool
+		kept     []string
+	}{
+		{"accepted by every browser", nil, true, []string{"/laptop", "/phone"}},
+		{"gone subscriptions are pruned", map[string]int{"/laptop": http.StatusGone}, true, []string{"/phone"}},
+		{"unknown subscriptions are pruned", map[string]int{"/laptop": http.StatusNotFound, "/phone": http.StatusNotFound}, false, []string{}},
+		{"rejected pushes keep the subscription", map[string]int{"/laptop": http.StatusInternalServerError, "/phone": http.StatusTooManyRequests}, false, []string{"/laptop", "/phone"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := newPushStandIn(t, test.statuses)
+			repository := newMemoryPushRepository()
+			service := newTestPushService(t, repository, true)
+			subscribe(t, service, standIn, "u1", "/laptop", newBrowser(t))
+			subscribe(t, service, standIn, "u1", "/phone", newBrowser(t))
+
+			sent, err := service.NotifyOfflineUser(testNotification)
+			if sent != test.sent || (err == nil) != test.sent {
+				t.Fatalf("expected sent to be %v, got %v %v", test.sent, sent, err)
+			}
+			kept := []string{}
+			for _, endpoint := range repository.endpoints() {
+				kept = append(kept, strings.TrimPrefix(endpoint, standIn.server.URL))
+			}
+			if strings.Join(kept, ",") != strings.Join(test.kept, ",") {
+				t.Fatalf("expected subscriptions %v to be kept, got %v", test.kept, kept)
+			}
+		})
+	}
+}
+
+func TestNotifyOfflineUserSkipsUsersWithoutPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		push    string
+		enabled bool
+	}{
+		{"push is disabled", "false", true},
+		{"user muted notifications", "true", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ENABLE_PUSH", "true")
+			standIn := newPushStandIn(t, nil)
+			service := newTestPushService(t, newMemoryPushRepository(), test.enabled)
+			subscribe(t, service, standIn, "u1", "/laptop", newBrowser(t))
+			t.Setenv("ENABLE_PUSH", test.push)
+
+			sent, err := service.NotifyOfflineUser(testNotification)
+			if err != nil || sent {
+				t.Fatalf("expected nothing to be pushed, got %v %v", sent, err)
+			}
+			if len(standIn.received()) != 0 {
+				t.Fatal("expected the push service not to be reached")
+			}
+		})
+	}
+}
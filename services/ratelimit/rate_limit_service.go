@@ -0,0 +1,38 @@
+package rateLimitService
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrRateLimited is wrapped by the LimitExceededError returned once a rate limit is exceeded.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// LimitExceededError is returned when the bucket of a rate limit has no token left.
+type LimitExceededError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %ds", ErrRateLimited, e.Scope, e.RetryAfterSeconds())
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// RetryAfterSeconds returns the number of seconds until a token is available, at least 1.
+func (e *LimitExceededError) RetryAfterSeconds() int {
+	return max(int(math.Ceil(e.RetryAfter.Seconds())), 1)
+}
+
+// RateLimitService limits the notifications created per app and per recipient, and the events
+// received per WebSocket event type from every user and per WebSocket connection, with token buckets
+// shared by the replicas in Redis.
+type RateLimitService interface {
+	AllowNotification(tenantId string, appId string, userId string) error
+	AllowSocketEvent(tenantId string, userId string, connectionId string, event string) error
+}
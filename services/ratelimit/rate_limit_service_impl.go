@@ -0,0 +1,218 @@
+package rateLimitService
+
+import (
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokens refills the token buckets given as keys, each with the rate per minute and the burst
+// given as arguments after the current time in milliseconds, and takes a token from every bucket
+// only if none is empty. It returns the position of the first empty bucket, 0 if none is, and the
+// milliseconds until it holds a token again. Buckets expire once they would be full again.
+var takeTokens = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2]) / 60000
+	local burst = tonumber(ARGV[i * 2 + 1])
+	local bucket = redis.call('HMGET', key, 'tokens', 'at')
+	local available = tonumber(bucket[1]) or burst
+	local at = tonumber(bucket[2]) or now
+	available = math.min(burst, available + math.max(0, now - at) * rate)
+	if available < 1 then
+		return {i, math.ceil((1 - available) / rate)}
+	end
+	tokens[i] = available - 1
+end
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2]) / 60000
+	local burst = tonumber(ARGV[i * 2 + 1])
+	redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'at', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil((burst - tokens[i]) / rate) + 1000)
+end
+return {0, 0}
+`)
+
+// unknownEvent is the event type the buckets of the events the server does not handle are keyed by,
+// so that clients can not fill Redis with buckets of made up event names.
+const unknownEvent = "unknown"
+
+// socketEvents are the WebSocket events handled by the server, which have a bucket of their own.
+var socketEvents = []string{
+	data.MARK_AS_READ,
+	data.MARK_APP_AS_READ,
+	data.MARK_GROUP_AS_READ,
+	data.MARK_NOTIFICATION_AS_READ,
+	data.DELETE_NOTIFICATIONS,
+	data.DELETE_APP_NOTIFICATIONS,
+	data.DELETE_GROUP_NOTIFICATIONS,
+	data.DELETE_NOTIFICATION,
+	data.RELOAD_NOTIFICATIONS,
+	data.SET_NOTIFICATION_STATUS,
+	data.SET_EMAIL_NOTIFICATION_STATUS,
+	data.SET_DIGEST_NOTIFICATION_STATUS,
+	data.SET_CHAT_TARGETS,
+	data.REGISTER_PUSH_SUBSCRIPTION,
+	data.REAUTHENTICATE,
+}
+
+// limit is a token bucket refilled with rate tokens per minute, holding up to burst tokens.
+// A limit with no rate is not enforced.
+type limit struct {
+	rate  int
+	burst int
+}
+
+// bucket is the token bucket of a limit applied to a scope, such as an app or a user.
+type bucket struct {
+	key   string
+	scope string
+	limit limit
+}
+
+type RateLimitServiceImpl struct {
+	enabled    bool
+	app        limit
+	user       limit
+	socket     limit
+	connection limit
+	events     map[string]limit
+}
+
+// NewRateLimitServiceImpl returns a new instance of RateLimitService with the limits configured
+// through the RATE_LIMIT_* variables, which are only enforced when ENABLE_RATE_LIMIT is set.
+// If RATE_LIMIT_SOCKET_EVENTS or RATE_LIMIT_OVERFLOW is invalid, an error is returned.
+func NewRateLimitServiceImpl() (service RateLimitService, err error) {
+	cfg := config.LoadConfig()
+	switch cfg.RateLimitOverflow {
+	case data.RATE_LIMIT_OVERFLOW_DEAD_LETTER, data.RATE_LIMIT_OVERFLOW_DROP, data.RATE_LIMIT_OVERFLOW_DELAY:
+	default:
+		return nil, fmt.Errorf("unsupported rate limit overflow %q", cfg.RateLimitOverflow)
+	}
+	socket := limit{rate: cfg.RateLimitSocket, burst: cfg.RateLimitSocketBurst}
+	events, err := parseEventLimits(cfg.RateLimitSocketEvents, socket)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimitServiceImpl{
+		enabled:    cfg.EnableRateLimit,
+		app:        limit{rate: cfg.RateLimitApp, burst: cfg.RateLimitAppBurst},
+		user:       limit{rate: cfg.RateLimitUser, burst: cfg.RateLimitUserBurst},
+		socket:     socket,
+		connection: limit{rate: cfg.RateLimitConnection, burst: cfg.RateLimitConnectionBurst},
+		events:     events,
+	}, nil
+}
+
+// AllowNotification takes a token from the buckets of the app and of the recipient of a notification.
+// When either is empty, no token is taken and a LimitExceededError is returned.
+func (t *RateLimitServiceImpl) AllowNotification(tenantId string, appId string, userId string) error {
+	return t.take(
+		bucket{key: "ratelimit:app:" + tenantId + ":" + appId, scope: "app " + appId, limit: t.app},
+		bucket{key: "ratelimit:user:" + tenantId + ":" + userId, scope: "user " + userId, limit: t.user},
+	)
+}
+
+// AllowSocketEvent takes a token from the bucket of the event type for the user and from the bucket
+// of the connection, and returns a LimitExceededError when either is empty. Every event type has its
+// own bucket, so that a client reloading its notifications too often can still mark them as read,
+// except the events the server does not handle, which share the unknown bucket. The bucket of the
+// connection caps the events of every type a single connection sends.
+func (t *RateLimitServiceImpl) AllowSocketEvent(tenantId string, userId string, connectionId string, event string) error {
+	if !slices.Contains(socketEvents, event) {
+		event = unknownEvent
+	}
+	eventLimit, ok := t.events[event]
+	if !ok {
+		eventLimit = t.socket
+	}
+	return t.take(
+		bucket{key: "ratelimit:socket:" + tenantId + ":" + userId + ":" + event, scope: event + " events", limit: eventLimit},
+		bucket{key: "ratelimit:connection:" + tenantId + ":" + connectionId, scope: "connection", limit: t.connection},
+	)
+}
+
+// take takes a token from every enforced bucket, or from none of them. Failures to reach Redis are
+// logged and let the request through, so that notifications are not lost while Redis is unavailable.
+func (t *RateLimitServiceImpl) take(buckets ...bucket) error {
+	if !t.enabled {
+		return nil
+	}
+	var enforced []bucket
+	keys := []string{}
+	args := []interface{}{time.Now().UnixMilli()}
+	for _, b := range buckets {
+		if b.limit.rate > 0 {
+			enforced = append(enforced, b)
+			keys = append(keys, b.key)
+			args = append(args, b.limit.rate, max(b.limit.burst, 1))
+		}
+	}
+	if len(enforced) == 0 {
+		return nil
+	}
+
+	result, err := takeTokens.Run(config.Ctx, config.RDB, keys, args...).Int64Slice()
+	if err == nil && len(result) != 2 {
+		err = errors.New("unexpected result of the token bucket script")
+	}
+	if err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Rate Limit Service",
+			Operation: "Take",
+			Message:   "Failed to take a token of " + enforced[0].scope + ", the request is let through",
+			Error:     err,
+		})
+		return nil
+	}
+	if result[0] == 0 {
+		return nil
+	}
+	return &LimitExceededError{
+		Scope:      enforced[result[0]-1].scope,
+		RetryAfter: time.Duration(result[1]) * time.Millisecond,
+	}
+}
+
+// parseEventLimits reads the limits of RATE_LIMIT_SOCKET_EVENTS, a comma separated list of
+// <event>=<rate per minute>/<burst> entries, where event is a handled event or unknown. The burst
+// defaults to the one of RATE_LIMIT_SOCKET_BURST.
+func parseEventLimits(value string, socket limit) (map[string]limit, error) {
+	events := map[string]limit{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		event, rates, found := strings.Cut(entry, "=")
+		event = strings.TrimSpace(event)
+		if !found || event == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected <event>=<rate>/<burst>", entry)
+		}
+		if event != unknownEvent && !slices.Contains(socketEvents, event) {
+			return nil, fmt.Errorf("unknown event %q in rate limit %q", event, entry)
+		}
+		rate, burst, hasBurst := strings.Cut(rates, "/")
+		eventLimit := limit{burst: socket.burst}
+		var err error
+		if eventLimit.rate, err = strconv.Atoi(strings.TrimSpace(rate)); err != nil || eventLimit.rate < 0 {
+			return nil, fmt.Errorf("invalid rate of rate limit %q", entry)
+		}
+		if hasBurst {
+			if eventLimit.burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || eventLimit.burst < 1 {
+				return nil, fmt.Errorf("invalid burst of rate limit %q", entry)
+			}
+		}
+		events[event] = eventLimit
+	}
+	return events, nil
+}
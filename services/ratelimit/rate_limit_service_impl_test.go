@@ -0,0 +1,287 @@
+package rateLimitService
+
+import (
+	"errors"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// newService returns a rate limit service over an in-memory Redis, with the given RATE_LIMIT_* variables.
+func newService(t *testing.T, env map[string]string) (RateLimitService, *miniredis.Miniredis) {
+	t.Helper()
+	redisServer := miniredis.RunT(t)
+	config.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Setenv("ENABLE_RATE_LIMIT", "true")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	service, err := NewRateLimitServiceImpl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, redisServer
+}
+
+// expectLimitExceeded fails the test unless err is a LimitExceededError of the scope, with a token available within a second.
+func expectLimitExceeded(t *testing.T, err error, scope string) {
+	t.Helper()
+	var exceeded *LimitExceededError
+	if !errors.As(err, &exceeded) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected the rate limit of %s to be exceeded, got %v", scope, err)
+	}
+	if exceeded.Scope != scope {
+		t.Fatalf("expected the rate limit of %s to be exceeded, got %s", scope, exceeded.Scope)
+	}
+	if exceeded.RetryAfter <= 0 || exceeded.RetryAfter > time.Second || exceeded.RetryAfterSeconds() != 1 {
+		t.Fatalf("expected a token to be available within a second, got %s", exceeded.RetryAfter)
+	}
+}
+
+// rewind moves the last refill of a bucket back, as if the time had passed.
+func rewind(t *testing.T, redisServer *miniredis.Miniredis, key string, elapsed time.Duration) {
+	t.Helper()
+	at, err := strconv.ParseInt(redisServer.HGet(key, "at"), 10, 64)
+	if err != nil {
+		t.Fatalf("expected the bucket %s to exist, got %v", key, err)
+	}
+	redisServer.HSet(key, "at", strconv.FormatInt(at-elapsed.Milliseconds(), 10))
+}
+
+func TestAllowNotification(t *testing.T) {
+	service, redisServer := newService(t, map[string]string{
+		"RATE_LIMIT_APP":        "60",
+		"RATE_LIMIT_APP_BURST":  "3",
+		"RATE_LIMIT_USER":       "60",
+		"RATE_LIMIT_USER_BURST": "2",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := service.AllowNotification("default", "app-a", "u1"); err != nil {
+			t.Fatalf("expected notification %d to be allowed, got %v", i+1, err)
+		}
+	}
+	expectLimitExceeded(t, service.AllowNotification("default", "app-a", "u1"), "user u1")
+
+	// The app bucket kept the token the rejected notification did not take
+	if err := service.AllowNotification("default", "app-a", "u2"); err != nil {
+		t.Fatalf("expected the notification of another user to be allowed, got %v", err)
+	}
+	expectLimitExceeded(t, service.AllowNotification("default", "app-a", "u3"), "app app-a")
+
+	if err := service.AllowNotification("tenant-b", "app-a", "u1"); err != nil {
+		t.Fatalf("expected the buckets of another tenant to be separate, got %v", err)
+	}
+
+	rewind(t, redisServer, "ratelimit:app:default:app-a", time.Second)
+	rewind(t, redisServer, "ratelimit:user:default:u1", time.Second)
+	if err := service.AllowNotification("default", "app-a", "u1"); err != nil {
+		t.Fatalf("expected a token to be refilled after a second, got %v", err)
+	}
+	if ttl := redisServer.TTL("ratelimit:user:default:u1"); ttl <= 0 || ttl > 3*time.Second {
+		t.Fatalf("expected the bucket to expire once it would be full again, got %s", ttl)
+	}
+}
+
+func TestAllowSocketEvent(t *testing.T) {
+	service, _ := newService(t, map[string]string{
+		"RATE_LIMIT_SOCKET":        "60",
+		"RATE_LIMIT_SOCKET_BURST":  "2",
+		"RATE_LIMIT_SOCKET_EVENTS": "reloadNotifications=60/1",
+	})
+
+	if err := service.AllowSocketEvent("default", "u1", "c1", "reloadNotifications"); err != nil {
+		t.Fatal(err)
+	}
+	expectLimitExceeded(t, service.AllowSocketEvent("default", "u1", "c1", "reloadNotifications"), "reloadNotifications events")
+
+	for i := 0; i < 2; i++ {
+		if err := service.AllowSocketEvent("default", "u1", "c1", "markAsRead"); err != nil {
+			t.Fatalf("expected every event type to have its own bucket, got %v", err)
+		}
+	}
+	expectLimitExceeded(t, service.AllowSocketEvent("default", "u1", "c1", "markAsRead"), "markAsRead events")
+
+	if err := service.AllowSocketEvent("default", "u2", "c2", "markAsRead"); err != nil {
+		t.Fatalf("expected every user to have their own bucket, got %v", err)
+	}
+}
+
+func TestUnknownSocketEventsShareABucket(t *testing.T) {
+	service, redisServer := newService(t, map[string]string{
+		"RATE_LIMIT_SOCKET":        "60",
+		"RATE_LIMIT_SOCKET_BURST":  "5",
+		"RATE_LIMIT_SOCKET_EVENTS": "unknown=60/2",
+	})
+
+	for _, event := range []string{"made-up-1", "made-up-2"} {
+		if err := service.AllowSocketEvent("default", "u1", "c1", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLimitExceeded(t, service.AllowSocketEvent("default", "u1", "c1", "made-up-3"), "unknown events")
+
+	for _, key := range redisServer.Keys() {
+		if strings.Contains(key, "made-up") {
+			t.Fatalf("expected unknown events not to be part of the keys, got %s", key)
+		}
+	}
+	if !redisServer.Exists("ratelimit:socket:default:u1:unknown") {
+		t.Fatalf("expected the unknown bucket to be stored, got %v", redisServer.Keys())
+	}
+}
+
+func TestSocketEventsAreLimitedPerConnection(t *testing.T) {
+	service, _ := newService(t, map[string]string{
+		"RATE_LIMIT_SOCKET":           "60",
+		"RATE_LIMIT_SOCKET_BURST":     "5",
+		"RATE_LIMIT_CONNECTION":       "60",
+		"RATE_LIMIT_CONNECTION_BURST": "3",
+	})
+
+	for _, event := range []string{"markAsRead", "reloadNotifications", "deleteNotifications"} {
+		if err := service.AllowSocketEvent("default", "u1", "c1", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLimitExceeded(t, service.AllowSocketEvent("default", "u1", "c1", "markAppAsRead"), "connection")
+
+	// The bucket of the event type kept the token the rejected event did not take
+	if err := service.AllowSocketEvent("default", "u1", "c2", "markAppAsRead"); err != nil {
+		t.Fatalf("expected another connection of the user to have its own bucket, got %v", err)
+	}
+}
+
+func TestLimitsAreNotEnforced(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"rate limiting is disabled", map[string]string{"ENABLE_RATE_LIMIT": "false", "RATE_LIMIT_APP_BURST": "1", "RATE_LIMIT_USER_BURST": "1"}},
+		{"limits without rate", map[string]string{"RATE_LIMIT_APP": "0", "RATE_LIMIT_USER": "0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service, redisServer := newService(t, test.env)
+			for i := 0; i < 5; i++ {
+				if err := service.AllowNotification("default", "app-a", "u1"); err != nil {
+					t.Fatalf("expected notification %d to be allowed, got %v", i+1, err)
+				}
+			}
+			if keys := redisServer.Keys(); len(keys) != 0 {
+				t.Fatalf("expected no bucket to be stored, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestRequestsAreLetThroughWhenRedisIsUnavailable(t *testing.T) {
+	service, redisServer := newService(t, map[string]string{"RATE_LIMIT_USER_BURST": "1"})
+	redisServer.Close()
+	for i := 0; i < 3; i++ {
+		if err := service.AllowNotification("default", "app-a", "u1"); err != nil {
+			t.Fatalf("expected notification %d to be let through, got %v", i+1, err)
+		}
+	}
+}
+
+func TestNewRateLimitServiceImpl(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		events   string
+		valid    bool
+	}{
+		{"dead letter overflow", "deadLetter", "", true},
+		{"drop overflow", "drop", "", true},
+		{"delay overflow", "delay", "", true},
+		{"unknown overflow", "queue", "", false},
+		{"invalid event limit", "deadLetter", "reloadNotifications", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("RATE_LIMIT_OVERFLOW", test.overflow)
+			t.Setenv("RATE_LIMIT_SOCKET_EVENTS", test.events)
+			_, err := NewRateLimitServiceImpl()
+			if test.valid != (err == nil) {
+				t.Fatalf("expected valid to be %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestParseEventLimits(t *testing.T) {
+	socket := limit{rate: 60, burst: 20}
+	tests := []struct {
+		name   string
+		value  string
+		valid  bool
+		events map[string]limit
+	}{
+		{"no limits", "", true, map[string]limit{}},
+		{"rate and burst", "reloadNotifications=12/3", true, map[string]limit{"reloadNotifications": {rate: 12, burst: 3}}},
+		{"burst defaults to the socket burst", " markAsRead = 30 , ", true, map[string]limit{"markAsRead": {rate: 30, burst: 20}}},
+		{"no rate disables the limit", "markAsRead=0", true, map[string]limit{"markAsRead": {rate: 0, burst: 20}}},
+		{"missing rate", "reloadNotifications", false, nil},
+		{"missing event", "=12/3", false, nil},
+		{"invalid rate", "reloadNotifications=often", false, nil},
+		{"negative rate", "reloadNotifications=-1", false, nil},
+		{"invalid burst", "reloadNotifications=12/0", false, nil},
+		{"unknown events", "unknown=6/2", true, map[string]limit{"unknown": {rate: 6, burst: 2}}},
+		{"event not handled by the server", "madeUp=12/3", false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := parseEventLimits(test.value, socket)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected", test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, test.events) {
+				t.Fatalf("expected %v, got %v", test.events, events)
+			}
+		})
+	}
+}
+
+func TestLimitExceededError(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		seconds    int
+	}{
+		{0, 1},
+		{200 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1001 * time.Millisecond, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.retryAfter.String(), func(t *testing.T) {
+			err := &LimitExceededError{Scope: "user u1", RetryAfter: test.retryAfter}
+			if err.RetryAfterSeconds() != test.seconds {
+				t.Fatalf("expected to retry after %ds, got %ds", test.seconds, err.RetryAfterSeconds())
+			}
+			if want := "rate limit exceeded: user u1, retry after " + strconv.Itoa(test.seconds) + "s"; err.Error() != want {
+				t.Fatalf("expected %q, got %q", want, err.Error())
+			}
+		})
+	}
+}
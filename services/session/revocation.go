@@ -0,0 +1,99 @@
+package sessionService
+
+import (
+	"context"
+	"encoding/json"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	clientStore "r2-notify-server/services"
+	"r2-notify-server/utils"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revocationChannel prefixes the Redis channels revocations are published on, one per tenant, so that
+// every replica closes the WebSocket connections of the revoked sessions it holds.
+const revocationChannel = "sessions:revoked:"
+
+// revocation is a revoked session, or every session of the user when SessionId is empty.
+type revocation struct {
+	TenantId  string `json:"tenantId"`
+	UserId    string `json:"userId"`
+	SessionId string `json:"sessionId,omitempty"`
+}
+
+// IsRevoked reports whether a token belongs to a revoked session, or was issued before the sessions
+// of its user were revoked. Tokens without an issue time are revoked along with their user.
+func IsRevoked(claims *utils.TokenClaims) (bool, error) {
+	keys := []string{"revoked:user:" + claims.Tenant() + ":" + claims.Subject}
+	if claims.SessionId != "" {
+		keys = append(keys, "revoked:session:"+claims.SessionId)
+	}
+	values, err := config.RDB.MGet(config.Ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	if len(values) > 1 && values[1] != nil {
+		return true, nil
+	}
+	if value, ok := values[0].(string); ok {
+		revokedAt, _ := strconv.ParseInt(value, 10, 64)
+		return claims.IssuedAt == nil || claims.IssuedAt.Unix() <= revokedAt, nil
+	}
+	return false, nil
+}
+
+// revokeSession rejects the access tokens of a session until they have expired.
+func revokeSession(tenantId string, userId string, sessionId string) error {
+	ttl := time.Duration(config.LoadConfig().AccessTokenTtl)*time.Second + time.Minute
+	if err := config.RDB.Set(config.Ctx, "revoked:session:"+sessionId, userId, ttl).Err(); err != nil {
+		return err
+	}
+	return publish(revocation{TenantId: tenantId, UserId: userId, SessionId: sessionId})
+}
+
+// revokeUser rejects the tokens of a user issued until now. The revocation is kept as long as a
+// refresh token, as tokens of identity providers may be valid for longer than an access token.
+func revokeUser(tenantId string, userId string) error {
+	ttl := time.Duration(max(config.LoadConfig().RefreshTokenTtl, config.LoadConfig().AccessTokenTtl))*time.Second + time.Minute
+	if err := config.RDB.Set(config.Ctx, "revoked:user:"+tenantId+":"+userId, time.Now().Unix(), ttl).Err(); err != nil {
+		return err
+	}
+	return publish(revocation{TenantId: tenantId, UserId: userId})
+}
+
+func publish(revoked revocation) error {
+	message, _ := json.Marshal(revoked)
+	return config.RDB.Publish(config.Ctx, revocationChannel+revoked.TenantId, message).Err()
+}
+
+// closeRevokedConnections closes the WebSocket connections of the revocations published by
+// any replica until the context is cancelled.
+func closeRevokedConnections(ctx context.Context) {
+	subscription := config.RDB.PSubscribe(ctx, revocationChannel+"*")
+	defer subscription.Close()
+	messages := subscription.Channel(redis.WithChannelHealthCheckInterval(time.Minute))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var revoked revocation
+			if err := json.Unmarshal([]byte(message.Payload), &revoked); err != nil || revoked.UserId == "" || message.Channel != revocationChannel+revoked.TenantId {
+				logger.Log.Warn(logger.LogPayload{
+					Component: "Session Service",
+					Operation: "CloseRevokedConnections",
+					Message:   "Ignored invalid revocation message",
+					Error:     err,
+				})
+				continue
+			}
+			clientStore.CloseSessions(revoked.TenantId, revoked.UserId, revoked.SessionId)
+		}
+	}
+}
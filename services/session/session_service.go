@@ -0,0 +1,25 @@
+package sessionService
+
+import (
+	"context"
+	"errors"
+	"r2-notify-server/data"
+	"r2-notify-server/utils"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that are unknown, expired, or were already used.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrInvalidTicket is returned for WebSocket tickets that are unknown, expired, or were already used.
+	ErrInvalidTicket = errors.New("invalid ticket")
+)
+
+type SessionService interface {
+	Issue(user data.UserInfo) (tokens data.SessionTokens, err error)
+	Refresh(refreshToken string) (tokens data.SessionTokens, err error)
+	Logout(refreshToken string) error
+	RevokeUser(tenantId string, userId string) error
+	IssueTicket(claims *utils.TokenClaims) (ticket string, err error)
+	RedeemTicket(ticket string) (claims *utils.TokenClaims, err error)
+	Start(ctx context.Context)
+}
@@ -0,0 +1,178 @@
+package sessionService
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	sessionRepository "r2-notify-server/repository/session"
+	"r2-notify-server/utils"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SessionServiceImpl struct {
+	SessionRepository sessionRepository.SessionRepository
+}
+
+// NewSessionServiceImpl returns a new instance of SessionService which issues the tokens of login
+// sessions: access tokens valid for ACCESS_TOKEN_TTL seconds, and refresh tokens valid for
+// REFRESH_TOKEN_TTL seconds that are rotated on every refresh. Revoked sessions are recorded in Redis.
+func NewSessionServiceImpl(sessionRepository sessionRepository.SessionRepository) (service SessionService, err error) {
+	if sessionRepository == nil {
+		return nil, errors.New("session repository cannot be nil")
+	}
+	return &SessionServiceImpl{SessionRepository: sessionRepository}, nil
+}
+
+// Issue starts a new login session for the user.
+func (t *SessionServiceImpl) Issue(user data.UserInfo) (data.SessionTokens, error) {
+	return t.issue(models.RefreshToken{
+		SessionId: utils.GenerateUUID(),
+		TenantId:  config.ResolveTenant(user.TenantId),
+		UserId:    user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token of the same
+// session. A refresh token that was already used is a sign that it was stolen, and revokes its session.
+func (t *SessionServiceImpl) Refresh(refreshToken string) (data.SessionTokens, error) {
+	token, err := t.SessionRepository.RotateRefreshToken(hashToken(refreshToken), time.Now())
+	if errors.Is(err, sessionRepository.ErrRefreshTokenReused) {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Session Service",
+			Operation: "Refresh",
+			Message:   "Refresh token reused, revoking session " + token.SessionId,
+			UserId:    token.UserId,
+		})
+		if err := t.revokeSession(config.ResolveTenant(token.TenantId), token.UserId, token.SessionId); err != nil {
+			return data.SessionTokens{}, err
+		}
+		return data.SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if errors.Is(err, sessionRepository.ErrRefreshTokenNotFound) {
+		return data.SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return data.SessionTokens{}, err
+	}
+	return t.issue(token)
+}
+
+// Logout ends the session of a refresh token, closing its WebSocket connections. Logging out of a
+// session that already ended succeeds.
+func (t *SessionServiceImpl) Logout(refreshToken string) error {
+	token, err := t.SessionRepository.FindRefreshToken(hashToken(refreshToken))
+	if errors.Is(err, sessionRepository.ErrRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return t.revokeSession(config.ResolveTenant(token.TenantId), token.UserId, token.SessionId)
+}
+
+// RevokeUser ends every session of a user of a tenant and rejects the tokens issued to the user until now.
+func (t *SessionServiceImpl) RevokeUser(tenantId string, userId string) error {
+	if err := t.SessionRepository.DeleteUserSessions(tenantId, userId); err != nil {
+		return err
+	}
+	if err := revokeUser(tenantId, userId); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Service",
+			Operation: "RevokeUser",
+			Message:   "Failed to revoke the tokens of user",
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Session Service",
+		Operation: "RevokeUser",
+		Message:   "Revoked all sessions of user",
+		UserId:    userId,
+	})
+	return nil
+}
+
+// Start closes the WebSocket connections of the sessions revoked on any replica until the context is cancelled.
+func (t *SessionServiceImpl) Start(ctx context.Context) {
+	closeRevokedConnections(ctx)
+}
+
+func (t *SessionServiceImpl) revokeSession(tenantId string, userId string, sessionId string) error {
+	if err := t.SessionRepository.DeleteSession(sessionId); err != nil {
+		return err
+	}
+	if err := revokeSession(tenantId, userId, sessionId); err != nil {
+		logger.Log.Error(logger.LogPayload{
+			Component: "Session Service",
+			Operation: "RevokeSession",
+			Message:   "Failed to revoke session " + sessionId,
+			Error:     err,
+			UserId:    userId,
+		})
+		return err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Session Service",
+		Operation: "RevokeSession",
+		Message:   "Revoked session " + sessionId,
+		UserId:    userId,
+	})
+	return nil
+}
+
+// issue stores a new refresh token of a session and signs an access token for it.
+func (t *SessionServiceImpl) issue(session models.RefreshToken) (data.SessionTokens, error) {
+	cfg := config.LoadConfig()
+	now := time.Now()
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return data.SessionTokens{}, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+	if err := t.SessionRepository.CreateRefreshToken(models.RefreshToken{
+		SessionId: session.SessionId,
+		TenantId:  config.ResolveTenant(session.TenantId),
+		UserId:    session.UserId,
+		Name:      session.Name,
+		Email:     session.Email,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: now.Add(time.Duration(cfg.RefreshTokenTtl) * time.Second),
+	}); err != nil {
+		return data.SessionTokens{}, err
+	}
+
+	accessToken, err := utils.SignToken(jwt.MapClaims{
+		"sub":       session.UserId,
+		"email":     session.Email,
+		"name":      session.Name,
+		"sid":       session.SessionId,
+		"r2_tenant": config.ResolveTenant(session.TenantId),
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Duration(cfg.AccessTokenTtl) * time.Second).Unix(),
+	})
+	if err != nil {
+		return data.SessionTokens{}, err
+	}
+	return data.SessionTokens{Jwt: accessToken, RefreshToken: refreshToken, ExpiresIn: cfg.AccessTokenTtl}, nil
+}
+
+// hashToken returns the SHA-256 hash refresh tokens are stored and looked up by. The tokens are
+// random, so an unsalted hash is enough to keep them from being usable if the database leaks.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package sessionService
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/utils"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TicketTtl is the time a WebSocket ticket can be redeemed in.
+const TicketTtl = 30 * time.Second
+
+// IssueTicket exchanges the claims of a validated access token for an opaque ticket that opens a
+// single WebSocket connection within TicketTtl, so that the token itself is not sent in the URL.
+func (t *SessionServiceImpl) IssueTicket(claims *utils.TokenClaims) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(secret)
+	value, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	if err := config.RDB.Set(config.Ctx, "wsTicket:"+hashToken(ticket), value, TicketTtl).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// RedeemTicket returns the claims of the token a ticket was issued for, and deletes the ticket.
+func (t *SessionServiceImpl) RedeemTicket(ticket string) (*utils.TokenClaims, error) {
+	value, err := config.RDB.GetDel(config.Ctx, "wsTicket:"+hashToken(ticket)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+	var claims utils.TokenClaims
+	if err := json.Unmarshal(value, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
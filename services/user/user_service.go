@@ -0,0 +1,15 @@
+package userService
+
+import (
+	"r2-notify-server/data"
+	"r2-notify-server/utils"
+)
+
+type UserService interface {
+	ForTenant(tenantId string) UserService
+	FindAccess(claims *utils.TokenClaims) (access data.UserAccess, err error)
+	FindAll() (users []data.UserAccess, err error)
+	FindById(userId string) (user data.UserAccess, err error)
+	FindUserAccess(userId string) (access data.UserAccess, err error)
+	Save(user data.UserAccess) (data.UserAccess, error)
+}
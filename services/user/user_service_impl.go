@@ -0,0 +1,165 @@
+package userService
+
+import (
+	"errors"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+	userRepository "r2-notify-server/repository/user"
+	"r2-notify-server/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type UserServiceImpl struct {
+	UserRepository userRepository.UserRepository
+	Validate       *validator.Validate
+	TenantId       string
+}
+
+// NewUserServiceImpl returns a new instance of UserService with the provided UserRepository and
+// validator.Validate instance. If the validator instance is nil, an error is returned.
+// The returned service manages the users of the default tenant.
+func NewUserServiceImpl(userRepository userRepository.UserRepository, validate *validator.Validate) (service UserService, err error) {
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	return &UserServiceImpl{UserRepository: userRepository, Validate: validate, TenantId: config.LoadConfig().DefaultTenant}, nil
+}
+
+// ForTenant returns the service of the users of the given tenant, the default tenant when it is empty.
+func (t *UserServiceImpl) ForTenant(tenantId string) UserService {
+	service := *t
+	service.UserRepository = t.UserRepository.ForTenant(tenantId)
+	service.TenantId = config.ResolveTenant(tenantId)
+	return &service
+}
+
+// FindAccess returns the roles and apps of the user of a token in the token's tenant. They are the
+// union of the roles and apps claims of the token, those granted to the user in the users collection
+// of the tenant, and the super-admin role for the users of ADMIN_USER_IDS. Every user has the user role.
+// The claims are ignored unless the token was signed with a key of the server, as the JWKS document
+// and JWT_PUBLIC_KEYS may belong to an identity provider whose tokens carry roles of their own.
+func (t *UserServiceImpl) FindAccess(claims *utils.TokenClaims) (data.UserAccess, error) {
+	access, err := t.ForTenant(claims.Tenant()).FindUserAccess(claims.Subject)
+	if err != nil {
+		return data.UserAccess{}, err
+	}
+	if !claims.ServerSigned {
+		return access, nil
+	}
+	access.Roles = appendUnique(access.Roles, claims.Roles...)
+	access.Apps = appendUnique(access.Apps, claims.Apps...)
+	return access, nil
+}
+
+// FindUserAccess returns the roles and apps of a user of the tenant without a token: those granted
+// in the users collection and the super-admin role for the users of ADMIN_USER_IDS. Every user has
+// the user role.
+func (t *UserServiceImpl) FindUserAccess(userId string) (data.UserAccess, error) {
+	access := data.UserAccess{TenantId: t.TenantId, UserId: userId, Roles: []string{data.ROLE_USER}}
+	user, err := t.UserRepository.FindById(userId)
+	if err != nil && !errors.Is(err, userRepository.ErrUserNotFound) {
+		return data.UserAccess{}, err
+	}
+	access.Roles = appendUnique(access.Roles, user.Roles...)
+	access.Apps = appendUnique(access.Apps, user.Apps...)
+	if IsAdminUser(t.TenantId, userId) {
+		access.Roles = appendUnique(access.Roles, data.ROLE_SUPER_ADMIN)
+	}
+	return access, nil
+}
+
+// FindAll returns every user of the tenant granted roles in the users collection.
+func (t *UserServiceImpl) FindAll() ([]data.UserAccess, error) {
+	result, err := t.UserRepository.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	users := []data.UserAccess{}
+	for _, user := range result {
+		users = append(users, toUserData(user))
+	}
+	return users, nil
+}
+
+// FindById returns the roles granted to a user of the tenant in the users collection.
+func (t *UserServiceImpl) FindById(userId string) (data.UserAccess, error) {
+	user, err := t.UserRepository.FindById(userId)
+	if err != nil {
+		return data.UserAccess{}, err
+	}
+	return toUserData(user), nil
+}
+
+// Save validates and replaces the roles and apps granted to a user of the tenant.
+func (t *UserServiceImpl) Save(user data.UserAccess) (data.UserAccess, error) {
+	if err := t.Validate.Struct(user); err != nil {
+		return data.UserAccess{}, err
+	}
+	user.Roles = appendUnique([]string{}, user.Roles...)
+	user.Apps = appendUnique([]string{}, user.Apps...)
+	if err := t.UserRepository.Save(models.User{UserId: user.UserId, Roles: user.Roles, Apps: user.Apps}); err != nil {
+		return data.UserAccess{}, err
+	}
+	user.TenantId = t.TenantId
+	user.UpdatedAt = time.Now()
+	return user, nil
+}
+
+// IsAdminUser reports whether ADMIN_USER_IDS grants the super-admin role to the given user of the
+// given tenant. Entries are <tenantId>:<userId>; entries without a tenant are users of the default tenant.
+func IsAdminUser(tenantId string, userId string) bool {
+	tenantId = config.ResolveTenant(tenantId)
+	for _, entry := range strings.Split(config.LoadConfig().AdminUserIds, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		adminTenantId, adminId, qualified := strings.Cut(entry, ":")
+		if !qualified {
+			adminTenantId, adminId = config.LoadConfig().DefaultTenant, entry
+		}
+		if adminTenantId == tenantId && adminId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the user has one of the given roles. Super admins have every role.
+func HasRole(access data.UserAccess, roles ...string) bool {
+	for _, role := range access.Roles {
+		if role == data.ROLE_SUPER_ADMIN || slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagesApp reports whether the user administers the given app, as a super admin or as an app-admin of the app.
+func ManagesApp(access data.UserAccess, appId string) bool {
+	return HasRole(access, data.ROLE_SUPER_ADMIN) || (HasRole(access, data.ROLE_APP_ADMIN) && slices.Contains(access.Apps, appId))
+}
+
+func appendUnique(values []string, additions ...string) []string {
+	for _, value := range additions {
+		if value != "" && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func toUserData(user models.User) data.UserAccess {
+	return data.UserAccess{
+		TenantId:  config.ResolveTenant(user.TenantId),
+		UserId:    user.UserId,
+		Roles:     user.Roles,
+		Apps:      user.Apps,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
@@ -0,0 +1,201 @@
+package userService
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	userRepository "r2-notify-server/repository/user"
+	"r2-notify-server/utils"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// memoryUserRepository keeps the users of every tenant, keyed by <tenantId>:<userId>.
+type memoryUserRepository struct {
+	tenantId string
+	users    map[string]models.User
+}
+
+func (r memoryUserRepository) ForTenant(tenantId string) userRepository.UserRepository {
+	r.tenantId = tenantId
+	return r
+}
+
+func (r memoryUserRepository) FindAll() ([]models.User, error) {
+	users := []models.User{}
+	for _, user := range r.users {
+		if user.TenantId == r.tenantId {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r memoryUserRepository) FindById(userId string) (models.User, error) {
+	user, ok := r.users[r.tenantId+":"+userId]
+	if !ok {
+		return models.User{}, userRepository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUserRepository) Save(user models.User) error {
+	user.TenantId = r.tenantId
+	r.users[r.tenantId+":"+user.UserId] = user
+	return nil
+}
+
+func TestUsersAreKeyedByTenant(t *testing.T) {
+	t.Setenv("TENANT_IDS", "tenant-b")
+	t.Setenv("ADMIN_USER_IDS", "root, tenant-b:boss")
+	repository := memoryUserRepository{tenantId: "default", users: map[string]models.User{}}
+	service, err := NewUserServiceImpl(repository, validator.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ForTenant("tenant-b").Save(data.UserAccess{UserId: "u1", Roles: []string{data.ROLE_APP_ADMIN}, Apps: []string{"app-b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		tenantId string
+		userId   string
+		roles    []string
+	}{
+		{"user granted roles in the tenant", "tenant-b", "u1", []string{data.ROLE_USER, data.ROLE_APP_ADMIN}},
+		{"same user id in another tenant", "default", "u1", []string{data.ROLE_USER}},
+		{"unqualified admin in the default tenant", "default", "root", []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}},
+		{"unqualified admin in another tenant", "tenant-b", "root", []string{data.ROLE_USER}},
+		{"qualified admin in its tenant", "tenant-b", "boss", []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}},
+		{"qualified admin in the default tenant", "default", "boss", []string{data.ROLE_USER}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access, err := service.FindAccess(&utils.TokenClaims{TenantId: test.tenantId, RegisteredClaims: jwt.RegisteredClaims{Subject: test.userId}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(access.Roles, test.roles) {
+				t.Fatalf("expected roles %v, got %v", test.roles, access.Roles)
+			}
+		})
+	}
+
+	users, err := service.ForTenant("default").FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("expected the users of tenant-b not to be listed in the default tenant, got %v", users)
+	}
+	if _, err := service.ForTenant("default").FindById("u1"); err != userRepository.ErrUserNotFound {
+		t.Fatalf("expected u1 not to be found in the default tenant, got %v", err)
+	}
+}
+
+// writeKeyPair writes a P-256 key pair to PEM files and returns the private key with the paths.
+func writeKeyPair(t *testing.T, dir string, name string) (*ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := filepath.Join(dir, name+".key"), filepath.Join(dir, name+".pub")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key, privatePath, publicPath
+}
+
+func TestRoleClaimsAreOnlyTrustedFromTokensOfTheServer(t *testing.T) {
+	dir := t.TempDir()
+	_, serverKey, _ := writeKeyPair(t, dir, "server")
+	providerKey, _, providerPublicKey := writeKeyPair(t, dir, "provider")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_SIGNING_KEYS", "server="+serverKey)
+	t.Setenv("JWT_PUBLIC_KEYS", "provider="+providerPublicKey)
+	if err := utils.InitTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewUserServiceImpl(memoryUserRepository{tenantId: "default", users: map[string]models.User{}}, validator.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   "u1",
+			"roles": []string{data.ROLE_SUPER_ADMIN},
+			"apps":  []string{"app-a"},
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+	}
+	signedByServer, err := utils.SignToken(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedWithSecret, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerToken := jwt.NewWithClaims(jwt.SigningMethodES256, claims())
+	providerToken.Header["kid"] = "provider"
+	signedByProvider, err := providerToken.SignedString(providerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		roles []string
+		apps  []string
+	}{
+		{"token signed with a signing key of the server", signedByServer, []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}, []string{"app-a"}},
+		{"token signed with the secret", signedWithSecret, []string{data.ROLE_USER, data.ROLE_SUPER_ADMIN}, []string{"app-a"}},
+		{"token signed with a public key of another issuer", signedByProvider, []string{data.ROLE_USER}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenClaims, err := utils.ParseToken(test.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			access, err := service.FindAccess(tokenClaims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(access.Roles, test.roles) || !slices.Equal(access.Apps, test.apps) {
+				t.Fatalf("expected roles %v and apps %v, got %+v", test.roles, test.apps, access)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package webhookService
+
+import (
+	"context"
+	"r2-notify-server/data"
+	"r2-notify-server/models"
+)
+
+type WebhookService interface {
+	ForTenant(tenantId string) WebhookService
+	Subscribe(appId string, request data.CreateWebhookRequest) (subscription data.WebhookSubscription, err error)
+	Unsubscribe(appId string, subscriptionId string) error
+	FindSubscriptions(appId string) (subscriptions []data.WebhookSubscription, err error)
+	FindDeliveries(appId string, status string) (deliveries []data.WebhookDelivery, err error)
+	Replay(appId string, deliveryId string) (delivery data.WebhookDelivery, err error)
+	Subscribed(tenantId string, appId string, event string) bool
+	Publish(event string, notifications []models.Notification)
+	Start(ctx context.Context)
+}
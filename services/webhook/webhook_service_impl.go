@@ -0,0 +1,503 @@
+package webhookService
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"r2-notify-server/config"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	webhookRepository "r2-notify-server/repository/webhook"
+	"r2-notify-server/utils"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxRetryDelay caps the exponential backoff between two delivery attempts.
+const maxRetryDelay = time.Hour
+
+type WebhookServiceImpl struct {
+	WebhookRepository webhookRepository.WebhookRepository
+	Validate          *validator.Validate
+	TenantId          string
+	httpClient        *http.Client
+	wake              chan struct{}
+}
+
+// NewWebhookServiceImpl returns a new instance of WebhookService with the provided
+// WebhookRepository and validator.Validate instance. If the validator instance is nil,
+// an error is returned. Deliveries are only attempted once Start has been called.
+// The returned service manages the webhooks of the default tenant.
+func NewWebhookServiceImpl(webhookRepository webhookRepository.WebhookRepository, validate *validator.Validate) (service WebhookService, err error) {
+	if validate == nil {
+		return nil, errors.New("validator instance cannot be nil")
+	}
+	return &WebhookServiceImpl{
+		WebhookRepository: webhookRepository,
+		Validate:          validate,
+		TenantId:          config.LoadConfig().DefaultTenant,
+		httpClient:        utils.NewOutboundHttpClient(time.Duration(config.LoadConfig().WebhookTimeout) * time.Second),
+		wake:              make(chan struct{}, 1),
+	}, err
+}
+
+// ForTenant returns the service of the webhooks of the given tenant, the default tenant when it is empty.
+func (t *WebhookServiceImpl) ForTenant(tenantId string) WebhookService {
+	service := *t
+	service.WebhookRepository = t.WebhookRepository.ForTenant(tenantId)
+	service.TenantId = config.ResolveTenant(tenantId)
+	return &service
+}
+
+// Subscribe registers a new webhook subscription for the given appId. If the request does not
+// carry a secret, a random one is generated. The secret is only returned by this call and is used
+// to sign every delivery made to the subscription. URLs rejected by utils.ValidateOutboundUrl are
+// reported with utils.ErrForbiddenUrl.
+func (t *WebhookServiceImpl) Subscribe(appId string, request data.CreateWebhookRequest) (data.WebhookSubscription, error) {
+	if err := t.Validate.Struct(request); err != nil {
+		return data.WebhookSubscription{}, err
+	}
+	if err := utils.ValidateOutboundUrl(request.Url); err != nil {
+		return data.WebhookSubscription{}, err
+	}
+	format := request.Format
+	if format == "" {
+		format = data.WEBHOOK_FORMAT_R2
+	}
+	secret := request.Secret
+	if secret == "" {
+		generated, err := generateSecret()
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Webhook Service",
+				Operation: "Subscribe",
+				Message:   "Failed to generate webhook secret for appId: " + appId,
+				Error:     err,
+				AppId:     appId,
+			})
+			return data.WebhookSubscription{}, err
+		}
+		secret = generated
+	}
+	subscription := models.WebhookSubscription{
+		AppId:     appId,
+		Url:       request.Url,
+		Secret:    secret,
+		Events:    request.Events,
+		Format:    format,
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	id, err := t.WebhookRepository.CreateSubscription(subscription)
+	if err != nil {
+		return data.WebhookSubscription{}, err
+	}
+	subscription.Id = id
+	result := toSubscriptionData(subscription)
+	result.Secret = secret
+	logger.Log.Info(logger.LogPayload{
+		Component: "Webhook Service",
+		Operation: "Subscribe",
+		Message:   "Registered webhook subscription " + id.Hex() + " for appId: " + appId,
+		AppId:     appId,
+	})
+	return result, nil
+}
+
+// Unsubscribe removes the subscription with the given ID from the given appId.
+func (t *WebhookServiceImpl) Unsubscribe(appId string, subscriptionId string) error {
+	id, err := primitive.ObjectIDFromHex(subscriptionId)
+	if err != nil {
+		return err
+	}
+	return t.WebhookRepository.DeleteSubscription(appId, id)
+}
+
+// FindSubscriptions returns the subscriptions registered for the given appId. Secrets are omitted.
+func (t *WebhookServiceImpl) FindSubscriptions(appId string) ([]data.WebhookSubscription, error) {
+	result, err := t.WebhookRepository.FindSubscriptions(appId)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := []data.WebhookSubscription{}
+	for _, value := range result {
+		subscriptions = append(subscriptions, toSubscriptionData(value))
+	}
+	return subscriptions, nil
+}
+
+// FindDeliveries returns the delivery log of the given appId, optionally filtered by status.
+func (t *WebhookServiceImpl) FindDeliveries(appId string, status string) ([]data.WebhookDelivery, error) {
+	result, err := t.WebhookRepository.FindDeliveries(appId, status)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []data.WebhookDelivery{}
+	for _, value := range result {
+		deliveries = append(deliveries, toDeliveryData(value))
+	}
+	return deliveries, nil
+}
+
+// Replay queues a fresh copy of an existing delivery, regardless of the outcome of the original.
+// The copy keeps the original payload and references the original delivery through ReplayOf.
+func (t *WebhookServiceImpl) Replay(appId string, deliveryId string) (data.WebhookDelivery, error) {
+	id, err := primitive.ObjectIDFromHex(deliveryId)
+	if err != nil {
+		return data.WebhookDelivery{}, err
+	}
+	original, err := t.WebhookRepository.FindDeliveryById(appId, id)
+	if err != nil {
+		return data.WebhookDelivery{}, err
+	}
+	replay := models.WebhookDelivery{
+		SubscriptionId: original.SubscriptionId,
+		AppId:          original.AppId,
+		Event:          original.Event,
+		Payload:        original.Payload,
+		Status:         data.WEBHOOK_DELIVERY_PENDING,
+		ReplayOf:       original.Id,
+		NextAttemptAt:  time.Now(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	replay.Id, err = t.WebhookRepository.CreateDelivery(replay)
+	if err != nil {
+		return data.WebhookDelivery{}, err
+	}
+	logger.Log.Info(logger.LogPayload{
+		Component: "Webhook Service",
+		Operation: "Replay",
+		Message:   "Queued replay " + replay.Id.Hex() + " of webhook delivery " + deliveryId,
+		AppId:     appId,
+	})
+	t.notifyWorker()
+	return toDeliveryData(replay), nil
+}
+
+// Subscribed reports whether a webhook of the given tenant receives the given lifecycle event for
+// the notifications of appId, or of any application when appId is empty. When the subscriptions
+// cannot be looked up it reports true, so that the caller still captures the event for Publish.
+func (t *WebhookServiceImpl) Subscribed(tenantId string, appId string, event string) bool {
+	subscribed, err := t.WebhookRepository.ForTenant(tenantId).HasActiveSubscriptions(appId, event)
+	return subscribed || err != nil
+}
+
+// Publish queues a delivery of the given lifecycle event to every active subscription of the
+// notification's app in the notification's tenant. Failures are logged and never returned, so that
+// publishing can not break the notification operation that triggered it.
+func (t *WebhookServiceImpl) Publish(event string, notifications []models.Notification) {
+	subscriptionsByApp := map[string][]models.WebhookSubscription{}
+	queued := 0
+	for _, notification := range notifications {
+		repository := t.WebhookRepository.ForTenant(notification.TenantId)
+		key := config.ResolveTenant(notification.TenantId) + ":" + notification.AppId
+		subscriptions, ok := subscriptionsByApp[key]
+		if !ok {
+			found, err := repository.FindActiveSubscriptions(notification.AppId, event)
+			if err != nil {
+				continue
+			}
+			subscriptions = found
+			subscriptionsByApp[key] = found
+		}
+		if len(subscriptions) == 0 {
+			continue
+		}
+		payload, cloudEvent, err := eventPayloads(event, notification)
+		if err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Webhook Service",
+				Operation: "Publish",
+				Message:   "Failed to marshal webhook event " + event,
+				Error:     err,
+				UserId:    notification.UserId,
+				AppId:     notification.AppId,
+			})
+			continue
+		}
+		for _, subscription := range subscriptions {
+			subscriptionPayload := payload
+			if subscription.Format == data.WEBHOOK_FORMAT_CLOUDEVENTS {
+				subscriptionPayload = cloudEvent
+			}
+			_, err := repository.CreateDelivery(models.WebhookDelivery{
+				SubscriptionId: subscription.Id,
+				AppId:          subscription.AppId,
+				Event:          event,
+				Payload:        string(subscriptionPayload),
+				Status:         data.WEBHOOK_DELIVERY_PENDING,
+				NextAttemptAt:  time.Now(),
+				CreatedAt:      time.Now(),
+				UpdatedAt:      time.Now(),
+			})
+			if err == nil {
+				queued++
+			}
+		}
+	}
+	if queued > 0 {
+		logger.Log.Debug(logger.LogPayload{
+			Component: "Webhook Service",
+			Operation: "Publish",
+			Message:   fmt.Sprintf("Queued %d webhook deliveries for event %s", queued, event),
+		})
+		t.notifyWorker()
+	}
+}
+
+// Start runs the delivery worker until the context is cancelled. The worker attempts due
+// deliveries of every tenant whenever new ones are queued and on every poll interval, which also
+// picks up retries and deliveries left behind by other replicas.
+func (t *WebhookServiceImpl) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(config.LoadConfig().WebhookPollInterval) * time.Second)
+	defer ticker.Stop()
+	logger.Log.Info(logger.LogPayload{
+		Component: "Webhook Service",
+		Operation: "Start",
+		Message:   "Webhook delivery worker started",
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(logger.LogPayload{
+				Component: "Webhook Service",
+				Operation: "Start",
+				Message:   "Webhook delivery worker stopped",
+			})
+			return
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.processDueDeliveries(ctx)
+	}
+}
+
+// notifyWorker wakes the delivery worker without blocking if a wake up is already pending.
+func (t *WebhookServiceImpl) notifyWorker() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processDueDeliveries claims and attempts the due deliveries of every tenant until none are left.
+func (t *WebhookServiceImpl) processDueDeliveries(ctx context.Context) {
+	lease := t.httpClient.Timeout * 2
+	for _, tenantId := range config.Tenants() {
+		repository := t.WebhookRepository.ForTenant(tenantId)
+		for ctx.Err() == nil {
+			delivery, err := repository.ClaimDueDelivery(time.Now(), time.Now().Add(lease))
+			if err != nil {
+				break
+			}
+			t.attempt(ctx, repository, delivery)
+		}
+	}
+}
+
+// attempt posts a single delivery to its subscription and records the outcome. Failed attempts
+// are rescheduled with exponential backoff until the configured maximum number of attempts.
+func (t *WebhookServiceImpl) attempt(ctx context.Context, repository webhookRepository.WebhookRepository, delivery models.WebhookDelivery) {
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now()
+
+	subscription, err := repository.FindSubscriptionById(delivery.SubscriptionId)
+	if err == nil && !subscription.Active {
+		err = errors.New("webhook subscription is inactive")
+	}
+	if err == nil {
+		delivery.ResponseCode, err = t.post(ctx, subscription, delivery)
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = data.WEBHOOK_DELIVERY_SUCCEEDED
+		delivery.LastError = ""
+	case delivery.Attempts >= config.LoadConfig().WebhookMaxAttempts:
+		delivery.Status = data.WEBHOOK_DELIVERY_FAILED
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = data.WEBHOOK_DELIVERY_PENDING
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(retryDelay(delivery.Attempts))
+	}
+
+	if err != nil {
+		logger.Log.Warn(logger.LogPayload{
+			Component: "Webhook Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Webhook delivery %s attempt %d failed, status: %s", delivery.Id.Hex(), delivery.Attempts, delivery.Status),
+			Error:     err,
+			AppId:     delivery.AppId,
+		})
+	} else {
+		logger.Log.Info(logger.LogPayload{
+			Component: "Webhook Service",
+			Operation: "Attempt",
+			Message:   fmt.Sprintf("Webhook delivery %s succeeded after %d attempt(s)", delivery.Id.Hex(), delivery.Attempts),
+			AppId:     delivery.AppId,
+		})
+	}
+	_ = repository.UpdateDelivery(delivery)
+}
+
+// post sends the delivery payload to the subscription URL. The request carries the event name,
+// the delivery ID and an HMAC-SHA256 signature of "<timestamp>.<payload>" computed with the
+// subscription secret. Any non 2xx response is treated as a failure.
+func (t *WebhookServiceImpl) post(ctx context.Context, subscription models.WebhookSubscription, delivery models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if subscription.Format == data.WEBHOOK_FORMAT_CLOUDEVENTS {
+		request.Header.Set("Content-Type", "application/cloudevents+json")
+	}
+	request.Header.Set("User-Agent", data.SERVICE_NAME)
+	request.Header.Set("X-R2-Event", delivery.Event)
+	request.Header.Set("X-R2-Delivery", delivery.Id.Hex())
+	request.Header.Set("X-R2-Timestamp", timestamp)
+	request.Header.Set("X-R2-Signature", "sha256="+sign(subscription.Secret, timestamp, delivery.Payload))
+
+	response, err := t.httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("webhook endpoint responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// eventPayloads returns the payload of a lifecycle event in the r2 format and in the CloudEvents
+// format. Both share the same event ID and occurrence time.
+func eventPayloads(event string, notification models.Notification) (payload []byte, cloudEvent []byte, err error) {
+	id := utils.GenerateUUID()
+	occurredAt := time.Now()
+	notificationData := toNotificationData(notification)
+
+	payload, err = json.Marshal(data.WebhookEvent{
+		Id:         id,
+		Event:      event,
+		AppId:      notification.AppId,
+		OccurredAt: occurredAt,
+		Data:       notificationData,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	eventData, err := json.Marshal(notificationData)
+	if err != nil {
+		return nil, nil, err
+	}
+	cloudEvent, err = json.Marshal(data.CloudEvent{
+		SpecVersion:     "1.0",
+		Id:              id,
+		Type:            event,
+		Source:          "/" + data.SERVICE_NAME + "/apps/" + notification.AppId,
+		Subject:         notification.Id.Hex(),
+		Time:            &occurredAt,
+		DataContentType: "application/json",
+		Data:            eventData,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, cloudEvent, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<payload>".
+func sign(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns the backoff before the next attempt, doubling the configured base delay
+// after every failed attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(config.LoadConfig().WebhookRetryBaseDelay) * time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// generateSecret returns a random 32 byte hex encoded secret.
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func toNotificationData(notification models.Notification) data.Notification {
+	return data.Notification{
+		Id:          notification.Id.Hex(),
+		TenantId:    notification.TenantId,
+		AppId:       notification.AppId,
+		UserID:      notification.UserId,
+		GroupKey:    notification.GroupKey,
+		Title:       notification.Title,
+		ActionUrl:   notification.ActionUrl,
+		Message:     notification.Message,
+		ReadStatus:  notification.ReadStatus,
+		Status:      notification.Status,
+		Priority:    notification.Priority,
+		Channels:    notification.Channels,
+		Source:      notification.Source,
+		DeliveredAt: notification.DeliveredAt,
+		CreatedAt:   notification.CreatedAt,
+		UpdatedAt:   notification.UpdatedAt,
+	}
+}
+
+func toSubscriptionData(subscription models.WebhookSubscription) data.WebhookSubscription {
+	return data.WebhookSubscription{
+		Id:        subscription.Id.Hex(),
+		AppId:     subscription.AppId,
+		Url:       subscription.Url,
+		Events:    subscription.Events,
+		Format:    subscription.Format,
+		Active:    subscription.Active,
+		CreatedAt: subscription.CreatedAt,
+		UpdatedAt: subscription.UpdatedAt,
+	}
+}
+
+func toDeliveryData(delivery models.WebhookDelivery) data.WebhookDelivery {
+	result := data.WebhookDelivery{
+		Id:             delivery.Id.Hex(),
+		SubscriptionId: delivery.SubscriptionId.Hex(),
+		AppId:          delivery.AppId,
+		Event:          delivery.Event,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseCode:   delivery.ResponseCode,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		CreatedAt:      delivery.CreatedAt,
+		UpdatedAt:      delivery.UpdatedAt,
+	}
+	if !delivery.ReplayOf.IsZero() {
+		result.ReplayOf = delivery.ReplayOf.Hex()
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package webhookService
+
+import (
+	"errors"
+	"os"
+	"r2-notify-server/data"
+	"r2-notify-server/logger"
+	"r2-notify-server/models"
+	webhookRepository "r2-notify-server/repository/webhook"
+	"r2-notify-server/utils"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewTestSink(zapcore.DebugLevel).Logger
+	os.Exit(m.Run())
+}
+
+// memoryWebhookRepository keeps the subscriptions it creates.
+type memoryWebhookRepository struct {
+	webhookRepository.WebhookRepository
+	subscriptions []models.WebhookSubscription
+}
+
+func (r *memoryWebhookRepository) ForTenant(string) webhookRepository.WebhookRepository {
+	return r
+}
+
+func (r *memoryWebhookRepository) CreateSubscription(subscription models.WebhookSubscription) (primitive.ObjectID, error) {
+	r.subscriptions = append(r.subscriptions, subscription)
+	return primitive.NewObjectID(), nil
+}
+
+func TestSubscribeRejectsInternalUrls(t *testing.T) {
+	repository := &memoryWebhookRepository{}
+	service, err := NewWebhookServiceImpl(repository, validator.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"http://93.184.216.34/hooks", "https://127.0.0.1/hooks", "https://169.254.169.254/latest/meta-data", "https://10.0.0.8/hooks"} {
+		_, err := service.Subscribe("app-a", data.CreateWebhookRequest{Url: url, Events: []string{data.WEBHOOK_EVENT_CREATED}})
+		if !errors.Is(err, utils.ErrForbiddenUrl) {
+			t.Errorf("expected %s to be rejected with ErrForbiddenUrl, got %v", url, err)
+		}
+	}
+	if len(repository.subscriptions) != 0 {
+		t.Fatalf("expected no subscription to be stored, stored %d", len(repository.subscriptions))
+	}
+
+	subscription, err := service.Subscribe("app-a", data.CreateWebhookRequest{Url: "https://93.184.216.34/hooks", Events: []string{data.WEBHOOK_EVENT_CREATED}})
+	if err != nil {
+		t.Fatalf("expected a public https url to be accepted, got %v", err)
+	}
+	if subscription.Secret == "" || len(repository.subscriptions) != 1 {
+		t.Fatalf("expected the subscription to be stored with a generated secret")
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"strings"
+)
+
+// FieldKeys is the key set notification fields are encrypted with. It is initialized by InitFieldKeys,
+// and fields are stored in plaintext while it is nil.
+var FieldKeys *FieldKeySet
+
+// FieldKeySet holds the key-encryption keys of FIELD_ENCRYPTION_KEYS, by key ID. Every document is
+// encrypted with its own data key, which is stored wrapped by the active key-encryption key,
+// FIELD_ENCRYPTION_KEY_ID. The other keys are kept to unwrap the data keys of documents that were not
+// rotated yet.
+type FieldKeySet struct {
+	activeKeyId string
+	keys        map[string]cipher.AEAD
+}
+
+// InitFieldKeys loads the key-encryption keys from the configuration. Without keys, field encryption
+// is disabled and FieldKeys is left nil.
+func InitFieldKeys() error {
+	cfg := config.LoadConfig()
+	if cfg.FieldEncryptionKeys == "" {
+		if cfg.FieldEncryptionKeyId != "" {
+			return errors.New("FIELD_ENCRYPTION_KEY_ID is set without FIELD_ENCRYPTION_KEYS")
+		}
+		return nil
+	}
+	keys, err := ParseFieldKeys(cfg.FieldEncryptionKeys, cfg.FieldEncryptionKeyId)
+	if err != nil {
+		return err
+	}
+	FieldKeys = keys
+	return nil
+}
+
+// ParseFieldKeys reads a comma separated list of <keyId>=<base64 key> entries of 32 byte AES keys,
+// and activates the given key, or the only key when no key ID is given.
+func ParseFieldKeys(value string, activeKeyId string) (*FieldKeySet, error) {
+	keys := &FieldKeySet{activeKeyId: activeKeyId, keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		keyId, encoded, ok := strings.Cut(entry, "=")
+		keyId = strings.TrimSpace(keyId)
+		if !ok || keyId == "" {
+			return nil, fmt.Errorf("invalid field encryption key %q, expected <keyId>=<base64 key>", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("field encryption key %s must be 32 bytes encoded in base64", keyId)
+		}
+		aead, err := newAead(key)
+		if err != nil {
+			return nil, err
+		}
+		keys.keys[keyId] = aead
+	}
+	if keys.activeKeyId == "" && len(keys.keys) == 1 {
+		for keyId := range keys.keys {
+			keys.activeKeyId = keyId
+		}
+	}
+	if _, ok := keys.keys[keys.activeKeyId]; !ok {
+		return nil, fmt.Errorf("FIELD_ENCRYPTION_KEY_ID %q is not one of FIELD_ENCRYPTION_KEYS", keys.activeKeyId)
+	}
+	return keys, nil
+}
+
+// ActiveKeyId returns the ID of the key new data keys are wrapped with.
+func (k *FieldKeySet) ActiveKeyId() string {
+	return k.activeKeyId
+}
+
+// NewDataKey generates the data key of a document, and returns it together with its wrapped form and
+// the ID of the key it was wrapped with, to be stored with the document.
+func (k *FieldKeySet) NewDataKey() (dataKey []byte, wrapped []byte, keyId string, err error) {
+	dataKey = make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, "", err
+	}
+	wrapped, err = k.WrapDataKey(dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return dataKey, wrapped, k.activeKeyId, nil
+}
+
+// WrapDataKey encrypts a data key with the active key.
+func (k *FieldKeySet) WrapDataKey(dataKey []byte) ([]byte, error) {
+	return seal(k.keys[k.activeKeyId], dataKey, []byte(k.activeKeyId))
+}
+
+// UnwrapDataKey decrypts a data key wrapped with the given key.
+func (k *FieldKeySet) UnwrapDataKey(keyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("unknown field encryption key %q", keyId)
+	}
+	dataKey, err := open(aead, wrapped, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with field encryption key %s: %w", keyId, err)
+	}
+	return dataKey, nil
+}
+
+// EncryptField encrypts the value of a field with a data key. The field name is authenticated, so that
+// the values of two fields can not be swapped. Empty values are left empty.
+func EncryptField(dataKey []byte, field string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(value), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptField decrypts the value of a field encrypted by EncryptField.
+func DecryptField(dataKey []byte, field string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext of field %s: %w", field, err)
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt field %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with AES-GCM, prefixing the ciphertext with its random nonce.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testFieldKey returns a base64 encoded 32 byte key filled with the given byte.
+func testFieldKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestParseFieldKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		activeKeyId string
+		valid       bool
+		active      string
+	}{
+		{"single key is active", "k1=" + testFieldKey(1), "", true, "k1"},
+		{"active key is chosen", " k1=" + testFieldKey(1) + " , k2=" + testFieldKey(2) + ",", "k2", true, "k2"},
+		{"several keys need an active key", "k1=" + testFieldKey(1) + ",k2=" + testFieldKey(2), "", false, ""},
+		{"active key must be listed", "k1=" + testFieldKey(1), "k2", false, ""},
+		{"entry without key id", "=" + testFieldKey(1), "", false, ""},
+		{"entry without separator", testFieldKey(1), "", false, ""},
+		{"key is not base64", "k1=not-base64!", "", false, ""},
+		{"key is too short", "k1=" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := ParseFieldKeys(test.value, test.activeKeyId)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected", test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys.ActiveKeyId() != test.active {
+				t.Fatalf("expected %s to be active, got %s", test.active, keys.ActiveKeyId())
+			}
+		})
+	}
+}
+
+func TestInitFieldKeys(t *testing.T) {
+	t.Cleanup(func() { FieldKeys = nil })
+
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "")
+	t.Setenv("FIELD_ENCRYPTION_KEY_ID", "k1")
+	if err := InitFieldKeys(); err == nil {
+		t.Fatal("expected a key ID without keys to be rejected")
+	}
+
+	t.Setenv("FIELD_ENCRYPTION_KEY_ID", "")
+	if err := InitFieldKeys(); err != nil || FieldKeys != nil {
+		t.Fatalf("expected field encryption to be disabled without keys, got %v", err)
+	}
+
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "k1="+testFieldKey(1))
+	if err := InitFieldKeys(); err != nil || FieldKeys == nil || FieldKeys.ActiveKeyId() != "k1" {
+		t.Fatalf("expected field encryption to be enabled with k1, got %v", err)
+	}
+}
+
+func TestDataKeysAreUnwrappedAfterRotation(t *testing.T) {
+	before, err := ParseFieldKeys("k1="+testFieldKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, wrapped, keyId, err := before.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyId != "k1" || len(dataKey) != 32 || bytes.Contains(wrapped, dataKey) {
+		t.Fatalf("expected a 32 byte data key wrapped by k1, got %s", keyId)
+	}
+
+	after, err := ParseFieldKeys("k1="+testFieldKey(1)+",k2="+testFieldKey(2), "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := after.UnwrapDataKey(keyId, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("expected the data key wrapped by k1 to be unwrapped after k2 was activated, got %v", err)
+	}
+	rewrapped, err := after.WrapDataKey(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := after.UnwrapDataKey("k2", rewrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("expected the data key to be wrapped by k2, got %v", err)
+	}
+
+	if _, err := after.UnwrapDataKey("k1", rewrapped); err == nil {
+		t.Fatal("expected a data key wrapped by k2 not to be unwrapped by k1")
+	}
+	if _, err := after.UnwrapDataKey("k3", wrapped); err == nil {
+		t.Fatal("expected an unknown key ID to be rejected")
+	}
+	tampered := append([]byte{}, wrapped...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := after.UnwrapDataKey("k1", tampered); err == nil {
+		t.Fatal("expected a tampered data key to be rejected")
+	}
+	if _, err := after.UnwrapDataKey("k1", wrapped[:4]); err == nil {
+		t.Fatal("expected a truncated data key to be rejected")
+	}
+}
+
+func TestEncryptField(t *testing.T) {
+	keys, err := ParseFieldKeys("k1="+testFieldKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, _, _, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, _, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := EncryptField(dataKey, "message", "main is red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(ciphertext, "main is red") {
+		t.Fatalf("expected the value to be encrypted, got %s", ciphertext)
+	}
+	if again, _ := EncryptField(dataKey, "message", "main is red"); again == ciphertext {
+		t.Fatal("expected every encryption to use a new nonce")
+	}
+	if plaintext, err := DecryptField(dataKey, "message", ciphertext); err != nil || plaintext != "main is red" {
+		t.Fatalf("expected the value to be decrypted, got %q %v", plaintext, err)
+	}
+
+	if _, err := DecryptField(dataKey, "title", ciphertext); err == nil {
+		t.Fatal("expected the ciphertext of the message not to be decrypted as the title")
+	}
+	if _, err := DecryptField(otherKey, "message", ciphertext); err == nil {
+		t.Fatal("expected the ciphertext not to be decrypted with another data key")
+	}
+	raw, _ := base64.StdEncoding.DecodeString(ciphertext)
+	raw[len(raw)-1] ^= 1
+	if _, err := DecryptField(dataKey, "message", base64.StdEncoding.EncodeToString(raw)); err == nil {
+		t.Fatal("expected a tampered ciphertext to be rejected")
+	}
+	if _, err := DecryptField(dataKey, "message", "not base64!"); err == nil {
+		t.Fatal("expected an invalid ciphertext to be rejected")
+	}
+
+	if empty, err := EncryptField(dataKey, "title", ""); err != nil || empty != "" {
+		t.Fatalf("expected an empty value to stay empty, got %q %v", empty, err)
+	}
+	if empty, err := DecryptField(dataKey, "title", ""); err != nil || empty != "" {
+		t.Fatalf("expected an empty value to stay empty, got %q %v", empty, err)
+	}
+}
@@ -0,0 +1,498 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"r2-notify-server/config"
+	"r2-notify-server/logger"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksMinRefresh is the minimum time between two fetches of the JWKS document triggered by tokens
+// signed with an unknown key, so that forged key IDs can not be used to flood the identity provider.
+const jwksMinRefresh = time.Minute
+
+// TokenKeys is the key set tokens are signed and verified with. It is initialized by InitTokenKeys.
+var TokenKeys *KeySet
+
+// KeySet holds the keys of the tokens: the HMAC secret, the private keys the server signs tokens
+// with, and the public keys of JWT_PUBLIC_KEYS and of the JWKS document tokens are verified with.
+// Signing keys are rotated on schedule: each one is used from its activation time until the next
+// one is activated, and is still accepted for JWT_KEY_OVERLAP hours after that.
+type KeySet struct {
+	mu            sync.RWMutex
+	secret        []byte
+	signing       []signingKey
+	public        map[string]crypto.PublicKey
+	jwks          map[string]crypto.PublicKey
+	jwksFetchedAt time.Time
+	httpClient    *http.Client
+}
+
+// signingKey is a private key the server signs tokens with from its activation time.
+type signingKey struct {
+	kid        string
+	key        crypto.Signer
+	activation time.Time
+}
+
+// JsonWebKey is a public key of a JWKS document.
+type JsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JsonWebKeySet is a JWKS document.
+type JsonWebKeySet struct {
+	Keys []JsonWebKey `json:"keys"`
+}
+
+// InitTokenKeys loads the token keys from the configuration and reloads them every JWT_JWKS_REFRESH
+// seconds, so that replaced key files and JWKS documents are picked up without a restart.
+func InitTokenKeys() error {
+	keys := &KeySet{httpClient: &http.Client{Timeout: 10 * time.Second}}
+	if err := keys.load(); err != nil {
+		return err
+	}
+	if len(keys.secret) == 0 && len(keys.signing) == 0 && len(keys.public) == 0 && config.LoadConfig().JwtJwksUrl == "" {
+		return errors.New("no token keys configured: set JWT_SECRET, JWT_SIGNING_KEYS, JWT_PUBLIC_KEYS or JWT_JWKS_URL")
+	}
+	TokenKeys = keys
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(max(config.LoadConfig().JwtJwksRefresh, 60)) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := keys.load(); err != nil {
+				logger.Log.Error(logger.LogPayload{
+					Component: "Token Keys",
+					Operation: "Reload",
+					Message:   "Failed to reload token keys, keeping the previous ones",
+					Error:     err,
+				})
+			}
+		}
+	}()
+	return nil
+}
+
+// load reads the configured keys and replaces the current ones. A JWKS document that can not be
+// fetched is logged and the previous one kept, since it is fetched again for unknown key IDs.
+func (k *KeySet) load() error {
+	cfg := config.LoadConfig()
+
+	var signing []signingKey
+	for _, entry := range splitList(cfg.JwtSigningKeys) {
+		kid, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid JWT_SIGNING_KEYS entry %q, expected <kid>=<path>@<activation>", entry)
+		}
+		path, activation := rest, time.Time{}
+		if at := strings.LastIndex(rest, "@"); at >= 0 {
+			parsed, err := time.Parse(time.RFC3339, rest[at+1:])
+			if err != nil {
+				return fmt.Errorf("invalid activation time of signing key %s: %w", kid, err)
+			}
+			path, activation = rest[:at], parsed
+		}
+		key, err := readPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key %s: %w", kid, err)
+		}
+		if public, ok := key.Public().(*ecdsa.PublicKey); ok && public.Curve != elliptic.P256() {
+			return fmt.Errorf("signing key %s must be a P-256 key for ES256", kid)
+		}
+		signing = append(signing, signingKey{kid: kid, key: key, activation: activation})
+	}
+	slices.SortFunc(signing, func(a, b signingKey) int { return a.activation.Compare(b.activation) })
+
+	public := map[string]crypto.PublicKey{}
+	for _, entry := range splitList(cfg.JwtPublicKeys) {
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid JWT_PUBLIC_KEYS entry %q, expected <kid>=<path>", entry)
+		}
+		key, err := readPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to read public key %s: %w", kid, err)
+		}
+		public[kid] = key
+	}
+
+	k.mu.Lock()
+	k.secret = []byte(cfg.JwtSecret)
+	k.signing = signing
+	k.public = public
+	k.mu.Unlock()
+
+	if cfg.JwtJwksUrl != "" {
+		if err := k.fetchJwks(); err != nil {
+			logger.Log.Error(logger.LogPayload{
+				Component: "Token Keys",
+				Operation: "FetchJwks",
+				Message:   "Failed to fetch JWKS document " + cfg.JwtJwksUrl,
+				Error:     err,
+			})
+		}
+	}
+	return nil
+}
+
+// fetchJwks reads the JWKS document from its URL or file and replaces the cached keys.
+func (k *KeySet) fetchJwks() error {
+	location := config.LoadConfig().JwtJwksUrl
+	k.mu.Lock()
+	k.jwksFetchedAt = time.Now()
+	k.mu.Unlock()
+
+	var document []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		document, err = k.download(location)
+	} else {
+		document, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParseJwks(document)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.jwks = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeySet) download(url string) ([]byte, error) {
+	return Download(k.httpClient, url)
+}
+
+// Download reads a document of at most 1 MiB, such as a JWKS or an OpenID discovery document, from a URL.
+func Download(client *http.Client, url string) ([]byte, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with status %d", url, response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// ParseJwks decodes the signature keys of a JWKS document by key ID. Keys of unsupported types are skipped.
+func ParseJwks(document []byte) (map[string]crypto.PublicKey, error) {
+	var set JsonWebKeySet
+	if err := json.Unmarshal(document, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Log.Warn(logger.LogPayload{
+				Component: "Token Keys",
+				Operation: "ParseJwks",
+				Message:   "Skipped key " + jwk.Kid + " of JWKS document",
+				Error:     err,
+			})
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// signer returns the signing key active at the given time, or false when only the HMAC secret
+// can be used. Before the first activation time, the earliest key is used.
+func (k *KeySet) signer(now time.Time) (signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.signing) == 0 {
+		return signingKey{}, false
+	}
+	current := k.signing[0]
+	for _, key := range k.signing[1:] {
+		if !key.activation.After(now) {
+			current = key
+		}
+	}
+	return current, true
+}
+
+// verificationKey returns the public key of a key ID. Signing keys are accepted from before their
+// activation, so that tokens of replicas that switched earlier are accepted, until the overlap
+// after the activation of the next key is over. Without a key ID, the only asymmetric key is used.
+func (k *KeySet) verificationKey(kid string, now time.Time) (crypto.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	overlap := time.Duration(config.LoadConfig().JwtKeyOverlap) * time.Hour
+	candidates := map[string]crypto.PublicKey{}
+	for i, key := range k.signing {
+		if i+1 < len(k.signing) && now.After(k.signing[i+1].activation.Add(overlap)) {
+			continue
+		}
+		candidates[key.kid] = key.key.Public()
+	}
+	for id, key := range k.public {
+		candidates[id] = key
+	}
+	for id, key := range k.jwks {
+		candidates[id] = key
+	}
+
+	if kid == "" && len(candidates) == 1 {
+		for _, key := range candidates {
+			return key, true
+		}
+	}
+	key, ok := candidates[kid]
+	return key, ok
+}
+
+// lookup returns the public key of a key ID, fetching the JWKS document again when the key is
+// unknown, as identity providers publish new keys before signing with them.
+func (k *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := k.verificationKey(kid, time.Now()); ok {
+		return key, true
+	}
+	k.mu.RLock()
+	stale := time.Since(k.jwksFetchedAt) >= jwksMinRefresh
+	k.mu.RUnlock()
+	if config.LoadConfig().JwtJwksUrl == "" || !stale {
+		return nil, false
+	}
+	if err := k.fetchJwks(); err != nil {
+		return nil, false
+	}
+	return k.verificationKey(kid, time.Now())
+}
+
+// isServerKey reports whether a verified token was signed with the HMAC secret or with one of the
+// signing keys of the server, rather than with JWT_PUBLIC_KEYS or a key of the JWKS document.
+func (k *KeySet) isServerKey(token *jwt.Token) bool {
+	if token.Method == jwt.SigningMethodHS256 {
+		return true
+	}
+	kid, _ := token.Header["kid"].(string)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.signing {
+		if key.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFunc selects the key of a token from its signing method and key ID.
+func (k *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method {
+	case jwt.SigningMethodHS256:
+		k.mu.RLock()
+		defer k.mu.RUnlock()
+		if len(k.secret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return k.secret, nil
+	case jwt.SigningMethodRS256, jwt.SigningMethodES256:
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return MatchSigningMethod(token, key)
+	}
+	return nil, errors.New("unexpected signing method")
+}
+
+// MatchSigningMethod returns the public key if it can verify the RS256 or ES256 signature of the token.
+func MatchSigningMethod(token *jwt.Token, key crypto.PublicKey) (interface{}, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if token.Method == jwt.SigningMethodRS256 {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if token.Method == jwt.SigningMethodES256 && key.Curve == elliptic.P256() {
+			return key, nil
+		}
+	}
+	kid, _ := token.Header["kid"].(string)
+	return nil, fmt.Errorf("signing key %q does not match the %s signing method", kid, token.Method.Alg())
+}
+
+// PublicJWKS returns the public keys of the signing keys as a JWKS document, for the services
+// verifying the tokens issued by the server.
+func (k *KeySet) PublicJWKS() JsonWebKeySet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	overlap := time.Duration(config.LoadConfig().JwtKeyOverlap) * time.Hour
+	set := JsonWebKeySet{Keys: []JsonWebKey{}}
+	for i, key := range k.signing {
+		if i+1 < len(k.signing) && time.Now().After(k.signing[i+1].activation.Add(overlap)) {
+			continue
+		}
+		switch public := key.key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JsonWebKey{
+				Kty: "RSA", Kid: key.kid, Use: "sig", Alg: "RS256",
+				N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, JsonWebKey{
+				Kty: "EC", Kid: key.kid, Use: "sig", Alg: "ES256", Crv: public.Curve.Params().Name,
+				X: base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size))),
+				Y: base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+	return set
+}
+
+// publicKey decodes an RSA or P-256 key of a JWKS document.
+func (jwk JsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		// ecdh validates that the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// readPrivateKey reads an RSA or ECDSA private key from a PEM file, in PKCS #8, PKCS #1 or SEC 1 form.
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// readPublicKey reads an RSA or ECDSA public key from a PEM file, as a PKIX or PKCS #1 key or a certificate.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.PublicKey
+	if block.Type == "CERTIFICATE" {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = certificate.PublicKey
+	} else if parsed, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		key = parsed
+	} else if parsed, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		key = parsed
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("unsupported public key format")
+}
+
+func readPem(path string) (*pem.Block, error) {
+	content, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found in " + path)
+	}
+	return block, nil
+}
+
+// splitList splits a comma separated configuration value, dropping empty entries.
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"r2-notify-server/config"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenUrl is returned for the URLs the server refuses to post to: URLs without https, and
+// URLs whose host resolves to a loopback, link-local, private or otherwise internal address.
+var ErrForbiddenUrl = errors.New("forbidden url")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routed on the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// ValidateOutboundUrl checks a URL users register for the server to post to, such as a webhook or
+// a chat target, so that the server can not be used to reach internal services. The URL must use
+// https and its host must only resolve to public addresses. With ALLOW_PRIVATE_OUTBOUND_URLS, any
+// http or https URL is accepted, for local development.
+func ValidateOutboundUrl(rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("%w: %q is not an absolute url", ErrForbiddenUrl, rawUrl)
+	}
+	if config.LoadConfig().AllowPrivateOutboundUrls {
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("%w: %q must use http or https", ErrForbiddenUrl, rawUrl)
+		}
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("%w: %q must use https", ErrForbiddenUrl, rawUrl)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: host of %q can not be resolved: %v", ErrForbiddenUrl, rawUrl, err)
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address) {
+			return fmt.Errorf("%w: host of %q resolves to the internal address %s", ErrForbiddenUrl, rawUrl, address)
+		}
+	}
+	return nil
+}
+
+// IsPublicAddress reports whether an address is routed on the internet, as opposed to loopback,
+// link-local, private, shared, multicast and unspecified addresses.
+func IsPublicAddress(address netip.Addr) bool {
+	address = address.Unmap()
+	return address.IsValid() &&
+		!address.IsLoopback() &&
+		!address.IsPrivate() &&
+		!address.IsLinkLocalUnicast() &&
+		!address.IsLinkLocalMulticast() &&
+		!address.IsInterfaceLocalMulticast() &&
+		!address.IsMulticast() &&
+		!address.IsUnspecified() &&
+		!sharedAddressSpace.Contains(address)
+}
+
+// NewOutboundHttpClient returns the client posting to the URLs registered by users. Unless
+// ALLOW_PRIVATE_OUTBOUND_URLS is set, it refuses to connect to internal addresses and to follow
+// redirects away from https, so that hosts re-resolving to internal addresses after they were
+// validated can not be reached either.
+func NewOutboundHttpClient(timeout time.Duration) *http.Client {
+	if config.LoadConfig().AllowPrivateOutboundUrls {
+		return &http.Client{Timeout: timeout}
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			addressPort, err := netip.ParseAddrPort(address)
+			if err != nil || !IsPublicAddress(addressPort.Addr()) {
+				return fmt.Errorf("%w: connection to the internal address %s", ErrForbiddenUrl, address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if request.URL.Scheme != "https" {
+				return fmt.Errorf("%w: redirect to %q", ErrForbiddenUrl, request.URL)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
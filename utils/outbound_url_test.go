@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateOutboundUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		allowed bool
+	}{
+		{"public https", "https://93.184.216.34/hooks", true},
+		{"public http", "http://93.184.216.34/hooks", false},
+		{"other scheme", "ftp://93.184.216.34/hooks", false},
+		{"relative", "/hooks", false},
+		{"loopback", "https://127.0.0.1/hooks", false},
+		{"loopback ipv6", "https://[::1]/hooks", false},
+		{"ipv4 mapped loopback", "https://[::ffff:127.0.0.1]/hooks", false},
+		{"private", "https://10.1.2.3/hooks", false},
+		{"private 192.168", "https://192.168.0.10:8443/hooks", false},
+		{"link-local metadata", "https://169.254.169.254/latest/meta-data", false},
+		{"shared address space", "https://100.64.0.1/hooks", false},
+		{"unspecified", "https://0.0.0.0/hooks", false},
+		{"localhost", "https://localhost/hooks", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateOutboundUrl(test.url)
+			if test.allowed && err != nil {
+				t.Fatalf("expected %s to be allowed, got %v", test.url, err)
+			}
+			if !test.allowed && !errors.Is(err, ErrForbiddenUrl) {
+				t.Fatalf("expected %s to be forbidden, got %v", test.url, err)
+			}
+		})
+	}
+}
+
+func TestValidateOutboundUrlAllowsPrivateUrlsForDevelopment(t *testing.T) {
+	t.Setenv("ALLOW_PRIVATE_OUTBOUND_URLS", "true")
+	if err := ValidateOutboundUrl("http://127.0.0.1:8080/hooks"); err != nil {
+		t.Fatalf("expected a local url to be allowed, got %v", err)
+	}
+	if err := ValidateOutboundUrl("file:///etc/passwd"); !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("expected a file url to be forbidden, got %v", err)
+	}
+}
+
+func TestOutboundHttpClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	response, err := NewOutboundHttpClient(time.Second).Get(server.URL)
+	if err == nil {
+		response.Body.Close()
+		t.Fatal("expected the connection to a loopback address to be refused")
+	}
+	if !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("expected ErrForbiddenUrl, got %v", err)
+	}
+
+	t.Setenv("ALLOW_PRIVATE_OUTBOUND_URLS", "true")
+	response, err = NewOutboundHttpClient(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the connection to be allowed for development, got %v", err)
+	}
+	response.Body.Close()
+}
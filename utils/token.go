@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"r2-notify-server/config"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenLeeway is the clock skew tolerated on the time based claims of a token.
+const tokenLeeway = 30 * time.Second
+
+// TokenClaims are the claims of the tokens accepted by the server. SessionId is set on the tokens
+// issued by the server, and identifies the login session the token was refreshed in. Roles and Apps
+// grant roles to the user, and the apps the user administers, in addition to the users collection,
+// and are only trusted when ServerSigned is set by ParseToken for tokens signed with a key of the server.
+// TenantId is the tenant the user belongs to, the default tenant when it is not set. It is read from
+// the namespaced r2_tenant claim, as the tid claim of identity providers such as Entra holds their own
+// tenant IDs.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	SessionId string   `json:"sid,omitempty"`
+	TenantId  string   `json:"r2_tenant,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Apps      []string `json:"apps,omitempty"`
+
+	ServerSigned bool `json:"-"`
+}
+
+// Tenant returns the tenant of the token, the default tenant when the token has none.
+func (t *TokenClaims) Tenant() string {
+	return config.ResolveTenant(t.TenantId)
+}
+
+// ValidateToken verifies a token signed with HS256, RS256 or ES256 and returns its subject (the user ID).
+func ValidateToken(tokenString string) (string, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ParseToken verifies a token signed with HS256, RS256 or ES256 and returns its claims.
+// Asymmetric keys are selected by the kid header. The token must carry a subject and an expiry,
+// and the JWT_ISSUER and JWT_AUDIENCE claims when they are configured.
+func ParseToken(tokenString string) (*TokenClaims, error) {
+	if TokenKeys == nil {
+		return nil, errors.New("token keys are not initialized")
+	}
+	cfg := config.LoadConfig()
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if cfg.JwtIssuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.JwtIssuer))
+	}
+	if cfg.JwtAudience != "" {
+		options = append(options, jwt.WithAudience(cfg.JwtAudience))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, TokenKeys.keyFunc, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid && claims.Subject != "" {
+		if !config.IsKnownTenant(claims.Tenant()) {
+			return nil, fmt.Errorf("unknown tenant %q", claims.TenantId)
+		}
+		claims.ServerSigned = TokenKeys.isServerKey(token)
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// SignToken signs the claims with the signing key active now, or with the HMAC secret when no
+// signing key is configured. The issuer and audience are set from JWT_ISSUER and JWT_AUDIENCE.
+func SignToken(claims jwt.MapClaims) (string, error) {
+	if TokenKeys == nil {
+		return "", errors.New("token keys are not initialized")
+	}
+	cfg := config.LoadConfig()
+	if cfg.JwtIssuer != "" {
+		claims["iss"] = cfg.JwtIssuer
+	}
+	if cfg.JwtAudience != "" {
+		claims["aud"] = cfg.JwtAudience
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = time.Now().Unix()
+	}
+
+	if key, ok := TokenKeys.signer(time.Now()); ok {
+		method := jwt.SigningMethod(jwt.SigningMethodRS256)
+		if _, isEcdsa := key.key.Public().(*ecdsa.PublicKey); isEcdsa {
+			method = jwt.SigningMethodES256
+		}
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = key.kid
+		return token.SignedString(key.key)
+	}
+
+	TokenKeys.mu.RLock()
+	secret := TokenKeys.secret
+	TokenKeys.mu.RUnlock()
+	if len(secret) == 0 {
+		return "", errors.New("no signing key configured")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}